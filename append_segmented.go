@@ -0,0 +1,109 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// segmentDirectory returns directory holding per writer segments of file
+func segmentDirectory(path string) string {
+	return filepath.Clean(path) + ".segments"
+}
+
+// SegmentedAppender appends to per writer segment of shared file so that
+// concurrent writers never contend on single flock, segments are merged on
+// read. Order of appends is preserved per writer only.
+type SegmentedAppender struct {
+	storage Storage
+	path    string
+	segment string
+}
+
+// NewSegmentedAppender returns appender to given file identified by writer,
+// every concurrent writer (goroutine or process) must use different name
+func NewSegmentedAppender(storage Storage, path string, writer string) (*SegmentedAppender, error) {
+	if writer == "" || strings.ContainsRune(writer, '/') {
+		return nil, fmt.Errorf("invalid writer name %q", writer)
+	}
+	return &SegmentedAppender{
+		storage: storage,
+		path:    filepath.Clean(path),
+		segment: segmentDirectory(path) + "/" + writer,
+	}, nil
+}
+
+// DefaultWriterName returns writer name unique to current process
+func DefaultWriterName() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// Append appends data to writer's segment
+func (appender *SegmentedAppender) Append(data []byte) error {
+	return appender.storage.AppendFile(appender.segment, data)
+}
+
+// ReadSegmented returns content of file followed by content of all its
+// segments ordered by writer name
+func ReadSegmented(storage Storage, path string) ([]byte, error) {
+	path = filepath.Clean(path)
+	result := make([]byte, 0)
+	ok, err := storage.Exists(path)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		data, err := storage.ReadFileFully(path)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, data...)
+	}
+	dir := segmentDirectory(path)
+	ok, err = storage.Exists(dir)
+	if err != nil || !ok {
+		return result, err
+	}
+	writers, err := storage.ListDirectory(dir, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, writer := range writers {
+		data, err := storage.ReadFileFully(dir + "/" + writer)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, data...)
+	}
+	return result, nil
+}
+
+// MergeSegments folds all segments of file into file itself, it must not
+// run concurrently with writers of same file
+func MergeSegments(storage Storage, path string) error {
+	path = filepath.Clean(path)
+	data, err := ReadSegmented(storage, path)
+	if err != nil {
+		return err
+	}
+	if err = storage.WriteFile(path, data); err != nil {
+		return err
+	}
+	return storage.Delete(segmentDirectory(path))
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSegmentedAppend(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	if err = storage.WriteFile("journal", []byte("0")); err != nil {
+		t.Fatalf("unexpected error when calling WriteFile %+v", err)
+	}
+
+	if _, err = NewSegmentedAppender(storage, "journal", "a/b"); err == nil {
+		t.Errorf("expected error for writer name containing separator")
+	}
+
+	var wg sync.WaitGroup
+	for _, writer := range []string{"b", "a"} {
+		wg.Add(1)
+		go func(writer string) {
+			defer wg.Done()
+			appender, _ := NewSegmentedAppender(storage, "journal", writer)
+			for i := 0; i < 3; i++ {
+				appender.Append([]byte(writer))
+			}
+		}(writer)
+	}
+	wg.Wait()
+
+	data, err := ReadSegmented(storage, "journal")
+	if err != nil {
+		t.Fatalf("unexpected error when calling ReadSegmented %+v", err)
+	}
+	if string(data) != "0aaabbb" {
+		t.Errorf("expected merged content 0aaabbb got %s", string(data))
+	}
+
+	if err = MergeSegments(storage, "journal"); err != nil {
+		t.Fatalf("unexpected error when calling MergeSegments %+v", err)
+	}
+	if ok, _ := storage.Exists("journal.segments"); ok {
+		t.Errorf("expected segments to be removed after merge")
+	}
+	data, _ = storage.ReadFileFully("journal")
+	if string(data) != "0aaabbb" {
+		t.Errorf("expected merged file content 0aaabbb got %s", string(data))
+	}
+}
+
+// TestAppendHelperProcess is not a real test, it is child process of
+// multi-process benchmarks
+func TestAppendHelperProcess(t *testing.T) {
+	root := os.Getenv("LOCALFS_APPEND_ROOT")
+	if root == "" {
+		return
+	}
+	count, _ := strconv.Atoi(os.Getenv("LOCALFS_APPEND_COUNT"))
+	storage, _ := NewPlaintextStorage(root)
+	data := make([]byte, 128)
+	if os.Getenv("LOCALFS_APPEND_MODE") == "segmented" {
+		appender, _ := NewSegmentedAppender(storage, "journal", DefaultWriterName())
+		for i := 0; i < count; i++ {
+			appender.Append(data)
+		}
+		return
+	}
+	for i := 0; i < count; i++ {
+		storage.AppendFile("journal", data)
+	}
+}
+
+func benchmarkAppendProcesses(b *testing.B, mode string) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		b.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	processes := 4
+	b.SetBytes(128)
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for i := 0; i < processes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd := exec.Command(os.Args[0], "-test.run=^TestAppendHelperProcess$")
+			cmd.Env = append(os.Environ(),
+				"LOCALFS_APPEND_ROOT="+tmpdir,
+				"LOCALFS_APPEND_MODE="+mode,
+				fmt.Sprintf("LOCALFS_APPEND_COUNT=%d", b.N/processes+1),
+			)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				b.Errorf("child process failed %+v %s", err, string(out))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkAppendFileMultiProcess(b *testing.B) {
+	benchmarkAppendProcesses(b, "shared")
+}
+
+func BenchmarkSegmentedAppendMultiProcess(b *testing.B) {
+	benchmarkAppendProcesses(b, "segmented")
+}
+
+func BenchmarkAppendFileParallel(b *testing.B) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		b.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	data := make([]byte, 128)
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			storage.AppendFile("journal", data)
+		}
+	})
+}
+
+func BenchmarkSegmentedAppendParallel(b *testing.B) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		b.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	data := make([]byte, 128)
+	var writers int64
+
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		appender, _ := NewSegmentedAppender(storage, "journal", fmt.Sprintf("w%d", atomic.AddInt64(&writers, 1)))
+		for pb.Next() {
+			appender.Append(data)
+		}
+	})
+}
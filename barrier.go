@@ -0,0 +1,160 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultMaxFreezeDuration is safety timeout after which frozen storage
+// thaws automatically when Freeze is called without timeout
+const DefaultMaxFreezeDuration = 5 * time.Minute
+
+// FreezeStatus represents state of write barrier
+type FreezeStatus struct {
+	Frozen   bool      `json:"frozen"`
+	Since    time.Time `json:"since,omitempty"`
+	Deadline time.Time `json:"deadline,omitempty"`
+}
+
+// writeBarrier lets mutations run concurrently unless storage is frozen
+type writeBarrier struct {
+	gate       sync.RWMutex
+	mutex      sync.Mutex
+	generation uint64
+	status     FreezeStatus
+	timer      *time.Timer
+}
+
+func newWriteBarrier() *writeBarrier {
+	return new(writeBarrier)
+}
+
+// enter blocks while storage is frozen and returns func marking end of
+// mutation
+func (barrier *writeBarrier) enter() func() {
+	if barrier == nil {
+		return noop
+	}
+	barrier.gate.RLock()
+	return barrier.gate.RUnlock
+}
+
+func (barrier *writeBarrier) freeze(timeout time.Duration) error {
+	if barrier == nil {
+		return fmt.Errorf("storage does not support freezing")
+	}
+	if timeout <= 0 {
+		timeout = DefaultMaxFreezeDuration
+	}
+	barrier.mutex.Lock()
+	if barrier.status.Frozen {
+		barrier.mutex.Unlock()
+		return fmt.Errorf("storage already frozen")
+	}
+	barrier.mutex.Unlock()
+
+	// waits for in-flight mutations to drain
+	barrier.gate.Lock()
+
+	barrier.mutex.Lock()
+	defer barrier.mutex.Unlock()
+	barrier.generation++
+	generation := barrier.generation
+	now := time.Now()
+	barrier.status = FreezeStatus{
+		Frozen:   true,
+		Since:    now,
+		Deadline: now.Add(timeout),
+	}
+	barrier.timer = time.AfterFunc(timeout, func() {
+		barrier.thaw(generation)
+	})
+	return nil
+}
+
+// thaw releases barrier if it is still frozen by given generation
+func (barrier *writeBarrier) thaw(generation uint64) bool {
+	if barrier == nil {
+		return false
+	}
+	barrier.mutex.Lock()
+	defer barrier.mutex.Unlock()
+	if !barrier.status.Frozen || barrier.generation != generation {
+		return false
+	}
+	barrier.timer.Stop()
+	barrier.status = FreezeStatus{}
+	barrier.gate.Unlock()
+	return true
+}
+
+func (barrier *writeBarrier) current() FreezeStatus {
+	if barrier == nil {
+		return FreezeStatus{}
+	}
+	barrier.mutex.Lock()
+	defer barrier.mutex.Unlock()
+	return barrier.status
+}
+
+func (barrier *writeBarrier) unfreeze() error {
+	if barrier == nil {
+		return fmt.Errorf("storage does not support freezing")
+	}
+	barrier.mutex.Lock()
+	generation := barrier.generation
+	barrier.mutex.Unlock()
+	if !barrier.thaw(generation) {
+		return fmt.Errorf("storage not frozen")
+	}
+	return nil
+}
+
+// Freeze blocks new mutations and waits for in-flight ones to finish so that
+// external snapshot captures consistent state, storage thaws automatically
+// after timeout
+func (storage PlaintextStorage) Freeze(timeout time.Duration) error {
+	return storage.barrier.freeze(timeout)
+}
+
+// Thaw resumes mutations blocked by Freeze
+func (storage PlaintextStorage) Thaw() error {
+	return storage.barrier.unfreeze()
+}
+
+// FreezeStatus returns state of write barrier
+func (storage PlaintextStorage) FreezeStatus() FreezeStatus {
+	return storage.barrier.current()
+}
+
+// Freeze blocks new mutations and waits for in-flight ones to finish so that
+// external snapshot captures consistent state, storage thaws automatically
+// after timeout
+func (storage EncryptedStorage) Freeze(timeout time.Duration) error {
+	return storage.barrier.freeze(timeout)
+}
+
+// Thaw resumes mutations blocked by Freeze
+func (storage EncryptedStorage) Thaw() error {
+	return storage.barrier.unfreeze()
+}
+
+// FreezeStatus returns state of write barrier
+func (storage EncryptedStorage) FreezeStatus() FreezeStatus {
+	return storage.barrier.current()
+}
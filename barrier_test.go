@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFreeze(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	plaintext := storage.(PlaintextStorage)
+
+	if err = plaintext.Thaw(); err == nil {
+		t.Errorf("expected error when thawing storage that is not frozen")
+	}
+
+	t.Log("blocks mutations")
+	{
+		if err = plaintext.Freeze(time.Minute); err != nil {
+			t.Fatalf("unexpected error when calling Freeze %+v", err)
+		}
+		if status := plaintext.FreezeStatus(); !status.Frozen || status.Deadline.Before(status.Since) {
+			t.Errorf("unexpected freeze status %+v", status)
+		}
+		if err = plaintext.Freeze(time.Minute); err == nil {
+			t.Errorf("expected error when freezing frozen storage")
+		}
+
+		done := make(chan error)
+		go func() {
+			done <- storage.WriteFile("foo", []byte("abc"))
+		}()
+		select {
+		case <-done:
+			t.Fatalf("expected WriteFile to block while frozen")
+		case <-time.After(20 * time.Millisecond):
+		}
+		if _, err = storage.Exists("foo"); err != nil {
+			t.Errorf("expected reads to proceed while frozen got %+v", err)
+		}
+
+		if err = plaintext.Thaw(); err != nil {
+			t.Fatalf("unexpected error when calling Thaw %+v", err)
+		}
+		if err = <-done; err != nil {
+			t.Fatalf("unexpected error when calling WriteFile %+v", err)
+		}
+	}
+
+	t.Log("safety timeout")
+	{
+		if err = plaintext.Freeze(10 * time.Millisecond); err != nil {
+			t.Fatalf("unexpected error when calling Freeze %+v", err)
+		}
+		if err = storage.WriteFile("bar", []byte("abc")); err != nil {
+			t.Fatalf("unexpected error when calling WriteFile %+v", err)
+		}
+		if plaintext.FreezeStatus().Frozen {
+			t.Errorf("expected storage to thaw after timeout")
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bench provides reproducible benchmarks of storage over large
+// directories with machine readable results
+package bench
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	storage "github.com/jancajthaml-openbank/local-fs"
+)
+
+// Config describes benchmark run
+type Config struct {
+	// Root is directory where benchmark directories are created
+	Root string
+	// Sizes are numbers of entries of benchmarked directories
+	Sizes []int
+	// Duration is minimal time spent measuring each operation
+	Duration time.Duration
+	// Keep preserves populated directories for subsequent runs
+	Keep bool
+}
+
+// DefaultSizes are directory sizes of published baselines
+var DefaultSizes = []int{10000, 100000, 1000000, 10000000}
+
+// Result represents measurement of single operation
+type Result struct {
+	Operation   string `json:"operation"`
+	Entries     int    `json:"entries"`
+	Iterations  int    `json:"iterations"`
+	NsPerOp     int64  `json:"nsPerOp"`
+	AllocsPerOp uint64 `json:"allocsPerOp"`
+	BytesPerOp  uint64 `json:"bytesPerOp"`
+}
+
+type operation struct {
+	name string
+	fn   func(storage.Storage, string) error
+}
+
+type walker interface {
+	ForEachEntry(string, func(string) bool) error
+}
+
+var operations = []operation{
+	{"ListDirectory", func(subject storage.Storage, dir string) error {
+		_, err := subject.ListDirectory(dir, true)
+		return err
+	}},
+	{"ListDirectoryUnsorted", func(subject storage.Storage, dir string) error {
+		_, err := storage.ListDirectoryUnsorted(subject, dir)
+		return err
+	}},
+	{"CountFiles", func(subject storage.Storage, dir string) error {
+		_, err := subject.CountFiles(dir)
+		return err
+	}},
+	{"FirstEntry", func(subject storage.Storage, dir string) error {
+		list, err := subject.ListDirectory(dir, true)
+		if err == nil && len(list) == 0 {
+			err = fmt.Errorf("empty directory")
+		}
+		return err
+	}},
+	{"LastEntry", func(subject storage.Storage, dir string) error {
+		list, err := subject.ListDirectory(dir, false)
+		if err == nil && len(list) == 0 {
+			err = fmt.Errorf("empty directory")
+		}
+		return err
+	}},
+	{"Walk", func(subject storage.Storage, dir string) error {
+		if iterable, ok := subject.(walker); ok {
+			return iterable.ForEachEntry(dir, func(string) bool {
+				return true
+			})
+		}
+		_, err := subject.ListDirectory(dir, true)
+		return err
+	}},
+}
+
+func populate(root string, dir string, entries int) error {
+	absPath := filepath.Join(root, dir)
+	marker := filepath.Join(root, dir+".complete")
+	if _, err := os.Stat(marker); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(absPath, os.ModePerm); err != nil {
+		return err
+	}
+	for i := 0; i < entries; i++ {
+		file, err := os.OpenFile(fmt.Sprintf("%s/%010d", absPath, i), os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		file.Close()
+	}
+	file, err := os.Create(marker)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func measure(subject storage.Storage, dir string, entries int, op operation, duration time.Duration) (Result, error) {
+	var before, after runtime.MemStats
+	result := Result{Operation: op.name, Entries: entries}
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for time.Since(start) < duration || result.Iterations == 0 {
+		if err := op.fn(subject, dir); err != nil {
+			return result, err
+		}
+		result.Iterations++
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	result.NsPerOp = elapsed.Nanoseconds() / int64(result.Iterations)
+	result.AllocsPerOp = (after.Mallocs - before.Mallocs) / uint64(result.Iterations)
+	result.BytesPerOp = (after.TotalAlloc - before.TotalAlloc) / uint64(result.Iterations)
+	return result, nil
+}
+
+// Run populates directories of configured sizes and measures operations over
+// them
+func Run(config Config) ([]Result, error) {
+	if config.Root == "" {
+		return nil, fmt.Errorf("no root given")
+	}
+	if len(config.Sizes) == 0 {
+		config.Sizes = DefaultSizes
+	}
+	if config.Duration <= 0 {
+		config.Duration = time.Second
+	}
+	subject, err := storage.NewPlaintextStorage(config.Root)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]Result, 0, len(config.Sizes)*len(operations))
+	for _, entries := range config.Sizes {
+		dir := fmt.Sprintf("bench_%d", entries)
+		if err = populate(config.Root, dir, entries); err != nil {
+			return results, err
+		}
+		for _, op := range operations {
+			result, err := measure(subject, dir, entries, op, config.Duration)
+			if err != nil {
+				return results, err
+			}
+			results = append(results, result)
+		}
+		if !config.Keep {
+			os.RemoveAll(filepath.Join(config.Root, dir))
+			os.Remove(filepath.Join(config.Root, dir+".complete"))
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,63 @@
+package bench
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	storage "github.com/jancajthaml-openbank/local-fs"
+)
+
+func TestRun(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_bench")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	results, err := Run(Config{
+		Root:     tmpdir,
+		Sizes:    []int{10, 100},
+		Duration: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error when calling Run %+v", err)
+	}
+	if len(results) != 2*len(operations) {
+		t.Fatalf("expected %d results got %d instead", 2*len(operations), len(results))
+	}
+	for _, result := range results {
+		if result.Iterations == 0 || result.NsPerOp <= 0 {
+			t.Errorf("expected measured result got %+v", result)
+		}
+	}
+	if _, err = os.Stat(tmpdir + "/bench_10"); !os.IsNotExist(err) {
+		t.Errorf("expected populated directory to be removed")
+	}
+}
+
+func BenchmarkLargeDirectory(b *testing.B) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_bench")
+	if err != nil {
+		b.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	if err = populate(tmpdir, "dir", 10000); err != nil {
+		b.Fatalf("unexpected error when populating directory %+v", err)
+	}
+
+	subject, _ := storage.NewPlaintextStorage(tmpdir)
+
+	for _, op := range operations {
+		op := op
+		b.Run(op.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				op.fn(subject, "dir")
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidKey is returned for empty key or key too long to be file name
+var ErrInvalidKey = errors.New("invalid key")
+
+// maxKeyName is longest file name of key
+const maxKeyName = 255
+
+// Bucket maps keys to files in single directory of storage, keys consisting
+// of letters, digits, dot, dash and underscore are file names as they are,
+// other keys are stored under base64 encoded name starting with "~" so any
+// key round-trips through Keys
+type Bucket struct {
+	storage Storage
+	dir     string
+}
+
+// NewBucket returns bucket stored in given directory of storage
+func NewBucket(storage Storage, dir string) Bucket {
+	return Bucket{
+		storage: storage,
+		dir:     dir,
+	}
+}
+
+// keyName returns file name of key
+func keyName(key string) (string, error) {
+	name := key
+	if !safeKey(key) {
+		name = "~" + base64.RawURLEncoding.EncodeToString([]byte(key))
+	}
+	if key == "" || len(name) > maxKeyName {
+		return "", ErrInvalidKey
+	}
+	return name, nil
+}
+
+// safeKey returns true when key can be used as file name as it is
+func safeKey(key string) bool {
+	if key == "" || key[0] == '.' {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// nameKey returns key of file name, false for names not created by bucket
+func nameKey(name string) (string, bool) {
+	if !strings.HasPrefix(name, "~") {
+		return name, safeKey(name)
+	}
+	key, err := base64.RawURLEncoding.DecodeString(name[1:])
+	if err != nil || safeKey(string(key)) {
+		return "", false
+	}
+	return string(key), true
+}
+
+// Get returns value of key
+func (bucket Bucket) Get(key string) ([]byte, error) {
+	name, err := keyName(key)
+	if err != nil {
+		return nil, err
+	}
+	return bucket.storage.ReadFileFully(bucket.dir + "/" + name)
+}
+
+// Put sets value of key
+func (bucket Bucket) Put(key string, value []byte) error {
+	name, err := keyName(key)
+	if err != nil {
+		return err
+	}
+	return bucket.storage.WriteFile(bucket.dir+"/"+name, value)
+}
+
+// Delete removes key, removing missing key is not an error
+func (bucket Bucket) Delete(key string) error {
+	name, err := keyName(key)
+	if err != nil {
+		return err
+	}
+	return bucket.storage.Delete(bucket.dir + "/" + name)
+}
+
+// Keys returns keys of bucket in ascending order
+func (bucket Bucket) Keys() ([]string, error) {
+	names, err := bucket.storage.ListDirectory(bucket.dir, true)
+	if os.IsNotExist(err) {
+		return make([]string, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		if key, ok := nameKey(name); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
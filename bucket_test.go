@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBucket(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	plaintext, _ := NewPlaintextStorage(tmpdir + "/plaintext")
+	encrypted, _ := NewEncryptedStorage(tmpdir+"/encrypted", getKey())
+
+	keys := []string{"account_A", "../escape", "with/slash", "~tilde", ".hidden", "ünicode key"}
+
+	for name, storage := range map[string]Storage{"plaintext": plaintext, "encrypted": encrypted} {
+		bucket := NewBucket(storage, "kv/accounts")
+
+		t.Logf("%s empty bucket", name)
+		{
+			if result, err := bucket.Keys(); err != nil || len(result) != 0 {
+				t.Errorf("%s expected no keys got %+v %+v", name, result, err)
+			}
+		}
+
+		t.Logf("%s put and get", name)
+		{
+			for _, key := range keys {
+				if err := bucket.Put(key, []byte("value of "+key)); err != nil {
+					t.Fatalf("%s unexpected error when calling Put %q %+v", name, key, err)
+				}
+			}
+			for _, key := range keys {
+				if value, err := bucket.Get(key); err != nil || string(value) != "value of "+key {
+					t.Errorf("%s expected value of %q got %q %+v", name, key, value, err)
+				}
+			}
+			if _, err := bucket.Get("missing"); !os.IsNotExist(err) {
+				t.Errorf("%s expected not exist error got %+v", name, err)
+			}
+		}
+
+		t.Logf("%s keys stay inside bucket directory", name)
+		{
+			if exists, _ := storage.Exists("kv/escape"); exists {
+				t.Errorf("%s expected key not to escape bucket", name)
+			}
+			names, _ := storage.ListDirectory("kv/accounts", true)
+			if len(names) != len(keys) {
+				t.Errorf("%s expected %d files got %+v", name, len(keys), names)
+			}
+		}
+
+		t.Logf("%s keys round-trip", name)
+		{
+			result, err := bucket.Keys()
+			expected := append([]string(nil), keys...)
+			sortNames(expected, SortLexicographic, true)
+			if err != nil || !reflect.DeepEqual(result, expected) {
+				t.Errorf("%s expected keys %+v got %+v %+v", name, expected, result, err)
+			}
+		}
+
+		t.Logf("%s delete", name)
+		{
+			if err := bucket.Delete("with/slash"); err != nil {
+				t.Errorf("%s unexpected error when calling Delete %+v", name, err)
+			}
+			if err := bucket.Delete("with/slash"); err != nil {
+				t.Errorf("%s expected deleting missing key to succeed got %+v", name, err)
+			}
+			if _, err := bucket.Get("with/slash"); !os.IsNotExist(err) {
+				t.Errorf("%s expected not exist error got %+v", name, err)
+			}
+		}
+
+		t.Logf("%s invalid keys", name)
+		{
+			if err := bucket.Put("", nil); err != ErrInvalidKey {
+				t.Errorf("%s expected ErrInvalidKey got %+v", name, err)
+			}
+			if err := bucket.Put(strings.Repeat("/", 200), nil); err != ErrInvalidKey {
+				t.Errorf("%s expected ErrInvalidKey got %+v", name, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrBundleTampered is returned when export bundle does not match its signed
+// manifest
+var ErrBundleTampered = errors.New("export bundle does not match manifest")
+
+const (
+	bundleFilesPrefix = "files/"
+	bundleManifest    = "manifest.json"
+	bundleSignature   = "manifest.sig"
+)
+
+// BundleFile describes single file of export bundle
+type BundleFile struct {
+	Path         string    `json:"path"`
+	Size         int64     `json:"size"`
+	Checksum     string    `json:"checksum"`
+	LastModified time.Time `json:"lastModified"`
+	KeyID        string    `json:"keyId,omitempty"`
+}
+
+// BundleManifest describes content of export bundle, it is signed so bundle
+// can be verified offline
+type BundleManifest struct {
+	Meta     map[string]string `json:"meta,omitempty"`
+	Created  time.Time         `json:"created"`
+	SignedBy string            `json:"signedBy"`
+	Files    []BundleFile      `json:"files"`
+}
+
+// BundleSigner is Ed25519 key signing bundle manifests
+type BundleSigner struct {
+	KeyID string
+	Key   ed25519.PrivateKey
+}
+
+// keyID returns fingerprint of encryption key safe to disclose
+func keyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte("localfs key id\x00"), key...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// KeyID returns id of newest key of key ring or fingerprint of encryption
+// key when storage has no key ring
+func (storage EncryptedStorage) KeyID() string {
+	if storage.ring != nil {
+		if id, _, ok := storage.ring.Newest(); ok {
+			return id
+		}
+	}
+	return keyID(storage.encryptionKey)
+}
+
+// encryptionKeyID returns fingerprint of encryption key of storage looking
+// through decorators, empty for plaintext storage
+func encryptionKeyID(storage Storage) string {
+	for storage != nil {
+		if encrypted, ok := storage.(EncryptedStorage); ok {
+			return encrypted.KeyID()
+		}
+		decorator, ok := storage.(wrapper)
+		if !ok {
+			break
+		}
+		storage = decorator.unwrap()
+	}
+	return ""
+}
+
+// bundlePaths expands directories into files they contain
+func bundlePaths(storage Storage, paths []string) ([]string, error) {
+	result := make([]string, 0, len(paths))
+	seen := make(map[string]bool)
+	var visit func(string) error
+	visit = func(item string) error {
+		item = strings.TrimPrefix(path.Clean("/"+item), "/")
+		if entries, err := storage.ListDirectory(item, true); err == nil {
+			for _, entry := range entries {
+				if err = visit(item + "/" + entry); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if ok, err := storage.Exists(item); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("%s does not exist", item)
+		}
+		if !seen[item] {
+			seen[item] = true
+			result = append(result, item)
+		}
+		return nil
+	}
+	for _, item := range paths {
+		if err := visit(item); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// BuildExportBundle writes tar.gz with plaintext content of given files and
+// directories together with manifest of their checksums, modification times
+// and encryption key ids signed by given signer
+func BuildExportBundle(w io.Writer, storage Storage, paths []string, meta map[string]string, signer BundleSigner) error {
+	if len(signer.Key) != ed25519.PrivateKeySize {
+		return fmt.Errorf("invalid signing key")
+	}
+	files, err := bundlePaths(storage, paths)
+	if err != nil {
+		return err
+	}
+	manifest := BundleManifest{
+		Meta:     meta,
+		Created:  time.Now().UTC(),
+		SignedBy: signer.KeyID,
+		Files:    make([]BundleFile, 0, len(files)),
+	}
+	encryptionKey := encryptionKeyID(storage)
+
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	write := func(name string, data []byte, modified time.Time) error {
+		header := &tar.Header{
+			Name:     name,
+			Mode:     0400,
+			Size:     int64(len(data)),
+			ModTime:  modified,
+			Typeflag: tar.TypeReg,
+			Format:   tar.FormatPAX,
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := archive.Write(data)
+		return err
+	}
+
+	for _, file := range files {
+		data, err := storage.ReadFileFully(file)
+		if err != nil {
+			return err
+		}
+		modified, err := storage.LastModification(file)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		manifest.Files = append(manifest.Files, BundleFile{
+			Path:         file,
+			Size:         int64(len(data)),
+			Checksum:     "sha256:" + hex.EncodeToString(sum[:]),
+			LastModified: modified.UTC(),
+			KeyID:        encryptionKey,
+		})
+		if err = write(bundleFilesPrefix+file, data, modified.UTC()); err != nil {
+			return err
+		}
+	}
+
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = write(bundleManifest, encoded, manifest.Created); err != nil {
+		return err
+	}
+	signature := ed25519.Sign(signer.Key, encoded)
+	if err = write(bundleSignature, []byte(hex.EncodeToString(signature)), manifest.Created); err != nil {
+		return err
+	}
+	if err = archive.Close(); err != nil {
+		gz.Close()
+		return err
+	}
+	return gz.Close()
+}
+
+// VerifyBundle checks signature of bundle manifest with given public key and
+// that bundle contains exactly files listed in manifest with matching
+// checksums
+func VerifyBundle(r io.Reader, key ed25519.PublicKey) (BundleManifest, error) {
+	var manifest BundleManifest
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return manifest, err
+	}
+	defer gz.Close()
+	archive := tar.NewReader(gz)
+
+	var encoded, signature []byte
+	checksums := make(map[string]string)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, err
+		}
+		switch {
+		case header.Name == bundleManifest:
+			if encoded, err = io.ReadAll(archive); err != nil {
+				return manifest, err
+			}
+		case header.Name == bundleSignature:
+			raw, err := io.ReadAll(archive)
+			if err != nil {
+				return manifest, err
+			}
+			if signature, err = hex.DecodeString(string(bytes.TrimSpace(raw))); err != nil {
+				return manifest, ErrBundleTampered
+			}
+		case strings.HasPrefix(header.Name, bundleFilesPrefix):
+			hash := sha256.New()
+			if _, err = io.Copy(hash, archive); err != nil {
+				return manifest, err
+			}
+			checksums[strings.TrimPrefix(header.Name, bundleFilesPrefix)] = "sha256:" + hex.EncodeToString(hash.Sum(nil))
+		default:
+			return manifest, ErrBundleTampered
+		}
+	}
+	if encoded == nil || signature == nil || !ed25519.Verify(key, encoded, signature) {
+		return manifest, ErrBundleTampered
+	}
+	if err = json.Unmarshal(encoded, &manifest); err != nil {
+		return manifest, err
+	}
+	if len(manifest.Files) != len(checksums) {
+		return manifest, ErrBundleTampered
+	}
+	for _, file := range manifest.Files {
+		if checksums[file.Path] != file.Checksum {
+			return manifest, ErrBundleTampered
+		}
+	}
+	return manifest, nil
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestExportBundle(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewEncryptedStorage(tmpdir, getKey())
+	storage.WriteFile("account/a/snapshot", []byte("snapshot"))
+	storage.WriteFile("account/a/events/1", []byte("event"))
+	storage.WriteFile("account/b/snapshot", []byte("other"))
+
+	public, private, _ := ed25519.GenerateKey(nil)
+	signer := BundleSigner{KeyID: "auditor-2023", Key: private}
+
+	var bundle bytes.Buffer
+	err = BuildExportBundle(&bundle, storage, []string{"account/a"}, map[string]string{"case": "42"}, signer)
+	if err != nil {
+		t.Fatalf("unexpected error when calling BuildExportBundle %+v", err)
+	}
+
+	t.Log("verifies untouched bundle")
+	{
+		manifest, err := VerifyBundle(bytes.NewReader(bundle.Bytes()), public)
+		if err != nil {
+			t.Fatalf("unexpected error when calling VerifyBundle %+v", err)
+		}
+		if len(manifest.Files) != 2 || manifest.Files[0].Path != "account/a/events/1" {
+			t.Errorf("unexpected manifest files %+v", manifest.Files)
+		}
+		if manifest.Meta["case"] != "42" || manifest.SignedBy != "auditor-2023" {
+			t.Errorf("unexpected manifest %+v", manifest)
+		}
+		if manifest.Files[0].KeyID != storage.(EncryptedStorage).KeyID() {
+			t.Errorf("expected encryption key id in manifest")
+		}
+	}
+
+	t.Log("rejects other key")
+	{
+		other, _, _ := ed25519.GenerateKey(nil)
+		if _, err := VerifyBundle(bytes.NewReader(bundle.Bytes()), other); err != ErrBundleTampered {
+			t.Errorf("expected ErrBundleTampered got %+v", err)
+		}
+	}
+
+	t.Log("rejects modified file")
+	{
+		var tampered bytes.Buffer
+		gzr, _ := gzip.NewReader(bytes.NewReader(bundle.Bytes()))
+		reader := tar.NewReader(gzr)
+		gzw := gzip.NewWriter(&tampered)
+		writer := tar.NewWriter(gzw)
+		for {
+			header, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			data, _ := io.ReadAll(reader)
+			if header.Name == "files/account/a/snapshot" {
+				data = []byte("SNAPSHOT")
+			}
+			writer.WriteHeader(header)
+			writer.Write(data)
+		}
+		writer.Close()
+		gzw.Close()
+		if _, err := VerifyBundle(&tampered, public); err != ErrBundleTampered {
+			t.Errorf("expected ErrBundleTampered got %+v", err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"path/filepath"
+	"syscall"
+)
+
+// ChecksumAlgorithm selects hash computed by Checksum
+type ChecksumAlgorithm string
+
+const (
+	// ChecksumSHA256 is SHA-256 digest
+	ChecksumSHA256 ChecksumAlgorithm = "sha256"
+	// ChecksumCRC32 is IEEE CRC32 checksum
+	ChecksumCRC32 ChecksumAlgorithm = "crc32"
+)
+
+// newHash returns hash of algorithm
+func (algorithm ChecksumAlgorithm) newHash() (hash.Hash, error) {
+	switch algorithm {
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumCRC32:
+		return crc32.NewIEEE(), nil
+	default:
+		return nil, fmt.Errorf("unknown checksum algorithm %q", algorithm)
+	}
+}
+
+// Checksum returns "algorithm:hex" checksum of file streaming it with bounded
+// buffer, plaintext is hashed unless stored is requested in which case bytes
+// as stored on disk (ciphertext of encrypted storage) are hashed, stored
+// content is available only for local storages and ENOTSUP is returned
+// otherwise
+func Checksum(storage Storage, path string, algorithm ChecksumAlgorithm, stored bool) (string, error) {
+	digest, err := algorithm.newHash()
+	if err != nil {
+		return "", err
+	}
+	buffer := getScratch(8192)
+	defer putScratch(buffer)
+	if stored {
+		err = streamStored(storage, path, digest, *buffer)
+	} else {
+		err = streamFrom(storage, path, digest, *buffer)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(algorithm) + ":" + hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// streamStored writes content of file as stored on disk into w
+func streamStored(storage Storage, path string, w io.Writer, buffer []byte) error {
+	switch local := storage.(type) {
+	case PlaintextStorage:
+		return streamFrom(local, path, w, buffer)
+	case EncryptedStorage:
+		return withSharedLock(filepath.Clean(local.root+"/"+path), local.handles, local.noAtime, local.lockTimeout, func(fd int, size int64) error {
+			_, err := io.CopyBuffer(w, &fdReader{fd: fd, end: size}, buffer)
+			return err
+		})
+	default:
+		return syscall.ENOTSUP
+	}
+}
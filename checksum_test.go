@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/crc32"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	content := make([]byte, 100000)
+	rand.Read(content)
+	sum := sha256.Sum256(content)
+	expectedSHA := "sha256:" + hex.EncodeToString(sum[:])
+
+	plaintext, _ := NewPlaintextStorage(tmpdir + "/plaintext")
+	encrypted, _ := NewEncryptedStorageWithOptions(tmpdir+"/encrypted", getKey(), EncryptionOptions{HMAC: true})
+	chunked, _ := NewEncryptedStorageWithOptions(tmpdir+"/chunked", getKey(), EncryptionOptions{ChunkSize: 4096})
+
+	for name, storage := range map[string]Storage{"plaintext": plaintext, "encrypted": encrypted, "chunked": chunked} {
+		storage.WriteFile("snapshot", content)
+
+		t.Logf("%s checksum of plaintext", name)
+		{
+			if result, err := Checksum(storage, "snapshot", ChecksumSHA256, false); err != nil || result != expectedSHA {
+				t.Errorf("%s expected %s got %s %+v", name, expectedSHA, result, err)
+			}
+			if result, err := Checksum(storage, "snapshot", ChecksumCRC32, false); err != nil || len(result) != len("crc32:")+8 {
+				t.Errorf("%s unexpected crc32 %s %+v", name, result, err)
+			}
+		}
+
+		t.Logf("%s checksum of stored content", name)
+		{
+			raw, _ := os.ReadFile(tmpdir + "/" + name + "/snapshot")
+			crc := make([]byte, 4)
+			checksum := crc32.ChecksumIEEE(raw)
+			crc[0], crc[1], crc[2], crc[3] = byte(checksum>>24), byte(checksum>>16), byte(checksum>>8), byte(checksum)
+			if result, err := Checksum(storage, "snapshot", ChecksumCRC32, true); err != nil || result != "crc32:"+hex.EncodeToString(crc) {
+				t.Errorf("%s expected crc32 of stored bytes got %s %+v", name, result, err)
+			}
+		}
+
+		t.Logf("%s missing file", name)
+		{
+			if _, err := Checksum(storage, "missing", ChecksumSHA256, false); !os.IsNotExist(err) {
+				t.Errorf("%s expected not exist error got %+v", name, err)
+			}
+		}
+	}
+
+	t.Log("unknown algorithm")
+	{
+		if _, err := Checksum(plaintext, "snapshot", "md4", false); err == nil {
+			t.Errorf("expected error for unknown algorithm")
+		}
+	}
+
+	t.Log("stored content of decorated storage")
+	{
+		if _, err := Checksum(struct{ Storage }{plaintext}, "snapshot", ChecksumSHA256, true); err != syscall.ENOTSUP {
+			t.Errorf("expected ENOTSUP got %+v", err)
+		}
+		if result, err := Checksum(struct{ Storage }{plaintext}, "snapshot", ChecksumSHA256, false); err != nil || result != expectedSHA {
+			t.Errorf("expected %s got %s %+v", expectedSHA, result, err)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// chunkMagic starts header of chunked format, it follows key header, it is
+// long enough not to be mistaken for random IV of file in other format so
+// chunked files are recognised regardless of options storage was opened with
+const chunkMagic = "LFCHUNK\x01"
+
+// chunkSaltSize is length of random salt of chunked file, every file is
+// encrypted with its own key derived from storage key and salt so GCM nonce
+// is just chunk index and flag of final chunk and chunks cannot be reordered
+// or file truncated at chunk boundary
+const chunkSaltSize = 32
+
+// chunkKeyInfo binds derived keys to chunked format
+const chunkKeyInfo = "localfs chunked file key"
+
+// chunkOverhead is length of GCM tag of every chunk
+const chunkOverhead = 16
+
+// chunkHeaderSize is length of chunk magic, chunk size and salt
+const chunkHeaderSize = len(chunkMagic) + 4 + chunkSaltSize
+
+// maxChunkSize is largest plaintext size of single chunk
+const maxChunkSize = 1 << 30
+
+// SeekableReader reads plaintext of file at arbitrary offsets, it must be
+// closed to release lock and descriptor of file
+type SeekableReader interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+	// Size returns size of plaintext
+	Size() int64
+}
+
+// chunkLayout describes ciphertext of chunked file
+type chunkLayout struct {
+	// header is length of key header and chunk header
+	header int64
+	// chunkSize is plaintext size of every chunk but last
+	chunkSize int64
+	// salt is random salt of file key
+	salt []byte
+	// chunks is number of chunks, file has at least one
+	chunks int64
+	// size is plaintext size
+	size int64
+}
+
+// parseChunkLayout parses chunk header following key header of given length
+// in prefix of file, ok is false when file is not in chunked format
+func parseChunkLayout(prefix []byte, offset int, fileSize int64) (layout chunkLayout, ok bool, err error) {
+	data := prefix[offset:]
+	if len(data) < chunkHeaderSize || string(data[:len(chunkMagic)]) != chunkMagic {
+		return
+	}
+	ok = true
+	layout.header = int64(offset + chunkHeaderSize)
+	layout.chunkSize = int64(binary.LittleEndian.Uint32(data[len(chunkMagic):]))
+	layout.salt = append([]byte(nil), data[len(chunkMagic)+4:chunkHeaderSize]...)
+	body := fileSize - layout.header
+	stride := layout.chunkSize + chunkOverhead
+	if layout.chunkSize == 0 || layout.chunkSize > maxChunkSize || body < chunkOverhead {
+		err = ErrIntegrity
+		return
+	}
+	layout.chunks = (body + stride - 1) / stride
+	if body-(layout.chunks-1)*stride < chunkOverhead {
+		err = ErrIntegrity
+		return
+	}
+	layout.size = body - layout.chunks*chunkOverhead
+	return
+}
+
+// gcm returns AES-GCM keyed by key of file derived from storage key
+func (layout chunkLayout) gcm(key []byte) (cipher.AEAD, error) {
+	derived := make([]byte, len(key))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, layout.salt, []byte(chunkKeyInfo)), derived); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// nonce returns GCM nonce of chunk with given index
+func (layout chunkLayout) nonce(index int64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint32(nonce[7:], uint32(index))
+	if index == layout.chunks-1 {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// span returns offset and length of ciphertext of chunk with given index
+func (layout chunkLayout) span(index int64) (int64, int64) {
+	stride := layout.chunkSize + chunkOverhead
+	start := layout.header + index*stride
+	if index == layout.chunks-1 {
+		return start, layout.size - index*layout.chunkSize + chunkOverhead
+	}
+	return start, stride
+}
+
+// open decrypts chunk with given index appending plaintext to dst, failing
+// with ErrIntegrity when chunk was tampered with, moved or truncated
+func (layout chunkLayout) open(gcm cipher.AEAD, index int64, ciphertext []byte, ad []byte, dst []byte) ([]byte, error) {
+	plaintext, err := gcm.Open(dst, layout.nonce(index), ciphertext, ad)
+	if err != nil {
+		return nil, ErrIntegrity
+	}
+	return plaintext, nil
+}
+
+// sealChunks encrypts data into header with key header and chunk header and
+// AES-GCM chunks of chunkSize plaintext bytes, empty data has single empty
+// chunk so truncation to header is detected
+func sealChunks(key []byte, id string, offset int, chunkSize int, ad []byte, data []byte) ([][]byte, error) {
+	header := make([]byte, offset+chunkHeaderSize)
+	writeKeyHeader(header, id)
+	copy(header[offset:], chunkMagic)
+	binary.LittleEndian.PutUint32(header[offset+len(chunkMagic):], uint32(chunkSize))
+	salt := header[offset+len(chunkMagic)+4:]
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	chunks := (len(data) + chunkSize - 1) / chunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	layout := chunkLayout{salt: salt, chunks: int64(chunks)}
+	gcm, err := layout.gcm(key)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := make([]byte, 0, len(data)+chunks*chunkOverhead)
+	for i := 0; i < chunks; i++ {
+		start, end := i*chunkSize, (i+1)*chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		ciphertext = gcm.Seal(ciphertext, layout.nonce(int64(i)), data[start:end], ad)
+	}
+	return [][]byte{header, ciphertext}, nil
+}
+
+// openChunks decrypts whole chunked file
+func openChunks(key []byte, layout chunkLayout, data []byte, ad []byte) ([]byte, error) {
+	gcm, err := layout.gcm(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, 0, layout.size)
+	for i := int64(0); i < layout.chunks; i++ {
+		start, length := layout.span(i)
+		if plaintext, err = layout.open(gcm, i, data[start:start+length], ad, plaintext); err != nil {
+			return nil, err
+		}
+	}
+	return plaintext, nil
+}
+
+// chunkedReader reads chunked file decrypting only chunks covering requested
+// range, file is share locked until reader is closed
+type chunkedReader struct {
+	fd         int
+	filename   string
+	release    func()
+	gcm        cipher.AEAD
+	layout     chunkLayout
+	ad         []byte
+	offset     int64
+	mutex      sync.Mutex
+	cached     int64
+	plaintext  []byte
+	ciphertext []byte
+}
+
+// Size returns size of plaintext
+func (reader *chunkedReader) Size() int64 {
+	return reader.layout.size
+}
+
+// chunk returns plaintext of chunk with given index, last decrypted chunk is
+// kept so sequential reads decrypt every chunk once
+func (reader *chunkedReader) chunk(index int64) ([]byte, error) {
+	if index == reader.cached {
+		return reader.plaintext, nil
+	}
+	reader.cached = -1
+	start, length := reader.layout.span(index)
+	buf := reader.ciphertext[:length]
+	n, err := preadFull(reader.fd, buf, start)
+	if err != nil {
+		return nil, err
+	}
+	if int64(n) < length {
+		return nil, ErrIntegrity
+	}
+	plaintext, err := reader.layout.open(reader.gcm, index, buf, reader.ad, reader.plaintext[:0])
+	if err != nil {
+		return nil, err
+	}
+	reader.plaintext, reader.cached = plaintext, index
+	return plaintext, nil
+}
+
+// ReadAt reads plaintext at given offset, it is safe for concurrent use
+func (reader *chunkedReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, syscall.EINVAL
+	}
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+	if reader.release == nil {
+		return 0, os.ErrClosed
+	}
+	n := 0
+	for n < len(p) && off+int64(n) < reader.layout.size {
+		position := off + int64(n)
+		index := position / reader.layout.chunkSize
+		plaintext, err := reader.chunk(index)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], plaintext[position-index*reader.layout.chunkSize:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (reader *chunkedReader) Read(p []byte) (int, error) {
+	n, err := reader.ReadAt(p, reader.offset)
+	reader.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (reader *chunkedReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += reader.offset
+	case io.SeekEnd:
+		offset += reader.layout.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	reader.offset = offset
+	return offset, nil
+}
+
+// Close releases lock and descriptor of file
+func (reader *chunkedReader) Close() error {
+	reader.mutex.Lock()
+	defer reader.mutex.Unlock()
+	if reader.release == nil {
+		return os.ErrClosed
+	}
+	funlock(reader.fd, reader.filename)
+	reader.release()
+	reader.release = nil
+	return syscall.Close(reader.fd)
+}
+
+// bufferedSeekable is SeekableReader over plaintext of file not in chunked
+// format decrypted as whole
+type bufferedSeekable struct {
+	*bytes.Reader
+}
+
+func (bufferedSeekable) Close() error {
+	return nil
+}
+
+// chunkAD returns associated data of chunks of file, path is bound in AEAD
+// mode
+func (storage EncryptedStorage) chunkAD(path string) []byte {
+	if storage.aead {
+		return associatedData(path)
+	}
+	return nil
+}
+
+// openChunked opens chunked file for reading under shared lock, ok is false
+// when file is not in chunked format
+func (storage EncryptedStorage) openChunked(path string) (*chunkedReader, bool, error) {
+	filename := filepath.Clean(storage.root + "/" + path)
+	fd, _, err := openFile(filename, syscall.O_RDONLY|syscall.O_NONBLOCK, 0600, false, storage.noAtime)
+	if err != nil {
+		return nil, false, err
+	}
+	release := storage.handles.track(filename, "seekable")
+	reader, ok, err := func() (*chunkedReader, bool, error) {
+		if err := flock(fd, filename, syscall.LOCK_SH, storage.lockTimeout); err != nil {
+			return nil, false, err
+		}
+		var fs syscall.Stat_t
+		if err := syscall.Fstat(fd, &fs); err != nil {
+			funlock(fd, filename)
+			return nil, false, err
+		}
+		prefix, err := preadRange(fd, fs.Size, 0, int64(len(keyHeaderMagic)+256+chunkHeaderSize))
+		if err != nil {
+			funlock(fd, filename)
+			return nil, false, err
+		}
+		offset, _, key := storage.header(prefix)
+		layout, ok, err := parseChunkLayout(prefix, offset, fs.Size)
+		if !ok || err != nil {
+			funlock(fd, filename)
+			return nil, ok, err
+		}
+		gcm, err := layout.gcm(key)
+		if err != nil {
+			funlock(fd, filename)
+			return nil, false, err
+		}
+		return &chunkedReader{
+			fd:         fd,
+			filename:   filename,
+			release:    release,
+			gcm:        gcm,
+			layout:     layout,
+			ad:         storage.chunkAD(path),
+			cached:     -1,
+			plaintext:  make([]byte, 0, layout.chunkSize),
+			ciphertext: make([]byte, layout.chunkSize+chunkOverhead),
+		}, true, nil
+	}()
+	if !ok || err != nil {
+		release()
+		syscall.Close(fd)
+	}
+	return reader, ok, err
+}
+
+// GetSeekableReader returns reader of plaintext of file supporting Seek and
+// ReadAt, file in chunked format (see ChunkSize of EncryptionOptions) is
+// share locked until reader is closed and only chunks covering read range
+// are read and decrypted, other files are decrypted whole
+func (storage EncryptedStorage) GetSeekableReader(path string) (SeekableReader, error) {
+	reader, ok, err := storage.openChunked(path)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return reader, nil
+	}
+	data, err := storage.ReadFileFully(path)
+	if err != nil {
+		return nil, err
+	}
+	return bufferedSeekable{bytes.NewReader(data)}, nil
+}
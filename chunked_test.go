@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestChunkedEncrypted(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, err := NewEncryptedStorageWithOptions(tmpdir, getKey(), EncryptionOptions{ChunkSize: 64})
+	if err != nil {
+		t.Fatalf("unexpected error when creating storage %+v", err)
+	}
+	encrypted := storage.(EncryptedStorage)
+
+	content := make([]byte, 1000)
+	rand.Read(content)
+
+	t.Log("roundtrip")
+	for _, size := range []int{0, 1, 63, 64, 65, 128, 1000} {
+		if err := storage.WriteFile("journal", content[:size]); err != nil {
+			t.Fatalf("unexpected error when calling WriteFile %+v", err)
+		}
+		data, err := storage.ReadFileFully("journal")
+		if err != nil || !bytes.Equal(data, content[:size]) {
+			t.Errorf("size %d expected roundtrip got %d bytes %+v", size, len(data), err)
+		}
+		if length, err := storage.FileSize("journal"); err != nil || length != int64(size) {
+			t.Errorf("size %d expected FileSize to match got %d %+v", size, length, err)
+		}
+	}
+
+	t.Log("seekable reader")
+	{
+		reader, err := encrypted.GetSeekableReader("journal")
+		if err != nil {
+			t.Fatalf("unexpected error when calling GetSeekableReader %+v", err)
+		}
+		if reader.Size() != int64(len(content)) {
+			t.Errorf("expected size %d got %d", len(content), reader.Size())
+		}
+		buf := make([]byte, 100)
+		if n, err := reader.ReadAt(buf, 60); err != nil || !bytes.Equal(buf[:n], content[60:160]) {
+			t.Errorf("expected range across chunks got %d %+v", n, err)
+		}
+		if n, err := reader.ReadAt(buf, 950); err != io.EOF || !bytes.Equal(buf[:n], content[950:]) {
+			t.Errorf("expected tail and io.EOF got %d %+v", n, err)
+		}
+		if _, err := reader.Seek(-10, io.SeekEnd); err != nil {
+			t.Errorf("unexpected error when calling Seek %+v", err)
+		}
+		if rest, err := io.ReadAll(reader); err != nil || !bytes.Equal(rest, content[990:]) {
+			t.Errorf("expected last 10 bytes got %d %+v", len(rest), err)
+		}
+		if err := reader.Close(); err != nil {
+			t.Errorf("unexpected error when calling Close %+v", err)
+		}
+		if err := reader.Close(); err != os.ErrClosed {
+			t.Errorf("expected os.ErrClosed on second Close got %+v", err)
+		}
+	}
+
+	t.Log("detects tampered chunk")
+	{
+		raw, _ := os.ReadFile(tmpdir + "/journal")
+		tampered := append([]byte(nil), raw...)
+		tampered[chunkHeaderSize+200] ^= 0x01
+		os.WriteFile(tmpdir+"/tampered", tampered, 0600)
+		if _, err := storage.ReadFileFully("tampered"); err != ErrIntegrity {
+			t.Errorf("expected ErrIntegrity got %+v", err)
+		}
+		if _, err := ReadFileRange(storage, "tampered", 0, 10); err != nil {
+			t.Errorf("expected untouched chunk to be readable got %+v", err)
+		}
+		if _, err := ReadFileRange(storage, "tampered", 150, 10); err != ErrIntegrity {
+			t.Errorf("expected ErrIntegrity got %+v", err)
+		}
+	}
+
+	t.Log("detects truncation at chunk boundary")
+	{
+		raw, _ := os.ReadFile(tmpdir + "/journal")
+		os.WriteFile(tmpdir+"/truncated", raw[:chunkHeaderSize+2*(64+chunkOverhead)], 0600)
+		if _, err := storage.ReadFileFully("truncated"); err != ErrIntegrity {
+			t.Errorf("expected ErrIntegrity got %+v", err)
+		}
+	}
+
+	t.Log("reads files not in chunked format")
+	{
+		legacy, _ := NewEncryptedStorage(tmpdir, getKey())
+		legacy.WriteFile("legacy", content)
+		data, err := storage.ReadFileFully("legacy")
+		if err != nil || !bytes.Equal(data, content) {
+			t.Errorf("expected legacy file to be readable got %+v", err)
+		}
+		reader, err := encrypted.GetSeekableReader("legacy")
+		if err != nil {
+			t.Fatalf("unexpected error when calling GetSeekableReader %+v", err)
+		}
+		defer reader.Close()
+		buf := make([]byte, 10)
+		if _, err := reader.ReadAt(buf, 500); err != nil || !bytes.Equal(buf, content[500:510]) {
+			t.Errorf("expected range of legacy file got %+v", err)
+		}
+	}
+
+	t.Log("chunked files are readable without chunk size option")
+	{
+		storage.WriteFile("journal", content)
+		plain, _ := NewEncryptedStorage(tmpdir, getKey())
+		if data, err := plain.ReadFileFully("journal"); err != nil || !bytes.Equal(data, content) {
+			t.Errorf("expected chunked file to be readable got %+v", err)
+		}
+		if size, err := plain.FileSize("journal"); err != nil || size != int64(len(content)) {
+			t.Errorf("expected plaintext size %d got %d %+v", len(content), size, err)
+		}
+		if data, err := plain.(EncryptedStorage).ReadFileRange("journal", 100, 10); err != nil || !bytes.Equal(data, content[100:110]) {
+			t.Errorf("expected range of chunked file got %+v", err)
+		}
+	}
+
+	t.Log("every file has own salt")
+	{
+		storage.WriteFile("a", content)
+		storage.WriteFile("b", content)
+		a, _ := os.ReadFile(tmpdir + "/a")
+		b, _ := os.ReadFile(tmpdir + "/b")
+		if bytes.Equal(a[:chunkHeaderSize], b[:chunkHeaderSize]) || bytes.Equal(a[chunkHeaderSize:], b[chunkHeaderSize:]) {
+			t.Errorf("expected files with same content to differ in salt and ciphertext")
+		}
+	}
+
+	t.Log("rejects invalid chunk size")
+	{
+		if _, err := NewEncryptedStorageWithOptions(tmpdir, getKey(), EncryptionOptions{ChunkSize: -1}); err == nil {
+			t.Errorf("expected error for negative chunk size")
+		}
+	}
+}
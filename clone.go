@@ -0,0 +1,74 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// temporaries counts temporary names so goroutines asking within same clock
+// tick get distinct names
+var temporaries uint64
+
+// temporarySibling returns unique name of temporary file next to filename,
+// name carries process id so processes sharing root never collide
+func temporarySibling(filename string, kind string) string {
+	return filename + "." + kind + "-" + strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.Itoa(os.Getpid()) + "-" + strconv.FormatUint(atomic.AddUint64(&temporaries, 1), 36)
+}
+
+// cloneFile copies source to target through temporary file renamed into
+// place so target appears complete, returns true when copy was reflink
+func cloneFile(source string, target string, bufferSize int, handles *handleRegistry, timeout time.Duration) (bool, error) {
+	temporary := temporarySibling(target, "clone")
+	method, err := copyFile(source, temporary, bufferSize, handles, timeout)
+	if err != nil {
+		os.Remove(temporary)
+		return false, err
+	}
+	if err = os.Rename(temporary, target); err != nil {
+		os.Remove(temporary)
+		return false, err
+	}
+	return method == copyReflink, nil
+}
+
+// CloneFile creates copy-on-write snapshot of file with FICLONE which is
+// instant regardless of file size, falling back to normal copy elsewhere,
+// target appears atomically and true is returned when it shares blocks with
+// source
+func (storage PlaintextStorage) CloneFile(source string, target string) (bool, error) {
+	defer storage.barrier.enter()()
+	return cloneFile(filepath.Clean(storage.root+"/"+source), filepath.Clean(storage.root+"/"+target), storage.bufferSize, storage.handles, storage.lockTimeout)
+}
+
+// CloneFile creates copy-on-write snapshot of encrypted file with FICLONE
+// falling back to normal copy, in AEAD mode ciphertext is bound to its path
+// so file is re-encrypted for target instead, target appears atomically and
+// true is returned when it shares blocks with source
+func (storage EncryptedStorage) CloneFile(source string, target string) (bool, error) {
+	if storage.aead {
+		data, err := storage.ReadFileFully(source)
+		if err != nil {
+			return false, err
+		}
+		return false, storage.WriteFile(target, data)
+	}
+	defer storage.barrier.enter()()
+	return cloneFile(filepath.Clean(storage.root+"/"+source), filepath.Clean(storage.root+"/"+target), storage.bufferSize, storage.handles, storage.lockTimeout)
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestCloneFile(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	data := bytes.Repeat([]byte("account"), 1000)
+
+	t.Log("reflink")
+	{
+		ioctlFileClone = func(destFd int, srcFd int) error {
+			return nil
+		}
+		storage, _ := NewPlaintextStorage(tmpdir + "/reflink")
+		storage.WriteFile("account/snapshot", data)
+		cloned, err := storage.(PlaintextStorage).CloneFile("account/snapshot", "account/snapshot.1")
+		ioctlFileClone = defaultIoctlFileClone
+		if err != nil || !cloned {
+			t.Errorf("expected reflink clone got %v %+v", cloned, err)
+		}
+	}
+
+	t.Log("falls back to copy")
+	{
+		ioctlFileClone = func(destFd int, srcFd int) error {
+			return syscall.EOPNOTSUPP
+		}
+		defer func() {
+			ioctlFileClone = defaultIoctlFileClone
+		}()
+		storage, _ := NewPlaintextStorage(tmpdir + "/copy")
+		storage.WriteFile("account/snapshot", data)
+		cloned, err := storage.(PlaintextStorage).CloneFile("account/snapshot", "other/snapshot")
+		if err != nil || cloned {
+			t.Fatalf("expected copy got %v %+v", cloned, err)
+		}
+		if copied, _ := storage.ReadFileFully("other/snapshot"); !bytes.Equal(copied, data) {
+			t.Errorf("expected clone to equal source")
+		}
+		entries, _ := storage.ListDirectory("other", true)
+		if len(entries) != 1 {
+			t.Errorf("expected no temporary files left got %+v", entries)
+		}
+	}
+
+	t.Log("AEAD re-encrypts for target path")
+	{
+		storage, _ := NewEncryptedStorageWithOptions(tmpdir+"/aead", getKey(), EncryptionOptions{AEAD: true})
+		storage.WriteFile("account/snapshot", data)
+		if _, err := storage.(EncryptedStorage).CloneFile("account/snapshot", "account/snapshot.1"); err != nil {
+			t.Fatalf("unexpected error when calling CloneFile %+v", err)
+		}
+		if copied, err := storage.ReadFileFully("account/snapshot.1"); err != nil || !bytes.Equal(copied, data) {
+			t.Errorf("expected clone to be readable got %+v", err)
+		}
+	}
+
+	t.Log("missing source")
+	{
+		storage, _ := NewPlaintextStorage(tmpdir + "/missing")
+		if _, err := storage.(PlaintextStorage).CloneFile("missing", "target"); !os.IsNotExist(err) {
+			t.Errorf("expected not exist error got %+v", err)
+		}
+	}
+}
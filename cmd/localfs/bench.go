@@ -0,0 +1,52 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jancajthaml-openbank/local-fs/bench"
+)
+
+func benchCommand(args []string) error {
+	var (
+		config bench.Config
+		sizes  string
+	)
+	set := flag.NewFlagSet("bench", flag.ExitOnError)
+	set.StringVar(&config.Root, "root", "", "directory where benchmark directories are created")
+	set.StringVar(&sizes, "sizes", "10000,100000,1000000,10000000", "comma separated numbers of entries")
+	set.DurationVar(&config.Duration, "duration", 0, "minimal time spent measuring each operation")
+	set.BoolVar(&config.Keep, "keep", false, "keep populated directories for subsequent runs")
+	set.Parse(args)
+	for _, size := range strings.Split(sizes, ",") {
+		entries, err := strconv.Atoi(strings.TrimSpace(size))
+		if err != nil {
+			return err
+		}
+		config.Sizes = append(config.Sizes, entries)
+	}
+	results, err := bench.Run(config)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
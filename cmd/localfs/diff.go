@@ -0,0 +1,58 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	localfs "github.com/jancajthaml-openbank/local-fs"
+)
+
+func diffCommand(args []string) error {
+	var left, right storageFlags
+	set := flag.NewFlagSet("diff", flag.ExitOnError)
+	left.register(set)
+	set.StringVar(&right.root, "against", "", "root directory of storage to compare against")
+	set.StringVar(&right.keyFile, "against-key", "", "file with hex encoded encryption key of storage to compare against")
+	set.Parse(args)
+	path := "."
+	if set.NArg() > 0 {
+		path = set.Arg(0)
+	}
+	a, err := left.open()
+	if err != nil {
+		return err
+	}
+	b, err := right.open()
+	if err != nil {
+		return err
+	}
+	report, err := localfs.Diff(a, b, path)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(report); err != nil {
+		return err
+	}
+	if !report.Identical() {
+		return fmt.Errorf("trees differ")
+	}
+	return nil
+}
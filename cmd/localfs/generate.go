@@ -0,0 +1,52 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/jancajthaml-openbank/local-fs/loadgen"
+)
+
+func generateCommand(args []string) error {
+	var (
+		flags  storageFlags
+		config loadgen.Config
+		event  int
+		snap   int
+	)
+	set := flag.NewFlagSet("generate", flag.ExitOnError)
+	flags.register(set)
+	set.IntVar(&config.Tenants, "tenants", 1, "number of tenants")
+	set.IntVar(&config.Accounts, "accounts", 100, "number of accounts per tenant")
+	set.IntVar(&config.Events, "events", 10, "number of events per account")
+	set.IntVar(&snap, "snapshot-size", 512, "median snapshot size in bytes")
+	set.IntVar(&event, "event-size", 64, "median event size in bytes")
+	set.Int64Var(&config.Seed, "seed", 0, "random seed")
+	set.Parse(args)
+	config.SnapshotSize = loadgen.LogNormal{Median: snap, Sigma: 0.5}
+	config.EventSize = loadgen.LogNormal{Median: event, Sigma: 0.3}
+	target, err := flags.open()
+	if err != nil {
+		return err
+	}
+	report, err := loadgen.Populate(target, config)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("generated %d files, %d bytes in %v\n", report.Files, report.Bytes, report.Duration)
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	localfs "github.com/jancajthaml-openbank/local-fs"
+)
+
+type inspector interface {
+	Inspect(string) (localfs.Inspection, error)
+}
+
+func inspectCommand(args []string) error {
+	var flags storageFlags
+	set := flag.NewFlagSet("inspect", flag.ExitOnError)
+	flags.register(set)
+	set.Parse(args)
+	if set.NArg() == 0 {
+		return fmt.Errorf("no path given")
+	}
+	storage, err := flags.open()
+	if err != nil {
+		return err
+	}
+	subject, ok := storage.(inspector)
+	if !ok {
+		return fmt.Errorf("storage does not support inspection")
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	for _, path := range set.Args() {
+		result, err := subject.Inspect(path)
+		if err != nil {
+			return err
+		}
+		if err = encoder.Encode(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
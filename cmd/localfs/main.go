@@ -0,0 +1,61 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type command func(args []string) error
+
+var commands = map[string]command{
+	"inspect":  inspectCommand,
+	"generate": generateCommand,
+	"bench":    benchCommand,
+	"serve":    serveCommand,
+	"mount":    mountCommand,
+	"verify":   verifyCommand,
+	"diff":     diffCommand,
+	"repair":   repairCommand,
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: localfs <command> [flags] [args]\n\ncommands:\n")
+	fmt.Fprintf(os.Stderr, "  inspect   print everything known about files\n")
+	fmt.Fprintf(os.Stderr, "  generate  populate storage with openbank shaped tree\n")
+	fmt.Fprintf(os.Stderr, "  bench     measure operations over large directories\n")
+	fmt.Fprintf(os.Stderr, "  serve     expose storage over gRPC\n")
+	fmt.Fprintf(os.Stderr, "  mount     mount storage as plaintext FUSE filesystem\n")
+	fmt.Fprintf(os.Stderr, "  verify    scan files for unreadable or corrupted content\n")
+	fmt.Fprintf(os.Stderr, "  diff      compare files of two storages\n")
+	fmt.Fprintf(os.Stderr, "  repair    check and repair control directory\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %+v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
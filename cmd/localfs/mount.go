@@ -0,0 +1,60 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || darwin
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jancajthaml-openbank/local-fs/fusefs"
+)
+
+func mountCommand(args []string) error {
+	var (
+		flags   storageFlags
+		options fusefs.Options
+	)
+	set := flag.NewFlagSet("mount", flag.ExitOnError)
+	flags.register(set)
+	set.BoolVar(&options.ReadOnly, "ro", false, "mount read only")
+	set.BoolVar(&options.AllowOther, "allow-other", false, "allow other users to access mount")
+	set.BoolVar(&options.Debug, "debug", false, "log every FUSE request")
+	set.Parse(args)
+	if set.NArg() != 1 {
+		return fmt.Errorf("expected mount point")
+	}
+	target, err := flags.open()
+	if err != nil {
+		return err
+	}
+	server, err := fusefs.Mount(target, set.Arg(0), options)
+	if err != nil {
+		return err
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		server.Unmount()
+	}()
+	fmt.Printf("mounted %s at %s\n", flags.root, set.Arg(0))
+	server.Wait()
+	return nil
+}
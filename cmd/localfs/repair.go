@@ -0,0 +1,62 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	localfs "github.com/jancajthaml-openbank/local-fs"
+)
+
+func repairCommand(args []string) error {
+	var flags storageFlags
+	set := flag.NewFlagSet("repair", flag.ExitOnError)
+	flags.register(set)
+	check := set.Bool("check", false, "only check control directory")
+	set.Parse(args)
+	storage, err := flags.open()
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if *check {
+		report, err := localfs.CheckControl(storage)
+		if err != nil {
+			return err
+		}
+		if err = encoder.Encode(report); err != nil {
+			return err
+		}
+		if !report.Healthy() {
+			return fmt.Errorf("%d missing, %d corrupted and %d stale artifacts", len(report.Missing), len(report.Corrupted), len(report.Stale))
+		}
+		return nil
+	}
+	report, err := localfs.Repair(storage)
+	if err != nil {
+		return err
+	}
+	if err = encoder.Encode(report); err != nil {
+		return err
+	}
+	if len(report.Unrepairable) > 0 {
+		return fmt.Errorf("%d unrepairable artifacts", len(report.Unrepairable))
+	}
+	return nil
+}
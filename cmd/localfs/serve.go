@@ -0,0 +1,47 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+
+	"github.com/jancajthaml-openbank/local-fs/remote"
+	"google.golang.org/grpc"
+)
+
+func serveCommand(args []string) error {
+	var (
+		flags  storageFlags
+		listen string
+	)
+	set := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.register(set)
+	set.StringVar(&listen, "listen", "127.0.0.1:7070", "address to listen on")
+	set.Parse(args)
+	target, err := flags.open()
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer()
+	remote.RegisterStorageServer(server, target)
+	fmt.Printf("serving storage over gRPC on %s\n", listener.Addr())
+	return server.Serve(listener)
+}
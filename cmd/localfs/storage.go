@@ -0,0 +1,60 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"os"
+
+	localfs "github.com/jancajthaml-openbank/local-fs"
+)
+
+type storageFlags struct {
+	root           string
+	keyFile        string
+	passphraseFile string
+}
+
+func (flags *storageFlags) register(set *flag.FlagSet) {
+	set.StringVar(&flags.root, "root", "", "storage root directory")
+	set.StringVar(&flags.keyFile, "key", "", "file with hex encoded encryption key")
+	set.StringVar(&flags.passphraseFile, "passphrase", "", "file with passphrase to derive encryption key from")
+}
+
+func (flags *storageFlags) open() (localfs.Storage, error) {
+	if flags.passphraseFile != "" {
+		passphrase, err := os.ReadFile(flags.passphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		return localfs.NewEncryptedStorageFromPassphrase(flags.root, bytes.TrimSpace(passphrase))
+	}
+	if flags.keyFile == "" {
+		return localfs.NewPlaintextStorage(flags.root)
+	}
+	raw, err := os.ReadFile(flags.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	raw = bytes.TrimSpace(raw)
+	key := make([]byte, hex.DecodedLen(len(raw)))
+	n, err := hex.Decode(key, raw)
+	if err != nil {
+		return nil, err
+	}
+	return localfs.NewEncryptedStorage(flags.root, key[:n])
+}
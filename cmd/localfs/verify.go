@@ -0,0 +1,69 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	localfs "github.com/jancajthaml-openbank/local-fs"
+)
+
+type verifier interface {
+	Verify(string, ...localfs.ScanOption) (localfs.VerifyReport, error)
+}
+
+func verifyCommand(args []string) error {
+	var flags storageFlags
+	set := flag.NewFlagSet("verify", flag.ExitOnError)
+	flags.register(set)
+	readahead := set.Bool("readahead", false, "advise kernel files are read sequentially")
+	dontNeed := set.Bool("dontneed", false, "drop page cache of verified files")
+	set.Parse(args)
+	prefix := "."
+	if set.NArg() > 0 {
+		prefix = set.Arg(0)
+	}
+	storage, err := flags.open()
+	if err != nil {
+		return err
+	}
+	subject, ok := storage.(verifier)
+	if !ok {
+		return fmt.Errorf("storage does not support verification")
+	}
+	options := make([]localfs.ScanOption, 0, 2)
+	if *readahead {
+		options = append(options, localfs.WithReadahead())
+	}
+	if *dontNeed {
+		options = append(options, localfs.WithDontNeed())
+	}
+	report, err := subject.Verify(prefix, options...)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(report); err != nil {
+		return err
+	}
+	if !report.Healthy() {
+		return fmt.Errorf("%d unreadable and %d corrupted files", len(report.Unreadable), len(report.Corrupted))
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCodec(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	type account struct {
+		Name    string
+		Balance int64
+	}
+
+	storage, _ := NewEncryptedStorage(tmpdir, getKey())
+
+	for name, codec := range map[string]Codec{"json": JSONCodec, "gob": GobCodec} {
+		t.Logf("%s roundtrip", name)
+		{
+			if err := WriteValue(storage, name, codec, account{Name: "A", Balance: 100}); err != nil {
+				t.Fatalf("unexpected error when calling WriteValue %+v", err)
+			}
+			var result account
+			if err := ReadValue(storage, name, codec, &result); err != nil || result.Name != "A" || result.Balance != 100 {
+				t.Errorf("expected account A with balance 100 got %+v %+v", result, err)
+			}
+			if err := WriteValueExclusive(storage, name, codec, account{}); err == nil {
+				t.Errorf("expected error when file already exists")
+			}
+		}
+	}
+
+	t.Log("protobuf roundtrip")
+	{
+		if err := WriteValue(storage, "protobuf", ProtobufCodec, wrapperspb.String("balance 100")); err != nil {
+			t.Fatalf("unexpected error when calling WriteValue %+v", err)
+		}
+		result := new(wrapperspb.StringValue)
+		if err := ReadValue(storage, "protobuf", ProtobufCodec, result); err != nil || result.Value != "balance 100" {
+			t.Errorf("expected balance 100 got %+v %+v", result, err)
+		}
+	}
+
+	t.Log("protobuf rejects non message")
+	{
+		if err := WriteValue(storage, "invalid", ProtobufCodec, account{}); err == nil {
+			t.Errorf("expected error for value not implementing proto.Message")
+		}
+		var result account
+		if err := ReadValue(storage, "protobuf", ProtobufCodec, &result); err == nil {
+			t.Errorf("expected error for value not implementing proto.Message")
+		}
+	}
+}
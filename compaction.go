@@ -0,0 +1,257 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// CompactionPolicy selects directories and files packed by Compactor
+type CompactionPolicy struct {
+	// Prefix is subtree evaluated by policy
+	Prefix string
+	// MinAge is minimal time since last change of directory for it to be
+	// compacted
+	MinAge time.Duration
+	// MaxFileSize packs only files smaller than given size, zero means any
+	// size
+	MaxFileSize int64
+	// TargetPackSize stops packing files into directory pack once it would
+	// grow beyond given size, zero means unlimited
+	TargetPackSize int64
+}
+
+// CompactionMetrics represents counters of compactor
+type CompactionMetrics struct {
+	Runs        uint64    `json:"runs"`
+	Directories uint64    `json:"directories"`
+	FilesPacked uint64    `json:"filesPacked"`
+	InodesSaved int64     `json:"inodesSaved"`
+	BytesSaved  int64     `json:"bytesSaved"`
+	Failed      uint64    `json:"failed"`
+	LastRun     time.Time `json:"lastRun"`
+}
+
+// Compactor packs small files of cold directories according to policies, it
+// is maintenance task meant to be registered to Scheduler
+type Compactor struct {
+	storage  PackedStorage
+	root     string
+	policies []CompactionPolicy
+	mutex    sync.Mutex
+	metrics  CompactionMetrics
+}
+
+// NewCompactor returns compactor over packed storage evaluating given
+// policies
+func NewCompactor(storage Storage, policies ...CompactionPolicy) (*Compactor, error) {
+	var packed PackedStorage
+	found := false
+	for candidate := storage; candidate != nil && !found; {
+		if packed, found = candidate.(PackedStorage); found {
+			break
+		}
+		decorator, ok := candidate.(wrapper)
+		if !ok {
+			break
+		}
+		candidate = decorator.unwrap()
+	}
+	if !found {
+		return nil, fmt.Errorf("compaction requires packed storage")
+	}
+	root, ok := rootOf(packed)
+	if !ok {
+		return nil, fmt.Errorf("compaction requires local storage")
+	}
+	return &Compactor{
+		storage:  packed,
+		root:     filepath.Clean(root),
+		policies: policies,
+	}, nil
+}
+
+// Name returns name of compactor as maintenance task
+func (compactor *Compactor) Name() string {
+	return "compaction"
+}
+
+// Metrics returns counters of compactor
+func (compactor *Compactor) Metrics() CompactionMetrics {
+	compactor.mutex.Lock()
+	defer compactor.mutex.Unlock()
+	return compactor.metrics
+}
+
+// Run evaluates every policy once and packs selected files
+func (compactor *Compactor) Run() error {
+	var (
+		now      = time.Now()
+		metrics  CompactionMetrics
+		visited  = make(map[string]bool)
+		firstErr error
+	)
+	for _, policy := range compactor.policies {
+		base := filepath.Clean(compactor.root + "/" + policy.Prefix)
+		err := filepath.WalkDir(base, func(absPath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if absPath == base {
+					return nil
+				}
+				return err
+			}
+			if !entry.IsDir() {
+				return nil
+			}
+			if absPath != base && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			relPath, err := filepath.Rel(compactor.root, absPath)
+			if err != nil {
+				return err
+			}
+			if visited[relPath] {
+				return nil
+			}
+			visited[relPath] = true
+			if err := compactor.compact(relPath, policy, now, &metrics); err != nil {
+				metrics.Failed++
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+			return nil
+		})
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	compactor.mutex.Lock()
+	compactor.metrics.Runs++
+	compactor.metrics.Directories += metrics.Directories
+	compactor.metrics.FilesPacked += metrics.FilesPacked
+	compactor.metrics.InodesSaved += metrics.InodesSaved
+	compactor.metrics.BytesSaved += metrics.BytesSaved
+	compactor.metrics.Failed += metrics.Failed
+	compactor.metrics.LastRun = now
+	compactor.mutex.Unlock()
+
+	return firstErr
+}
+
+// diskUsage returns number of entries and bytes allocated by files of
+// directory
+func diskUsage(absPath string) (int64, int64, error) {
+	entries, err := os.ReadDir(absPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	var inodes, bytes int64
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		inodes++
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			bytes += stat.Blocks * 512
+		} else {
+			bytes += info.Size()
+		}
+	}
+	return inodes, bytes, nil
+}
+
+// compact packs loose files of directory selected by policy
+func (compactor *Compactor) compact(dir string, policy CompactionPolicy, now time.Time, metrics *CompactionMetrics) error {
+	absPath := filepath.Clean(compactor.root + "/" + dir)
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return err
+	}
+	if now.Sub(info.ModTime()) < policy.MinAge {
+		return nil
+	}
+	entries, err := os.ReadDir(absPath)
+	if err != nil {
+		return err
+	}
+	var (
+		packSize  int64
+		selected  = make([]string, 0, len(entries))
+		inspected = make([]os.FileInfo, 0, len(entries))
+	)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if entry.Name() == PackName {
+			packSize = info.Size()
+			continue
+		}
+		// directory is cold only when none of its files changed recently
+		if now.Sub(info.ModTime()) < policy.MinAge {
+			return nil
+		}
+		inspected = append(inspected, info)
+	}
+	for _, info := range inspected {
+		if policy.MaxFileSize > 0 && info.Size() >= policy.MaxFileSize {
+			continue
+		}
+		if policy.TargetPackSize > 0 && packSize+info.Size() > policy.TargetPackSize {
+			continue
+		}
+		packSize += info.Size()
+		selected = append(selected, info.Name())
+	}
+	if len(selected) == 0 {
+		return nil
+	}
+	inodesBefore, bytesBefore, err := diskUsage(absPath)
+	if err != nil {
+		return err
+	}
+	if dir == "." {
+		dir = ""
+	}
+	if err = compactor.storage.packFiles(dir, selected); err != nil {
+		return err
+	}
+	inodesAfter, bytesAfter, err := diskUsage(absPath)
+	if err != nil {
+		return err
+	}
+	metrics.Directories++
+	metrics.FilesPacked += uint64(len(selected))
+	metrics.InodesSaved += inodesBefore - inodesAfter
+	metrics.BytesSaved += bytesBefore - bytesAfter
+	return nil
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompactor(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	underlying, _ := NewPlaintextStorage(tmpdir)
+	storage := NewPackedStorage(underlying)
+
+	old := time.Now().Add(-48 * time.Hour)
+	for i := 0; i < 10; i++ {
+		storage.WriteFile(fmt.Sprintf("cold/%02d", i), []byte(fmt.Sprintf("event %d", i)))
+		storage.WriteFile(fmt.Sprintf("hot/%02d", i), []byte(fmt.Sprintf("event %d", i)))
+	}
+	storage.WriteFile("cold/large", make([]byte, 4096))
+	for _, dir := range []string{"cold", "hot"} {
+		entries, _ := os.ReadDir(filepath.Join(tmpdir, dir))
+		for _, entry := range entries {
+			os.Chtimes(filepath.Join(tmpdir, dir, entry.Name()), old, old)
+		}
+		os.Chtimes(filepath.Join(tmpdir, dir), old, old)
+	}
+	os.Chtimes(filepath.Join(tmpdir, "hot", "05"), time.Now(), time.Now())
+
+	compactor, err := NewCompactor(storage, CompactionPolicy{
+		MinAge:         24 * time.Hour,
+		MaxFileSize:    1024,
+		TargetPackSize: 1024,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error when calling NewCompactor %+v", err)
+	}
+
+	scheduler := NewScheduler(time.Hour)
+	scheduler.Register(compactor)
+	if err := scheduler.RunOnce(); err != nil {
+		t.Fatalf("unexpected error when calling RunOnce %+v", err)
+	}
+
+	t.Log("packs small files of cold directories only")
+	{
+		raw, _ := underlying.ListDirectory("cold", true)
+		if len(raw) != 2 || raw[0] != PackName || raw[1] != "large" {
+			t.Errorf("expected pack and large file on disk got %+v", raw)
+		}
+		raw, _ = underlying.ListDirectory("hot", true)
+		if len(raw) != 10 {
+			t.Errorf("expected hot directory untouched got %+v", raw)
+		}
+		data, err := storage.ReadFileFully("cold/07")
+		if err != nil || string(data) != "event 7" {
+			t.Errorf("expected event 7 got %s %+v", string(data), err)
+		}
+	}
+
+	t.Log("reports savings")
+	{
+		metrics := compactor.Metrics()
+		if metrics.Runs != 1 || metrics.Directories != 1 || metrics.FilesPacked != 10 || metrics.InodesSaved != 9 {
+			t.Errorf("unexpected metrics %+v", metrics)
+		}
+		if metrics.BytesSaved <= 0 {
+			t.Errorf("expected positive space savings got %d", metrics.BytesSaved)
+		}
+		status := scheduler.Status()
+		if len(status) != 1 || status[0].Name != "compaction" || status[0].Runs != 1 || status[0].Failures != 0 {
+			t.Errorf("unexpected scheduler status %+v", status)
+		}
+	}
+
+	t.Log("respects target pack size")
+	{
+		for i := 0; i < 100; i++ {
+			storage.WriteFile(fmt.Sprintf("cold/more/%03d", i), make([]byte, 100))
+		}
+		entries, _ := os.ReadDir(filepath.Join(tmpdir, "cold", "more"))
+		for _, entry := range entries {
+			os.Chtimes(filepath.Join(tmpdir, "cold", "more", entry.Name()), old, old)
+		}
+		os.Chtimes(filepath.Join(tmpdir, "cold", "more"), old, old)
+		if err := compactor.Run(); err != nil {
+			t.Fatalf("unexpected error when calling Run %+v", err)
+		}
+		raw, _ := underlying.ListDirectory("cold/more", true)
+		if len(raw) <= 1 || len(raw) == 100 {
+			t.Errorf("expected partially packed directory got %d entries", len(raw))
+		}
+		if count, _ := storage.CountFiles("cold/more"); count != 100 {
+			t.Errorf("expected 100 files got %d", count)
+		}
+	}
+}
+
+func TestCompactorRequiresPackedStorage(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	if _, err := NewCompactor(storage); err == nil {
+		t.Errorf("expected error for storage without packs")
+	}
+}
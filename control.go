@@ -0,0 +1,410 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ControlDirectory is directory under storage root holding internal state
+const ControlDirectory = ".localfs"
+
+// files of control directory
+const (
+	saltFile     = "salt"
+	usageFile    = "usage.json"
+	manifestFile = "manifest.json"
+)
+
+// kinds of control artifacts
+const (
+	// ArtifactMarker is small immutable file guarded by checksum
+	ArtifactMarker = "marker"
+	// ArtifactLedger is state that cannot be recomputed from data files
+	ArtifactLedger = "ledger"
+	// ArtifactLock is liveness of running instances
+	ArtifactLock = "lock"
+	// ArtifactDerived is state rebuilt from data files by Repair
+	ArtifactDerived = "derived"
+)
+
+// ControlArtifact describes file or directory of control directory
+type ControlArtifact struct {
+	Name        string `json:"name"`
+	Kind        string `json:"kind"`
+	Description string `json:"description"`
+	Checksum    string `json:"checksum,omitempty"`
+}
+
+// controlArtifacts are all artifacts this version keeps in control directory
+var controlArtifacts = []ControlArtifact{
+	{Name: formatFile, Kind: ArtifactMarker, Description: "format version of on-disk layout"},
+	{Name: saltFile, Kind: ArtifactMarker, Description: "Argon2id parameters of passphrase derived key"},
+	{Name: chargebackFile, Kind: ArtifactLedger, Description: "monthly I/O usage of tenants"},
+	{Name: journalFile, Kind: ArtifactLedger, Description: "append-only changelog of mutations"},
+	{Name: instancesDirectory, Kind: ArtifactLock, Description: "lock files of instances holding root"},
+	{Name: usageFile, Kind: ArtifactDerived, Description: "file and byte counters of tenants"},
+}
+
+// ControlManifest describes control directory so it can be checked and
+// understood without reading code
+type ControlManifest struct {
+	Format    int               `json:"format"`
+	Updated   time.Time         `json:"updated"`
+	Artifacts []ControlArtifact `json:"artifacts"`
+}
+
+// TenantUsage represents files and bytes of tenant
+type TenantUsage struct {
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// ControlUsage represents counters of data files rebuilt by Repair
+type ControlUsage struct {
+	Files   int64                  `json:"files"`
+	Bytes   int64                  `json:"bytes"`
+	Tenants map[string]TenantUsage `json:"tenants"`
+	Updated time.Time              `json:"updated"`
+}
+
+// ControlReport represents result of control directory integrity check
+type ControlReport struct {
+	Missing   []string `json:"missing"`
+	Corrupted []string `json:"corrupted"`
+	Stale     []string `json:"stale"`
+	Unknown   []string `json:"unknown"`
+}
+
+// Healthy returns true when control directory has no missing, corrupted or
+// stale artifacts
+func (report ControlReport) Healthy() bool {
+	return len(report.Missing) == 0 && len(report.Corrupted) == 0 && len(report.Stale) == 0
+}
+
+// RepairReport represents changes done by Repair
+type RepairReport struct {
+	Removed      []string `json:"removed"`
+	Rebuilt      []string `json:"rebuilt"`
+	Unrepairable []string `json:"unrepairable"`
+}
+
+func controlRoot(storage Storage) (string, error) {
+	root, ok := rootOf(storage)
+	if !ok {
+		return "", fmt.Errorf("control directory requires local storage")
+	}
+	return filepath.Clean(root), nil
+}
+
+func checksumOf(filename string) (string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+func readManifest(dir string) (ControlManifest, error) {
+	var manifest ControlManifest
+	data, err := os.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return manifest, err
+	}
+	err = json.Unmarshal(data, &manifest)
+	return manifest, err
+}
+
+func knownChecksums(manifest ControlManifest) map[string]string {
+	result := make(map[string]string)
+	for _, artifact := range manifest.Artifacts {
+		if artifact.Checksum != "" {
+			result[artifact.Name] = artifact.Checksum
+		}
+	}
+	return result
+}
+
+// checkArtifact returns problem of artifact, empty when it is healthy or
+// absent
+func checkArtifact(dir string, artifact ControlArtifact, checksums map[string]string) string {
+	filename := filepath.Join(dir, artifact.Name)
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return ""
+	}
+	switch artifact.Kind {
+	case ArtifactMarker:
+		expected, ok := checksums[artifact.Name]
+		if !ok {
+			return ""
+		}
+		if actual, err := checksumOf(filename); err != nil || actual != expected {
+			return "corrupted"
+		}
+	case ArtifactLedger, ArtifactDerived:
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return "corrupted"
+		}
+		if artifact.Name == journalFile {
+			if !validJSONLines(data) {
+				return "corrupted"
+			}
+		} else if !json.Valid(data) {
+			return "corrupted"
+		}
+	}
+	return ""
+}
+
+// CheckControl checks integrity of control directory of storage root
+func CheckControl(storage Storage) (ControlReport, error) {
+	report := ControlReport{
+		Missing:   make([]string, 0),
+		Corrupted: make([]string, 0),
+		Stale:     make([]string, 0),
+		Unknown:   make([]string, 0),
+	}
+	root, err := controlRoot(storage)
+	if err != nil {
+		return report, err
+	}
+	dir := filepath.Join(root, ControlDirectory)
+	manifest, err := readManifest(dir)
+	switch {
+	case os.IsNotExist(err):
+		report.Missing = append(report.Missing, manifestFile)
+	case err != nil:
+		report.Corrupted = append(report.Corrupted, manifestFile)
+	}
+	checksums := knownChecksums(manifest)
+	unreadableFormat := false
+	if _, err := os.Stat(filepath.Join(dir, formatFile)); os.IsNotExist(err) {
+		report.Missing = append(report.Missing, formatFile)
+	} else if _, err := readFormat(root); err != nil {
+		unreadableFormat = true
+	}
+	known := map[string]bool{manifestFile: true}
+	for _, artifact := range controlArtifacts {
+		known[artifact.Name] = true
+		if (artifact.Name == formatFile && unreadableFormat) || checkArtifact(dir, artifact, checksums) != "" {
+			report.Corrupted = append(report.Corrupted, artifact.Name)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return report, err
+	}
+	for _, entry := range entries {
+		switch {
+		case strings.HasSuffix(entry.Name(), ".tmp"):
+			report.Stale = append(report.Stale, entry.Name())
+		case !known[entry.Name()]:
+			report.Unknown = append(report.Unknown, entry.Name())
+		}
+	}
+	instances := filepath.Join(dir, instancesDirectory)
+	if before, err := os.ReadDir(instances); err == nil {
+		live, _ := liveLockFiles(instances)
+		for _, entry := range before {
+			if !live[entry.Name()] {
+				report.Stale = append(report.Stale, instancesDirectory+"/"+entry.Name())
+			}
+		}
+	}
+	sort.Strings(report.Stale)
+	return report, nil
+}
+
+// liveLockFiles returns names of files still locked by their instances
+func liveLockFiles(dir string) (map[string]bool, error) {
+	result := make(map[string]bool)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return result, err
+	}
+	for _, entry := range entries {
+		held, err := lockHeld(filepath.Join(dir, entry.Name()))
+		if err == nil && held {
+			result[entry.Name()] = true
+		}
+	}
+	return result, nil
+}
+
+// rebuildUsage counts data files and bytes of every tenant
+func rebuildUsage(root string) (ControlUsage, error) {
+	usage := ControlUsage{
+		Tenants: make(map[string]TenantUsage),
+		Updated: time.Now(),
+	}
+	err := filepath.WalkDir(root, func(absPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && absPath != root && filepath.Dir(absPath) == root && strings.HasPrefix(entry.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		relPath, err := filepath.Rel(root, absPath)
+		if err != nil {
+			return err
+		}
+		tenant := TenantOf(relPath)
+		if tenant == relPath {
+			tenant = ""
+		}
+		counters := usage.Tenants[tenant]
+		counters.Files++
+		counters.Bytes += info.Size()
+		usage.Tenants[tenant] = counters
+		usage.Files++
+		usage.Bytes += info.Size()
+		return nil
+	})
+	return usage, err
+}
+
+// Usage returns file and byte counters of tenants as of last Repair
+func Usage(storage Storage) (ControlUsage, error) {
+	var usage ControlUsage
+	root, err := controlRoot(storage)
+	if err != nil {
+		return usage, err
+	}
+	data, err := os.ReadFile(filepath.Join(root, ControlDirectory, usageFile))
+	if err != nil {
+		return usage, err
+	}
+	err = json.Unmarshal(data, &usage)
+	return usage, err
+}
+
+func writeControlFile(filename string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceControlFile(filename, data)
+}
+
+// replaceControlFile writes data into unique temporary sibling renamed over
+// filename so concurrent writers never rename each other's temporary, names
+// end with .tmp so leftovers of crash are reported stale
+func replaceControlFile(filename string, data []byte) error {
+	temporary := temporarySibling(filename, "write") + ".tmp"
+	if err := writeSynced(temporary, data); err != nil {
+		os.Remove(temporary)
+		return err
+	}
+	if err := os.Rename(temporary, filename); err != nil {
+		os.Remove(temporary)
+		return err
+	}
+	return nil
+}
+
+// Repair removes stale artifacts of control directory, restores format
+// marker, rebuilds derived state from data files and rewrites manifest,
+// artifacts that cannot be recomputed are reported as unrepairable
+func Repair(storage Storage) (RepairReport, error) {
+	report := RepairReport{
+		Removed:      make([]string, 0),
+		Rebuilt:      make([]string, 0),
+		Unrepairable: make([]string, 0),
+	}
+	check, err := CheckControl(storage)
+	if err != nil {
+		return report, err
+	}
+	root, _ := controlRoot(storage)
+	dir := filepath.Join(root, ControlDirectory)
+	if err = os.MkdirAll(dir, os.ModePerm); err != nil {
+		return report, err
+	}
+	for _, name := range check.Stale {
+		if err = os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return report, err
+		}
+		report.Removed = append(report.Removed, name)
+	}
+	corrupted := make(map[string]bool)
+	for _, name := range check.Corrupted {
+		corrupted[name] = true
+	}
+	if corrupted[formatFile] {
+		report.Unrepairable = append(report.Unrepairable, formatFile)
+	} else if err = ensureFormat(root); err != nil {
+		return report, err
+	}
+	for _, name := range check.Missing {
+		if name == formatFile {
+			report.Rebuilt = append(report.Rebuilt, name)
+		}
+	}
+	usage, err := rebuildUsage(root)
+	if err != nil {
+		return report, err
+	}
+	if err = writeControlFile(filepath.Join(dir, usageFile), usage); err != nil {
+		return report, err
+	}
+	report.Rebuilt = append(report.Rebuilt, usageFile)
+
+	previous, _ := readManifest(dir)
+	checksums := knownChecksums(previous)
+	manifest := ControlManifest{
+		Format:    FormatVersion,
+		Updated:   time.Now(),
+		Artifacts: make([]ControlArtifact, 0, len(controlArtifacts)),
+	}
+	for _, artifact := range controlArtifacts {
+		if artifact.Kind == ArtifactMarker {
+			if corrupted[artifact.Name] {
+				// keep checksum of good content so corruption is not blessed
+				artifact.Checksum = checksums[artifact.Name]
+			} else if checksum, err := checksumOf(filepath.Join(dir, artifact.Name)); err == nil {
+				artifact.Checksum = checksum
+			}
+		}
+		if corrupted[artifact.Name] && artifact.Kind != ArtifactDerived && artifact.Name != formatFile {
+			report.Unrepairable = append(report.Unrepairable, artifact.Name)
+		}
+		manifest.Artifacts = append(manifest.Artifacts, artifact)
+	}
+	if err = writeControlFile(filepath.Join(dir, manifestFile), manifest); err != nil {
+		return report, err
+	}
+	if corrupted[manifestFile] || len(check.Missing) > 0 {
+		report.Rebuilt = append(report.Rebuilt, manifestFile)
+	}
+	return report, nil
+}
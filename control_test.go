@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestControlDirectory(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	storage.WriteFile("t_alpha/account/A", []byte("12345"))
+	storage.WriteFile("t_alpha/account/B", []byte("123"))
+	storage.WriteFile("t_beta/account/C", []byte("1"))
+	dir := filepath.Join(tmpdir, ControlDirectory)
+
+	t.Log("fresh root misses manifest")
+	{
+		report, err := CheckControl(storage)
+		if err != nil {
+			t.Fatalf("unexpected error when calling CheckControl %+v", err)
+		}
+		if report.Healthy() || len(report.Missing) != 1 || report.Missing[0] != manifestFile {
+			t.Errorf("expected missing manifest got %+v", report)
+		}
+	}
+
+	t.Log("repair rebuilds derived state")
+	{
+		if _, err := Repair(storage); err != nil {
+			t.Fatalf("unexpected error when calling Repair %+v", err)
+		}
+		report, _ := CheckControl(storage)
+		if !report.Healthy() {
+			t.Errorf("expected healthy control directory got %+v", report)
+		}
+		usage, err := Usage(storage)
+		if err != nil {
+			t.Fatalf("unexpected error when calling Usage %+v", err)
+		}
+		if usage.Files != 3 || usage.Bytes != 9 || usage.Tenants["t_alpha"].Files != 2 || usage.Tenants["t_beta"].Bytes != 1 {
+			t.Errorf("unexpected usage %+v", usage)
+		}
+	}
+
+	t.Log("detects corruption and stale artifacts")
+	{
+		os.WriteFile(filepath.Join(dir, formatFile), []byte("2\n"), 0600)
+		os.WriteFile(filepath.Join(dir, usageFile), []byte("{broken"), 0600)
+		os.WriteFile(filepath.Join(dir, "usage.json.tmp"), []byte("x"), 0600)
+		os.MkdirAll(filepath.Join(dir, instancesDirectory), os.ModePerm)
+		os.WriteFile(filepath.Join(dir, instancesDirectory, "1-dead.lock"), []byte("{}"), 0600)
+		os.WriteFile(filepath.Join(dir, "mystery"), []byte("x"), 0600)
+
+		report, _ := CheckControl(storage)
+		if len(report.Corrupted) != 2 || report.Corrupted[0] != formatFile || report.Corrupted[1] != usageFile {
+			t.Errorf("expected corrupted format and usage got %+v", report.Corrupted)
+		}
+		if len(report.Stale) != 2 || len(report.Unknown) != 1 || report.Unknown[0] != "mystery" {
+			t.Errorf("expected stale and unknown artifacts got %+v", report)
+		}
+
+		repaired, err := Repair(storage)
+		if err != nil {
+			t.Fatalf("unexpected error when calling Repair %+v", err)
+		}
+		if len(repaired.Removed) != 2 || len(repaired.Unrepairable) != 1 || repaired.Unrepairable[0] != formatFile {
+			t.Errorf("unexpected repair report %+v", repaired)
+		}
+		report, _ = CheckControl(storage)
+		if len(report.Corrupted) != 1 || report.Corrupted[0] != formatFile || len(report.Stale) != 0 {
+			t.Errorf("expected only format to stay corrupted got %+v", report)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// indirections allowing tests to emulate filesystems without reflink or
+// in-kernel copy support
+var (
+	ioctlFileClone = defaultIoctlFileClone
+	copyFileRange  = defaultCopyFileRange
+	sendFile       = defaultSendFile
+)
+
+const (
+	copyReflink         = "reflink"
+	copyFileRangeMethod = "copy_file_range"
+	copySendfile        = "sendfile"
+	copyBuffered        = "buffered"
+	copySparse          = "sparse"
+)
+
+// copyContents copies size bytes from source to target preferring FICLONE
+// reflink, then copy_file_range, sendfile and finally buffered copy, sparse source is
+// copied extent by extent so holes stay holes, returns method that finished
+// the copy
+func copyContents(source int, target int, size int64, sparse bool, bufferSize int) (string, error) {
+	if ioctlFileClone(target, source) == nil {
+		return copyReflink, nil
+	}
+	if sparse {
+		return copySparse, copyExtents(source, target, size, bufferSize)
+	}
+	var copied int64
+	for copied < size {
+		n, err := copyFileRange(source, nil, target, nil, int(size-copied), 0)
+		if err != nil || n == 0 {
+			break
+		}
+		copied += int64(n)
+	}
+	if size > 0 && copied == size {
+		return copyFileRangeMethod, nil
+	}
+	// sendfile copies across filesystems where copy_file_range refuses to
+	for copied < size {
+		n, err := sendFile(target, source, nil, int(size-copied))
+		if err != nil || n <= 0 {
+			break
+		}
+		copied += int64(n)
+	}
+	if size > 0 && copied == size {
+		return copySendfile, nil
+	}
+	// offsets of both descriptors are advanced past what was already copied
+	buf := make([]byte, bufferSize)
+	for {
+		n, err := readFull(source, buf)
+		if err != nil {
+			return copyBuffered, err
+		}
+		if n <= 0 {
+			return copyBuffered, nil
+		}
+		if err = writeFull(target, buf[:n]); err != nil {
+			return copyBuffered, err
+		}
+	}
+}
+
+func copyFile(source string, target string, bufferSize int, handles *handleRegistry, timeout time.Duration) (string, error) {
+	in, err := openRetrying(source, syscall.O_RDONLY|syscall.O_NONBLOCK, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer handles.track(source, "read")()
+	defer syscall.Close(in)
+	if err = flock(in, source, syscall.LOCK_EX, timeout); err != nil {
+		return "", err
+	}
+	defer funlock(in, source)
+	var fs syscall.Stat_t
+	if err = syscall.Fstat(in, &fs); err != nil {
+		return "", err
+	}
+	if fs.Mode&syscall.S_IFMT != syscall.S_IFREG {
+		return "", &os.PathError{Op: "copy", Path: source, Err: syscall.EINVAL}
+	}
+	if err = os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return "", err
+	}
+	out, err := openRetrying(target, syscall.O_CREAT|syscall.O_WRONLY|syscall.O_NONBLOCK, uint32(fs.Mode&0777))
+	if err != nil {
+		return "", err
+	}
+	defer handles.track(target, "write")()
+	defer syscall.Close(out)
+	var ts syscall.Stat_t
+	if err = syscall.Fstat(out, &ts); err != nil {
+		return "", err
+	}
+	// copying file onto itself would truncate it before anything is read
+	if ts.Dev == fs.Dev && ts.Ino == fs.Ino {
+		return "", &os.PathError{Op: "copy", Path: target, Err: syscall.EINVAL}
+	}
+	if err = flock(out, target, syscall.LOCK_EX, timeout); err != nil {
+		return "", err
+	}
+	defer funlock(out, target)
+	if err = syscall.Ftruncate(out, 0); err != nil {
+		return "", err
+	}
+	method, err := copyContents(in, out, fs.Size, fs.Blocks*512 < fs.Size, bufferSize)
+	if err != nil {
+		return method, err
+	}
+	return method, syscall.Fsync(out)
+}
+
+func copyDirectory(source string, target string, bufferSize int, handles *handleRegistry, timeout time.Duration) error {
+	return filepath.WalkDir(source, func(absPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(source, absPath)
+		if err != nil {
+			return err
+		}
+		destination := filepath.Join(target, relPath)
+		if entry.IsDir() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			return os.MkdirAll(destination, info.Mode().Perm()|0700)
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		_, err = copyFile(absPath, destination, bufferSize, handles, timeout)
+		return err
+	})
+}
+
+// CopyFile copies file using reflink where filesystem supports it
+func (storage PlaintextStorage) CopyFile(source string, target string) error {
+	defer storage.barrier.enter()()
+	_, err := copyFile(filepath.Clean(storage.root+"/"+source), filepath.Clean(storage.root+"/"+target), storage.bufferSize, storage.handles, storage.lockTimeout)
+	return err
+}
+
+// CopyFile copies encrypted file verbatim using reflink where filesystem
+// supports it
+func (storage EncryptedStorage) CopyFile(source string, target string) error {
+	defer storage.barrier.enter()()
+	_, err := copyFile(filepath.Clean(storage.root+"/"+source), filepath.Clean(storage.root+"/"+target), storage.bufferSize, storage.handles, storage.lockTimeout)
+	return err
+}
+
+// CopyDirectory copies directory recursively using reflink where filesystem
+// supports it
+func (storage PlaintextStorage) CopyDirectory(source string, target string) error {
+	defer storage.barrier.enter()()
+	return copyDirectory(filepath.Clean(storage.root+"/"+source), filepath.Clean(storage.root+"/"+target), storage.bufferSize, storage.handles, storage.lockTimeout)
+}
+
+// CopyDirectory copies directory of encrypted files verbatim using reflink
+// where filesystem supports it
+func (storage EncryptedStorage) CopyDirectory(source string, target string) error {
+	defer storage.barrier.enter()()
+	return copyDirectory(filepath.Clean(storage.root+"/"+source), filepath.Clean(storage.root+"/"+target), storage.bufferSize, storage.handles, storage.lockTimeout)
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestCopyFile(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	data := bytes.Repeat([]byte("ledger"), 10000)
+
+	t.Log("copies content")
+	{
+		storage.WriteFile("a/source", data)
+		if err := storage.(PlaintextStorage).CopyFile("a/source", "b/target"); err != nil {
+			t.Fatalf("unexpected error when calling CopyFile %+v", err)
+		}
+		copied, _ := storage.ReadFileFully("b/target")
+		if !bytes.Equal(copied, data) {
+			t.Errorf("expected copy to equal source")
+		}
+	}
+
+	t.Log("falls back to buffered copy")
+	{
+		ioctlFileClone = func(destFd int, srcFd int) error {
+			return syscall.EOPNOTSUPP
+		}
+		copyFileRange = func(rfd int, roff *int64, wfd int, woff *int64, len int, flags int) (int, error) {
+			return 0, syscall.EXDEV
+		}
+		sendFile = func(outfd int, infd int, offset *int64, count int) (int, error) {
+			return 0, syscall.EINVAL
+		}
+		defer func() {
+			ioctlFileClone = defaultIoctlFileClone
+			copyFileRange = defaultCopyFileRange
+			sendFile = defaultSendFile
+		}()
+		method, err := copyFile(tmpdir+"/a/source", tmpdir+"/b/buffered", 4096, nil, 0)
+		if err != nil {
+			t.Fatalf("unexpected error when calling copyFile %+v", err)
+		}
+		if method != copyBuffered {
+			t.Errorf("expected buffered copy got %s", method)
+		}
+		copied, _ := storage.ReadFileFully("b/buffered")
+		if !bytes.Equal(copied, data) {
+			t.Errorf("expected buffered copy to equal source")
+		}
+	}
+
+	t.Log("finishes partial in-kernel copy with buffered copy")
+	{
+		ioctlFileClone = func(destFd int, srcFd int) error {
+			return syscall.EOPNOTSUPP
+		}
+		calls := 0
+		copyFileRange = func(rfd int, roff *int64, wfd int, woff *int64, len int, flags int) (int, error) {
+			calls++
+			if calls > 1 {
+				return 0, syscall.EIO
+			}
+			return defaultCopyFileRange(rfd, roff, wfd, woff, 1000, flags)
+		}
+		sendFile = func(outfd int, infd int, offset *int64, count int) (int, error) {
+			return 0, syscall.EINVAL
+		}
+		defer func() {
+			ioctlFileClone = defaultIoctlFileClone
+			copyFileRange = defaultCopyFileRange
+			sendFile = defaultSendFile
+		}()
+		method, err := copyFile(tmpdir+"/a/source", tmpdir+"/b/partial", 4096, nil, 0)
+		if err != nil {
+			t.Fatalf("unexpected error when calling copyFile %+v", err)
+		}
+		copied, _ := storage.ReadFileFully("b/partial")
+		if method != copyBuffered || !bytes.Equal(copied, data) {
+			t.Errorf("expected buffered copy to equal source got %s %d bytes", method, len(copied))
+		}
+	}
+
+	t.Log("falls back to sendfile across filesystems")
+	{
+		ioctlFileClone = func(destFd int, srcFd int) error {
+			return syscall.EOPNOTSUPP
+		}
+		copyFileRange = func(rfd int, roff *int64, wfd int, woff *int64, len int, flags int) (int, error) {
+			return 0, syscall.EXDEV
+		}
+		sendFile = defaultSendFile
+		defer func() {
+			ioctlFileClone = defaultIoctlFileClone
+			copyFileRange = defaultCopyFileRange
+		}()
+		method, err := copyFile(tmpdir+"/a/source", tmpdir+"/b/sendfile", 4096, nil, 0)
+		if err != nil {
+			t.Fatalf("unexpected error when calling copyFile %+v", err)
+		}
+		copied, _ := storage.ReadFileFully("b/sendfile")
+		if method != copySendfile || !bytes.Equal(copied, data) {
+			t.Errorf("expected sendfile copy to equal source got %s %d bytes", method, len(copied))
+		}
+	}
+
+	t.Log("refuses to copy file onto itself")
+	{
+		if err := storage.(PlaintextStorage).CopyFile("a/source", "a//source"); err == nil {
+			t.Errorf("expected error when copying file onto itself")
+		}
+		if copied, _ := storage.ReadFileFully("a/source"); !bytes.Equal(copied, data) {
+			t.Errorf("expected source to stay intact")
+		}
+	}
+
+	t.Log("copies directory")
+	{
+		storage.WriteFile("c/x/1", []byte("one"))
+		storage.WriteFile("c/2", []byte("two"))
+		if err := storage.(PlaintextStorage).CopyDirectory("c", "d"); err != nil {
+			t.Fatalf("unexpected error when calling CopyDirectory %+v", err)
+		}
+		if data, _ := storage.ReadFileFully("d/x/1"); string(data) != "one" {
+			t.Errorf("expected d/x/1 to contain one got %s", string(data))
+		}
+		if data, _ := storage.ReadFileFully("d/2"); string(data) != "two" {
+			t.Errorf("expected d/2 to contain two got %s", string(data))
+		}
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package crashtest runs storage workloads in child process killed in middle
+// of random write system call and verifies invariants of what survived on
+// disk, giving evidence for durability claims of storage
+package crashtest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"syscall"
+
+	storage "github.com/jancajthaml-openbank/local-fs"
+)
+
+const (
+	envWorkload = "LOCALFS_CRASHTEST_WORKLOAD"
+	envRoot     = "LOCALFS_CRASHTEST_ROOT"
+	envPoint    = "LOCALFS_CRASHTEST_POINT"
+)
+
+// Workload is scenario executed in child process until it is killed
+type Workload struct {
+	// Name identifies workload between parent and child process
+	Name string
+	// Open returns storage over root, plaintext storage when nil
+	Open func(root string) (storage.Storage, error)
+	// Run performs operations whose durability is tested
+	Run func(storage.Storage) error
+	// Verify checks invariants of storage after child process was killed
+	// or completed, it may replay journals before checking
+	Verify func(storage.Storage) error
+}
+
+// Options controls crash test run
+type Options struct {
+	// Iterations is number of child processes spawned
+	Iterations int
+	// MaxPoint is highest write system call at which child is killed, runs
+	// choosing point past end of workload complete without crash
+	MaxPoint int
+	// Seed makes sequence of crash points reproducible
+	Seed int64
+}
+
+// Report summarizes crash test run
+type Report struct {
+	Iterations int
+	Crashed    int
+	Completed  int
+	Failures   []error
+}
+
+func (workload Workload) open(root string) (storage.Storage, error) {
+	if workload.Open != nil {
+		return workload.Open(root)
+	}
+	return storage.NewPlaintextStorage(root)
+}
+
+// Main runs workload selected by parent process and exits when process is
+// crash test child, otherwise it returns immediately, it must be called from
+// TestMain before m.Run with all workloads used by tests
+func Main(workloads ...Workload) {
+	name := os.Getenv(envWorkload)
+	if name == "" {
+		return
+	}
+	point, err := strconv.Atoi(os.Getenv(envPoint))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid crash point %+v\n", err)
+		os.Exit(2)
+	}
+	for _, workload := range workloads {
+		if workload.Name != name {
+			continue
+		}
+		calls := 0
+		// part of write written before kill is derived from crash point so
+		// runs stay reproducible
+		random := rand.New(rand.NewSource(int64(point)))
+		storage.SetFaultHook(func(op string, size int) (int, func()) {
+			calls++
+			if calls != point {
+				return size, nil
+			}
+			allowed := 0
+			if size > 0 {
+				allowed = random.Intn(size)
+			}
+			return allowed, func() {
+				syscall.Kill(os.Getpid(), syscall.SIGKILL)
+				select {}
+			}
+		})
+		subject, err := workload.open(os.Getenv(envRoot))
+		if err == nil {
+			err = workload.Run(subject)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%+v\n", err)
+			os.Exit(2)
+		}
+		os.Exit(0)
+	}
+	fmt.Fprintf(os.Stderr, "unknown workload %s\n", name)
+	os.Exit(2)
+}
+
+// Run executes workload in child processes killed in middle of random write
+// system calls and verifies invariants after each of them, violated invariants are
+// collected in report, error is returned when workload cannot be run at all
+func Run(workload Workload, options Options) (Report, error) {
+	report := Report{}
+	if options.Iterations <= 0 {
+		options.Iterations = 1
+	}
+	if options.MaxPoint <= 0 {
+		options.MaxPoint = 100
+	}
+	random := rand.New(rand.NewSource(options.Seed))
+	for i := 0; i < options.Iterations; i++ {
+		point := 1 + random.Intn(options.MaxPoint)
+		crashed, violation, err := runOnce(workload, point)
+		if err != nil {
+			return report, err
+		}
+		report.Iterations++
+		if crashed {
+			report.Crashed++
+		} else {
+			report.Completed++
+		}
+		if violation != nil {
+			report.Failures = append(report.Failures, fmt.Errorf("crash point %d %w", point, violation))
+		}
+	}
+	return report, nil
+}
+
+// runOnce runs workload in child process killed at given write system call
+// and returns whether it was killed and violation found by verification
+func runOnce(workload Workload, point int) (bool, error, error) {
+	root, err := ioutil.TempDir(os.TempDir(), "crashtest")
+	if err != nil {
+		return false, nil, err
+	}
+	defer os.RemoveAll(root)
+
+	var stderr bytes.Buffer
+	child := exec.Command(os.Args[0], "-test.run=^$")
+	child.Env = append(os.Environ(),
+		envWorkload+"="+workload.Name,
+		envRoot+"="+root,
+		envPoint+"="+strconv.Itoa(point),
+	)
+	child.Stderr = &stderr
+	err = child.Run()
+	crashed := false
+	if err != nil {
+		var exit *exec.ExitError
+		if !errors.As(err, &exit) || exit.Sys().(syscall.WaitStatus).Signal() != syscall.SIGKILL {
+			return false, nil, fmt.Errorf("workload %s failed %w %s", workload.Name, err, stderr.String())
+		}
+		crashed = true
+	}
+
+	subject, err := workload.open(root)
+	if err != nil {
+		return crashed, err, nil
+	}
+	return crashed, workload.Verify(subject), nil
+}
+
+// Payload returns self verifying record of given size derived from seed,
+// length prefix and crc32 suffix make truncated or torn record detectable
+func Payload(seed int64, size int) []byte {
+	data := make([]byte, 4, size+8)
+	binary.LittleEndian.PutUint32(data, uint32(size))
+	body := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(body)
+	data = append(data, body...)
+	return binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE(body))
+}
+
+// SplitPayloads returns number of complete records of concatenated payloads,
+// error is returned when data does not end at record boundary or record is
+// corrupted
+func SplitPayloads(data []byte) (int, error) {
+	count := 0
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return count, fmt.Errorf("partial record header after %d records", count)
+		}
+		size := int(binary.LittleEndian.Uint32(data))
+		if len(data) < size+8 {
+			return count, fmt.Errorf("partial record after %d records", count)
+		}
+		body := data[4 : 4+size]
+		if binary.LittleEndian.Uint32(data[4+size:]) != crc32.ChecksumIEEE(body) {
+			return count, fmt.Errorf("corrupted record after %d records", count)
+		}
+		data = data[size+8:]
+		count++
+	}
+	return count, nil
+}
+
+// CompletePayloads returns number of complete records of concatenated
+// payloads ignoring partial final record left by torn append, error is
+// returned when complete record is corrupted
+func CompletePayloads(data []byte) (int, error) {
+	count := 0
+	for len(data) >= 8 {
+		size := int(binary.LittleEndian.Uint32(data))
+		if len(data) < size+8 {
+			break
+		}
+		body := data[4 : 4+size]
+		if binary.LittleEndian.Uint32(data[4+size:]) != crc32.ChecksumIEEE(body) {
+			return count, fmt.Errorf("corrupted record after %d records", count)
+		}
+		data = data[size+8:]
+		count++
+	}
+	return count, nil
+}
+
+// NoPartialFiles verifies every file in directory is single complete payload
+func NoPartialFiles(subject storage.Storage, dir string) error {
+	names, err := subject.ListDirectory(dir, true)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		data, err := subject.ReadFileFully(path.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		if count, err := SplitPayloads(data); err != nil || count != 1 {
+			return fmt.Errorf("partial file %s visible", path.Join(dir, name))
+		}
+	}
+	return nil
+}
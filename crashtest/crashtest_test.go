@@ -0,0 +1,163 @@
+package crashtest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"testing"
+
+	storage "github.com/jancajthaml-openbank/local-fs"
+)
+
+var appendLog = Workload{
+	Name: "append",
+	Run: func(subject storage.Storage) error {
+		for i := 0; i < 50; i++ {
+			if err := subject.AppendFile("log", Payload(int64(i), 100+i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Verify: func(subject storage.Storage) error {
+		data, err := subject.ReadFileFully("log")
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = CompletePayloads(data)
+		return err
+	},
+}
+
+var createFiles = Workload{
+	Name: "create",
+	Run: func(subject storage.Storage) error {
+		for i := 0; i < 20; i++ {
+			if err := subject.WriteFile(fmt.Sprintf("files/%d", i), Payload(int64(i), 64)); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Verify: func(subject storage.Storage) error {
+		return NoPartialFiles(subject, "files")
+	},
+}
+
+var journaled = Workload{
+	Name: "journal",
+	Open: func(root string) (storage.Storage, error) {
+		underlying, err := storage.NewPlaintextStorage(root)
+		if err != nil {
+			return nil, err
+		}
+		return storage.NewJournaledStorage(underlying)
+	},
+	Run: func(subject storage.Storage) error {
+		for i := 0; i < 20; i++ {
+			if err := subject.WriteFile(fmt.Sprintf("files/%d", i), Payload(int64(i), 64)); err != nil {
+				return err
+			}
+			if err := subject.AppendFile("log", Payload(int64(i), 32)); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Verify: func(subject storage.Storage) error {
+		// consumer replaying journal sees every recorded mutation applied
+		// whatever was in flight when process died
+		expected := make(map[string]string)
+		_, err := subject.(storage.JournaledStorage).Replay(0, func(entry storage.JournalEntry) bool {
+			if entry.Op == storage.JournalWrite {
+				expected[entry.Path] = entry.Checksum
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		for path, checksum := range expected {
+			data, err := subject.ReadFileFully(path)
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(data)
+			if "sha256:"+hex.EncodeToString(sum[:]) != checksum {
+				return fmt.Errorf("journaled file %s does not match journal", path)
+			}
+		}
+		data, err := subject.ReadFileFully("log")
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = CompletePayloads(data)
+		return err
+	},
+}
+
+func TestMain(m *testing.M) {
+	Main(appendLog, createFiles, journaled)
+	os.Exit(m.Run())
+}
+
+func TestAppendSurvivesCrash(t *testing.T) {
+	report, err := Run(appendLog, Options{Iterations: 10, MaxPoint: 60, Seed: 1})
+	if err != nil {
+		t.Fatalf("unexpected error when calling Run %+v", err)
+	}
+	if report.Iterations != 10 || report.Crashed == 0 {
+		t.Errorf("expected crashed iterations got %+v", report)
+	}
+	for _, failure := range report.Failures {
+		t.Errorf("invariant violated %+v", failure)
+	}
+}
+
+func TestJournalReplayAfterCrash(t *testing.T) {
+	report, err := Run(journaled, Options{Iterations: 10, MaxPoint: 80, Seed: 1})
+	if err != nil {
+		t.Fatalf("unexpected error when calling Run %+v", err)
+	}
+	if report.Iterations != 10 || report.Crashed == 0 {
+		t.Errorf("expected crashed iterations got %+v", report)
+	}
+	for _, failure := range report.Failures {
+		t.Errorf("invariant violated %+v", failure)
+	}
+}
+
+func TestPartialFilesAreDetected(t *testing.T) {
+	// file created by WriteFile is visible before its content is written
+	report, err := Run(createFiles, Options{Iterations: 5, MaxPoint: 20, Seed: 1})
+	if err != nil {
+		t.Fatalf("unexpected error when calling Run %+v", err)
+	}
+	if report.Crashed != 5 || len(report.Failures) != report.Crashed {
+		t.Errorf("expected every crash to leave partial file got %+v", report)
+	}
+}
+
+func TestPayload(t *testing.T) {
+	data := append(Payload(1, 10), Payload(2, 20)...)
+	if count, err := SplitPayloads(data); err != nil || count != 2 {
+		t.Errorf("expected two records got %d %+v", count, err)
+	}
+	if _, err := SplitPayloads(data[:len(data)-1]); err == nil {
+		t.Errorf("expected torn record to be detected")
+	}
+	if count, err := CompletePayloads(data[:len(data)-1]); err != nil || count != 1 {
+		t.Errorf("expected torn final record to be ignored got %d %+v", count, err)
+	}
+	data[5] ^= 0x01
+	if _, err := CompletePayloads(data); err == nil {
+		t.Errorf("expected corrupted record to be detected")
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+)
+
+// AppendCSVRecord appends record as CSV line to file given path, file is
+// created with header line on first write, record must have same number of
+// fields as header
+func AppendCSVRecord(storage Storage, path string, header []string, record []string) error {
+	if len(record) != len(header) {
+		return fmt.Errorf("record has %d fields but header has %d", len(record), len(header))
+	}
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	writer.Write(header)
+	writer.Flush()
+	headerSize := buffer.Len()
+	writer.Write(record)
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	// only writer creating file writes header, others append
+	err := storage.WriteFileExclusive(path, buffer.Bytes())
+	if !os.IsExist(err) {
+		return err
+	}
+	return storage.AppendFile(path, buffer.Bytes()[headerSize:])
+}
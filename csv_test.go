@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestAppendCSVRecord(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	plaintext, _ := NewPlaintextStorage(tmpdir + "/plaintext")
+	encrypted, _ := NewEncryptedStorage(tmpdir+"/encrypted", getKey())
+	header := []string{"account", "amount", "note"}
+
+	for name, storage := range map[string]Storage{"plaintext": plaintext, "encrypted": encrypted} {
+		t.Logf("%s header written once", name)
+		{
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					if err := AppendCSVRecord(storage, "report/2023.csv", header, []string{fmt.Sprintf("A%d", i), "100", "with, comma"}); err != nil {
+						t.Errorf("%s unexpected error when calling AppendCSVRecord %+v", name, err)
+					}
+				}(i)
+			}
+			wg.Wait()
+			data, _ := storage.ReadFileFully("report/2023.csv")
+			records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+			if err != nil || len(records) != 11 {
+				t.Fatalf("%s expected header and 10 records got %d %+v", name, len(records), err)
+			}
+			if records[0][0] != "account" || records[1][2] != "with, comma" {
+				t.Errorf("%s unexpected content %+v", name, records)
+			}
+		}
+
+		t.Logf("%s rejects record not matching header", name)
+		{
+			if err := AppendCSVRecord(storage, "report/2023.csv", header, []string{"A"}); err == nil {
+				t.Errorf("%s expected error for short record", name)
+			}
+		}
+	}
+}
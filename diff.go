@@ -0,0 +1,129 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DiffReport represents difference of two trees, Added are files present
+// only in second tree, Removed only in first and Changed in both with
+// different content
+type DiffReport struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+// Identical returns true when trees do not differ
+func (report DiffReport) Identical() bool {
+	return len(report.Added) == 0 && len(report.Removed) == 0 && len(report.Changed) == 0
+}
+
+// walkFiles returns sorted paths of all files under given path, directories
+// are told from files by listing them because Storage contract has no stat
+func walkFiles(storage Storage, prefix string) ([]string, error) {
+	result := make([]string, 0)
+	var visit func(string) error
+	visit = func(item string) error {
+		if isControlPath(item) {
+			return nil
+		}
+		if entries, err := storage.ListDirectory(item, true); err == nil {
+			for _, entry := range entries {
+				if err = visit(strings.TrimPrefix(item+"/", "/") + entry); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		ok, err := storage.Exists(item)
+		if err != nil {
+			return err
+		}
+		if ok {
+			result = append(result, item)
+		}
+		return nil
+	}
+	if err := visit(strings.TrimPrefix(path.Clean("/"+prefix), "/")); err != nil {
+		return nil, err
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// Diff compares files under given path of two storages by content, files are
+// compared decrypted so plaintext and encrypted trees can be compared
+func Diff(a Storage, b Storage, path string) (DiffReport, error) {
+	return diffTrees(a, b, path, func(file string) (bool, error) {
+		return sameContent(a, b, file)
+	})
+}
+
+// diffTrees compares file sets of two trees, files present in both are
+// compared by given func
+func diffTrees(a Storage, b Storage, path string, same func(string) (bool, error)) (DiffReport, error) {
+	report := DiffReport{
+		Added:   make([]string, 0),
+		Removed: make([]string, 0),
+		Changed: make([]string, 0),
+	}
+	left, err := walkFiles(a, path)
+	if err != nil {
+		return report, err
+	}
+	right, err := walkFiles(b, path)
+	if err != nil {
+		return report, err
+	}
+	i, j := 0, 0
+	for i < len(left) || j < len(right) {
+		switch {
+		case j == len(right) || (i < len(left) && left[i] < right[j]):
+			report.Removed = append(report.Removed, left[i])
+			i++
+		case i == len(left) || right[j] < left[i]:
+			report.Added = append(report.Added, right[j])
+			j++
+		default:
+			equal, err := same(left[i])
+			if err != nil {
+				return report, err
+			}
+			if !equal {
+				report.Changed = append(report.Changed, left[i])
+			}
+			i++
+			j++
+		}
+	}
+	return report, nil
+}
+
+func sameContent(a Storage, b Storage, path string) (bool, error) {
+	left, err := a.ReadFileFully(path)
+	if err != nil {
+		return false, err
+	}
+	right, err := b.ReadFileFully(path)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(left, right), nil
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	a, _ := NewPlaintextStorage(tmpdir + "/a")
+	b, _ := NewEncryptedStorage(tmpdir+"/b", getKey())
+
+	for _, storage := range []Storage{a, b} {
+		storage.WriteFile("account/same", []byte("same"))
+		storage.WriteFile("account/x/nested", []byte("nested"))
+	}
+	a.WriteFile("account/changed", []byte("before"))
+	b.WriteFile("account/changed", []byte("after"))
+	a.WriteFile("account/removed", []byte("gone"))
+	b.WriteFile("account/x/added", []byte("new"))
+
+	t.Log("reports differences")
+	{
+		report, err := Diff(a, b, "account")
+		if err != nil {
+			t.Fatalf("unexpected error when calling Diff %+v", err)
+		}
+		if len(report.Added) != 1 || report.Added[0] != "account/x/added" {
+			t.Errorf("unexpected added %+v", report.Added)
+		}
+		if len(report.Removed) != 1 || report.Removed[0] != "account/removed" {
+			t.Errorf("unexpected removed %+v", report.Removed)
+		}
+		if len(report.Changed) != 1 || report.Changed[0] != "account/changed" {
+			t.Errorf("unexpected changed %+v", report.Changed)
+		}
+	}
+
+	t.Log("reports identical trees")
+	{
+		report, err := Diff(a, b, "account/x/nested")
+		if err != nil {
+			t.Fatalf("unexpected error when calling Diff %+v", err)
+		}
+		if !report.Identical() {
+			t.Errorf("expected identical got %+v", report)
+		}
+	}
+}
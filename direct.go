@@ -0,0 +1,116 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// directAlignment is alignment of buffers, offsets and lengths of O_DIRECT
+// transfers, logical block size of common devices divides it
+const directAlignment = 4096
+
+// directChunk is size of aligned buffer data is staged in before written
+const directChunk = 1 << 20
+
+var directBuffers = sync.Pool{
+	New: func() interface{} {
+		buffer := alignedBuffer(directChunk)
+		return &buffer
+	},
+}
+
+// alignedBuffer returns buffer of given size starting at address aligned to
+// directAlignment
+func alignedBuffer(size int) []byte {
+	buffer := make([]byte, size+directAlignment)
+	shift := int(uintptr(unsafe.Pointer(&buffer[0])) & (directAlignment - 1))
+	if shift != 0 {
+		shift = directAlignment - shift
+	}
+	return buffer[shift : shift+size : shift+size]
+}
+
+// readDirect reads whole file of given size from O_DIRECT descriptor into
+// aligned buffer
+func readDirect(fd int, size int64) ([]byte, error) {
+	length := (int(size) + directAlignment - 1) &^ (directAlignment - 1)
+	if length == 0 {
+		length = directAlignment
+	}
+	buffer := alignedBuffer(length)
+	read := 0
+	for read < len(buffer) {
+		chunk := buffer[read:]
+		if len(chunk) > maxTransfer {
+			chunk = chunk[:maxTransfer]
+		}
+		n, err := sysRead(fd, chunk)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n <= 0 {
+			break
+		}
+		read += n
+		// unaligned count means end of file, reading further from unaligned
+		// offset would fail
+		if n%directAlignment != 0 {
+			break
+		}
+	}
+	return buffer[:read], nil
+}
+
+// writeDirect writes segments to O_DIRECT descriptor staging them in aligned
+// chunks, tail shorter than directAlignment is written through page cache
+func writeDirect(fd int, segments [][]byte) error {
+	staged := directBuffers.Get().(*[]byte)
+	defer directBuffers.Put(staged)
+	buffer := *staged
+	filled := 0
+	for _, segment := range segments {
+		for len(segment) > 0 {
+			n := copy(buffer[filled:], segment)
+			segment = segment[n:]
+			filled += n
+			if filled < len(buffer) {
+				continue
+			}
+			if err := writeFull(fd, buffer); err != nil {
+				return err
+			}
+			filled = 0
+		}
+	}
+	aligned := filled &^ (directAlignment - 1)
+	if aligned > 0 {
+		if err := writeFull(fd, buffer[:aligned]); err != nil {
+			return err
+		}
+	}
+	if aligned == filled {
+		return nil
+	}
+	if err := clearDirect(fd); err != nil {
+		return err
+	}
+	return writeFull(fd, buffer[aligned:filled])
+}
@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+func TestDirectIO(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	t.Log("aligned buffers")
+	{
+		for _, size := range []int{1, directAlignment, directChunk} {
+			buffer := alignedBuffer(size)
+			if len(buffer) != size || uintptr(unsafe.Pointer(&buffer[0]))%directAlignment != 0 {
+				t.Errorf("expected aligned buffer of %d bytes", size)
+			}
+		}
+	}
+
+	t.Log("descriptor bypasses page cache where supported")
+	{
+		fd, direct, err := openFile(tmpdir+"/probe", syscall.O_CREAT|syscall.O_WRONLY, 0600, true, false)
+		if err != nil {
+			t.Fatalf("unexpected error when calling openFile %+v", err)
+		}
+		syscall.Close(fd)
+		if !direct {
+			t.Log("filesystem of temp dir does not support O_DIRECT, page cache fallback is tested")
+		}
+	}
+
+	plaintext, _ := NewPlaintextStorageWithOptions(tmpdir+"/plain", PlaintextOptions{DirectIO: true})
+	encrypted, _ := NewEncryptedStorageWithOptions(tmpdir+"/encrypted", getKey(), EncryptionOptions{DirectIO: true, HMAC: true})
+	buffered, _ := NewPlaintextStorage(tmpdir + "/plain")
+
+	for _, size := range []int{0, 1, directAlignment - 1, directAlignment, directAlignment + 1, directChunk, 3*directChunk + 7} {
+		data := make([]byte, size)
+		rand.Read(data)
+
+		if err := plaintext.WriteFile("file", data); err != nil {
+			t.Fatalf("unexpected error when calling WriteFile of %d bytes %+v", size, err)
+		}
+		if content, err := plaintext.ReadFileFully("file"); err != nil || !bytes.Equal(content, data) {
+			t.Errorf("expected direct read of %d bytes to equal written got %d %+v", size, len(content), err)
+		}
+		if content, _ := buffered.ReadFileFully("file"); !bytes.Equal(content, data) {
+			t.Errorf("expected buffered read of %d bytes to equal direct write got %d", size, len(content))
+		}
+
+		if err := encrypted.WriteFile("file", data); err != nil {
+			t.Fatalf("unexpected error when calling WriteFile of %d bytes %+v", size, err)
+		}
+		if content, err := encrypted.ReadFileFully("file"); err != nil || !bytes.Equal(content, data) {
+			t.Errorf("expected encrypted direct read of %d bytes to equal written got %+v", size, err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	direntReclenOffset = int(unsafe.Offsetof(syscall.Dirent{}.Reclen))
+	direntTypeOffset   = int(unsafe.Offsetof(syscall.Dirent{}.Type))
+	direntNameOffset   = int(unsafe.Offsetof(syscall.Dirent{}.Name))
+)
+
+// direntUint16 reads native endian uint16 at offset of record, zero when
+// record is too short
+func direntUint16(record []byte, offset int) uint16 {
+	if offset+2 > len(record) {
+		return 0
+	}
+	return *(*uint16)(unsafe.Pointer(&record[offset]))
+}
+
+// direntUint64 reads native endian uint64 at offset of record, zero when
+// record is too short
+func direntUint64(record []byte, offset int) uint64 {
+	if offset+8 > len(record) {
+		return 0
+	}
+	return *(*uint64)(unsafe.Pointer(&record[offset]))
+}
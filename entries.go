@@ -0,0 +1,173 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// EntryType is kind of directory entry
+type EntryType uint8
+
+const (
+	// EntryOther is device, socket, pipe or entry of unknown kind
+	EntryOther EntryType = iota
+	// EntryFile is regular file
+	EntryFile
+	// EntryDirectory is directory
+	EntryDirectory
+	// EntrySymlink is symbolic link, it is never followed
+	EntrySymlink
+)
+
+// String returns name of entry type
+func (kind EntryType) String() string {
+	switch kind {
+	case EntryFile:
+		return "file"
+	case EntryDirectory:
+		return "dir"
+	case EntrySymlink:
+		return "symlink"
+	default:
+		return "other"
+	}
+}
+
+// DirEntry represents item of directory, Size and ModTime are zero unless
+// requested, Size is size on disk (ciphertext for encrypted storage)
+type DirEntry struct {
+	Name    string    `json:"name"`
+	Type    EntryType `json:"type"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+func entryTypeOf(kind uint8) (EntryType, bool) {
+	switch kind {
+	case syscall.DT_REG:
+		return EntryFile, true
+	case syscall.DT_DIR:
+		return EntryDirectory, true
+	case syscall.DT_LNK:
+		return EntrySymlink, true
+	case syscall.DT_UNKNOWN:
+		return EntryOther, false
+	default:
+		return EntryOther, true
+	}
+}
+
+func entryTypeOfMode(mode uint16) EntryType {
+	switch mode & unix.S_IFMT {
+	case unix.S_IFREG:
+		return EntryFile
+	case unix.S_IFDIR:
+		return EntryDirectory
+	case unix.S_IFLNK:
+		return EntrySymlink
+	default:
+		return EntryOther
+	}
+}
+
+// listEntries returns ascending entries of directory, entries are stat-ed
+// relative to directory descriptor in one pass after scan when info is
+// requested or when filesystem does not report entry type
+func listEntries(absPath string, bufferSize int, info bool) ([]DirEntry, error) {
+	result := make([]DirEntry, 0)
+	unknown := false
+	err := scanDirectory(absPath, bufferSize, func(name []byte, kind uint8) bool {
+		entryType, known := entryTypeOf(kind)
+		unknown = unknown || !known
+		result = append(result, DirEntry{
+			Name: string(name),
+			Type: entryType,
+		})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	if !info && !unknown {
+		return result, nil
+	}
+	dirfd, err := unix.Open(filepath.Clean(absPath), unix.O_RDONLY|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(dirfd)
+	mask := statxType
+	if info {
+		mask |= statxSize | statxMtime
+	}
+	present := result[:0]
+	for _, entry := range result {
+		meta, err := statAt(dirfd, entry.Name, mask)
+		if err == unix.ENOENT {
+			// entry was removed after scan
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry.Type = entryTypeOfMode(meta.mode)
+		if info {
+			entry.Size = meta.size
+			entry.ModTime = meta.modified
+		}
+		present = append(present, entry)
+	}
+	return present, nil
+}
+
+// hideControlEntries removes control directory from entries listed in path
+// relative to root
+func hideControlEntries(path string, entries []DirEntry, err error) ([]DirEntry, error) {
+	visible := visibleEntry(path)
+	if err != nil || visible == nil {
+		return entries, err
+	}
+	result := entries[:0]
+	for _, entry := range entries {
+		if visible(entry.Name) {
+			result = append(result, entry)
+		}
+	}
+	return result, nil
+}
+
+// ListEntries returns ascending slice of entries in given path with their
+// type, size and modification time are filled when info is true
+func (storage PlaintextStorage) ListEntries(path string, info bool) ([]DirEntry, error) {
+	entries, err := listEntries(storage.root+"/"+path, storage.bufferSize, info)
+	return hideControlEntries(path, entries, err)
+}
+
+// ListEntries returns ascending slice of entries in given path with their
+// type, size and modification time (of ciphertext) are filled when info is
+// true
+func (storage EncryptedStorage) ListEntries(path string, info bool) ([]DirEntry, error) {
+	entries, err := listEntries(storage.root+"/"+path, storage.bufferSize, info)
+	return hideControlEntries(path, entries, err)
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestListEntries(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	plaintext := storage.(PlaintextStorage)
+	storage.WriteFile("dir/file", []byte("abcd"))
+	storage.Mkdir("dir/sub")
+	if err = os.Symlink("file", tmpdir+"/dir/link"); err != nil {
+		t.Fatalf("unexpected error when creating symlink %+v", err)
+	}
+
+	t.Log("types only")
+	{
+		entries, err := plaintext.ListEntries("dir", false)
+		if err != nil {
+			t.Fatalf("unexpected error when calling ListEntries %+v", err)
+		}
+		if len(entries) != 3 {
+			t.Fatalf("expected 3 entries got %+v", entries)
+		}
+		expected := []DirEntry{
+			{Name: "file", Type: EntryFile},
+			{Name: "link", Type: EntrySymlink},
+			{Name: "sub", Type: EntryDirectory},
+		}
+		for i := range expected {
+			if entries[i] != expected[i] {
+				t.Errorf("expected %+v got %+v", expected[i], entries[i])
+			}
+		}
+	}
+
+	t.Log("with info")
+	{
+		entries, err := plaintext.ListEntries("dir", true)
+		if err != nil {
+			t.Fatalf("unexpected error when calling ListEntries %+v", err)
+		}
+		info, _ := os.Stat(tmpdir + "/dir/file")
+		if entries[0].Size != 4 || !entries[0].ModTime.Equal(info.ModTime()) {
+			t.Errorf("unexpected file entry %+v", entries[0])
+		}
+		if entries[1].Size != int64(len("file")) {
+			t.Errorf("expected symlink not to be followed got %+v", entries[1])
+		}
+	}
+
+	t.Log("missing directory")
+	{
+		if _, err := plaintext.ListEntries("missing", true); err == nil {
+			t.Errorf("expected error when listing missing directory")
+		}
+	}
+}
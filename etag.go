@@ -0,0 +1,211 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// ErrETagMismatch is returned when file changed since its etag was read
+var ErrETagMismatch = errors.New("etag mismatch")
+
+// conditionalWriter is implemented by storages supporting compare-and-swap
+// writes
+type conditionalWriter interface {
+	ReadFileWithETag(path string) ([]byte, string, error)
+	WriteFileIfMatch(path string, data []byte, etag string) error
+}
+
+// ReadFileWithETag reads whole file and returns its etag, ENOTSUP is returned
+// by storages without support
+func ReadFileWithETag(storage Storage, path string) ([]byte, string, error) {
+	if candidate, ok := storage.(conditionalWriter); ok {
+		return candidate.ReadFileWithETag(path)
+	}
+	return nil, "", syscall.ENOTSUP
+}
+
+// WriteFileIfMatch writes data to file only when its etag still equals given
+// one, empty etag writes only when file does not exist, ErrETagMismatch is
+// returned otherwise
+func WriteFileIfMatch(storage Storage, path string, data []byte, etag string) error {
+	if candidate, ok := storage.(conditionalWriter); ok {
+		return candidate.WriteFileIfMatch(path, data, etag)
+	}
+	return syscall.ENOTSUP
+}
+
+// contentETag returns etag of stored content of file
+func contentETag(raw []byte) string {
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:16])
+}
+
+// writeIfMatch replaces stored content of file with segments when etag of its
+// current content matches, segments are written into temporary file renamed
+// into place under exclusive lock of replaced file so crash never leaves file
+// torn
+func writeIfMatch(filename string, etag string, handles *handleRegistry, timeout time.Duration, segments [][]byte) error {
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+		return err
+	}
+	temporary := temporarySibling(filename, "cas")
+	fd, err := openRetrying(temporary, syscall.O_CREAT|syscall.O_WRONLY|syscall.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer handles.track(filename, "cas")()
+	err = writevFull(fd, segments)
+	if err == nil {
+		err = syscall.Fsync(fd)
+	}
+	syscall.Close(fd)
+	if err == nil && etag == "" {
+		// link fails when file exists so it is created only when missing
+		if err = os.Link(temporary, filename); os.IsExist(err) {
+			err = ErrETagMismatch
+		}
+	} else if err == nil {
+		err = renameIfMatch(temporary, filename, etag, timeout)
+	}
+	os.Remove(temporary)
+	if err != nil {
+		return err
+	}
+	return syncDirectory(filepath.Dir(filename))
+}
+
+// renameIfMatch renames temporary over filename under its exclusive lock
+// when etag of its current content matches, lock taken on file already
+// replaced by another process is retried on file now in place
+func renameIfMatch(temporary string, filename string, etag string, timeout time.Duration) error {
+	for {
+		fd, err := openRetrying(filename, syscall.O_RDONLY|syscall.O_NONBLOCK, 0)
+		if err == syscall.ENOENT {
+			return ErrETagMismatch
+		}
+		if err != nil {
+			return err
+		}
+		replaced, err := func() (bool, error) {
+			defer syscall.Close(fd)
+			if err := flock(fd, filename, syscall.LOCK_EX, timeout); err != nil {
+				return false, err
+			}
+			defer funlock(fd, filename)
+			var locked, current syscall.Stat_t
+			if err := syscall.Fstat(fd, &locked); err != nil {
+				return false, err
+			}
+			if err := syscall.Stat(filename, &current); err == syscall.ENOENT {
+				return false, ErrETagMismatch
+			} else if err != nil {
+				return false, err
+			}
+			if locked.Dev != current.Dev || locked.Ino != current.Ino {
+				return true, nil
+			}
+			content := make([]byte, locked.Size)
+			n, err := preadFull(fd, content, 0)
+			if err != nil {
+				return false, err
+			}
+			if contentETag(content[:n]) != etag {
+				return false, ErrETagMismatch
+			}
+			return false, os.Rename(temporary, filename)
+		}()
+		if !replaced {
+			return err
+		}
+	}
+}
+
+// ReadFileWithETag reads whole file and returns etag derived from hash of its
+// content
+func (storage PlaintextStorage) ReadFileWithETag(path string) ([]byte, string, error) {
+	data, err := storage.ReadFileFully(path)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, contentETag(data), nil
+}
+
+// WriteFileIfMatch writes data to file only when its content did not change
+// since etag was read
+func (storage PlaintextStorage) WriteFileIfMatch(path string, data []byte, etag string) error {
+	defer storage.barrier.enter()()
+	filename := filepath.Clean(storage.root + "/" + path)
+	defer lockPath(filename).Unlock()
+	return writeIfMatch(filename, etag, storage.handles, storage.lockTimeout, [][]byte{data})
+}
+
+// ReadFileWithETag reads and decrypts whole file and returns etag derived
+// from hash of its ciphertext
+func (storage EncryptedStorage) ReadFileWithETag(path string) ([]byte, string, error) {
+	raw := PlaintextStorage{
+		root:        storage.root,
+		bufferSize:  storage.bufferSize,
+		handles:     storage.handles,
+		noAtime:     storage.noAtime,
+		lockTimeout: storage.lockTimeout,
+	}
+	ciphertext, err := raw.ReadFileFully(path)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := storage.decrypt(path, ciphertext)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, contentETag(ciphertext), nil
+}
+
+// WriteFileIfMatch encrypts data and writes it to file only when its
+// ciphertext did not change since etag was read
+func (storage EncryptedStorage) WriteFileIfMatch(path string, data []byte, etag string) error {
+	defer storage.barrier.enter()()
+	filename := filepath.Clean(storage.root + "/" + path)
+	defer lockPath(filename).Unlock()
+	segments, err := storage.encryptSegments(path, data)
+	if err != nil {
+		return err
+	}
+	return writeIfMatch(filename, etag, storage.handles, storage.lockTimeout, segments)
+}
+
+// ReadFileWithETag reads whole object and returns ETag of object store
+func (storage S3Storage) ReadFileWithETag(path string) ([]byte, string, error) {
+	return storage.get(path)
+}
+
+// WriteFileIfMatch writes object via conditional put guarded by ETag
+func (storage S3Storage) WriteFileIfMatch(path string, data []byte, etag string) error {
+	headers := map[string]string{"If-Match": etag}
+	if etag == "" {
+		headers = map[string]string{"If-None-Match": "*"}
+	}
+	err := storage.put("write", path, data, headers)
+	if os.IsExist(err) || os.IsNotExist(err) {
+		return ErrETagMismatch
+	}
+	return err
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+func TestWriteFileIfMatch(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	server := httptest.NewServer(&fakeS3{objects: make(map[string]fakeObject)})
+	defer server.Close()
+
+	plaintext, _ := NewPlaintextStorage(tmpdir + "/plain")
+	encrypted, _ := NewEncryptedStorage(tmpdir+"/encrypted", getKey())
+	s3, _ := NewS3Storage(S3Config{
+		Endpoint:  server.URL,
+		Bucket:    "bucket",
+		AccessKey: "key",
+		SecretKey: "secret",
+	})
+
+	for name, storage := range map[string]Storage{"plaintext": plaintext, "encrypted": encrypted, "s3": s3} {
+		t.Log(name)
+
+		if err := WriteFileIfMatch(storage, "balance", []byte("0"), ""); err != nil {
+			t.Fatalf("unexpected error when creating file %+v", err)
+		}
+		if err := WriteFileIfMatch(storage, "balance", []byte("0"), ""); err != ErrETagMismatch {
+			t.Errorf("expected ErrETagMismatch when creating existing file got %+v", err)
+		}
+
+		data, etag, err := ReadFileWithETag(storage, "balance")
+		if err != nil || string(data) != "0" || etag == "" {
+			t.Fatalf("unexpected result of ReadFileWithETag %q %q %+v", data, etag, err)
+		}
+		if err := WriteFileIfMatch(storage, "balance", []byte("1"), etag); err != nil {
+			t.Fatalf("unexpected error when calling WriteFileIfMatch %+v", err)
+		}
+		if err := WriteFileIfMatch(storage, "balance", []byte("2"), etag); err != ErrETagMismatch {
+			t.Errorf("expected ErrETagMismatch for stale etag got %+v", err)
+		}
+		if data, _ := storage.ReadFileFully("balance"); string(data) != "1" {
+			t.Errorf("expected stale write to be rejected got %q", data)
+		}
+		if err := WriteFileIfMatch(storage, "missing", []byte("1"), etag); err != ErrETagMismatch {
+			t.Errorf("expected ErrETagMismatch for missing file got %+v", err)
+		}
+	}
+
+	t.Log("optimistic concurrency")
+	{
+		plaintext.WriteFile("counter", []byte{0})
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					data, etag, err := ReadFileWithETag(plaintext, "counter")
+					if err != nil {
+						t.Errorf("unexpected error when calling ReadFileWithETag %+v", err)
+						return
+					}
+					if err = WriteFileIfMatch(plaintext, "counter", []byte{data[0] + 1}, etag); err != ErrETagMismatch {
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if data, _ := plaintext.ReadFileFully("counter"); data[0] != 16 {
+			t.Errorf("expected no lost updates got counter %d", data[0])
+		}
+	}
+
+	t.Log("journaled storage records conditional writes")
+	{
+		storage, _ := NewJournaledStorage(plaintext)
+		_, etag, err := ReadFileWithETag(storage, "counter")
+		if err != nil {
+			t.Fatalf("unexpected error when calling ReadFileWithETag %+v", err)
+		}
+		if err = WriteFileIfMatch(storage, "counter", []byte{0}, etag); err != nil {
+			t.Errorf("unexpected error when calling WriteFileIfMatch %+v", err)
+		}
+		if err = WriteFileIfMatch(storage, "counter", []byte{1}, etag); err != ErrETagMismatch {
+			t.Errorf("expected ErrETagMismatch got %+v", err)
+		}
+		writes := 0
+		storage.(JournaledStorage).Replay(0, func(entry JournalEntry) bool {
+			if entry.Path == "counter" {
+				writes++
+			}
+			return true
+		})
+		if writes != 1 {
+			t.Errorf("expected single journaled write got %d", writes)
+		}
+	}
+
+	t.Log("decorators without support do not bypass their transform")
+	{
+		storage := NewTracedStorage(plaintext, new(recordingTracer))
+		if _, _, err := ReadFileWithETag(storage, "counter"); err != syscall.ENOTSUP {
+			t.Errorf("expected ENOTSUP got %+v", err)
+		}
+		if err := WriteFileIfMatch(storage, "counter", []byte{1}, ""); err != syscall.ENOTSUP {
+			t.Errorf("expected ENOTSUP got %+v", err)
+		}
+	}
+
+	t.Log("replaced file leaves no temporary siblings")
+	{
+		names, _ := plaintext.ListDirectory("", true)
+		for _, name := range names {
+			if name != "balance" && name != "counter" {
+				t.Errorf("unexpected leftover %s", name)
+			}
+		}
+	}
+
+	t.Log("unsupported storage")
+	{
+		if _, _, err := ReadFileWithETag(struct{ Storage }{plaintext}, "balance"); err != syscall.ENOTSUP {
+			t.Errorf("expected ENOTSUP got %+v", err)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CollectorMetrics represents counters of expiration collector
+type CollectorMetrics struct {
+	Runs    uint64    `json:"runs"`
+	Scanned uint64    `json:"scanned"`
+	Expired uint64    `json:"expired"`
+	Deleted uint64    `json:"deleted"`
+	Failed  uint64    `json:"failed"`
+	LastRun time.Time `json:"lastRun"`
+}
+
+// Collector deletes files older than TTL registered for their prefix
+type Collector struct {
+	storage  Storage
+	root     string
+	interval time.Duration
+	dryRun   bool
+	mutex    sync.Mutex
+	ttls     map[string]time.Duration
+	metrics  CollectorMetrics
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// NewCollector returns collector over given storage running every interval,
+// in dry run mode expired files are only reported and never deleted
+func NewCollector(storage Storage, interval time.Duration, dryRun bool) (*Collector, error) {
+	root, ok := rootOf(storage)
+	if !ok {
+		return nil, fmt.Errorf("expiration requires local storage")
+	}
+	return &Collector{
+		storage:  storage,
+		root:     root,
+		interval: interval,
+		dryRun:   dryRun,
+		ttls:     make(map[string]time.Duration),
+	}, nil
+}
+
+// RegisterTTL sets time to live of files under given prefix by their last
+// modification, non positive ttl unregisters prefix
+func (collector *Collector) RegisterTTL(prefix string, ttl time.Duration) {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	prefix = filepath.Clean(prefix)
+	if ttl <= 0 {
+		delete(collector.ttls, prefix)
+		return
+	}
+	collector.ttls[prefix] = ttl
+}
+
+// Metrics returns counters of collector
+func (collector *Collector) Metrics() CollectorMetrics {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	return collector.metrics
+}
+
+// Collect runs single pass and returns expired paths
+func (collector *Collector) Collect() ([]string, error) {
+	collector.mutex.Lock()
+	prefixes := make([]string, 0, len(collector.ttls))
+	for prefix := range collector.ttls {
+		prefixes = append(prefixes, prefix)
+	}
+	ttls := make(map[string]time.Duration, len(collector.ttls))
+	for prefix, ttl := range collector.ttls {
+		ttls[prefix] = ttl
+	}
+	collector.mutex.Unlock()
+
+	sort.Strings(prefixes)
+
+	var (
+		now      = time.Now()
+		expired  = make([]string, 0)
+		metrics  CollectorMetrics
+		visited  = make(map[string]bool)
+		firstErr error
+	)
+
+	for _, prefix := range prefixes {
+		ttl := ttls[prefix]
+		base := filepath.Clean(collector.root + "/" + prefix)
+		err := filepath.WalkDir(base, func(absPath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if absPath == base {
+					return nil
+				}
+				return err
+			}
+			relPath, err := filepath.Rel(filepath.Clean(collector.root), absPath)
+			if err != nil {
+				return err
+			}
+			if isInternalPath(relPath) {
+				return filepath.SkipDir
+			}
+			if !entry.Type().IsRegular() {
+				return nil
+			}
+			// longest registered prefix wins
+			if owner := ownerPrefix(relPath, ttls); owner != prefix || visited[relPath] {
+				return nil
+			}
+			visited[relPath] = true
+			metrics.Scanned++
+			info, err := entry.Info()
+			if err != nil {
+				return nil
+			}
+			if now.Sub(info.ModTime()) < ttl {
+				return nil
+			}
+			metrics.Expired++
+			expired = append(expired, relPath)
+			if collector.dryRun {
+				return nil
+			}
+			if err := collector.storage.Delete(relPath); err != nil {
+				metrics.Failed++
+				return nil
+			}
+			metrics.Deleted++
+			return nil
+		})
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	collector.mutex.Lock()
+	collector.metrics.Runs++
+	collector.metrics.Scanned += metrics.Scanned
+	collector.metrics.Expired += metrics.Expired
+	collector.metrics.Deleted += metrics.Deleted
+	collector.metrics.Failed += metrics.Failed
+	collector.metrics.LastRun = now
+	collector.mutex.Unlock()
+
+	return expired, firstErr
+}
+
+func ownerPrefix(relPath string, ttls map[string]time.Duration) string {
+	owner := ""
+	for prefix := range ttls {
+		if prefix != "." && relPath != prefix && !hasPathPrefix(relPath, prefix) {
+			continue
+		}
+		if len(prefix) > len(owner) {
+			owner = prefix
+		}
+	}
+	return owner
+}
+
+func hasPathPrefix(path string, prefix string) bool {
+	return len(path) > len(prefix) && path[:len(prefix)] == prefix && path[len(prefix)] == '/'
+}
+
+// Start runs collector in background until Stop is called
+func (collector *Collector) Start() {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	if collector.done != nil {
+		return
+	}
+	collector.done = make(chan struct{})
+	collector.stopped = make(chan struct{})
+	go func(done chan struct{}, stopped chan struct{}) {
+		defer close(stopped)
+		ticker := time.NewTicker(collector.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				collector.Collect()
+			}
+		}
+	}(collector.done, collector.stopped)
+}
+
+// Stop stops background collector and waits for running pass to finish
+func (collector *Collector) Stop() {
+	collector.mutex.Lock()
+	done, stopped := collector.done, collector.stopped
+	collector.done, collector.stopped = nil, nil
+	collector.mutex.Unlock()
+	if done == nil {
+		return
+	}
+	close(done)
+	<-stopped
+}
+
+// Name returns name of collector as maintenance task
+func (collector *Collector) Name() string {
+	return "expiration"
+}
+
+// Run runs single pass so collector can be registered to Scheduler
+func (collector *Collector) Run() error {
+	_, err := collector.Collect()
+	return err
+}
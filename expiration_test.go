@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCollector(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	old := time.Now().Add(-2 * time.Hour)
+	for _, path := range []string{"tmp/old", "tmp/keep/old", "events/old", "other/old"} {
+		if err = storage.WriteFile(path, []byte("abc")); err != nil {
+			t.Fatalf("unexpected error when calling WriteFile %+v", err)
+		}
+		if err = os.Chtimes(tmpdir+"/"+path, old, old); err != nil {
+			t.Fatalf("unexpected error when changing times %+v", err)
+		}
+	}
+	if err = storage.WriteFile("tmp/new", []byte("abc")); err != nil {
+		t.Fatalf("unexpected error when calling WriteFile %+v", err)
+	}
+
+	t.Log("dry run")
+	{
+		collector, err := NewCollector(storage, time.Minute, true)
+		if err != nil {
+			t.Fatalf("unexpected error when calling NewCollector %+v", err)
+		}
+		collector.RegisterTTL("tmp", time.Hour)
+		expired, err := collector.Collect()
+		if err != nil {
+			t.Fatalf("unexpected error when calling Collect %+v", err)
+		}
+		if len(expired) != 2 {
+			t.Errorf("expected 2 expired files got %+v", expired)
+		}
+		if ok, _ := storage.Exists("tmp/old"); !ok {
+			t.Errorf("expected dry run not to delete files")
+		}
+		if metrics := collector.Metrics(); metrics.Expired != 2 || metrics.Deleted != 0 || metrics.Scanned != 3 {
+			t.Errorf("unexpected metrics %+v", metrics)
+		}
+	}
+
+	t.Log("collect")
+	{
+		collector, _ := NewCollector(storage, time.Minute, false)
+		collector.RegisterTTL("tmp", time.Hour)
+		collector.RegisterTTL("tmp/keep", 24*time.Hour)
+		collector.RegisterTTL("events", time.Hour)
+		expired, err := collector.Collect()
+		if err != nil {
+			t.Fatalf("unexpected error when calling Collect %+v", err)
+		}
+		if len(expired) != 2 || expired[0] != "events/old" || expired[1] != "tmp/old" {
+			t.Errorf("expected events/old and tmp/old to expire got %+v", expired)
+		}
+		for path, expected := range map[string]bool{"tmp/old": false, "events/old": false, "tmp/keep/old": true, "tmp/new": true, "other/old": true} {
+			if ok, _ := storage.Exists(path); ok != expected {
+				t.Errorf("expected existence of %s to be %v", path, expected)
+			}
+		}
+	}
+
+	t.Log("background")
+	{
+		collector, _ := NewCollector(storage, time.Millisecond, false)
+		collector.RegisterTTL("other", time.Hour)
+		collector.Start()
+		for i := 0; i < 1000; i++ {
+			if ok, _ := storage.Exists("other/old"); !ok {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		collector.Stop()
+		if ok, _ := storage.Exists("other/old"); ok {
+			t.Errorf("expected background collector to delete other/old")
+		}
+	}
+
+	t.Log("snapshots and trash are not collected")
+	{
+		for _, path := range []string{SnapshotDirectory + "/1/tmp/old", TrashDirectory + "/1/tmp/old"} {
+			storage.WriteFile(path, []byte("abc"))
+			os.Chtimes(tmpdir+"/"+path, old, old)
+		}
+		collector, _ := NewCollector(storage, time.Minute, false)
+		collector.RegisterTTL("", time.Hour)
+		if _, err := collector.Collect(); err != nil {
+			t.Fatalf("unexpected error when calling Collect %+v", err)
+		}
+		for _, path := range []string{SnapshotDirectory + "/1/tmp/old", TrashDirectory + "/1/tmp/old"} {
+			if ok, _ := storage.Exists(path); !ok {
+				t.Errorf("expected %s to survive collection", path)
+			}
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ExportOptions customizes archive export
+type ExportOptions struct {
+	// Decrypt makes EncryptedStorage export plaintext instead of ciphertext
+	Decrypt bool
+	// Include selects files whose path relative to root matches any of glob
+	// patterns, empty means all
+	Include []string
+	// ModifiedAfter selects files modified at or after given time
+	ModifiedAfter time.Time
+	// ModifiedBefore selects files modified before given time
+	ModifiedBefore time.Time
+	// Tenants selects files of given tenants stored under t_<tenant>
+	Tenants []string
+}
+
+// filtered returns true when options select subset of files
+func (options ExportOptions) filtered() bool {
+	return len(options.Include) > 0 || !options.ModifiedAfter.IsZero() || !options.ModifiedBefore.IsZero() || len(options.Tenants) > 0
+}
+
+// selects returns true when file of given relative path and modification
+// time passes all filters
+func (options ExportOptions) selects(relPath string, modified time.Time) bool {
+	if !options.ModifiedAfter.IsZero() && modified.Before(options.ModifiedAfter) {
+		return false
+	}
+	if !options.ModifiedBefore.IsZero() && !modified.Before(options.ModifiedBefore) {
+		return false
+	}
+	if len(options.Tenants) > 0 {
+		first := strings.SplitN(relPath, "/", 2)[0]
+		found := false
+		for _, tenant := range options.Tenants {
+			if first == "t_"+tenant {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(options.Include) > 0 {
+		for _, pattern := range options.Include {
+			if ok, _ := path.Match(pattern, relPath); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// exportArchive streams deterministic tar.gz of subtree, entries are visited
+// in lexical order and files are read by given func under storage locking,
+// when options filter files only directories leading to selected files are
+// exported
+func exportArchive(w io.Writer, root string, prefix string, options ExportOptions, read func(string) ([]byte, error)) error {
+	base := filepath.Clean(root)
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	filtered := options.filtered()
+	written := make(map[string]bool)
+
+	var writeParents func(string) error
+	writeParents = func(relPath string) error {
+		dir := path.Dir(relPath)
+		if dir == "." || written[dir] {
+			return nil
+		}
+		if err := writeParents(dir); err != nil {
+			return err
+		}
+		info, err := os.Stat(filepath.Join(base, filepath.FromSlash(dir)))
+		if err != nil {
+			return err
+		}
+		written[dir] = true
+		return archive.WriteHeader(&tar.Header{
+			Name:     dir + "/",
+			Mode:     int64(info.Mode().Perm()),
+			ModTime:  info.ModTime().UTC(),
+			Format:   tar.FormatPAX,
+			Typeflag: tar.TypeDir,
+		})
+	}
+
+	err := filepath.WalkDir(filepath.Clean(base+"/"+prefix), func(absPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(base, absPath)
+		if err != nil {
+			return err
+		}
+		if isInternalPath(relPath) {
+			return filepath.SkipDir
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		header := &tar.Header{
+			Name:    filepath.ToSlash(relPath),
+			Mode:    int64(info.Mode().Perm()),
+			ModTime: info.ModTime().UTC(),
+			Format:  tar.FormatPAX,
+		}
+		switch {
+		case entry.IsDir():
+			if relPath == "." || filtered {
+				return nil
+			}
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+			return archive.WriteHeader(header)
+		case entry.Type().IsRegular():
+			if filtered {
+				if !options.selects(filepath.ToSlash(relPath), info.ModTime()) {
+					return nil
+				}
+				if err = writeParents(filepath.ToSlash(relPath)); err != nil {
+					return err
+				}
+			}
+			data, err := read(relPath)
+			if err != nil {
+				return err
+			}
+			header.Typeflag = tar.TypeReg
+			header.Size = int64(len(data))
+			if err = archive.WriteHeader(header); err != nil {
+				return err
+			}
+			_, err = archive.Write(data)
+			return err
+		default:
+			return nil
+		}
+	})
+	if err != nil {
+		archive.Close()
+		gz.Close()
+		return err
+	}
+	if err = archive.Close(); err != nil {
+		gz.Close()
+		return err
+	}
+	return gz.Close()
+}
+
+// ExportArchive streams deterministic tar.gz of files at given prefix
+// selected by options, hints advise kernel how to cache exported files
+func (storage PlaintextStorage) ExportArchive(w io.Writer, prefix string, options ExportOptions, hints ...ScanOption) error {
+	if len(hints) > 0 {
+		scan := newScanHints(hints)
+		scan.lockTimeout = storage.lockTimeout
+		return exportArchive(w, storage.root, prefix, options, func(path string) ([]byte, error) {
+			return scan.read(filepath.Clean(storage.root+"/"+path), storage.handles)
+		})
+	}
+	return exportArchive(w, storage.root, prefix, options, storage.ReadFileFully)
+}
+
+// ExportArchive streams deterministic tar.gz of files at given prefix
+// selected by options, files are exported as stored unless options ask for
+// decryption, hints advise kernel how to cache exported files
+func (storage EncryptedStorage) ExportArchive(w io.Writer, prefix string, options ExportOptions, hints ...ScanOption) error {
+	if len(hints) > 0 {
+		scan := newScanHints(hints)
+		scan.lockTimeout = storage.lockTimeout
+		return exportArchive(w, storage.root, prefix, options, func(path string) ([]byte, error) {
+			raw, err := scan.read(filepath.Clean(storage.root+"/"+path), storage.handles)
+			if err != nil || !options.Decrypt {
+				return raw, err
+			}
+			return storage.decrypt(path, raw)
+		})
+	}
+	if options.Decrypt {
+		return exportArchive(w, storage.root, prefix, options, storage.ReadFileFully)
+	}
+	raw := PlaintextStorage{
+		root:        storage.root,
+		bufferSize:  storage.bufferSize,
+		handles:     storage.handles,
+		directIO:    storage.directIO,
+		noAtime:     storage.noAtime,
+		lockTimeout: storage.lockTimeout,
+	}
+	return exportArchive(w, storage.root, prefix, options, raw.ReadFileFully)
+}
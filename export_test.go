@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func readArchive(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error when opening gzip %+v", err)
+	}
+	archive := tar.NewReader(gz)
+	result := make(map[string]string)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return result
+		}
+		if err != nil {
+			t.Fatalf("unexpected error when reading tar %+v", err)
+		}
+		content, _ := ioutil.ReadAll(archive)
+		result[header.Name] = string(content)
+	}
+}
+
+func TestExportArchive(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewEncryptedStorage(tmpdir, getKey())
+	encrypted := storage.(EncryptedStorage)
+	storage.WriteFile("account/a/snapshot", []byte("alpha"))
+	storage.WriteFile("account/b/snapshot", []byte("beta"))
+	storage.WriteFile("other/c", []byte("gamma"))
+
+	t.Log("decrypted")
+	{
+		var buffer bytes.Buffer
+		if err = encrypted.ExportArchive(&buffer, "account", ExportOptions{Decrypt: true}); err != nil {
+			t.Fatalf("unexpected error when calling ExportArchive %+v", err)
+		}
+		entries := readArchive(t, buffer.Bytes())
+		if len(entries) != 5 {
+			t.Errorf("expected 5 entries got %+v", entries)
+		}
+		if entries["account/a/snapshot"] != "alpha" || entries["account/b/snapshot"] != "beta" {
+			t.Errorf("expected decrypted content got %+v", entries)
+		}
+		if _, ok := entries["other/c"]; ok {
+			t.Errorf("expected files outside of prefix not to be exported")
+		}
+
+		var again bytes.Buffer
+		encrypted.ExportArchive(&again, "account", ExportOptions{Decrypt: true})
+		if !bytes.Equal(buffer.Bytes(), again.Bytes()) {
+			t.Errorf("expected export to be deterministic")
+		}
+	}
+
+	t.Log("raw")
+	{
+		var buffer bytes.Buffer
+		if err = encrypted.ExportArchive(&buffer, "account", ExportOptions{}); err != nil {
+			t.Fatalf("unexpected error when calling ExportArchive %+v", err)
+		}
+		entries := readArchive(t, buffer.Bytes())
+		if len(entries["account/a/snapshot"]) != 16+5 {
+			t.Errorf("expected ciphertext to be exported got %q", entries["account/a/snapshot"])
+		}
+	}
+}
+
+func TestExportArchiveFiltered(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	plaintext := storage.(PlaintextStorage)
+	storage.WriteFile("t_alpha/account/x/snapshot", []byte("1"))
+	storage.WriteFile("t_alpha/account/x/events/1", []byte("2"))
+	storage.WriteFile("t_alpha/account/y/snapshot", []byte("3"))
+	storage.WriteFile("t_beta/account/x/snapshot", []byte("4"))
+
+	march := time.Date(2023, time.March, 10, 0, 0, 0, 0, time.UTC)
+	os.Chtimes(tmpdir+"/t_alpha/account/x/snapshot", march, march)
+
+	t.Log("by tenant and glob")
+	{
+		var buffer bytes.Buffer
+		err := plaintext.ExportArchive(&buffer, ".", ExportOptions{
+			Tenants: []string{"alpha"},
+			Include: []string{"t_*/account/x/*"},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error when calling ExportArchive %+v", err)
+		}
+		entries := readArchive(t, buffer.Bytes())
+		if len(entries) != 4 || entries["t_alpha/account/x/snapshot"] != "1" {
+			t.Errorf("expected t_alpha/account/x/snapshot with its directories got %+v", entries)
+		}
+		if _, ok := entries["t_alpha/account/x/"]; !ok {
+			t.Errorf("expected parent directory to be exported got %+v", entries)
+		}
+	}
+
+	t.Log("by modification time")
+	{
+		var buffer bytes.Buffer
+		err := plaintext.ExportArchive(&buffer, "t_alpha", ExportOptions{
+			ModifiedAfter:  time.Date(2023, time.March, 1, 0, 0, 0, 0, time.UTC),
+			ModifiedBefore: time.Date(2023, time.April, 1, 0, 0, 0, 0, time.UTC),
+		})
+		if err != nil {
+			t.Fatalf("unexpected error when calling ExportArchive %+v", err)
+		}
+		entries := readArchive(t, buffer.Bytes())
+		if len(entries) != 4 || entries["t_alpha/account/x/snapshot"] != "1" {
+			t.Errorf("expected only file modified in March got %+v", entries)
+		}
+	}
+}
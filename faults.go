@@ -0,0 +1,45 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+// faultHook is called before every write system call, see SetFaultHook
+var faultHook func(op string, size int) (int, func())
+
+// SetFaultHook installs hook called with name of system call and number of
+// bytes about to be written before every write system call of storage, it
+// exists for crash testing (see crashtest package) and must not be used in
+// production and must be installed before storage is used, when hook returns
+// non nil func only returned number of bytes is written and func is called
+// right after to kill process in middle of torn write, it is not expected to
+// return
+func SetFaultHook(hook func(op string, size int) (int, func())) {
+	faultHook = hook
+}
+
+// faultPoint returns number of bytes of write of size bytes allowed by fault
+// hook and continuation to be called right after they were written, nil
+// continuation lets write proceed whole
+func faultPoint(op string, size int) (int, func()) {
+	if faultHook == nil {
+		return size, nil
+	}
+	allowed, after := faultHook(op, size)
+	if allowed < 0 {
+		allowed = 0
+	} else if allowed > size {
+		allowed = size
+	}
+	return allowed, after
+}
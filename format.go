@@ -0,0 +1,143 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// FormatVersion is version of on-disk layout written by this package
+const FormatVersion = 1
+
+// formatFile is file in control directory holding format version of root
+const formatFile = "format"
+
+// ErrUnsupportedFormat is returned when root was written by incompatible
+// version of this package
+var ErrUnsupportedFormat = errors.New("unsupported on-disk format")
+
+// formatMigrations upgrade root from version given by key to next version
+var formatMigrations = map[int]func(root string) error{}
+
+// isControlPath returns true for path relative to root inside control
+// directory, such paths are internal state and never user data
+func isControlPath(relPath string) bool {
+	relPath = filepath.ToSlash(filepath.Clean(relPath))
+	return relPath == ControlDirectory || strings.HasPrefix(relPath, ControlDirectory+"/")
+}
+
+// visibleEntry returns filter of entries listed in path relative to root,
+// control directory in root is hidden from listings
+func visibleEntry(path string) func(name string) bool {
+	if filepath.Clean("/"+path) != "/" {
+		return nil
+	}
+	return func(name string) bool {
+		return name != ControlDirectory
+	}
+}
+
+// hideControl removes control directory from names listed in path relative
+// to root
+func hideControl(path string, names []string, err error) ([]string, error) {
+	visible := visibleEntry(path)
+	if err != nil || visible == nil {
+		return names, err
+	}
+	result := names[:0]
+	for _, name := range names {
+		if visible(name) {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+// isInternalPath returns true for path relative to root inside control,
+// snapshot or trash directory, walks over user data skip such paths
+func isInternalPath(relPath string) bool {
+	relPath = filepath.ToSlash(filepath.Clean(relPath))
+	for _, dir := range []string{ControlDirectory, SnapshotDirectory, TrashDirectory} {
+		if relPath == dir || strings.HasPrefix(relPath, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// readFormat returns format version of root, zero when root has no marker
+func readFormat(root string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(filepath.Clean(root), ControlDirectory, formatFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	version, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || version <= 0 {
+		return 0, fmt.Errorf("%w: invalid format marker %q", ErrUnsupportedFormat, strings.TrimSpace(string(data)))
+	}
+	return version, nil
+}
+
+func writeFormat(root string, version int) error {
+	filename := filepath.Join(filepath.Clean(root), ControlDirectory, formatFile)
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+		return err
+	}
+	return replaceControlFile(filename, []byte(strconv.Itoa(version)+"\n"))
+}
+
+// ensureFormat marks root with current format version on first use, runs
+// migrations of roots written by older version and refuses roots written by
+// newer version, roots without marker predate it and use version 1 layout,
+// read-only root without marker is used as version 1 without marking it
+func ensureFormat(root string) error {
+	version, err := readFormat(root)
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		err = writeFormat(root, FormatVersion)
+		if errors.Is(err, syscall.EROFS) || errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EPERM) {
+			return nil
+		}
+		return err
+	}
+	if version > FormatVersion {
+		return fmt.Errorf("%w: root %s has format version %d, newest supported is %d", ErrUnsupportedFormat, root, version, FormatVersion)
+	}
+	for version < FormatVersion {
+		migration, ok := formatMigrations[version]
+		if !ok {
+			return fmt.Errorf("%w: no migration of root %s from format version %d", ErrUnsupportedFormat, root, version)
+		}
+		if err = migration(root); err != nil {
+			return fmt.Errorf("migration of root %s from format version %d failed %w", root, version, err)
+		}
+		version++
+		if err = writeFormat(root, version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestFormatVersion(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	marker := tmpdir + "/" + ControlDirectory + "/" + formatFile
+
+	t.Log("marks root on first use")
+	{
+		if _, err := NewPlaintextStorage(tmpdir); err != nil {
+			t.Fatalf("unexpected error when calling NewPlaintextStorage %+v", err)
+		}
+		if version, err := readFormat(tmpdir); err != nil || version != FormatVersion {
+			t.Errorf("expected format version %d got %d %+v", FormatVersion, version, err)
+		}
+	}
+
+	t.Log("refuses root of newer version")
+	{
+		os.WriteFile(marker, []byte("999\n"), 0600)
+		if _, err := NewEncryptedStorage(tmpdir, getKey()); !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("expected ErrUnsupportedFormat got %+v", err)
+		}
+		os.WriteFile(marker, []byte("garbage"), 0600)
+		if _, err := NewPlaintextStorage(tmpdir); !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("expected ErrUnsupportedFormat got %+v", err)
+		}
+	}
+
+	t.Log("accepts root of current version")
+	{
+		if err := writeFormat(tmpdir, FormatVersion); err != nil {
+			t.Fatalf("unexpected error when calling writeFormat %+v", err)
+		}
+		if _, err := NewPlaintextStorage(tmpdir); err != nil {
+			t.Errorf("unexpected error when calling NewPlaintextStorage %+v", err)
+		}
+	}
+
+	t.Log("control directory is not user data")
+	{
+		storage, _ := NewEncryptedStorage(tmpdir, getKey())
+		storage.WriteFile("file", []byte("data"))
+		report, err := storage.(EncryptedStorage).Verify("")
+		if err != nil || !report.Healthy() || report.Scanned != 1 {
+			t.Errorf("expected only user file to be verified got %+v %+v", report, err)
+		}
+	}
+
+	t.Log("control directory is hidden from root listings")
+	{
+		plaintext, _ := NewPlaintextStorage(tmpdir)
+		encrypted, _ := NewEncryptedStorage(tmpdir, getKey())
+		for name, storage := range map[string]Storage{"plaintext": plaintext, "encrypted": encrypted} {
+			for _, path := range []string{"", ".", "/"} {
+				names, err := storage.ListDirectory(path, true)
+				if err != nil || len(names) != 1 || names[0] != "file" {
+					t.Errorf("%s expected only user file in %q got %+v %+v", name, path, names, err)
+				}
+				names, err = ListDirectoryFiltered(storage, path, func(string) bool { return true })
+				if err != nil || len(names) != 1 || names[0] != "file" {
+					t.Errorf("%s expected only user file in filtered %q got %+v %+v", name, path, names, err)
+				}
+				seen := 0
+				storage.(interface {
+					ForEachEntry(string, func(string) bool) error
+				}).ForEachEntry(path, func(string) bool {
+					seen++
+					return true
+				})
+				if seen != 1 {
+					t.Errorf("%s expected single entry in %q got %d", name, path, seen)
+				}
+				if entries, err := storage.(interface {
+					ListEntries(string, bool) ([]DirEntry, error)
+				}).ListEntries(path, false); err != nil || len(entries) != 1 {
+					t.Errorf("%s expected single entry in %q got %+v %+v", name, path, entries, err)
+				}
+				if count, err := storage.CountFiles(path); err != nil || count != 1 {
+					t.Errorf("%s expected single file in %q got %d %+v", name, path, count, err)
+				}
+			}
+		}
+	}
+}
+
+func TestFormatReadOnlyRoot(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	os.WriteFile(tmpdir+"/file", []byte("data"), 0600)
+	os.Chmod(tmpdir, 0500)
+	defer os.Chmod(tmpdir, 0700)
+
+	t.Log("read-only root without marker is used as version 1")
+	{
+		storage, err := NewPlaintextStorage(tmpdir)
+		if err != nil {
+			t.Fatalf("unexpected error when calling NewPlaintextStorage %+v", err)
+		}
+		if data, err := storage.ReadFileFully("file"); err != nil || string(data) != "data" {
+			t.Errorf("expected data got %s %+v", string(data), err)
+		}
+		if _, err := os.Stat(tmpdir + "/" + ControlDirectory); !os.IsNotExist(err) {
+			t.Errorf("expected no marker to be written got %+v", err)
+		}
+	}
+}
+
+func TestFormatConcurrentOpen(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	t.Log("concurrent opens of fresh root all succeed")
+	{
+		for round := 0; round < 20; round++ {
+			root := fmt.Sprintf("%s/%d", tmpdir, round)
+			var wg sync.WaitGroup
+			failures := make(chan error, 32)
+			for i := 0; i < 32; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					var err error
+					if i%2 == 0 {
+						_, err = NewPlaintextStorage(root)
+					} else {
+						_, err = NewEncryptedStorage(root, getKey())
+					}
+					if err != nil {
+						failures <- err
+					}
+				}(i)
+			}
+			wg.Wait()
+			close(failures)
+			for err := range failures {
+				t.Errorf("unexpected error when opening fresh root %+v", err)
+			}
+			if version, err := readFormat(root); err != nil || version != FormatVersion {
+				t.Errorf("expected format version %d got %d %+v", FormatVersion, version, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"io"
+	"syscall"
+)
+
+// indirections allowing tests to emulate short transfers and interrupted
+// system calls
+var (
+	sysRead   = syscall.Read
+	sysPread  = syscall.Pread
+	sysPwrite = syscall.Pwrite
+	sysWrite  = syscall.Write
+	sysWritev = defaultWritev
+)
+
+// maxTransfer is largest count Linux transfers in single read or write
+const maxTransfer = 0x7ffff000
+
+// maxSegments is largest number of segments of single writev
+const maxSegments = 1024
+
+// readFull reads until buffer is full or end of file is reached, retrying
+// interrupted and short reads, returns number of bytes read
+func readFull(fd int, buf []byte) (int, error) {
+	read := 0
+	for read < len(buf) {
+		chunk := buf[read:]
+		if len(chunk) > maxTransfer {
+			chunk = chunk[:maxTransfer]
+		}
+		n, err := sysRead(fd, chunk)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return read, err
+		}
+		if n <= 0 {
+			break
+		}
+		read += n
+	}
+	return read, nil
+}
+
+// preadFull reads at offset until buffer is full or end of file is reached,
+// retrying interrupted and short reads, returns number of bytes read
+func preadFull(fd int, buf []byte, offset int64) (int, error) {
+	read := 0
+	for read < len(buf) {
+		chunk := buf[read:]
+		if len(chunk) > maxTransfer {
+			chunk = chunk[:maxTransfer]
+		}
+		n, err := sysPread(fd, chunk, offset+int64(read))
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return read, err
+		}
+		if n <= 0 {
+			break
+		}
+		read += n
+	}
+	return read, nil
+}
+
+// writeFull writes whole buffer retrying interrupted and short writes
+func writeFull(fd int, data []byte) error {
+	written := 0
+	for written < len(data) {
+		chunk := data[written:]
+		if len(chunk) > maxTransfer {
+			chunk = chunk[:maxTransfer]
+		}
+		if allowed, after := faultPoint("write", len(chunk)); after != nil {
+			sysWrite(fd, chunk[:allowed])
+			after()
+		}
+		n, err := sysWrite(fd, chunk)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if n <= 0 {
+			return io.ErrShortWrite
+		}
+		written += n
+	}
+	return nil
+}
+
+// pwriteFull writes whole buffer at offset retrying interrupted and short
+// writes
+func pwriteFull(fd int, data []byte, offset int64) error {
+	written := 0
+	for written < len(data) {
+		chunk := data[written:]
+		if len(chunk) > maxTransfer {
+			chunk = chunk[:maxTransfer]
+		}
+		if allowed, after := faultPoint("pwrite", len(chunk)); after != nil {
+			sysPwrite(fd, chunk[:allowed], offset+int64(written))
+			after()
+		}
+		n, err := sysPwrite(fd, chunk, offset+int64(written))
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if n <= 0 {
+			return io.ErrShortWrite
+		}
+		written += n
+	}
+	return nil
+}
+
+// writevFull writes all segments with writev retrying interrupted and short
+// writes, segments are not copied into combined buffer
+func writevFull(fd int, segments [][]byte) error {
+	pending := make([][]byte, 0, len(segments))
+	for _, segment := range segments {
+		if len(segment) > 0 {
+			pending = append(pending, segment)
+		}
+	}
+	for len(pending) > 0 {
+		batch, size := pending, 0
+		if len(batch) > maxSegments {
+			batch = batch[:maxSegments]
+		}
+		for i, segment := range batch {
+			if size+len(segment) > maxTransfer {
+				batch = pending[:i]
+				break
+			}
+			size += len(segment)
+		}
+		if len(batch) == 0 {
+			// single segment larger than one transfer
+			if err := writeFull(fd, pending[0]); err != nil {
+				return err
+			}
+			pending = pending[1:]
+			continue
+		}
+		if allowed, after := faultPoint("writev", size); after != nil {
+			sysWrite(fd, bytes.Join(batch, nil)[:allowed])
+			after()
+		}
+		n, err := sysWritev(fd, batch)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if n <= 0 {
+			return io.ErrShortWrite
+		}
+		for n > 0 {
+			if n < len(pending[0]) {
+				pending[0] = pending[0][n:]
+				break
+			}
+			n -= len(pending[0])
+			pending = pending[1:]
+		}
+	}
+	return nil
+}
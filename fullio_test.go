@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// restoreSyscalls puts real system calls back once test finishes however it
+// ends, so fakes never leak into other tests
+func restoreSyscalls(t *testing.T) {
+	t.Cleanup(func() {
+		sysRead = syscall.Read
+		sysWrite = syscall.Write
+		sysWritev = defaultWritev
+	})
+}
+
+func TestFullTransfers(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	restoreSyscalls(t)
+
+	plaintext, _ := NewPlaintextStorage(tmpdir + "/plain")
+	encrypted, _ := NewEncryptedStorage(tmpdir+"/encrypted", getKey())
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+
+	t.Log("retries short and interrupted transfers")
+	{
+		calls := 0
+		sysRead = func(fd int, p []byte) (int, error) {
+			calls++
+			if calls%2 == 0 {
+				return 0, syscall.EINTR
+			}
+			if len(p) > 1000 {
+				p = p[:1000]
+			}
+			return syscall.Read(fd, p)
+		}
+		sysWrite = func(fd int, p []byte) (int, error) {
+			calls++
+			if calls%2 == 0 {
+				return 0, syscall.EINTR
+			}
+			if len(p) > 1000 {
+				p = p[:1000]
+			}
+			return syscall.Write(fd, p)
+		}
+		for _, storage := range []Storage{plaintext, encrypted} {
+			if err := storage.WriteFile("file", data); err != nil {
+				t.Fatalf("unexpected error when calling WriteFile %+v", err)
+			}
+			read, err := storage.ReadFileFully("file")
+			if err != nil || !bytes.Equal(read, data) {
+				t.Errorf("expected full content got %d bytes %+v", len(read), err)
+			}
+		}
+	}
+
+	t.Log("retries short vectored writes")
+	{
+		calls := 0
+		sysWritev = func(fd int, segments [][]byte) (int, error) {
+			calls++
+			if calls%2 == 0 {
+				return 0, syscall.EINTR
+			}
+			// write at most 7 bytes spanning segment boundaries
+			chunk := make([]byte, 0, 7)
+			for _, segment := range segments {
+				if len(chunk)+len(segment) > 7 {
+					chunk = append(chunk, segment[:7-len(chunk)]...)
+					break
+				}
+				chunk = append(chunk, segment...)
+			}
+			return syscall.Write(fd, chunk)
+		}
+		authenticated, _ := NewEncryptedStorageWithOptions(tmpdir+"/authenticated", getKey(), EncryptionOptions{HMAC: true})
+		for _, storage := range []Storage{encrypted, authenticated} {
+			if err := storage.WriteFile("vectored", data[:100]); err != nil {
+				t.Fatalf("unexpected error when calling WriteFile %+v", err)
+			}
+			read, err := storage.ReadFileFully("vectored")
+			if err != nil || !bytes.Equal(read, data[:100]) {
+				t.Errorf("expected full content got %d bytes %+v", len(read), err)
+			}
+		}
+	}
+
+}
+
+func TestFullTransfersWithoutProgress(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	restoreSyscalls(t)
+
+	plaintext, _ := NewPlaintextStorage(tmpdir)
+
+	t.Log("fails on write making no progress")
+	{
+		sysWrite = func(fd int, p []byte) (int, error) {
+			return 0, nil
+		}
+		if err := plaintext.WriteFile("file", []byte("data")); err == nil {
+			t.Errorf("expected short write error")
+		}
+	}
+}
+
+func TestFullTransfersLargerThanLimit(t *testing.T) {
+	restoreSyscalls(t)
+
+	t.Log("splits transfers larger than 2GB")
+	{
+		wanted := int64(maxTransfer) + 1<<20
+		if wanted > math.MaxInt {
+			t.Skip("address space too small")
+		}
+		// reserved but never touched mapping, it costs no memory
+		size := int(wanted)
+		huge, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANON|syscall.MAP_NORESERVE)
+		if err != nil {
+			t.Skipf("unable to reserve %d bytes %+v", size, err)
+		}
+		defer syscall.Munmap(huge)
+
+		chunks := make([]int, 0)
+		sysWrite = func(fd int, p []byte) (int, error) {
+			chunks = append(chunks, len(p))
+			return len(p), nil
+		}
+		if err := writeFull(-1, huge); err != nil {
+			t.Fatalf("unexpected error when calling writeFull %+v", err)
+		}
+		if len(chunks) != 2 || chunks[0] != maxTransfer || chunks[1] != 1<<20 {
+			t.Errorf("unexpected write chunks %+v", chunks)
+		}
+
+		chunks = chunks[:0]
+		sysRead = func(fd int, p []byte) (int, error) {
+			chunks = append(chunks, len(p))
+			return len(p), nil
+		}
+		if n, err := readFull(-1, huge); err != nil || n != size {
+			t.Fatalf("expected %d bytes read got %d %+v", size, n, err)
+		}
+		if len(chunks) != 2 || chunks[0] != maxTransfer {
+			t.Errorf("unexpected read chunks %+v", chunks)
+		}
+	}
+}
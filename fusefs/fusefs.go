@@ -0,0 +1,339 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || darwin
+
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	localfs "github.com/jancajthaml-openbank/local-fs"
+)
+
+// Options customizes mount
+type Options struct {
+	// ReadOnly rejects all modifications
+	ReadOnly bool
+	// AllowOther lets users other than one mounting access mount point
+	AllowOther bool
+	// Debug logs every FUSE request
+	Debug bool
+}
+
+// Mount exposes storage at given mount point, content is decrypted on read
+// and encrypted on write by storage itself, returned server is unmounted by
+// calling its Unmount
+func Mount(storage localfs.Storage, mountpoint string, options Options) (*fuse.Server, error) {
+	root := &dirNode{
+		storage:  storage,
+		readOnly: options.ReadOnly,
+	}
+	return fs.Mount(mountpoint, root, &fs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName:      "localfs",
+			Name:        "localfs",
+			AllowOther:  options.AllowOther,
+			Debug:       options.Debug,
+			DirectMount: true,
+		},
+	})
+}
+
+func toErrno(err error) syscall.Errno {
+	switch {
+	case err == nil:
+		return fs.OK
+	case errors.Is(err, os.ErrNotExist):
+		return syscall.ENOENT
+	case errors.Is(err, os.ErrExist):
+		return syscall.EEXIST
+	case errors.Is(err, os.ErrPermission):
+		return syscall.EACCES
+	default:
+		return fs.ToErrno(err)
+	}
+}
+
+type dirNode struct {
+	fs.Inode
+	storage  localfs.Storage
+	path     string
+	readOnly bool
+}
+
+var (
+	_ fs.NodeLookuper  = (*dirNode)(nil)
+	_ fs.NodeReaddirer = (*dirNode)(nil)
+	_ fs.NodeGetattrer = (*dirNode)(nil)
+	_ fs.NodeMkdirer   = (*dirNode)(nil)
+	_ fs.NodeCreater   = (*dirNode)(nil)
+	_ fs.NodeUnlinker  = (*dirNode)(nil)
+	_ fs.NodeRmdirer   = (*dirNode)(nil)
+)
+
+func (node *dirNode) child(name string) string {
+	return path.Join(node.path, name)
+}
+
+func (node *dirNode) newDir(ctx context.Context, name string, out *fuse.EntryOut) *fs.Inode {
+	child := &dirNode{storage: node.storage, path: node.child(name), readOnly: node.readOnly}
+	child.fill(&out.Attr)
+	return node.NewInode(ctx, child, fs.StableAttr{Mode: syscall.S_IFDIR})
+}
+
+func (node *dirNode) newFile(ctx context.Context, name string, data []byte, out *fuse.EntryOut) (*fileNode, *fs.Inode) {
+	child := &fileNode{storage: node.storage, path: node.child(name), readOnly: node.readOnly, data: data, loaded: data != nil}
+	child.fill(&out.Attr)
+	return child, node.NewInode(ctx, child, fs.StableAttr{Mode: syscall.S_IFREG})
+}
+
+func (node *dirNode) fill(out *fuse.Attr) {
+	out.Mode = syscall.S_IFDIR | 0700
+	if node.readOnly {
+		out.Mode = syscall.S_IFDIR | 0500
+	}
+	if modified, err := node.storage.LastModification(node.path); err == nil {
+		out.SetTimes(nil, &modified, &modified)
+	}
+}
+
+func (node *dirNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	node.fill(&out.Attr)
+	return fs.OK
+}
+
+// Lookup tells directories from files by listing them because Storage
+// contract has no stat
+func (node *dirNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	child := node.child(name)
+	if _, err := node.storage.ListDirectory(child, true); err == nil {
+		return node.newDir(ctx, name, out), fs.OK
+	}
+	data, err := node.storage.ReadFileFully(child)
+	if err != nil {
+		return nil, toErrno(err)
+	}
+	_, inode := node.newFile(ctx, name, data, out)
+	return inode, fs.OK
+}
+
+func (node *dirNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	names, err := node.storage.ListDirectory(node.path, true)
+	if err != nil {
+		return nil, toErrno(err)
+	}
+	entries := make([]fuse.DirEntry, 0, len(names))
+	for _, name := range names {
+		mode := uint32(syscall.S_IFREG)
+		if _, err := node.storage.ListDirectory(node.child(name), true); err == nil {
+			mode = syscall.S_IFDIR
+		}
+		entries = append(entries, fuse.DirEntry{Name: name, Mode: mode})
+	}
+	return fs.NewListDirStream(entries), fs.OK
+}
+
+func (node *dirNode) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if node.readOnly {
+		return nil, syscall.EROFS
+	}
+	if err := node.storage.Mkdir(node.child(name)); err != nil {
+		return nil, toErrno(err)
+	}
+	return node.newDir(ctx, name, out), fs.OK
+}
+
+func (node *dirNode) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
+	if node.readOnly {
+		return nil, nil, 0, syscall.EROFS
+	}
+	if err := node.storage.WriteFile(node.child(name), []byte{}); err != nil {
+		return nil, nil, 0, toErrno(err)
+	}
+	file, inode := node.newFile(ctx, name, []byte{}, out)
+	return inode, file, fuse.FOPEN_DIRECT_IO, fs.OK
+}
+
+func (node *dirNode) Unlink(ctx context.Context, name string) syscall.Errno {
+	if node.readOnly {
+		return syscall.EROFS
+	}
+	return toErrno(node.storage.Delete(node.child(name)))
+}
+
+func (node *dirNode) Rmdir(ctx context.Context, name string) syscall.Errno {
+	if node.readOnly {
+		return syscall.EROFS
+	}
+	return toErrno(node.storage.Delete(node.child(name)))
+}
+
+// fileNode holds decrypted content of file, modifications are encrypted and
+// written back when file is flushed
+type fileNode struct {
+	fs.Inode
+	storage  localfs.Storage
+	path     string
+	readOnly bool
+	mutex    sync.Mutex
+	data     []byte
+	loaded   bool
+	dirty    bool
+}
+
+var (
+	_ fs.NodeGetattrer = (*fileNode)(nil)
+	_ fs.NodeSetattrer = (*fileNode)(nil)
+	_ fs.NodeOpener    = (*fileNode)(nil)
+	_ fs.NodeReader    = (*fileNode)(nil)
+	_ fs.NodeWriter    = (*fileNode)(nil)
+	_ fs.NodeFlusher   = (*fileNode)(nil)
+)
+
+func (node *fileNode) fill(out *fuse.Attr) {
+	out.Mode = syscall.S_IFREG | 0600
+	if node.readOnly {
+		out.Mode = syscall.S_IFREG | 0400
+	}
+	out.Size = uint64(len(node.data))
+	if modified, err := node.storage.LastModification(node.path); err == nil {
+		out.SetTimes(nil, &modified, &modified)
+	}
+}
+
+func (node *fileNode) load() syscall.Errno {
+	if node.loaded || node.dirty {
+		return fs.OK
+	}
+	data, err := node.storage.ReadFileFully(node.path)
+	if err != nil {
+		return toErrno(err)
+	}
+	node.data = data
+	node.loaded = true
+	return fs.OK
+}
+
+func (node *fileNode) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	if errno := node.load(); errno != fs.OK {
+		return errno
+	}
+	node.fill(&out.Attr)
+	return fs.OK
+}
+
+func (node *fileNode) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	if size, ok := in.GetSize(); ok {
+		if node.readOnly {
+			return syscall.EROFS
+		}
+		if errno := node.load(); errno != fs.OK {
+			return errno
+		}
+		if int(size) <= len(node.data) {
+			node.data = node.data[:size]
+		} else {
+			node.data = append(node.data, make([]byte, int(size)-len(node.data))...)
+		}
+		node.dirty = true
+		if fh == nil {
+			if errno := node.flush(); errno != fs.OK {
+				return errno
+			}
+		}
+	}
+	node.fill(&out.Attr)
+	return fs.OK
+}
+
+func (node *fileNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	if node.readOnly && flags&(syscall.O_WRONLY|syscall.O_RDWR|syscall.O_TRUNC|syscall.O_APPEND) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+	if !node.dirty {
+		node.loaded = false
+	}
+	if flags&syscall.O_TRUNC != 0 {
+		node.data = []byte{}
+		node.dirty = true
+	} else if errno := node.load(); errno != fs.OK {
+		return nil, 0, errno
+	}
+	return node, fuse.FOPEN_DIRECT_IO, fs.OK
+}
+
+func (node *fileNode) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	if errno := node.load(); errno != fs.OK {
+		return nil, errno
+	}
+	if off >= int64(len(node.data)) {
+		return fuse.ReadResultData(nil), fs.OK
+	}
+	end := off + int64(len(dest))
+	if end > int64(len(node.data)) {
+		end = int64(len(node.data))
+	}
+	return fuse.ReadResultData(append([]byte(nil), node.data[off:end]...)), fs.OK
+}
+
+func (node *fileNode) Write(ctx context.Context, fh fs.FileHandle, data []byte, off int64) (uint32, syscall.Errno) {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	if node.readOnly {
+		return 0, syscall.EROFS
+	}
+	if errno := node.load(); errno != fs.OK {
+		return 0, errno
+	}
+	end := off + int64(len(data))
+	if end > int64(len(node.data)) {
+		node.data = append(node.data, make([]byte, end-int64(len(node.data)))...)
+	}
+	copy(node.data[off:], data)
+	node.dirty = true
+	return uint32(len(data)), fs.OK
+}
+
+func (node *fileNode) Flush(ctx context.Context, fh fs.FileHandle) syscall.Errno {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	return node.flush()
+}
+
+func (node *fileNode) flush() syscall.Errno {
+	if !node.dirty {
+		return fs.OK
+	}
+	if err := node.storage.WriteFile(node.path, node.data); err != nil {
+		return toErrno(err)
+	}
+	node.dirty = false
+	return fs.OK
+}
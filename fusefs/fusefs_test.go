@@ -0,0 +1,76 @@
+//go:build linux || darwin
+
+package fusefs
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	localfs "github.com/jancajthaml-openbank/local-fs"
+)
+
+func TestMountEncrypted(t *testing.T) {
+	if _, err := os.Stat("/dev/fuse"); err != nil {
+		t.Skip("fuse not available")
+	}
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	storage, _ := localfs.NewEncryptedStorage(tmpdir+"/data", key)
+	storage.WriteFile("account/a", []byte("secret"))
+
+	mountpoint := tmpdir + "/mnt"
+	os.MkdirAll(mountpoint, os.ModePerm)
+	server, err := Mount(storage, mountpoint, Options{})
+	if err != nil {
+		t.Skipf("unable to mount %+v", err)
+	}
+	defer server.Unmount()
+
+	t.Log("reads decrypted content")
+	{
+		data, err := os.ReadFile(mountpoint + "/account/a")
+		if err != nil {
+			t.Fatalf("unexpected error when reading through mount %+v", err)
+		}
+		if string(data) != "secret" {
+			t.Errorf("expected secret got %s", string(data))
+		}
+	}
+
+	t.Log("writes encrypted content")
+	{
+		if err := os.WriteFile(mountpoint+"/account/b", []byte("plain"), 0600); err != nil {
+			t.Fatalf("unexpected error when writing through mount %+v", err)
+		}
+		data, err := storage.ReadFileFully("account/b")
+		if err != nil {
+			t.Fatalf("unexpected error when calling ReadFileFully %+v", err)
+		}
+		if string(data) != "plain" {
+			t.Errorf("expected plain got %s", string(data))
+		}
+		raw, _ := os.ReadFile(tmpdir + "/data/account/b")
+		if string(raw) == "plain" {
+			t.Errorf("expected file at rest to be encrypted")
+		}
+	}
+
+	t.Log("lists directory")
+	{
+		entries, err := os.ReadDir(mountpoint + "/account")
+		if err != nil {
+			t.Fatalf("unexpected error when listing through mount %+v", err)
+		}
+		if len(entries) != 2 {
+			t.Errorf("expected 2 entries got %d", len(entries))
+		}
+	}
+}
@@ -1,3 +1,19 @@
 module github.com/jancajthaml-openbank/local-fs
 
 go 1.20
+
+require (
+	github.com/hanwen/go-fuse/v2 v2.4.2
+	github.com/klauspost/compress v1.16.7
+	golang.org/x/crypto v0.13.0
+	golang.org/x/sys v0.12.0
+	google.golang.org/grpc v1.56.3
+	google.golang.org/protobuf v1.30.0
+)
+
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	golang.org/x/net v0.10.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
+)
@@ -0,0 +1,119 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Handle represents file currently open by storage
+type Handle struct {
+	Path   string        `json:"path"`
+	Mode   string        `json:"mode"`
+	Opened time.Time     `json:"opened"`
+	Age    time.Duration `json:"age"`
+}
+
+type handleRegistry struct {
+	sync.Mutex
+	sequence uint64
+	open     map[uint64]Handle
+	latency  map[string]*LatencyHistogram
+}
+
+func newHandleRegistry() *handleRegistry {
+	return &handleRegistry{
+		open:    make(map[uint64]Handle),
+		latency: make(map[string]*LatencyHistogram),
+	}
+}
+
+func noop() {}
+
+// track registers open handle and returns func that unregisters it
+func (registry *handleRegistry) track(absPath string, mode string) func() {
+	if registry == nil {
+		return noop
+	}
+	opened := time.Now()
+	registry.Lock()
+	registry.sequence++
+	id := registry.sequence
+	registry.open[id] = Handle{
+		Path:   absPath,
+		Mode:   mode,
+		Opened: opened,
+	}
+	registry.Unlock()
+	return func() {
+		elapsed := time.Since(opened)
+		registry.Lock()
+		delete(registry.open, id)
+		histogram, ok := registry.latency[mode]
+		if !ok {
+			histogram = newLatencyHistogram()
+			registry.latency[mode] = histogram
+		}
+		histogram.observe(elapsed)
+		registry.Unlock()
+	}
+}
+
+// list returns open handles oldest first
+func (registry *handleRegistry) list() []Handle {
+	if registry == nil {
+		return nil
+	}
+	now := time.Now()
+	registry.Lock()
+	result := make([]Handle, 0, len(registry.open))
+	for _, handle := range registry.open {
+		handle.Age = now.Sub(handle.Opened)
+		result = append(result, handle)
+	}
+	registry.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Opened.Before(result[j].Opened)
+	})
+	return result
+}
+
+// latencies returns copy of latency histograms per handle mode
+func (registry *handleRegistry) latencies() map[string]LatencyHistogram {
+	if registry == nil {
+		return nil
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	result := make(map[string]LatencyHistogram, len(registry.latency))
+	for mode, histogram := range registry.latency {
+		copied := *histogram
+		copied.Counts = append([]uint64(nil), histogram.Counts...)
+		result[mode] = copied
+	}
+	return result
+}
+
+// OpenHandles returns files currently open by this storage oldest first
+func (storage PlaintextStorage) OpenHandles() []Handle {
+	return storage.handles.list()
+}
+
+// OpenHandles returns files currently open by this storage oldest first
+func (storage EncryptedStorage) OpenHandles() []Handle {
+	return storage.handles.list()
+}
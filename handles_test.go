@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestOpenHandles(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	plaintext := storage.(PlaintextStorage)
+
+	if err = storage.WriteFile("foo", []byte("abc")); err != nil {
+		t.Fatalf("unexpected error when calling WriteFile %+v", err)
+	}
+	if len(plaintext.OpenHandles()) != 0 {
+		t.Errorf("expected no open handles after WriteFile got %+v", plaintext.OpenHandles())
+	}
+
+	// hold lock from another descriptor so that AppendFile blocks with file open
+	filename := tmpdir + "/foo"
+	fd, err := syscall.Open(filename, syscall.O_RDONLY, 0600)
+	if err != nil {
+		t.Fatalf("unexpected error when opening file %+v", err)
+	}
+	defer syscall.Close(fd)
+	if err = syscall.Flock(fd, syscall.LOCK_EX); err != nil {
+		t.Fatalf("unexpected error when locking file %+v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- storage.AppendFile("foo", []byte("def"))
+	}()
+
+	var handles []Handle
+	for i := 0; i < 1000 && len(handles) == 0; i++ {
+		handles = plaintext.OpenHandles()
+		time.Sleep(time.Millisecond)
+	}
+	if len(handles) != 1 {
+		t.Fatalf("expected one open handle got %+v", handles)
+	}
+	if handles[0].Path != filename || handles[0].Mode != "append" {
+		t.Errorf("unexpected handle %+v", handles[0])
+	}
+	if stats := plaintext.Stats(); stats.OpenHandles != 1 || stats.OldestHandlePath != filename {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	syscall.Flock(fd, syscall.LOCK_UN)
+	if err = <-done; err != nil {
+		t.Fatalf("unexpected error when calling AppendFile %+v", err)
+	}
+	if len(plaintext.OpenHandles()) != 0 {
+		t.Errorf("expected no open handles after AppendFile got %+v", plaintext.OpenHandles())
+	}
+}
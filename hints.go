@@ -0,0 +1,87 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"syscall"
+	"time"
+)
+
+// indirection allowing tests to observe advice given to kernel
+var fadvise = defaultFadvise
+
+// ScanOption customizes how bulk scans such as Verify and ExportArchive read
+// files
+type ScanOption func(*scanHints)
+
+type scanHints struct {
+	sequential  bool
+	dontNeed    bool
+	lockTimeout time.Duration
+}
+
+// WithReadahead advises kernel that scanned files are read sequentially so
+// it reads ahead aggressively
+func WithReadahead() ScanOption {
+	return func(hints *scanHints) {
+		hints.sequential = true
+	}
+}
+
+// WithDontNeed advises kernel to drop page cache of every scanned file once
+// it was read so scan does not evict hot working set of live service
+func WithDontNeed() ScanOption {
+	return func(hints *scanHints) {
+		hints.dontNeed = true
+	}
+}
+
+func newScanHints(options []ScanOption) scanHints {
+	var hints scanHints
+	for _, option := range options {
+		option(&hints)
+	}
+	return hints
+}
+
+// read reads whole file under shared lock advising kernel according to hints
+func (hints scanHints) read(filename string, handles *handleRegistry) ([]byte, error) {
+	fd, err := openRetrying(filename, syscall.O_RDONLY|syscall.O_NONBLOCK, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer handles.track(filename, "scan")()
+	defer syscall.Close(fd)
+	if err = flock(fd, filename, syscall.LOCK_SH, hints.lockTimeout); err != nil {
+		return nil, err
+	}
+	defer funlock(fd, filename)
+	var fs syscall.Stat_t
+	if err = syscall.Fstat(fd, &fs); err != nil {
+		return nil, err
+	}
+	if hints.sequential {
+		fadvise(fd, 0, 0, fadvSequential)
+	}
+	buf := make([]byte, fs.Size)
+	n, err := readFull(fd, buf)
+	if hints.dontNeed {
+		fadvise(fd, 0, 0, fadvDontNeed)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestScanHints(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	advice := make(map[int]int)
+	fadvise = func(fd int, offset int64, length int64, kind int) error {
+		advice[kind]++
+		return nil
+	}
+	defer func() {
+		fadvise = defaultFadvise
+	}()
+
+	plaintext, _ := NewPlaintextStorage(tmpdir + "/plain")
+	encrypted, _ := NewEncryptedStorage(tmpdir+"/encrypted", getKey())
+	for _, storage := range []Storage{plaintext, encrypted} {
+		storage.WriteFile("a/1", []byte("one"))
+		storage.WriteFile("a/2", []byte("two"))
+	}
+
+	t.Log("no hints")
+	{
+		if report, err := plaintext.(PlaintextStorage).Verify("a"); err != nil || report.Scanned != 2 || !report.Healthy() {
+			t.Fatalf("unexpected result of Verify %+v %+v", report, err)
+		}
+		if len(advice) != 0 {
+			t.Errorf("expected no advice got %+v", advice)
+		}
+	}
+
+	t.Log("verify")
+	{
+		report, err := encrypted.(EncryptedStorage).Verify("a", WithReadahead(), WithDontNeed())
+		if err != nil || report.Scanned != 2 || !report.Healthy() {
+			t.Fatalf("unexpected result of Verify %+v %+v", report, err)
+		}
+		if advice[fadvSequential] != 2 || advice[fadvDontNeed] != 2 {
+			t.Errorf("expected sequential and dontneed advice for every file got %+v", advice)
+		}
+	}
+
+	t.Log("export")
+	{
+		for key := range advice {
+			delete(advice, key)
+		}
+		var hinted, plain bytes.Buffer
+		if err := encrypted.(EncryptedStorage).ExportArchive(&hinted, "a", ExportOptions{Decrypt: true}, WithDontNeed()); err != nil {
+			t.Fatalf("unexpected error when calling ExportArchive %+v", err)
+		}
+		if advice[fadvSequential] != 0 || advice[fadvDontNeed] != 2 {
+			t.Errorf("expected dontneed advice for every file got %+v", advice)
+		}
+		if err := plaintext.(PlaintextStorage).ExportArchive(&plain, "a", ExportOptions{}); err != nil {
+			t.Fatalf("unexpected error when calling ExportArchive %+v", err)
+		}
+		if readArchive(t, hinted.Bytes())["a/1"] != "one" || readArchive(t, plain.Bytes())["a/1"] != "one" {
+			t.Errorf("expected hinted export to contain decrypted files")
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+type httpHandler struct {
+	storage Storage
+	prefix  string
+}
+
+// HTTPHandler returns handler serving files of storage under given prefix,
+// request path is resolved relative to prefix and files of EncryptedStorage
+// are served decrypted, Range and conditional requests are supported and
+// read only part of file storage is able to read alone, control directory
+// of root is never served
+func HTTPHandler(storage Storage, prefix string) http.Handler {
+	return httpHandler{
+		storage: storage,
+		prefix:  strings.Trim(path.Clean("/"+prefix), "/"),
+	}
+}
+
+func (handler httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if handler.prefix != "" {
+		name = handler.prefix + "/" + name
+	}
+	if isControlPath(name) {
+		http.NotFound(w, r)
+		return
+	}
+	modified, err := handler.storage.LastModification(name)
+	if err != nil {
+		handler.fail(w, r, err)
+		return
+	}
+	content, err := handler.open(name)
+	if err != nil {
+		handler.fail(w, r, err)
+		return
+	}
+	defer content.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, name, modified, content)
+}
+
+// open returns seekable reader of file, readers of storages unable to seek
+// are wrapped so seeking forward skips content and seeking backward reopens
+// file
+func (handler httpHandler) open(name string) (io.ReadSeekCloser, error) {
+	reader, err := handler.storage.GetFileReader(name)
+	if err != nil {
+		return nil, err
+	}
+	if seekable, ok := reader.(io.ReadSeekCloser); ok {
+		return seekable, nil
+	}
+	size, err := handler.storage.FileSize(name)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return &streamSeeker{
+		storage: handler.storage,
+		name:    name,
+		reader:  reader,
+		size:    size,
+	}, nil
+}
+
+// streamSeeker is io.ReadSeekCloser over reader of storage of known size
+type streamSeeker struct {
+	storage Storage
+	name    string
+	reader  io.ReadCloser
+	size    int64
+	// position is offset requested by Seek, offset is offset of reader
+	position int64
+	offset   int64
+}
+
+func (seeker *streamSeeker) Read(p []byte) (int, error) {
+	if seeker.position < seeker.offset {
+		seeker.reader.Close()
+		reader, err := seeker.storage.GetFileReader(seeker.name)
+		if err != nil {
+			seeker.reader = http.NoBody
+			return 0, err
+		}
+		seeker.reader, seeker.offset = reader, 0
+	}
+	if skip := seeker.position - seeker.offset; skip > 0 {
+		n, err := io.CopyN(io.Discard, seeker.reader, skip)
+		seeker.offset += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := seeker.reader.Read(p)
+	seeker.offset += int64(n)
+	seeker.position = seeker.offset
+	return n, err
+}
+
+func (seeker *streamSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += seeker.position
+	case io.SeekEnd:
+		offset += seeker.size
+	default:
+		return 0, syscall.EINVAL
+	}
+	if offset < 0 {
+		return 0, syscall.EINVAL
+	}
+	seeker.position = offset
+	return offset, nil
+}
+
+func (seeker *streamSeeker) Close() error {
+	return seeker.reader.Close()
+}
+
+func (handler httpHandler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case os.IsNotExist(err), errors.Is(err, syscall.EISDIR):
+		http.NotFound(w, r)
+	case os.IsPermission(err):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewEncryptedStorage(tmpdir, getKey())
+	storage.WriteFile("public/account/a", []byte("0123456789"))
+	storage.WriteFile("private/b", []byte("secret"))
+
+	server := httptest.NewServer(HTTPHandler(storage, "public"))
+	defer server.Close()
+
+	t.Log("serves decrypted file")
+	{
+		response, err := http.Get(server.URL + "/account/a")
+		if err != nil {
+			t.Fatalf("unexpected error when calling GET %+v", err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK || string(body) != "0123456789" {
+			t.Errorf("expected 200 0123456789 got %d %s", response.StatusCode, string(body))
+		}
+		if response.Header.Get("Content-Length") != "10" {
+			t.Errorf("expected Content-Length 10 got %s", response.Header.Get("Content-Length"))
+		}
+		modified, _ := storage.LastModification("public/account/a")
+		if response.Header.Get("Last-Modified") != modified.UTC().Format(http.TimeFormat) {
+			t.Errorf("unexpected Last-Modified %s", response.Header.Get("Last-Modified"))
+		}
+	}
+
+	t.Log("serves range")
+	{
+		request, _ := http.NewRequest(http.MethodGet, server.URL+"/account/a", nil)
+		request.Header.Set("Range", "bytes=2-4")
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("unexpected error when calling GET %+v", err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != http.StatusPartialContent || string(body) != "234" {
+			t.Errorf("expected 206 234 got %d %s", response.StatusCode, string(body))
+		}
+	}
+
+	t.Log("does not escape prefix")
+	{
+		for _, path := range []string{"/../private/b", "/account", "/missing"} {
+			response, err := http.Get(server.URL + path)
+			if err != nil {
+				t.Fatalf("unexpected error when calling GET %+v", err)
+			}
+			response.Body.Close()
+			if response.StatusCode != http.StatusNotFound {
+				t.Errorf("expected 404 for %s got %d", path, response.StatusCode)
+			}
+		}
+	}
+
+	t.Log("does not serve control directory")
+	{
+		root := httptest.NewServer(HTTPHandler(storage, ""))
+		defer root.Close()
+		for _, path := range []string{"/" + ControlDirectory + "/" + formatFile, "/public/../" + ControlDirectory + "/" + formatFile} {
+			response, err := http.Get(root.URL + path)
+			if err != nil {
+				t.Fatalf("unexpected error when calling GET %+v", err)
+			}
+			response.Body.Close()
+			if response.StatusCode != http.StatusNotFound {
+				t.Errorf("expected 404 for %s got %d", path, response.StatusCode)
+			}
+		}
+	}
+
+	t.Log("streams file of storage unable to seek without reading it whole")
+	{
+		streaming := httptest.NewServer(HTTPHandler(streamingStorage{storage}, "public"))
+		defer streaming.Close()
+		for _, ranges := range []string{"bytes=2-4", "bytes=6-7,2-4"} {
+			request, _ := http.NewRequest(http.MethodGet, streaming.URL+"/account/a", nil)
+			request.Header.Set("Range", ranges)
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatalf("unexpected error when calling GET %+v", err)
+			}
+			body, _ := io.ReadAll(response.Body)
+			response.Body.Close()
+			if response.StatusCode != http.StatusPartialContent || !strings.Contains(string(body), "234") {
+				t.Errorf("expected 206 with 234 for %s got %d %s", ranges, response.StatusCode, string(body))
+			}
+		}
+	}
+}
+
+// streamingStorage offers file only as stream which can not seek
+type streamingStorage struct {
+	Storage
+}
+
+func (storage streamingStorage) ReadFileFully(path string) ([]byte, error) {
+	return nil, errors.New("file must be streamed")
+}
+
+func (storage streamingStorage) GetFileReader(path string) (io.ReadCloser, error) {
+	reader, err := storage.Storage.GetFileReader(path)
+	if err != nil {
+		return nil, err
+	}
+	return struct{ io.ReadCloser }{reader}, nil
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Inspection represents everything known about single file in storage
+type Inspection struct {
+	Path         string      `json:"path"`
+	Size         int64       `json:"size"`
+	Mode         os.FileMode `json:"mode"`
+	LastModified time.Time   `json:"lastModified"`
+	LastAccessed time.Time   `json:"lastAccessed"`
+	LastChanged  time.Time   `json:"lastChanged"`
+	Locked       bool        `json:"locked"`
+	Encrypted    bool        `json:"encrypted"`
+	Cipher       string      `json:"cipher,omitempty"`
+	KeyID        string      `json:"keyId,omitempty"`
+	IV           string      `json:"iv,omitempty"`
+	Compression  string      `json:"compression,omitempty"`
+	PayloadSize  int64       `json:"payloadSize"`
+	Checksum     string      `json:"checksum"`
+	Readable     bool        `json:"readable"`
+	ReadError    string      `json:"readError,omitempty"`
+}
+
+// inspectFile stats file and reads its raw content under shared lock
+func inspectFile(storage PlaintextStorage, path string) (Inspection, []byte, error) {
+	var (
+		result  = Inspection{Path: path}
+		trusted = new(syscall.Stat_t)
+		cleaned = filepath.Clean(storage.root + "/" + path)
+	)
+	if err := syscall.Stat(cleaned, trusted); err != nil {
+		return result, nil, err
+	}
+	result.Size = trusted.Size
+	result.Mode = os.FileMode(trusted.Mode & 0777)
+	result.LastModified = statMtime(trusted)
+	result.LastAccessed = statAtime(trusted)
+	result.LastChanged = statCtime(trusted)
+	if trusted.Mode&syscall.S_IFMT != syscall.S_IFREG {
+		result.ReadError = "not a regular file"
+		return result, nil, nil
+	}
+	locked, err := isLocked(cleaned)
+	if err != nil {
+		result.ReadError = err.Error()
+		return result, nil, nil
+	}
+	result.Locked = locked
+	var data []byte
+	err = withSharedLock(cleaned, storage.handles, storage.noAtime, storage.lockTimeout, func(fd int, size int64) error {
+		data = make([]byte, size)
+		n, err := preadFull(fd, data, 0)
+		data = data[:n]
+		return err
+	})
+	if err != nil {
+		result.ReadError = err.Error()
+		return result, nil, nil
+	}
+	sum := sha256.Sum256(data)
+	result.Checksum = "sha256:" + hex.EncodeToString(sum[:])
+	result.PayloadSize = int64(len(data))
+	result.Readable = true
+	return result, data, nil
+}
+
+// compressionOf returns compression algorithm named by header of data
+func compressionOf(data []byte) string {
+	if !compressed(data) {
+		return ""
+	}
+	switch algorithm := data[len(compressionMagic)]; algorithm {
+	case compressionGzip:
+		return "gzip"
+	case compressionZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("unknown %q", algorithm)
+	}
+}
+
+// keyHeaderOf returns length of key header of data and key id it names
+func keyHeaderOf(data []byte) (int, string) {
+	if len(data) <= len(keyHeaderMagic) || string(data[:len(keyHeaderMagic)]) != keyHeaderMagic {
+		return 0, ""
+	}
+	end := len(keyHeaderMagic) + 1 + int(data[len(keyHeaderMagic)])
+	if end > len(data) {
+		return 0, ""
+	}
+	return end, string(data[len(keyHeaderMagic)+1 : end])
+}
+
+func isLocked(absPath string) (bool, error) {
+	fd, err := openRetrying(absPath, syscall.O_RDONLY|syscall.O_NONBLOCK, 0600)
+	if err != nil {
+		return false, err
+	}
+	defer syscall.Close(fd)
+	err = syscall.Flock(fd, syscall.LOCK_SH|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	syscall.Flock(fd, syscall.LOCK_UN)
+	return false, nil
+}
+
+// Inspect returns everything known about file at given path
+func (storage PlaintextStorage) Inspect(path string) (Inspection, error) {
+	result, data, err := inspectFile(storage, path)
+	result.Compression = compressionOf(data)
+	return result, err
+}
+
+// Inspect returns everything known about file at given path, including
+// cipher header and whether file can be decrypted with current key, layout
+// is recognized from header of file, layouts without header are told apart
+// by which of them authenticates
+func (storage EncryptedStorage) Inspect(path string) (Inspection, error) {
+	result, data, err := inspectFile(PlaintextStorage{
+		root:        storage.root,
+		handles:     storage.handles,
+		noAtime:     storage.noAtime,
+		lockTimeout: storage.lockTimeout,
+	}, path)
+	if err != nil || !result.Readable {
+		return result, err
+	}
+	result.Encrypted = true
+	offset, id := keyHeaderOf(data)
+	result.KeyID = id
+	_, _, key := storage.header(data)
+	if layout, ok, _ := parseChunkLayout(data, offset, int64(len(data))); ok {
+		result.Cipher = "AES-GCM-CHUNKED"
+		result.IV = hex.EncodeToString(layout.salt)
+		result.PayloadSize = layout.size
+	} else {
+		result.Cipher = "AES-CFB"
+		ivSize, overhead := aes.BlockSize, offset+aes.BlockSize
+		if block, err := aes.NewCipher(key); err == nil {
+			if _, err := openAEAD(block, data[offset:], path); err == nil {
+				// 12 byte GCM nonce and 16 byte tag
+				result.Cipher = "AES-GCM"
+				ivSize, overhead = 12, offset+12+16
+			} else if len(data) >= overhead+sha256.Size && hmac.Equal(data[len(data)-sha256.Size:], tag(key, data[:len(data)-sha256.Size])) {
+				result.Cipher = "AES-CFB+HMAC-SHA256"
+				overhead += sha256.Size
+			}
+		}
+		if len(data) >= overhead {
+			result.IV = hex.EncodeToString(data[offset : offset+ivSize])
+			result.PayloadSize = int64(len(data) - overhead)
+		}
+	}
+	plaintext, err := storage.decrypt(path, data)
+	if err != nil {
+		result.Readable = false
+		result.ReadError = err.Error()
+		return result, nil
+	}
+	result.Compression = compressionOf(plaintext)
+	return result, nil
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInspectPlaintext(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	if err = storage.WriteFile("foo", []byte("abc")); err != nil {
+		t.Fatalf("unexpected error when calling WriteFile %+v", err)
+	}
+
+	result, err := storage.(PlaintextStorage).Inspect("foo")
+	if err != nil {
+		t.Fatalf("unexpected error when calling Inspect %+v", err)
+	}
+	if result.Size != 3 {
+		t.Errorf("expected size 3 got %d instead", result.Size)
+	}
+	if result.Encrypted {
+		t.Errorf("expected plaintext file not to be reported as encrypted")
+	}
+	if !result.Readable {
+		t.Errorf("expected file to be readable got %s", result.ReadError)
+	}
+	if result.Locked {
+		t.Errorf("expected file not to be locked")
+	}
+	if result.Checksum != "sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("unexpected checksum %s", result.Checksum)
+	}
+
+	if _, err = storage.(PlaintextStorage).Inspect("bar"); err == nil {
+		t.Errorf("expected error when inspecting non existent file")
+	}
+}
+
+func TestInspectEncrypted(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewEncryptedStorage(tmpdir, getKey())
+	if err = storage.WriteFile("foo", []byte("abc")); err != nil {
+		t.Fatalf("unexpected error when calling WriteFile %+v", err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(tmpdir, "short"), []byte("abc"), 0600); err != nil {
+		t.Fatalf("unexpected error when writing file %+v", err)
+	}
+
+	result, err := storage.(EncryptedStorage).Inspect("foo")
+	if err != nil {
+		t.Fatalf("unexpected error when calling Inspect %+v", err)
+	}
+	if !result.Encrypted || result.Cipher != "AES-CFB" {
+		t.Errorf("expected file to be reported as AES-CFB encrypted")
+	}
+	if result.PayloadSize != 3 {
+		t.Errorf("expected payload size 3 got %d instead", result.PayloadSize)
+	}
+	if len(result.IV) != 32 {
+		t.Errorf("expected hex encoded IV got %s", result.IV)
+	}
+
+	result, err = storage.(EncryptedStorage).Inspect("short")
+	if err != nil {
+		t.Fatalf("unexpected error when calling Inspect %+v", err)
+	}
+	if result.Readable {
+		t.Errorf("expected truncated file to be reported as unreadable")
+	}
+}
+
+func TestInspectFromHeader(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	plaintext, _ := NewPlaintextStorage(tmpdir + "/plain")
+	encrypted, _ := NewEncryptedStorage(tmpdir+"/encrypted", getKey())
+	aead, _ := NewEncryptedStorageWithOptions(tmpdir+"/encrypted", getKey(), EncryptionOptions{AEAD: true})
+
+	t.Log("reports compression of plaintext file")
+	{
+		compressed, _ := NewCompressedStorage(plaintext, CompressionOptions{Algorithm: CompressionGzip})
+		if err := compressed.WriteFile("foo", []byte("abc")); err != nil {
+			t.Fatalf("unexpected error when calling WriteFile %+v", err)
+		}
+		result, err := plaintext.(PlaintextStorage).Inspect("foo")
+		if err != nil || result.Compression != "gzip" {
+			t.Errorf("expected gzip compression got %q %+v", result.Compression, err)
+		}
+	}
+
+	t.Log("reports compression of encrypted file")
+	{
+		compressed, _ := NewCompressedStorage(encrypted, CompressionOptions{Algorithm: CompressionZstd})
+		if err := compressed.WriteFile("foo", []byte("abc")); err != nil {
+			t.Fatalf("unexpected error when calling WriteFile %+v", err)
+		}
+		result, err := encrypted.(EncryptedStorage).Inspect("foo")
+		if err != nil || result.Compression != "zstd" {
+			t.Errorf("expected zstd compression got %q %+v", result.Compression, err)
+		}
+	}
+
+	t.Log("recognizes cipher from file instead of options of storage")
+	{
+		if err := aead.WriteFile("bar", []byte("abc")); err != nil {
+			t.Fatalf("unexpected error when calling WriteFile %+v", err)
+		}
+		result, err := encrypted.(EncryptedStorage).Inspect("bar")
+		if err != nil || result.Cipher != "AES-GCM" || result.PayloadSize != 3 || len(result.IV) != 24 {
+			t.Errorf("expected AES-GCM with 3 byte payload got %+v %+v", result, err)
+		}
+		if err := encrypted.WriteFile("baz", []byte("abc")); err != nil {
+			t.Fatalf("unexpected error when calling WriteFile %+v", err)
+		}
+		result, err = aead.(EncryptedStorage).Inspect("baz")
+		if err != nil || result.Cipher != "AES-CFB" || result.PayloadSize != 3 {
+			t.Errorf("expected AES-CFB with 3 byte payload got %+v %+v", result, err)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// journalFile is file in control directory holding changelog of mutations
+const journalFile = "journal.log"
+
+// operations recorded in journal
+const (
+	JournalWrite  = "write"
+	JournalAppend = "append"
+	JournalDelete = "delete"
+)
+
+// JournalEntry represents single mutation recorded in journal
+type JournalEntry struct {
+	Time     time.Time `json:"time"`
+	Op       string    `json:"op"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Checksum string    `json:"checksum,omitempty"`
+	// Next is offset of entry following this one, consumers persist it to
+	// resume replay
+	Next int64 `json:"-"`
+}
+
+// JournaledStorage is a fascade recording every successful write, append and
+// delete into append-only journal in control directory
+type JournaledStorage struct {
+	Storage
+	root     string
+	filename string
+}
+
+// NewJournaledStorage returns storage recording mutations of underlying
+// storage into journal
+func NewJournaledStorage(underlying Storage) (Storage, error) {
+	root, ok := rootOf(underlying)
+	if !ok {
+		return NilStorage{}, fmt.Errorf("journal requires local storage")
+	}
+	dir := filepath.Join(filepath.Clean(root), ControlDirectory)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return NilStorage{}, err
+	}
+	return JournaledStorage{
+		Storage:  underlying,
+		root:     filepath.Clean(root),
+		filename: filepath.Join(dir, journalFile),
+	}, nil
+}
+
+func (storage JournaledStorage) unwrap() Storage {
+	return storage.Storage
+}
+
+// apply runs mutation of path and records it into journal while holding
+// ordering lock of path so concurrent mutations of same path are journaled in
+// order they were applied
+func (storage JournaledStorage) apply(op string, path string, data []byte, mutate func() error) error {
+	defer lockPathOrder(&journalOrder, filepath.Clean(storage.root+"/"+path)).Unlock()
+	if err := mutate(); err != nil {
+		return err
+	}
+	return storage.record(op, path, data)
+}
+
+// record appends entry to journal under exclusive lock so journals of several
+// processes sharing root do not interleave
+func (storage JournaledStorage) record(op string, path string, data []byte) error {
+	entry := JournalEntry{
+		Time: time.Now().UTC(),
+		Op:   op,
+		Path: filepath.Clean(path),
+		Size: int64(len(data)),
+	}
+	if op != JournalDelete {
+		sum := sha256.Sum256(data)
+		entry.Checksum = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	fd, err := openRetrying(storage.filename, syscall.O_CREAT|syscall.O_WRONLY|syscall.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	if err = flock(fd, storage.filename, syscall.LOCK_EX, 0); err != nil {
+		return err
+	}
+	defer funlock(fd, storage.filename)
+	if err = writeFull(fd, append(line, '\n')); err != nil {
+		return err
+	}
+	return syscall.Fsync(fd)
+}
+
+// WriteFileExclusive writes file and records it into journal
+func (storage JournaledStorage) WriteFileExclusive(path string, data []byte) error {
+	return storage.apply(JournalWrite, path, data, func() error {
+		return storage.Storage.WriteFileExclusive(path, data)
+	})
+}
+
+// WriteFile writes file and records it into journal
+func (storage JournaledStorage) WriteFile(path string, data []byte) error {
+	return storage.apply(JournalWrite, path, data, func() error {
+		return storage.Storage.WriteFile(path, data)
+	})
+}
+
+func (storage JournaledStorage) replaceFile(path string, data []byte) error {
+	return storage.apply(JournalWrite, path, data, func() error {
+		return replaceFile(storage.Storage, path, data)
+	})
+}
+
+// AppendFile appends to file and records appended data into journal
+func (storage JournaledStorage) AppendFile(path string, data []byte) error {
+	return storage.apply(JournalAppend, path, data, func() error {
+		return storage.Storage.AppendFile(path, data)
+	})
+}
+
+// Delete removes path and records it into journal
+func (storage JournaledStorage) Delete(path string) error {
+	return storage.apply(JournalDelete, path, nil, func() error {
+		return storage.Storage.Delete(path)
+	})
+}
+
+// ReadFileWithETag reads file of underlying storage with its etag
+func (storage JournaledStorage) ReadFileWithETag(path string) ([]byte, string, error) {
+	return ReadFileWithETag(storage.Storage, path)
+}
+
+// WriteFileIfMatch writes file only when its etag still matches and records
+// it into journal
+func (storage JournaledStorage) WriteFileIfMatch(path string, data []byte, etag string) error {
+	return storage.apply(JournalWrite, path, data, func() error {
+		return WriteFileIfMatch(storage.Storage, path, data, etag)
+	})
+}
+
+// Replay calls fn for every entry of journal starting at offset until fn
+// returns false, returns offset following last entry passed to fn so replay
+// can be resumed, entry still being written is not replayed
+func (storage JournaledStorage) Replay(offset int64, fn func(JournalEntry) bool) (int64, error) {
+	file, err := os.Open(storage.filename)
+	if os.IsNotExist(err) {
+		return offset, nil
+	}
+	if err != nil {
+		return offset, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return offset, err
+	}
+	if offset >= info.Size() {
+		return offset, nil
+	}
+	data := make([]byte, info.Size()-offset)
+	n, err := file.ReadAt(data, offset)
+	if err != nil && n < len(data) {
+		return offset, err
+	}
+	for {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			return offset, nil
+		}
+		var entry JournalEntry
+		if err = json.Unmarshal(data[:end], &entry); err != nil {
+			return offset, fmt.Errorf("corrupted journal entry at offset %d %w", offset, err)
+		}
+		entry.Next = offset + int64(end) + 1
+		if !fn(entry) {
+			return entry.Next, nil
+		}
+		offset = entry.Next
+		data = data[end+1:]
+	}
+}
+
+// Tail replays journal from offset and keeps following it, polling for new
+// entries every interval, until done is closed or fn returns false, returns
+// offset following last entry passed to fn
+func (storage JournaledStorage) Tail(offset int64, interval time.Duration, done <-chan struct{}, fn func(JournalEntry) bool) (int64, error) {
+	stopped := false
+	visit := func(entry JournalEntry) bool {
+		if !fn(entry) {
+			stopped = true
+			return false
+		}
+		return true
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		next, err := storage.Replay(offset, visit)
+		offset = next
+		if err != nil || stopped {
+			return offset, err
+		}
+		select {
+		case <-done:
+			return offset, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// validJSONLines returns true when every complete line of data is valid JSON,
+// trailing line without newline is entry still being written
+func validJSONLines(data []byte) bool {
+	for {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			return true
+		}
+		if !json.Valid(data[:end]) {
+			return false
+		}
+		data = data[end+1:]
+	}
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJournaledStorage(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	underlying, _ := NewPlaintextStorage(tmpdir)
+	storage, err := NewJournaledStorage(underlying)
+	if err != nil {
+		t.Fatalf("unexpected error when calling NewJournaledStorage %+v", err)
+	}
+	journaled := storage.(JournaledStorage)
+
+	storage.WriteFile("account/a", []byte("abc"))
+	storage.AppendFile("account/a", []byte("de"))
+	storage.Delete("account/a")
+	if err := storage.WriteFileExclusive("account/b", []byte("x")); err != nil {
+		t.Fatalf("unexpected error when calling WriteFileExclusive %+v", err)
+	}
+	if err := storage.WriteFileExclusive("account/b", []byte("x")); err == nil {
+		t.Fatalf("expected error when writing existing file exclusively")
+	}
+
+	t.Log("replay")
+	{
+		entries := make([]JournalEntry, 0)
+		next, err := journaled.Replay(0, func(entry JournalEntry) bool {
+			entries = append(entries, entry)
+			return true
+		})
+		if err != nil {
+			t.Fatalf("unexpected error when calling Replay %+v", err)
+		}
+		if len(entries) != 4 {
+			t.Fatalf("expected 4 successful mutations got %+v", entries)
+		}
+		expected := []struct {
+			op   string
+			size int64
+		}{{JournalWrite, 3}, {JournalAppend, 2}, {JournalDelete, 0}, {JournalWrite, 1}}
+		for i, entry := range entries {
+			if entry.Op != expected[i].op || entry.Size != expected[i].size || entry.Time.IsZero() {
+				t.Errorf("unexpected entry %d %+v", i, entry)
+			}
+		}
+		if entries[0].Checksum != "sha256:ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+			t.Errorf("expected checksum of written data got %s", entries[0].Checksum)
+		}
+		if next != entries[3].Next {
+			t.Errorf("expected replay to return offset after last entry")
+		}
+
+		resumed := 0
+		journaled.Replay(entries[1].Next, func(entry JournalEntry) bool {
+			resumed++
+			return false
+		})
+		if resumed != 1 {
+			t.Errorf("expected replay to stop when fn returns false got %d", resumed)
+		}
+	}
+
+	t.Log("tail")
+	{
+		offset, _ := journaled.Replay(0, func(JournalEntry) bool { return true })
+		done := make(chan struct{})
+		tailed := make(chan JournalEntry)
+		go journaled.Tail(offset, 10*time.Millisecond, done, func(entry JournalEntry) bool {
+			tailed <- entry
+			return true
+		})
+		storage.WriteFile("account/c", []byte("new"))
+		select {
+		case entry := <-tailed:
+			if entry.Path != "account/c" {
+				t.Errorf("expected tail to follow new entry got %+v", entry)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("expected tail to deliver new entry")
+		}
+		close(done)
+	}
+
+	t.Log("partial entry is not replayed and control check passes")
+	{
+		file, _ := os.OpenFile(tmpdir+"/"+ControlDirectory+"/"+journalFile, os.O_WRONLY|os.O_APPEND, 0600)
+		file.Write([]byte(`{"op":"wri`))
+		file.Close()
+		count := 0
+		if _, err := journaled.Replay(0, func(JournalEntry) bool { count++; return true }); err != nil || count != 5 {
+			t.Errorf("expected 5 complete entries got %d %+v", count, err)
+		}
+		report, _ := CheckControl(storage)
+		for _, name := range report.Corrupted {
+			if name == journalFile {
+				t.Errorf("expected journal with partial entry to pass check")
+			}
+		}
+	}
+}
+
+func TestJournalOrdering(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	underlying, _ := NewPlaintextStorage(tmpdir)
+	storage, err := NewJournaledStorage(underlying)
+	if err != nil {
+		t.Fatalf("unexpected error when calling NewJournaledStorage %+v", err)
+	}
+
+	t.Log("concurrent writes of same path are journaled in apply order")
+	{
+		var wg sync.WaitGroup
+		for i := 0; i < 32; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				storage.WriteFile("balance", []byte(fmt.Sprintf("value %d", i)))
+			}(i)
+		}
+		wg.Wait()
+		last := ""
+		storage.(JournaledStorage).Replay(0, func(entry JournalEntry) bool {
+			last = entry.Checksum
+			return true
+		})
+		data, _ := underlying.ReadFileFully("balance")
+		sum := sha256.Sum256(data)
+		if last != "sha256:"+hex.EncodeToString(sum[:]) {
+			t.Errorf("expected last journaled write to match content %s", string(data))
+		}
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+// ReadJSON reads file given path and unmarshals its JSON content into v
+func ReadJSON(storage Storage, path string, v interface{}) error {
+	return ReadValue(storage, path, JSONCodec, v)
+}
+
+// WriteJSON marshals v as JSON and replaces file given path with it
+func WriteJSON(storage Storage, path string, v interface{}) error {
+	return WriteValue(storage, path, JSONCodec, v)
+}
+
+// WriteJSONExclusive marshals v as JSON and writes it to file given path if
+// that file does not already exists
+func WriteJSONExclusive(storage Storage, path string, v interface{}) error {
+	return WriteValueExclusive(storage, path, JSONCodec, v)
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestJSON(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	type account struct {
+		Name    string `json:"name"`
+		Balance int64  `json:"balance"`
+	}
+
+	plaintext, _ := NewPlaintextStorage(tmpdir + "/plaintext")
+	encrypted, _ := NewEncryptedStorage(tmpdir+"/encrypted", getKey())
+
+	for name, storage := range map[string]Storage{"plaintext": plaintext, "encrypted": encrypted} {
+		t.Logf("%s roundtrip", name)
+		{
+			if err := WriteJSON(storage, "account/A", account{Name: "A", Balance: 100}); err != nil {
+				t.Fatalf("unexpected error when calling WriteJSON %+v", err)
+			}
+			var result account
+			if err := ReadJSON(storage, "account/A", &result); err != nil || result.Name != "A" || result.Balance != 100 {
+				t.Errorf("expected account A with balance 100 got %+v %+v", result, err)
+			}
+		}
+
+		t.Logf("%s exclusive write", name)
+		{
+			if err := WriteJSONExclusive(storage, "account/A", account{Name: "B"}); err == nil {
+				t.Errorf("expected error when file already exists")
+			}
+			if err := WriteJSONExclusive(storage, "account/B", account{Name: "B"}); err != nil {
+				t.Errorf("unexpected error when calling WriteJSONExclusive %+v", err)
+			}
+		}
+
+		t.Logf("%s marshal and unmarshal errors", name)
+		{
+			if err := WriteJSON(storage, "invalid", make(chan int)); err == nil {
+				t.Errorf("expected marshal error")
+			}
+			if exists, _ := storage.Exists("invalid"); exists {
+				t.Errorf("expected nothing written on marshal error")
+			}
+			storage.WriteFile("garbage", []byte("{"))
+			var result account
+			if err := ReadJSON(storage, "garbage", &result); err == nil {
+				t.Errorf("expected unmarshal error")
+			}
+			if err := ReadJSON(storage, "missing", &result); !os.IsNotExist(err) {
+				t.Errorf("expected not exist error got %+v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// AppendJSONLine marshals v as JSON and appends it as single line to file
+// given path, file is created when it does not exist
+func AppendJSONLine(storage Storage, path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return storage.AppendFile(path, append(data, '\n'))
+}
+
+// ScanJSONLines calls fn with every non empty line of file given path in
+// order until fn returns false, line passed to fn is valid only until it
+// returns, file is streamed so encrypted files in chunked format are never
+// held in memory whole
+func ScanJSONLines(storage Storage, path string, fn func(line json.RawMessage) bool) error {
+	reader, err := storage.GetFileReader(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	buffered := bufio.NewReader(reader)
+	var (
+		number int
+		long   []byte
+	)
+	for {
+		line, err := buffered.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			long = append(long, line...)
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if long != nil {
+			line = append(long, line...)
+			long = nil
+		}
+		number++
+		if record := bytes.TrimSpace(line); len(record) > 0 {
+			if !json.Valid(record) {
+				return fmt.Errorf("invalid JSON at line %d of %s", number, path)
+			}
+			if !fn(record) {
+				return nil
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
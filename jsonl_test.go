@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestJSONLines(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	type event struct {
+		Seq  int    `json:"seq"`
+		Note string `json:"note"`
+	}
+
+	plaintext, _ := NewPlaintextStorage(tmpdir + "/plaintext")
+	encrypted, _ := NewEncryptedStorage(tmpdir+"/encrypted", getKey())
+	chunked, _ := NewEncryptedStorageWithOptions(tmpdir+"/chunked", getKey(), EncryptionOptions{AEAD: true, ChunkSize: 64})
+
+	long := strings.Repeat("x", 10000)
+
+	for name, storage := range map[string]Storage{"plaintext": plaintext, "encrypted": encrypted, "chunked": chunked} {
+		t.Logf("%s append and scan", name)
+		{
+			for i := 0; i < 20; i++ {
+				note := "line\nbreak"
+				if i == 10 {
+					note = long
+				}
+				if err := AppendJSONLine(storage, "events", event{Seq: i, Note: note}); err != nil {
+					t.Fatalf("%s unexpected error when calling AppendJSONLine %+v", name, err)
+				}
+			}
+			seen := 0
+			err := ScanJSONLines(storage, "events", func(line json.RawMessage) bool {
+				var result event
+				if err := json.Unmarshal(line, &result); err != nil || result.Seq != seen {
+					t.Errorf("%s expected event %d got %+v %+v", name, seen, result.Seq, err)
+				}
+				if seen == 10 && result.Note != long {
+					t.Errorf("%s expected long note to survive", name)
+				}
+				seen++
+				return true
+			})
+			if err != nil || seen != 20 {
+				t.Errorf("%s expected 20 events got %d %+v", name, seen, err)
+			}
+		}
+
+		t.Logf("%s stops when fn returns false", name)
+		{
+			seen := 0
+			ScanJSONLines(storage, "events", func(line json.RawMessage) bool {
+				seen++
+				return seen < 3
+			})
+			if seen != 3 {
+				t.Errorf("%s expected 3 events got %d", name, seen)
+			}
+		}
+
+		t.Logf("%s rejects invalid line", name)
+		{
+			storage.AppendFile("events", []byte("{\n"))
+			if err := ScanJSONLines(storage, "events", func(json.RawMessage) bool { return true }); err == nil {
+				t.Errorf("%s expected error for invalid line", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"sync"
+)
+
+// KeyProvider supplies signing key and resolves verification keys by id
+type KeyProvider interface {
+	// SigningKey returns id and private key used to sign new data
+	SigningKey() (string, ed25519.PrivateKey, error)
+	// VerificationKey returns public key of given id
+	VerificationKey(id string) (ed25519.PublicKey, error)
+}
+
+// StaticKeyProvider is in memory key provider, newest added signing key is
+// used for signing while all added keys remain valid for verification
+type StaticKeyProvider struct {
+	mutex   sync.RWMutex
+	current string
+	signing map[string]ed25519.PrivateKey
+	public  map[string]ed25519.PublicKey
+}
+
+// NewStaticKeyProvider returns empty key provider
+func NewStaticKeyProvider() *StaticKeyProvider {
+	return &StaticKeyProvider{
+		signing: make(map[string]ed25519.PrivateKey),
+		public:  make(map[string]ed25519.PublicKey),
+	}
+}
+
+// AddSigningKey adds private key under given id and makes it current
+func (provider *StaticKeyProvider) AddSigningKey(id string, key ed25519.PrivateKey) error {
+	if len(key) != ed25519.PrivateKeySize {
+		return fmt.Errorf("invalid signing key %s", id)
+	}
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.signing[id] = key
+	provider.public[id] = key.Public().(ed25519.PublicKey)
+	provider.current = id
+	return nil
+}
+
+// AddVerificationKey adds public key under given id
+func (provider *StaticKeyProvider) AddVerificationKey(id string, key ed25519.PublicKey) error {
+	if len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid verification key %s", id)
+	}
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	provider.public[id] = key
+	return nil
+}
+
+// SigningKey returns current signing key
+func (provider *StaticKeyProvider) SigningKey() (string, ed25519.PrivateKey, error) {
+	provider.mutex.RLock()
+	defer provider.mutex.RUnlock()
+	if provider.current == "" {
+		return "", nil, fmt.Errorf("no signing key")
+	}
+	return provider.current, provider.signing[provider.current], nil
+}
+
+// VerificationKey returns public key of given id
+func (provider *StaticKeyProvider) VerificationKey(id string) (ed25519.PublicKey, error) {
+	provider.mutex.RLock()
+	defer provider.mutex.RUnlock()
+	key, ok := provider.public[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown verification key %s", id)
+	}
+	return key, nil
+}
+
+// KeyRing holds encryption keys by id, newest added key encrypts new data
+// while all keys remain available for decryption
+type KeyRing struct {
+	mutex  sync.RWMutex
+	newest string
+	keys   map[string][]byte
+}
+
+// NewKeyRing returns empty key ring
+func NewKeyRing() *KeyRing {
+	return &KeyRing{
+		keys: make(map[string][]byte),
+	}
+}
+
+// AddKey adds AES key under given id and makes it newest
+func (ring *KeyRing) AddKey(id string, key []byte) error {
+	if id == "" || len(id) > 255 {
+		return fmt.Errorf("invalid key id %q", id)
+	}
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return fmt.Errorf("invalid key size %d of key %s", len(key), id)
+	}
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+	ring.keys[id] = key
+	ring.newest = id
+	return nil
+}
+
+// Key returns key of given id
+func (ring *KeyRing) Key(id string) ([]byte, bool) {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+	key, ok := ring.keys[id]
+	return key, ok
+}
+
+// Newest returns id and key used to encrypt new data
+func (ring *KeyRing) Newest() (string, []byte, bool) {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+	if ring.newest == "" {
+		return "", nil, false
+	}
+	return ring.newest, ring.keys[ring.newest], true
+}
+
+// Len returns number of keys in ring
+func (ring *KeyRing) Len() int {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+	return len(ring.keys)
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ErrLeaseHeld is returned when lease is held by another owner and has not
+// expired yet
+var ErrLeaseHeld = errors.New("lease held by another owner")
+
+// ErrLeaseNotHeld is returned when renewing or releasing lease not held by
+// given owner
+var ErrLeaseNotHeld = errors.New("lease not held by owner")
+
+// Lease grants owner exclusive right until it expires, expiration is
+// compared with local clock so clocks of workers sharing storage must be
+// synchronized
+type Lease struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+// Expired returns true when lease is no longer valid
+func (lease Lease) Expired() bool {
+	return !time.Now().Before(lease.Expires)
+}
+
+// AcquireLease grants lease stored in file given path to owner for ttl when
+// it does not exist, has expired or is already held by owner, fails with
+// ErrLeaseHeld otherwise
+func AcquireLease(storage Storage, path string, ttl time.Duration, owner string) (Lease, error) {
+	return updateLease(storage, path, func(current Lease, exists bool) (Lease, error) {
+		if exists && current.Owner != owner && !current.Expired() {
+			return current, ErrLeaseHeld
+		}
+		return Lease{Owner: owner, Expires: time.Now().Add(ttl).UTC()}, nil
+	})
+}
+
+// RenewLease extends lease held by owner for ttl from now, fails with
+// ErrLeaseNotHeld when lease was taken over by another owner or released
+func RenewLease(storage Storage, path string, ttl time.Duration, owner string) (Lease, error) {
+	return updateLease(storage, path, func(current Lease, exists bool) (Lease, error) {
+		if !exists || current.Owner != owner {
+			return current, ErrLeaseNotHeld
+		}
+		return Lease{Owner: owner, Expires: time.Now().Add(ttl).UTC()}, nil
+	})
+}
+
+// ReleaseLease deletes lease held by owner, fails with ErrLeaseNotHeld when
+// lease was taken over by another owner or already released
+func ReleaseLease(storage Storage, path string, owner string) error {
+	_, err := updateLease(storage, path, func(current Lease, exists bool) (Lease, error) {
+		if !exists || current.Owner != owner {
+			return current, ErrLeaseNotHeld
+		}
+		return Lease{}, nil
+	})
+	return err
+}
+
+// updateLease replaces lease with result of fn under exclusive lock of lock
+// file beside lease file, lease without owner is deleted, new lease is
+// created exclusively so storages without lock support never grant it twice,
+// existing lease is replaced atomically and storages unable to do so fail
+// with ENOTSUP
+func updateLease(storage Storage, path string, fn func(current Lease, exists bool) (Lease, error)) (Lease, error) {
+	lock, err := LockFile(storage, path+".lock", true)
+	if err == nil {
+		defer lock.Unlock()
+	} else if err != syscall.ENOTSUP {
+		return Lease{}, err
+	}
+	var current Lease
+	err = ReadJSON(storage, path, &current)
+	exists := !os.IsNotExist(err)
+	if err != nil && exists {
+		// lease torn by crash is treated as expired
+		current = Lease{}
+	}
+	next, err := fn(current, exists)
+	if err != nil {
+		return next, err
+	}
+	switch {
+	case next.Owner == "":
+		return next, storage.Delete(path)
+	case exists:
+		data, err := JSONCodec.Marshal(next)
+		if err != nil {
+			return next, err
+		}
+		return next, replaceFile(storage, path, data)
+	default:
+		if err = WriteJSONExclusive(storage, path, next); os.IsExist(err) {
+			return current, ErrLeaseHeld
+		}
+		return next, err
+	}
+}
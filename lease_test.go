@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLease(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	plaintext, _ := NewPlaintextStorage(tmpdir + "/plaintext")
+	encrypted, _ := NewEncryptedStorage(tmpdir+"/encrypted", getKey())
+
+	for name, storage := range map[string]Storage{"plaintext": plaintext, "encrypted": encrypted} {
+		t.Logf("%s acquire and renew", name)
+		{
+			lease, err := AcquireLease(storage, "leader", time.Minute, "worker-a")
+			if err != nil || lease.Owner != "worker-a" || lease.Expired() {
+				t.Fatalf("%s expected lease for worker-a got %+v %+v", name, lease, err)
+			}
+			if _, err := AcquireLease(storage, "leader", time.Minute, "worker-b"); err != ErrLeaseHeld {
+				t.Errorf("%s expected ErrLeaseHeld got %+v", name, err)
+			}
+			if _, err := AcquireLease(storage, "leader", time.Minute, "worker-a"); err != nil {
+				t.Errorf("%s expected owner to reacquire got %+v", name, err)
+			}
+			renewed, err := RenewLease(storage, "leader", time.Hour, "worker-a")
+			if err != nil || !renewed.Expires.After(lease.Expires) {
+				t.Errorf("%s expected renewed lease got %+v %+v", name, renewed, err)
+			}
+			if _, err := RenewLease(storage, "leader", time.Hour, "worker-b"); err != ErrLeaseNotHeld {
+				t.Errorf("%s expected ErrLeaseNotHeld got %+v", name, err)
+			}
+		}
+
+		t.Logf("%s release", name)
+		{
+			if err := ReleaseLease(storage, "leader", "worker-b"); err != ErrLeaseNotHeld {
+				t.Errorf("%s expected ErrLeaseNotHeld got %+v", name, err)
+			}
+			if err := ReleaseLease(storage, "leader", "worker-a"); err != nil {
+				t.Errorf("%s unexpected error when calling ReleaseLease %+v", name, err)
+			}
+			if err := ReleaseLease(storage, "leader", "worker-a"); err != ErrLeaseNotHeld {
+				t.Errorf("%s expected ErrLeaseNotHeld got %+v", name, err)
+			}
+			if _, err := AcquireLease(storage, "leader", time.Minute, "worker-b"); err != nil {
+				t.Errorf("%s expected released lease to be acquired got %+v", name, err)
+			}
+		}
+
+		t.Logf("%s takes over expired lease", name)
+		{
+			AcquireLease(storage, "expiring", time.Millisecond, "worker-a")
+			time.Sleep(5 * time.Millisecond)
+			if _, err := AcquireLease(storage, "expiring", time.Minute, "worker-b"); err != nil {
+				t.Errorf("%s expected takeover got %+v", name, err)
+			}
+			if _, err := RenewLease(storage, "expiring", time.Minute, "worker-a"); err != ErrLeaseNotHeld {
+				t.Errorf("%s expected ErrLeaseNotHeld got %+v", name, err)
+			}
+		}
+
+		t.Logf("%s single winner", name)
+		{
+			var (
+				wg      sync.WaitGroup
+				mutex   sync.Mutex
+				winners int
+			)
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					_, err := AcquireLease(storage, "contended", time.Minute, fmt.Sprintf("worker-%d", i))
+					if err == nil {
+						mutex.Lock()
+						winners++
+						mutex.Unlock()
+					} else if err != ErrLeaseHeld {
+						t.Errorf("%s unexpected error when calling AcquireLease %+v", name, err)
+					}
+				}(i)
+			}
+			wg.Wait()
+			if winners != 1 {
+				t.Errorf("%s expected single winner got %d", name, winners)
+			}
+		}
+	}
+
+	t.Log("renewal through decorators replaces lease atomically or refuses")
+	{
+		traced := NewTracedStorage(plaintext, new(recordingTracer))
+		if _, err := AcquireLease(traced, "decorated", time.Minute, "worker-a"); err != nil {
+			t.Fatalf("unexpected error when calling AcquireLease %+v", err)
+		}
+		if _, err := RenewLease(traced, "decorated", time.Hour, "worker-a"); err != nil {
+			t.Errorf("unexpected error when calling RenewLease %+v", err)
+		}
+		compressed, _ := NewCompressedStorage(plaintext, CompressionOptions{})
+		if _, err := AcquireLease(compressed, "compressed", time.Minute, "worker-a"); err != nil {
+			t.Fatalf("unexpected error when calling AcquireLease %+v", err)
+		}
+		if _, err := RenewLease(compressed, "compressed", time.Hour, "worker-a"); err != syscall.ENOTSUP {
+			t.Errorf("expected ENOTSUP got %+v", err)
+		}
+	}
+}
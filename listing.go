@@ -0,0 +1,172 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"sort"
+	"strings"
+)
+
+// SortMode selects ordering of directory listing
+type SortMode int
+
+const (
+	// SortLexicographic orders names byte by byte, "10" precedes "9"
+	SortLexicographic SortMode = iota
+	// SortNumeric orders runs of digits by their numeric value, "9" precedes
+	// "10"
+	SortNumeric
+	// SortNone skips sorting and leaves names in order they were returned by
+	// backend, on local filesystems this is kernel readdir order which is
+	// neither alphabetical nor creation order (ext4 and xfs return hash order)
+	// and may change when directory is modified, use it for membership checks
+	// on huge directories where sorting dominates cost
+	SortNone
+)
+
+// sortedLister is implemented by storages able to list directory in given
+// sort mode without re-sorting
+type sortedLister interface {
+	ListDirectorySorted(path string, mode SortMode, ascending bool) ([]string, error)
+}
+
+// ListDirectorySorted returns slice of item names in given path ordered by
+// given sort mode, storages not supporting sort modes are listed with
+// ListDirectory and re-sorted
+func ListDirectorySorted(storage Storage, path string, mode SortMode, ascending bool) ([]string, error) {
+	if candidate, ok := storage.(sortedLister); ok {
+		return candidate.ListDirectorySorted(path, mode, ascending)
+	}
+	result, err := storage.ListDirectory(path, ascending)
+	if err != nil || mode != SortNumeric {
+		return result, err
+	}
+	sortNames(result, mode, ascending)
+	return result, nil
+}
+
+// ListDirectoryUnsorted returns slice of item names in given path in
+// backend order without sorting, see SortNone
+func ListDirectoryUnsorted(storage Storage, path string) ([]string, error) {
+	return ListDirectorySorted(storage, path, SortNone, true)
+}
+
+// filteredLister is implemented by storages able to filter names during
+// directory scan
+type filteredLister interface {
+	ListDirectoryFiltered(path string, match func(name string) bool) ([]string, error)
+}
+
+// ListDirectoryFiltered returns ascending slice of item names in given path
+// accepted by match, storages supporting it filter during scan so rejected
+// names are never allocated, match must not retain name
+func ListDirectoryFiltered(storage Storage, path string, match func(name string) bool) ([]string, error) {
+	if candidate, ok := storage.(filteredLister); ok {
+		return candidate.ListDirectoryFiltered(path, match)
+	}
+	names, err := storage.ListDirectory(path, true)
+	if err != nil {
+		return nil, err
+	}
+	result := names[:0]
+	for _, name := range names {
+		if match(name) {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+// ListDirectoryPrefix returns ascending slice of item names in given path
+// starting with given prefix
+func ListDirectoryPrefix(storage Storage, path string, prefix string) ([]string, error) {
+	return ListDirectoryFiltered(storage, path, func(name string) bool {
+		return strings.HasPrefix(name, prefix)
+	})
+}
+
+// sortNames orders names in place by given sort mode
+func sortNames(names []string, mode SortMode, ascending bool) {
+	switch mode {
+	case SortNone:
+		return
+	case SortNumeric:
+		if ascending {
+			sort.Slice(names, func(i, j int) bool {
+				return numericLess(names[i], names[j])
+			})
+		} else {
+			sort.Slice(names, func(i, j int) bool {
+				return numericLess(names[j], names[i])
+			})
+		}
+	default:
+		if ascending {
+			sort.Slice(names, func(i, j int) bool {
+				return names[i] < names[j]
+			})
+		} else {
+			sort.Slice(names, func(i, j int) bool {
+				return names[i] > names[j]
+			})
+		}
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// numericLess compares names as sequences of text and numbers, numbers are
+// compared by value and ties on value are broken by fewer leading zeros
+func numericLess(a string, b string) bool {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if !isDigit(a[i]) || !isDigit(b[j]) {
+			if a[i] != b[j] {
+				return a[i] < b[j]
+			}
+			i++
+			j++
+			continue
+		}
+		startA, startB := i, j
+		for i < len(a) && isDigit(a[i]) {
+			i++
+		}
+		for j < len(b) && isDigit(b[j]) {
+			j++
+		}
+		numA, numB := a[startA:i], b[startB:j]
+		trimmedA, trimmedB := trimZeros(numA), trimZeros(numB)
+		if len(trimmedA) != len(trimmedB) {
+			return len(trimmedA) < len(trimmedB)
+		}
+		if trimmedA != trimmedB {
+			return trimmedA < trimmedB
+		}
+		if len(numA) != len(numB) {
+			return len(numA) < len(numB)
+		}
+	}
+	return len(a)-i < len(b)-j
+}
+
+func trimZeros(number string) string {
+	for len(number) > 1 && number[0] == '0' {
+		number = number[1:]
+	}
+	return number
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestListDirectorySorted(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	for _, name := range []string{"10", "9", "100", "v2.10", "v2.9", "a", "009"} {
+		if err = storage.TouchFile("dir/" + name); err != nil {
+			t.Fatalf("unexpected error when calling TouchFile %+v", err)
+		}
+	}
+
+	t.Log("lexicographic")
+	{
+		list, err := ListDirectorySorted(storage, "dir", SortLexicographic, true)
+		if err != nil {
+			t.Fatalf("unexpected error when calling ListDirectorySorted %+v", err)
+		}
+		expected := "009,10,100,9,a,v2.10,v2.9"
+		if strings.Join(list, ",") != expected {
+			t.Errorf("expected %s got %+v", expected, list)
+		}
+	}
+
+	t.Log("numeric ascending")
+	{
+		list, err := ListDirectorySorted(storage, "dir", SortNumeric, true)
+		if err != nil {
+			t.Fatalf("unexpected error when calling ListDirectorySorted %+v", err)
+		}
+		expected := "9,009,10,100,a,v2.9,v2.10"
+		if strings.Join(list, ",") != expected {
+			t.Errorf("expected %s got %+v", expected, list)
+		}
+	}
+
+	t.Log("numeric descending")
+	{
+		list, err := ListDirectorySorted(storage, "dir", SortNumeric, false)
+		if err != nil {
+			t.Fatalf("unexpected error when calling ListDirectorySorted %+v", err)
+		}
+		expected := "v2.10,v2.9,a,100,10,009,9"
+		if strings.Join(list, ",") != expected {
+			t.Errorf("expected %s got %+v", expected, list)
+		}
+	}
+
+	t.Log("none")
+	{
+		list, err := ListDirectorySorted(storage, "dir", SortNone, true)
+		if err != nil {
+			t.Fatalf("unexpected error when calling ListDirectorySorted %+v", err)
+		}
+		sort.Strings(list)
+		if strings.Join(list, ",") != "009,10,100,9,a,v2.10,v2.9" {
+			t.Errorf("expected all entries got %+v", list)
+		}
+	}
+
+	t.Log("unsorted")
+	{
+		list, err := ListDirectoryUnsorted(storage, "dir")
+		if err != nil {
+			t.Fatalf("unexpected error when calling ListDirectoryUnsorted %+v", err)
+		}
+		if len(list) != 7 {
+			t.Errorf("expected 7 entries got %+v", list)
+		}
+	}
+
+	t.Log("decorated storage is re-sorted")
+	{
+		list, err := ListDirectorySorted(struct{ Storage }{storage}, "dir", SortNumeric, true)
+		if err != nil {
+			t.Fatalf("unexpected error when calling ListDirectorySorted %+v", err)
+		}
+		if strings.Join(list, ",") != "9,009,10,100,a,v2.9,v2.10" {
+			t.Errorf("expected numeric order got %+v", list)
+		}
+	}
+}
+
+func TestListDirectoryFiltered(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	for _, name := range []string{"tx_2", "tx_1", "snapshot_1", "tx_3", "meta"} {
+		if err = storage.TouchFile("dir/" + name); err != nil {
+			t.Fatalf("unexpected error when calling TouchFile %+v", err)
+		}
+	}
+
+	t.Log("prefix")
+	{
+		list, err := ListDirectoryPrefix(storage, "dir", "tx_")
+		if err != nil {
+			t.Fatalf("unexpected error when calling ListDirectoryPrefix %+v", err)
+		}
+		if strings.Join(list, ",") != "tx_1,tx_2,tx_3" {
+			t.Errorf("expected transactions got %+v", list)
+		}
+	}
+
+	t.Log("predicate")
+	{
+		list, err := ListDirectoryFiltered(storage, "dir", func(name string) bool {
+			return strings.HasSuffix(name, "_1")
+		})
+		if err != nil {
+			t.Fatalf("unexpected error when calling ListDirectoryFiltered %+v", err)
+		}
+		if strings.Join(list, ",") != "snapshot_1,tx_1" {
+			t.Errorf("expected matching names got %+v", list)
+		}
+	}
+
+	t.Log("decorated storage")
+	{
+		list, err := ListDirectoryPrefix(struct{ Storage }{storage}, "dir", "m")
+		if err != nil || strings.Join(list, ",") != "meta" {
+			t.Errorf("expected meta got %+v %+v", list, err)
+		}
+	}
+
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package loadgen populates storage with openbank shaped trees for
+// benchmarks and capacity tests
+package loadgen
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	storage "github.com/jancajthaml-openbank/local-fs"
+)
+
+// Distribution samples sizes of generated files
+type Distribution interface {
+	Sample(random *rand.Rand) int
+}
+
+// Fixed distribution always returns same size
+type Fixed int
+
+// Sample returns fixed size
+func (size Fixed) Sample(random *rand.Rand) int {
+	return int(size)
+}
+
+// Uniform distribution returns size in [Min, Max]
+type Uniform struct {
+	Min int
+	Max int
+}
+
+// Sample returns uniformly distributed size
+func (size Uniform) Sample(random *rand.Rand) int {
+	if size.Max <= size.Min {
+		return size.Min
+	}
+	return size.Min + random.Intn(size.Max-size.Min+1)
+}
+
+// LogNormal distribution returns sizes with long tail typical for snapshots
+type LogNormal struct {
+	Median int
+	Sigma  float64
+}
+
+// Sample returns log-normally distributed size
+func (size LogNormal) Sample(random *rand.Rand) int {
+	return int(float64(size.Median) * math.Exp(random.NormFloat64()*size.Sigma))
+}
+
+// Config describes shape of generated tree
+type Config struct {
+	Tenants      int
+	Accounts     int
+	Events       int
+	SnapshotSize Distribution
+	EventSize    Distribution
+	Seed         int64
+}
+
+// Report summarizes generated tree
+type Report struct {
+	Files    int
+	Bytes    int64
+	Duration time.Duration
+}
+
+// SnapshotPath returns path of account snapshot in generated tree
+func SnapshotPath(tenant int, account int) string {
+	return fmt.Sprintf("t_tenant%04d/account/%08d/snapshot/0000000000", tenant, account)
+}
+
+// EventPath returns path of account event in generated tree
+func EventPath(tenant int, account int, event int) string {
+	return fmt.Sprintf("t_tenant%04d/account/%08d/events/0000000000/%010d", tenant, account, event)
+}
+
+func payload(random *rand.Rand, size int) []byte {
+	if size < 0 {
+		size = 0
+	}
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = 'a' + byte(random.Intn(26))
+	}
+	return data
+}
+
+// Populate writes tenants × accounts × events tree into given storage
+func Populate(target storage.Storage, config Config) (Report, error) {
+	var (
+		random = rand.New(rand.NewSource(config.Seed))
+		report = Report{}
+		start  = time.Now()
+	)
+	if config.SnapshotSize == nil {
+		config.SnapshotSize = Fixed(128)
+	}
+	if config.EventSize == nil {
+		config.EventSize = Fixed(64)
+	}
+	write := func(path string, size Distribution) error {
+		data := payload(random, size.Sample(random))
+		if err := target.WriteFile(path, data); err != nil {
+			return err
+		}
+		report.Files++
+		report.Bytes += int64(len(data))
+		return nil
+	}
+	for tenant := 0; tenant < config.Tenants; tenant++ {
+		for account := 0; account < config.Accounts; account++ {
+			if err := write(SnapshotPath(tenant, account), config.SnapshotSize); err != nil {
+				return report, err
+			}
+			for event := 0; event < config.Events; event++ {
+				if err := write(EventPath(tenant, account, event), config.EventSize); err != nil {
+					return report, err
+				}
+			}
+		}
+	}
+	report.Duration = time.Since(start)
+	return report, nil
+}
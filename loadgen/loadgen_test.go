@@ -0,0 +1,49 @@
+package loadgen
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	storage "github.com/jancajthaml-openbank/local-fs"
+)
+
+func TestPopulate(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_loadgen")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	target, _ := storage.NewPlaintextStorage(tmpdir)
+
+	report, err := Populate(target, Config{
+		Tenants:   2,
+		Accounts:  3,
+		Events:    4,
+		EventSize: Uniform{Min: 10, Max: 20},
+		Seed:      1,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error when calling Populate %+v", err)
+	}
+	if report.Files != 2*3*(1+4) {
+		t.Errorf("expected %d files got %d instead", 2*3*(1+4), report.Files)
+	}
+
+	count, err := target.CountFiles("t_tenant0001/account/00000002/events/0000000000")
+	if err != nil {
+		t.Fatalf("unexpected error when calling CountFiles %+v", err)
+	}
+	if count != 4 {
+		t.Errorf("expected 4 events got %d instead", count)
+	}
+
+	data, err := target.ReadFileFully(EventPath(0, 0, 0))
+	if err != nil {
+		t.Fatalf("unexpected error when calling ReadFileFully %+v", err)
+	}
+	if len(data) < 10 || len(data) > 20 {
+		t.Errorf("expected event size within distribution got %d", len(data))
+	}
+}
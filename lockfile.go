@@ -0,0 +1,237 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Lock is advisory lock on file of storage held by this process, storage
+// operations on locked file from any process wait until it is released so
+// holder reads and writes the file through lock itself
+type Lock struct {
+	mutex     sync.Mutex
+	fd        int
+	filename  string
+	path      string
+	exclusive bool
+	timeout   time.Duration
+	barrier   *writeBarrier
+	decode    func(string, []byte) ([]byte, error)
+	encode    func(string, []byte) ([][]byte, error)
+	untrack   func()
+}
+
+// fileLocker is implemented by storages able to lock files
+type fileLocker interface {
+	LockFile(path string, exclusive bool) (*Lock, error)
+	TryLockFile(path string, exclusive bool) (*Lock, error)
+}
+
+// LockFile acquires advisory lock on file of storage creating the file when
+// missing, blocks until lock is available or lock timeout of storage elapses,
+// ENOTSUP is returned by storages without support
+func LockFile(storage Storage, path string, exclusive bool) (*Lock, error) {
+	if candidate, ok := storage.(fileLocker); ok {
+		return candidate.LockFile(path, exclusive)
+	}
+	return nil, syscall.ENOTSUP
+}
+
+// TryLockFile acquires advisory lock on file of storage like LockFile but
+// fails with ErrLockTimeout right away when lock is held by someone else
+func TryLockFile(storage Storage, path string, exclusive bool) (*Lock, error) {
+	if candidate, ok := storage.(fileLocker); ok {
+		return candidate.TryLockFile(path, exclusive)
+	}
+	return nil, syscall.ENOTSUP
+}
+
+// lockFile locks file waiting up to timeout, negative timeout does not wait
+func lockFile(filename string, path string, exclusive bool, handles *handleRegistry, timeout time.Duration) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+		return nil, err
+	}
+	fd, err := openRetrying(filename, syscall.O_CREAT|syscall.O_RDWR|syscall.O_NONBLOCK, 0600)
+	if err != nil {
+		return nil, err
+	}
+	untrack := handles.track(filename, "lock")
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if timeout < 0 {
+		how |= syscall.LOCK_NB
+		timeout = 0
+	}
+	if err = flock(fd, filename, how, timeout); err != nil {
+		syscall.Close(fd)
+		untrack()
+		if err == syscall.EWOULDBLOCK {
+			return nil, ErrLockTimeout
+		}
+		return nil, err
+	}
+	return &Lock{
+		fd:        fd,
+		filename:  filename,
+		path:      path,
+		exclusive: exclusive,
+		timeout:   timeout,
+		untrack:   untrack,
+	}, nil
+}
+
+// LockFile acquires advisory lock on file creating the file when missing
+func (storage PlaintextStorage) LockFile(path string, exclusive bool) (*Lock, error) {
+	return storage.lockFile(path, exclusive, storage.lockTimeout)
+}
+
+// TryLockFile acquires advisory lock on file without waiting for it
+func (storage PlaintextStorage) TryLockFile(path string, exclusive bool) (*Lock, error) {
+	return storage.lockFile(path, exclusive, -1)
+}
+
+func (storage PlaintextStorage) lockFile(path string, exclusive bool, timeout time.Duration) (*Lock, error) {
+	lock, err := lockFile(filepath.Clean(storage.root+"/"+path), path, exclusive, storage.handles, timeout)
+	if err != nil {
+		return nil, err
+	}
+	lock.barrier = storage.barrier
+	lock.decode = func(_ string, raw []byte) ([]byte, error) {
+		return raw, nil
+	}
+	lock.encode = func(_ string, data []byte) ([][]byte, error) {
+		return [][]byte{data}, nil
+	}
+	return lock, nil
+}
+
+// LockFile acquires advisory lock on encrypted file creating the file when
+// missing
+func (storage EncryptedStorage) LockFile(path string, exclusive bool) (*Lock, error) {
+	return storage.lockFile(path, exclusive, storage.lockTimeout)
+}
+
+// TryLockFile acquires advisory lock on encrypted file without waiting for it
+func (storage EncryptedStorage) TryLockFile(path string, exclusive bool) (*Lock, error) {
+	return storage.lockFile(path, exclusive, -1)
+}
+
+func (storage EncryptedStorage) lockFile(path string, exclusive bool, timeout time.Duration) (*Lock, error) {
+	lock, err := lockFile(filepath.Clean(storage.root+"/"+path), path, exclusive, storage.handles, timeout)
+	if err != nil {
+		return nil, err
+	}
+	lock.barrier = storage.barrier
+	lock.decode = storage.decrypt
+	lock.encode = storage.encryptSegments
+	return lock, nil
+}
+
+// Exclusive returns true while lock is held exclusively
+func (lock *Lock) Exclusive() bool {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+	return lock.exclusive
+}
+
+// ReadFile reads whole content of locked file, file created by LockFile is
+// empty
+func (lock *Lock) ReadFile() ([]byte, error) {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+	if lock.fd < 0 {
+		return nil, syscall.EBADF
+	}
+	var fs syscall.Stat_t
+	if err := syscall.Fstat(lock.fd, &fs); err != nil {
+		return nil, err
+	}
+	if fs.Size == 0 {
+		return make([]byte, 0), nil
+	}
+	buf := make([]byte, fs.Size)
+	n, err := preadFull(lock.fd, buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	return lock.decode(lock.path, buf[:n])
+}
+
+// WriteFile replaces content of locked file, lock must be exclusive
+func (lock *Lock) WriteFile(data []byte) error {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+	if lock.fd < 0 {
+		return syscall.EBADF
+	}
+	if !lock.exclusive {
+		return syscall.EPERM
+	}
+	defer lock.barrier.enter()()
+	segments, err := lock.encode(lock.path, data)
+	if err != nil {
+		return err
+	}
+	if err = syscall.Ftruncate(lock.fd, 0); err != nil {
+		return err
+	}
+	var offset int64
+	for _, segment := range segments {
+		if err = pwriteFull(lock.fd, segment, offset); err != nil {
+			return err
+		}
+		offset += int64(len(segment))
+	}
+	return syscall.Fsync(lock.fd)
+}
+
+// Downgrade converts exclusive lock to shared one letting other readers in,
+// conversion is not atomic so writer may acquire lock in between
+func (lock *Lock) Downgrade() error {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+	if lock.fd < 0 {
+		return syscall.EBADF
+	}
+	if !lock.exclusive {
+		return nil
+	}
+	if err := flock(lock.fd, lock.filename, syscall.LOCK_SH, lock.timeout); err != nil {
+		return err
+	}
+	lock.exclusive = false
+	return nil
+}
+
+// Unlock releases lock, unlocking released lock is no-op
+func (lock *Lock) Unlock() error {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+	if lock.fd < 0 {
+		return nil
+	}
+	err := funlock(lock.fd, lock.filename)
+	syscall.Close(lock.fd)
+	lock.fd = -1
+	lock.untrack()
+	return err
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLockFile(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	plaintext, _ := NewPlaintextStorage(tmpdir + "/plain")
+	encrypted, _ := NewEncryptedStorage(tmpdir+"/encrypted", getKey())
+
+	t.Log("exclusive lock blocks storage operations")
+	{
+		lock, err := LockFile(plaintext, "account/balance", true)
+		if err != nil {
+			t.Fatalf("unexpected error when calling LockFile %+v", err)
+		}
+		if data, err := lock.ReadFile(); err != nil || len(data) != 0 {
+			t.Fatalf("expected empty file got %q %+v", data, err)
+		}
+		done := make(chan []byte)
+		go func() {
+			data, _ := plaintext.ReadFileFully("account/balance")
+			done <- data
+		}()
+		if err := lock.WriteFile([]byte("100")); err != nil {
+			t.Fatalf("unexpected error when calling WriteFile %+v", err)
+		}
+		select {
+		case <-done:
+			t.Fatalf("expected read to wait for lock")
+		case <-time.After(50 * time.Millisecond):
+		}
+		if err := lock.Unlock(); err != nil {
+			t.Fatalf("unexpected error when calling Unlock %+v", err)
+		}
+		if data := <-done; string(data) != "100" {
+			t.Errorf("expected read to see content written under lock got %q", data)
+		}
+		if err := lock.Unlock(); err != nil {
+			t.Errorf("expected second Unlock to be no-op got %+v", err)
+		}
+		if _, err := lock.ReadFile(); err != syscall.EBADF {
+			t.Errorf("expected EBADF after Unlock got %+v", err)
+		}
+	}
+
+	t.Log("downgrade lets shared locks in")
+	{
+		lock, _ := LockFile(plaintext, "account/balance", true)
+		acquired := make(chan *Lock)
+		go func() {
+			shared, _ := LockFile(plaintext, "account/balance", false)
+			acquired <- shared
+		}()
+		select {
+		case <-acquired:
+			t.Fatalf("expected shared lock to wait for exclusive one")
+		case <-time.After(50 * time.Millisecond):
+		}
+		if err := lock.Downgrade(); err != nil {
+			t.Fatalf("unexpected error when calling Downgrade %+v", err)
+		}
+		shared := <-acquired
+		if lock.Exclusive() || shared.Exclusive() {
+			t.Errorf("expected both locks to be shared")
+		}
+		if err := shared.WriteFile([]byte("0")); err != syscall.EPERM {
+			t.Errorf("expected EPERM when writing under shared lock got %+v", err)
+		}
+		if data, _ := shared.ReadFile(); string(data) != "100" {
+			t.Errorf("expected shared lock to read content got %q", data)
+		}
+		shared.Unlock()
+		lock.Unlock()
+	}
+
+	t.Log("encrypted file is written through lock")
+	{
+		lock, err := LockFile(encrypted, "account/balance", true)
+		if err != nil {
+			t.Fatalf("unexpected error when calling LockFile %+v", err)
+		}
+		lock.WriteFile([]byte("200"))
+		if data, err := lock.ReadFile(); err != nil || string(data) != "200" {
+			t.Errorf("expected decrypted content got %q %+v", data, err)
+		}
+		lock.Unlock()
+		if data, err := encrypted.ReadFileFully("account/balance"); err != nil || string(data) != "200" {
+			t.Errorf("expected storage to decrypt content written under lock got %q %+v", data, err)
+		}
+	}
+
+	t.Log("unsupported storage")
+	{
+		if _, err := LockFile(struct{ Storage }{plaintext}, "x", true); err != syscall.ENOTSUP {
+			t.Errorf("expected ENOTSUP got %+v", err)
+		}
+	}
+}
+
+func TestLockTimeout(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorageWithOptions(tmpdir, PlaintextOptions{LockTimeout: 50 * time.Millisecond})
+	storage.WriteFile("file", []byte("data"))
+
+	peer, err := syscall.Open(tmpdir+"/file", syscall.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("unexpected error when opening file %+v", err)
+	}
+	defer syscall.Close(peer)
+	syscall.Flock(peer, syscall.LOCK_EX)
+
+	t.Log("stuck peer produces ErrLockTimeout")
+	{
+		started := time.Now()
+		if err := storage.WriteFile("file", []byte("next")); err != ErrLockTimeout {
+			t.Errorf("expected ErrLockTimeout got %+v", err)
+		}
+		if elapsed := time.Since(started); elapsed < 50*time.Millisecond || elapsed > time.Second {
+			t.Errorf("expected to wait for lock timeout got %v", elapsed)
+		}
+		if _, err := ReadFileRange(storage, "file", 0, 1); err != ErrLockTimeout {
+			t.Errorf("expected ErrLockTimeout got %+v", err)
+		}
+	}
+
+	t.Log("try lock does not wait")
+	{
+		started := time.Now()
+		if _, err := TryLockFile(storage, "file", false); err != ErrLockTimeout {
+			t.Errorf("expected ErrLockTimeout got %+v", err)
+		}
+		if elapsed := time.Since(started); elapsed > 25*time.Millisecond {
+			t.Errorf("expected try lock to fail right away got %v", elapsed)
+		}
+	}
+
+	t.Log("released lock is acquired")
+	{
+		syscall.Flock(peer, syscall.LOCK_UN)
+		lock, err := TryLockFile(storage, "file", true)
+		if err != nil {
+			t.Fatalf("unexpected error when calling TryLockFile %+v", err)
+		}
+		lock.Unlock()
+		if err := storage.WriteFile("file", []byte("next")); err != nil {
+			t.Errorf("unexpected error when calling WriteFile %+v", err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// LockStatus represents state of advisory lock on a file
+type LockStatus struct {
+	Path      string        `json:"path"`
+	Locked    bool          `json:"locked"`
+	Exclusive bool          `json:"exclusive"`
+	PID       int           `json:"pid"`
+	Waiters   int           `json:"waiters"`
+	Since     time.Time     `json:"since,omitempty"`
+	Held      time.Duration `json:"held"`
+}
+
+// acquisitions remembers when this process acquired locks so that duration
+// of lock can be reported, kernel does not expose it
+var acquisitions = struct {
+	sync.Mutex
+	since map[string]time.Time
+}{
+	since: make(map[string]time.Time),
+}
+
+// indirection allowing tests to emulate transient errors
+var sysFlock = syscall.Flock
+
+// ErrLockTimeout is returned when lock of file is not acquired in time
+var ErrLockTimeout = errors.New("lock timeout")
+
+// flock acquires lock retrying transient errors according to policy, lock
+// busy for longer than positive timeout fails with ErrLockTimeout, busy lock
+// requested without blocking is not retried
+func flock(fd int, absPath string, how int, timeout time.Duration) error {
+	var (
+		retry    retrier
+		deadline time.Time
+		wait     = time.Millisecond
+	)
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		if timeout <= 0 {
+			err := sysFlock(fd, how)
+			if err == nil {
+				break
+			}
+			if (how&syscall.LOCK_NB != 0 && err == syscall.EWOULDBLOCK) || !retry.again(err) {
+				return err
+			}
+			continue
+		}
+		err := sysFlock(fd, how|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK {
+			if !retry.again(err) {
+				return err
+			}
+			continue
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return ErrLockTimeout
+		}
+		if wait > remaining {
+			wait = remaining
+		}
+		time.Sleep(wait)
+		if wait < 50*time.Millisecond {
+			wait *= 2
+		}
+	}
+	acquisitions.Lock()
+	acquisitions.since[absPath] = time.Now()
+	acquisitions.Unlock()
+	return nil
+}
+
+func funlock(fd int, absPath string) error {
+	acquisitions.Lock()
+	delete(acquisitions.since, absPath)
+	acquisitions.Unlock()
+	return syscall.Flock(fd, syscall.LOCK_UN)
+}
+
+// LockStatus reports whether file is currently flocked and by which process
+func (storage PlaintextStorage) LockStatus(path string) (LockStatus, error) {
+	return lockStatus(storage.root + "/" + path)
+}
+
+// LockStatus reports whether file is currently flocked and by which process
+func (storage EncryptedStorage) LockStatus(path string) (LockStatus, error) {
+	return lockStatus(storage.root + "/" + path)
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || freebsd
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// lockStatus probes flock held on given file from own descriptor because BSD
+// kernels do not list locks, holder is known only when it is this process and
+// waiters are not reported
+func lockStatus(absPath string) (LockStatus, error) {
+	var (
+		cleaned = filepath.Clean(absPath)
+		result  = LockStatus{Path: cleaned}
+	)
+	fd, err := openRetrying(cleaned, syscall.O_RDONLY|syscall.O_NONBLOCK, 0600)
+	if err != nil {
+		return result, err
+	}
+	defer syscall.Close(fd)
+	err = syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == nil {
+		syscall.Flock(fd, syscall.LOCK_UN)
+		return result, nil
+	}
+	if err != syscall.EWOULDBLOCK {
+		return result, err
+	}
+	result.Locked = true
+	err = syscall.Flock(fd, syscall.LOCK_SH|syscall.LOCK_NB)
+	if err == nil {
+		syscall.Flock(fd, syscall.LOCK_UN)
+	} else if err == syscall.EWOULDBLOCK {
+		result.Exclusive = true
+	} else {
+		return result, err
+	}
+	acquisitions.Lock()
+	since, ok := acquisitions.since[cleaned]
+	acquisitions.Unlock()
+	if ok {
+		result.PID = os.Getpid()
+		result.Since = since
+		result.Held = time.Since(since)
+	}
+	return result, nil
+}
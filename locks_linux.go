@@ -0,0 +1,84 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func deviceNumbers(dev uint64) (uint64, uint64) {
+	major := ((dev >> 8) & 0xfff) | ((dev >> 32) & 0xfffff000)
+	minor := (dev & 0xff) | ((dev >> 12) & 0xffffff00)
+	return major, minor
+}
+
+// lockStatus parses /proc/locks looking for flock held on given file
+func lockStatus(absPath string) (LockStatus, error) {
+	var (
+		trusted = new(syscall.Stat_t)
+		cleaned = filepath.Clean(absPath)
+		result  = LockStatus{Path: cleaned}
+	)
+	if err := syscall.Stat(cleaned, trusted); err != nil {
+		return result, err
+	}
+	major, minor := deviceNumbers(uint64(trusted.Dev))
+	needle := fmt.Sprintf("%02x:%02x:%d", major, minor, trusted.Ino)
+
+	fd, err := os.Open("/proc/locks")
+	if err != nil {
+		return result, err
+	}
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		waiting := len(fields) > 1 && fields[1] == "->"
+		if waiting {
+			fields = append(fields[:1], fields[2:]...)
+		}
+		if len(fields) < 6 || fields[1] != "FLOCK" || fields[5] != needle {
+			continue
+		}
+		if waiting {
+			result.Waiters++
+			continue
+		}
+		result.Locked = true
+		result.Exclusive = fields[3] == "WRITE"
+		result.PID, _ = strconv.Atoi(fields[4])
+	}
+	if err = scanner.Err(); err != nil {
+		return result, err
+	}
+	if result.Locked && result.PID == os.Getpid() {
+		acquisitions.Lock()
+		since, ok := acquisitions.since[cleaned]
+		acquisitions.Unlock()
+		if ok {
+			result.Since = since
+			result.Held = time.Since(since)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestLockStatus(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	if err = storage.TouchFile("foo"); err != nil {
+		t.Fatalf("unexpected error when calling TouchFile %+v", err)
+	}
+
+	status, err := storage.(PlaintextStorage).LockStatus("foo")
+	if err != nil {
+		t.Fatalf("unexpected error when calling LockStatus %+v", err)
+	}
+	if status.Locked {
+		t.Errorf("expected file not to be locked")
+	}
+
+	filename := filepath.Clean(tmpdir + "/foo")
+	fd, err := syscall.Open(filename, syscall.O_RDONLY, 0600)
+	if err != nil {
+		t.Fatalf("unexpected error when opening file %+v", err)
+	}
+	defer syscall.Close(fd)
+	if err = flock(fd, filename, syscall.LOCK_EX, 0); err != nil {
+		t.Fatalf("unexpected error when locking file %+v", err)
+	}
+
+	status, err = storage.(PlaintextStorage).LockStatus("foo")
+	if err != nil {
+		t.Fatalf("unexpected error when calling LockStatus %+v", err)
+	}
+	if !status.Locked || !status.Exclusive {
+		t.Errorf("expected file to be exclusively locked got %+v", status)
+	}
+	if status.PID != os.Getpid() {
+		t.Errorf("expected lock to be held by %d got %d instead", os.Getpid(), status.PID)
+	}
+	if status.Since.IsZero() {
+		t.Errorf("expected acquisition time to be known for own lock")
+	}
+
+	if err = funlock(fd, filename); err != nil {
+		t.Fatalf("unexpected error when unlocking file %+v", err)
+	}
+	status, _ = storage.(PlaintextStorage).LockStatus("foo")
+	if status.Locked {
+		t.Errorf("expected file not to be locked after unlock")
+	}
+}
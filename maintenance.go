@@ -0,0 +1,143 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// MaintenanceTask is unit of background work run by Scheduler
+type MaintenanceTask interface {
+	Name() string
+	Run() error
+}
+
+// TaskStatus represents outcome of runs of maintenance task
+type TaskStatus struct {
+	Name         string        `json:"name"`
+	Runs         uint64        `json:"runs"`
+	Failures     uint64        `json:"failures"`
+	LastRun      time.Time     `json:"lastRun"`
+	LastDuration time.Duration `json:"lastDuration"`
+	LastError    string        `json:"lastError,omitempty"`
+}
+
+// Scheduler runs registered maintenance tasks one after another every
+// interval
+type Scheduler struct {
+	interval time.Duration
+	mutex    sync.Mutex
+	running  sync.Mutex
+	tasks    []MaintenanceTask
+	status   []TaskStatus
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// NewScheduler returns scheduler running tasks every interval
+func NewScheduler(interval time.Duration) *Scheduler {
+	return &Scheduler{
+		interval: interval,
+	}
+}
+
+// Register adds task to be run by scheduler
+func (scheduler *Scheduler) Register(task MaintenanceTask) {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	scheduler.tasks = append(scheduler.tasks, task)
+	scheduler.status = append(scheduler.status, TaskStatus{Name: task.Name()})
+}
+
+// Status returns outcome of runs of registered tasks in order of registration
+func (scheduler *Scheduler) Status() []TaskStatus {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	return append([]TaskStatus(nil), scheduler.status...)
+}
+
+// RunOnce runs every registered task once, failure of task does not prevent
+// others from running
+func (scheduler *Scheduler) RunOnce() error {
+	scheduler.running.Lock()
+	defer scheduler.running.Unlock()
+
+	scheduler.mutex.Lock()
+	tasks := append([]MaintenanceTask(nil), scheduler.tasks...)
+	scheduler.mutex.Unlock()
+
+	var errs []error
+	for i, task := range tasks {
+		start := time.Now()
+		err := task.Run()
+		duration := time.Since(start)
+
+		scheduler.mutex.Lock()
+		status := &scheduler.status[i]
+		status.Runs++
+		status.LastRun = start
+		status.LastDuration = duration
+		status.LastError = ""
+		if err != nil {
+			status.Failures++
+			status.LastError = err.Error()
+		}
+		scheduler.mutex.Unlock()
+
+		if err != nil {
+			errs = append(errs, errors.New(task.Name()+": "+err.Error()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Start runs scheduler in background until Stop is called
+func (scheduler *Scheduler) Start() {
+	scheduler.mutex.Lock()
+	defer scheduler.mutex.Unlock()
+	if scheduler.done != nil {
+		return
+	}
+	scheduler.done = make(chan struct{})
+	scheduler.stopped = make(chan struct{})
+	go func(done chan struct{}, stopped chan struct{}) {
+		defer close(stopped)
+		ticker := time.NewTicker(scheduler.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				scheduler.RunOnce()
+			}
+		}
+	}(scheduler.done, scheduler.stopped)
+}
+
+// Stop stops background scheduler and waits for running tasks to finish
+func (scheduler *Scheduler) Stop() {
+	scheduler.mutex.Lock()
+	done, stopped := scheduler.done, scheduler.stopped
+	scheduler.done, scheduler.stopped = nil, nil
+	scheduler.mutex.Unlock()
+	if done == nil {
+		return
+	}
+	close(done)
+	<-stopped
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type countingTask struct {
+	runs chan struct{}
+	err  error
+}
+
+func (task *countingTask) Name() string {
+	return "counting"
+}
+
+func (task *countingTask) Run() error {
+	select {
+	case task.runs <- struct{}{}:
+	default:
+	}
+	return task.err
+}
+
+func TestScheduler(t *testing.T) {
+	t.Log("records failures and keeps running other tasks")
+	{
+		scheduler := NewScheduler(time.Hour)
+		failing := &countingTask{runs: make(chan struct{}, 1), err: errors.New("boom")}
+		passing := &countingTask{runs: make(chan struct{}, 1)}
+		scheduler.Register(failing)
+		scheduler.Register(passing)
+		if err := scheduler.RunOnce(); err == nil {
+			t.Errorf("expected error of failing task")
+		}
+		status := scheduler.Status()
+		if status[0].Failures != 1 || status[0].LastError != "boom" {
+			t.Errorf("unexpected status of failing task %+v", status[0])
+		}
+		if status[1].Runs != 1 || status[1].Failures != 0 {
+			t.Errorf("unexpected status of passing task %+v", status[1])
+		}
+	}
+
+	t.Log("runs tasks in background")
+	{
+		scheduler := NewScheduler(time.Millisecond)
+		task := &countingTask{runs: make(chan struct{}, 1)}
+		scheduler.Register(task)
+		scheduler.Start()
+		select {
+		case <-task.runs:
+		case <-time.After(time.Second):
+			t.Errorf("expected task to run")
+		}
+		scheduler.Stop()
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// MigrationProgress represents state of migration
+type MigrationProgress struct {
+	Total    int64 `json:"total"`
+	Migrated int64 `json:"migrated"`
+	Skipped  int64 `json:"skipped"`
+	Failed   int64 `json:"failed"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// Migrate encrypts every file of plaintext source into encrypted target
+// using given number of workers, each file is written to temporary file,
+// verified to decrypt to original content and then renamed into place, files
+// already present in target with same content are skipped so interrupted
+// migration can be resumed, progress is called after every file
+func Migrate(source PlaintextStorage, target EncryptedStorage, concurrency int, progress func(MigrationProgress)) (MigrationProgress, error) {
+	var result MigrationProgress
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	base := filepath.Clean(source.root)
+	files := make([]string, 0)
+	err := filepath.WalkDir(base, func(absPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(base, absPath)
+		if err != nil {
+			return err
+		}
+		if isInternalPath(relPath) {
+			return filepath.SkipDir
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		files = append(files, relPath)
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	result.Total = int64(len(files))
+
+	var (
+		wg       sync.WaitGroup
+		mutex    sync.Mutex
+		failures []error
+		next     int64 = -1
+	)
+	report := func(outcome *int64, size int64, err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		*outcome++
+		result.Bytes += size
+		if err != nil {
+			failures = append(failures, err)
+		}
+		if progress != nil {
+			progress(result)
+		}
+	}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				index := atomic.AddInt64(&next, 1)
+				if index >= int64(len(files)) {
+					return
+				}
+				skipped, size, err := migrateFile(source, target, files[index])
+				switch {
+				case err != nil:
+					report(&result.Failed, 0, fmt.Errorf("%s %w", files[index], err))
+				case skipped:
+					report(&result.Skipped, 0, nil)
+				default:
+					report(&result.Migrated, size, nil)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return result, errors.Join(failures...)
+}
+
+func migrateFile(source PlaintextStorage, target EncryptedStorage, path string) (bool, int64, error) {
+	data, err := source.ReadFileFully(path)
+	if err != nil {
+		return false, 0, err
+	}
+	filename := filepath.Clean(target.root + "/" + path)
+	if existing, err := os.ReadFile(filename); err == nil {
+		if plain, err := target.decrypt(path, existing); err == nil && bytes.Equal(plain, data) {
+			return true, 0, nil
+		}
+	}
+	ciphertext, err := target.encrypt(path, data)
+	if err != nil {
+		return false, 0, err
+	}
+	if err = os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+		return false, 0, err
+	}
+	temporary := filename + ".migrating"
+	defer os.Remove(temporary)
+	if err = writeSynced(temporary, ciphertext); err != nil {
+		return false, 0, err
+	}
+	written, err := os.ReadFile(temporary)
+	if err != nil {
+		return false, 0, err
+	}
+	plain, err := target.decrypt(path, written)
+	if err != nil {
+		return false, 0, err
+	}
+	if !bytes.Equal(plain, data) {
+		return false, 0, fmt.Errorf("round trip verification failed")
+	}
+	if err = os.Rename(temporary, filename); err != nil {
+		return false, 0, err
+	}
+	return false, int64(len(data)), nil
+}
+
+func writeSynced(filename string, data []byte) error {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
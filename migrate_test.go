@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	source, _ := NewPlaintextStorage(tmpdir + "/plain")
+	target, _ := NewEncryptedStorage(tmpdir+"/encrypted", getKey())
+
+	for i := 0; i < 20; i++ {
+		source.WriteFile(fmt.Sprintf("account/%d/snapshot", i), []byte(fmt.Sprintf("data %d", i)))
+	}
+	target.WriteFile("account/0/snapshot", []byte("data 0"))
+
+	calls := 0
+	result, err := Migrate(source.(PlaintextStorage), target.(EncryptedStorage), 4, func(MigrationProgress) {
+		calls++
+	})
+	if err != nil {
+		t.Fatalf("unexpected error when calling Migrate %+v", err)
+	}
+	if result.Total != 20 || result.Migrated != 19 || result.Skipped != 1 || result.Failed != 0 {
+		t.Errorf("unexpected migration result %+v", result)
+	}
+	if calls != 20 {
+		t.Errorf("expected progress reported 20 times got %d", calls)
+	}
+	for i := 0; i < 20; i++ {
+		data, err := target.ReadFileFully(fmt.Sprintf("account/%d/snapshot", i))
+		if err != nil || string(data) != fmt.Sprintf("data %d", i) {
+			t.Errorf("expected decrypted data %d got %s %+v", i, string(data), err)
+		}
+	}
+	if ok, _ := target.Exists("account/1/snapshot.migrating"); ok {
+		t.Errorf("expected temporary file to be removed")
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// mapFile memory maps whole file holding shared lock so writers, which
+// truncate files, wait until release is called
+func mapFile(filename string, handles *handleRegistry, noAtime bool, timeout time.Duration) ([]byte, func(), error) {
+	fd, _, err := openFile(filename, syscall.O_RDONLY|syscall.O_NONBLOCK, 0600, false, noAtime)
+	if err != nil {
+		return nil, noop, err
+	}
+	untrack := handles.track(filename, "mapped")
+	if err = flock(fd, filename, syscall.LOCK_SH, timeout); err != nil {
+		untrack()
+		syscall.Close(fd)
+		return nil, noop, err
+	}
+	closeFile := func() {
+		funlock(fd, filename)
+		syscall.Close(fd)
+		untrack()
+	}
+	var fs syscall.Stat_t
+	if err = syscall.Fstat(fd, &fs); err != nil {
+		closeFile()
+		return nil, noop, err
+	}
+	if fs.Size == 0 {
+		closeFile()
+		return []byte{}, noop, nil
+	}
+	data, err := syscall.Mmap(fd, 0, int(fs.Size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		closeFile()
+		return nil, noop, err
+	}
+	return data, func() {
+		syscall.Munmap(data)
+		closeFile()
+	}, nil
+}
+
+// ReadFileMapped returns content of file memory mapped instead of copied
+// into buffer, slice is valid and must not be modified until release is
+// called, writes of the file wait for release
+func (storage PlaintextStorage) ReadFileMapped(path string) ([]byte, func(), error) {
+	return mapFile(filepath.Clean(storage.root+"/"+path), storage.handles, storage.noAtime, storage.lockTimeout)
+}
+
+// ReadFileMapped returns decrypted content of file, ciphertext is memory
+// mapped so only plaintext buffer is allocated, release is no-op
+func (storage EncryptedStorage) ReadFileMapped(path string) ([]byte, func(), error) {
+	data, release, err := mapFile(filepath.Clean(storage.root+"/"+path), storage.handles, storage.noAtime, storage.lockTimeout)
+	if err != nil {
+		return nil, noop, err
+	}
+	defer release()
+	plaintext, err := storage.decrypt(path, data)
+	if err != nil {
+		return nil, noop, err
+	}
+	return plaintext, noop, nil
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReadFileMapped(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	plaintext, _ := NewPlaintextStorage(tmpdir + "/plain")
+	encrypted, _ := NewEncryptedStorage(tmpdir+"/encrypted", getKey())
+	content := bytes.Repeat([]byte("snapshot"), 4096)
+
+	t.Log("maps plaintext file")
+	{
+		plaintext.WriteFile("snapshot", content)
+		data, release, err := plaintext.(PlaintextStorage).ReadFileMapped("snapshot")
+		if err != nil {
+			t.Fatalf("unexpected error when calling ReadFileMapped %+v", err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Errorf("mapped content differs")
+		}
+
+		written := make(chan struct{})
+		go func() {
+			plaintext.WriteFile("snapshot", []byte("new"))
+			close(written)
+		}()
+		select {
+		case <-written:
+			t.Errorf("expected writer to wait for release")
+		case <-time.After(20 * time.Millisecond):
+		}
+		if data[len(data)-1] != 't' {
+			t.Errorf("expected mapped content to stay intact")
+		}
+		release()
+		<-written
+	}
+
+	t.Log("maps empty file")
+	{
+		plaintext.WriteFile("empty", nil)
+		data, release, err := plaintext.(PlaintextStorage).ReadFileMapped("empty")
+		if err != nil || len(data) != 0 {
+			t.Errorf("expected empty content got %d %+v", len(data), err)
+		}
+		release()
+	}
+
+	t.Log("decrypts mapped ciphertext")
+	{
+		encrypted.WriteFile("snapshot", content)
+		data, release, err := encrypted.(EncryptedStorage).ReadFileMapped("snapshot")
+		if err != nil || !bytes.Equal(data, content) {
+			t.Errorf("unexpected decrypted content %+v", err)
+		}
+		release()
+	}
+
+	t.Log("missing file")
+	{
+		if _, _, err := plaintext.(PlaintextStorage).ReadFileMapped("missing"); !os.IsNotExist(err) {
+			t.Errorf("expected not exist error got %+v", err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func accessTime(t *testing.T, filename string) time.Time {
+	var fs syscall.Stat_t
+	if err := syscall.Stat(filename, &fs); err != nil {
+		t.Fatalf("unexpected error when calling Stat %+v", err)
+	}
+	return statAtime(&fs)
+}
+
+func TestNoAtime(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	plaintext, _ := NewPlaintextStorageWithOptions(tmpdir, PlaintextOptions{NoAtime: true})
+	encrypted, _ := NewEncryptedStorageWithOptions(tmpdir, getKey(), EncryptionOptions{NoAtime: true})
+	updating, _ := NewPlaintextStorage(tmpdir)
+
+	plaintext.WriteFile("plain", []byte("data"))
+	encrypted.WriteFile("encrypted", []byte("data"))
+	past := time.Now().Add(-72 * time.Hour).Truncate(time.Second)
+	for _, name := range []string{"plain", "encrypted"} {
+		os.Chtimes(tmpdir+"/"+name, past.Add(time.Hour), past)
+	}
+
+	t.Log("reads do not update access time")
+	{
+		if data, err := plaintext.ReadFileFully("plain"); err != nil || string(data) != "data" {
+			t.Fatalf("unexpected result of ReadFileFully %q %+v", data, err)
+		}
+		if _, err := ReadFileRange(plaintext, "plain", 1, 2); err != nil {
+			t.Fatalf("unexpected error when calling ReadFileRange %+v", err)
+		}
+		if data, err := encrypted.ReadFileFully("encrypted"); err != nil || string(data) != "data" {
+			t.Fatalf("unexpected result of ReadFileFully %q %+v", data, err)
+		}
+		for _, name := range []string{"plain", "encrypted"} {
+			if !accessTime(t, tmpdir+"/"+name).Equal(past.Add(time.Hour)) {
+				t.Errorf("expected access time of %s to stay intact", name)
+			}
+		}
+	}
+
+	t.Log("without option reads update access time")
+	{
+		updating.ReadFileFully("plain")
+		if accessTime(t, tmpdir+"/plain").Equal(past.Add(time.Hour)) {
+			t.Log("filesystem of temp dir does not record access time")
+		}
+	}
+}
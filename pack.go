@@ -0,0 +1,193 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// PackName is name of file holding packed files of its directory
+const PackName = ".pack"
+
+// pack layout is data of entries followed by names, fixed size records sorted
+// by name and trailer, records are binary searched in place so opening pack
+// does not deserialize it
+//
+//	trailer  recordsOffset u64 | namesOffset u64 | count u32 | "LFPK"
+//	record   nameOffset u32 | nameLength u32 | dataOffset u64 | dataLength u64 | modified i64
+const (
+	packMagic       = "LFPK"
+	packTrailerSize = 24
+	packRecordSize  = 32
+)
+
+type packEntry struct {
+	name     string
+	data     []byte
+	modified time.Time
+}
+
+func encodePack(entries []packEntry) []byte {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+	size := packTrailerSize + packRecordSize*len(entries)
+	for _, entry := range entries {
+		size += len(entry.data) + len(entry.name)
+	}
+	buf := make([]byte, 0, size)
+	offsets := make([]uint64, len(entries))
+	for i, entry := range entries {
+		offsets[i] = uint64(len(buf))
+		buf = append(buf, entry.data...)
+	}
+	namesOffset := uint64(len(buf))
+	nameOffsets := make([]uint32, len(entries))
+	for i, entry := range entries {
+		nameOffsets[i] = uint32(uint64(len(buf)) - namesOffset)
+		buf = append(buf, entry.name...)
+	}
+	recordsOffset := uint64(len(buf))
+	for i, entry := range entries {
+		buf = binary.LittleEndian.AppendUint32(buf, nameOffsets[i])
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(entry.name)))
+		buf = binary.LittleEndian.AppendUint64(buf, offsets[i])
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(len(entry.data)))
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(entry.modified.UnixNano()))
+	}
+	buf = binary.LittleEndian.AppendUint64(buf, recordsOffset)
+	buf = binary.LittleEndian.AppendUint64(buf, namesOffset)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(entries)))
+	return append(buf, packMagic...)
+}
+
+// packView reads pack in place
+type packView struct {
+	data    []byte
+	records []byte
+	names   []byte
+	count   int
+}
+
+func openPack(data []byte) (packView, error) {
+	var view packView
+	if len(data) < packTrailerSize || string(data[len(data)-4:]) != packMagic {
+		return view, fmt.Errorf("invalid pack")
+	}
+	trailer := data[len(data)-packTrailerSize:]
+	recordsOffset := binary.LittleEndian.Uint64(trailer[0:8])
+	namesOffset := binary.LittleEndian.Uint64(trailer[8:16])
+	count := int(binary.LittleEndian.Uint32(trailer[16:20]))
+	end := uint64(len(data) - packTrailerSize)
+	if namesOffset > recordsOffset || recordsOffset > end || end-recordsOffset != uint64(count)*packRecordSize {
+		return view, fmt.Errorf("invalid pack")
+	}
+	view.data = data[:namesOffset]
+	view.names = data[namesOffset:recordsOffset]
+	view.records = data[recordsOffset:end]
+	view.count = count
+	return view, nil
+}
+
+func (view packView) name(i int) []byte {
+	record := view.records[i*packRecordSize:]
+	offset := binary.LittleEndian.Uint32(record[0:4])
+	length := binary.LittleEndian.Uint32(record[4:8])
+	if uint64(offset)+uint64(length) > uint64(len(view.names)) {
+		return nil
+	}
+	return view.names[offset : offset+length]
+}
+
+func (view packView) entry(i int) ([]byte, time.Time, error) {
+	record := view.records[i*packRecordSize:]
+	offset := binary.LittleEndian.Uint64(record[8:16])
+	length := binary.LittleEndian.Uint64(record[16:24])
+	modified := time.Unix(0, int64(binary.LittleEndian.Uint64(record[24:32])))
+	if offset+length > uint64(len(view.data)) {
+		return nil, modified, fmt.Errorf("invalid pack entry")
+	}
+	return view.data[offset : offset+length], modified, nil
+}
+
+// lookup binary searches records for given name without allocating
+func (view packView) lookup(name string) (int, bool) {
+	i := sort.Search(view.count, func(i int) bool {
+		return string(view.name(i)) >= name
+	})
+	return i, i < view.count && string(view.name(i)) == name
+}
+
+// entries returns copy of all entries safe to use after view is released
+func (view packView) entries() ([]packEntry, error) {
+	result := make([]packEntry, 0, view.count)
+	for i := 0; i < view.count; i++ {
+		data, modified, err := view.entry(i)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, packEntry{name: string(view.name(i)), data: append([]byte(nil), data...), modified: modified})
+	}
+	return result, nil
+}
+
+// mapPack memory maps pack file so it is searched without reading it, view
+// is valid until release is called, packs are replaced by rename so mapping
+// keeps seeing consistent content
+func mapPack(absPath string) (packView, func(), error) {
+	fd, err := openRetrying(absPath, syscall.O_RDONLY, 0)
+	if err != nil {
+		return packView{}, noop, err
+	}
+	defer syscall.Close(fd)
+	var fs syscall.Stat_t
+	if err = syscall.Fstat(fd, &fs); err != nil {
+		return packView{}, noop, err
+	}
+	if fs.Size < packTrailerSize {
+		return packView{}, noop, fmt.Errorf("invalid pack")
+	}
+	data, err := syscall.Mmap(fd, 0, int(fs.Size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return packView{}, noop, err
+	}
+	view, err := openPack(data)
+	if err != nil {
+		syscall.Munmap(data)
+		return view, noop, err
+	}
+	return view, func() {
+		syscall.Munmap(data)
+	}, nil
+}
+
+// writePackFile atomically replaces pack file
+func writePackFile(absPath string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(absPath), os.ModePerm); err != nil {
+		return err
+	}
+	temporary := temporarySibling(absPath, "tmp")
+	if err := writeSynced(temporary, data); err != nil {
+		os.Remove(temporary)
+		return err
+	}
+	return os.Rename(temporary, absPath)
+}
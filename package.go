@@ -15,6 +15,7 @@
 package storage
 
 import (
+	"io"
 	"os"
 	"time"
 )
@@ -28,9 +29,11 @@ type Storage interface {
 	TouchFile(string) error
 	Mkdir( string) error
 	ReadFileFully(string) ([]byte, error)
+	GetFileReader(string) (io.ReadCloser, error)
 	WriteFileExclusive(string, []byte) error
 	WriteFile(string, []byte) error
 	Delete(string) error
 	AppendFile(string, []byte) error
 	LastModification(string) (time.Time, error)
+	FileSize(string) (int64, error)
 }
@@ -0,0 +1,125 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"os"
+	"time"
+)
+
+// Partitioning selects period covered by single partition
+type Partitioning int
+
+const (
+	// PartitionDaily routes appends into directory per UTC day
+	// "2006-01-02"
+	PartitionDaily Partitioning = iota
+	// PartitionHourly routes appends into directory per UTC hour
+	// "2006-01-02/15"
+	PartitionHourly
+)
+
+// PartitionedAppender appends into file of given name inside time
+// partitioned directories, e.g. "events/2024-05-01/journal"
+type PartitionedAppender struct {
+	storage      Storage
+	dir          string
+	name         string
+	partitioning Partitioning
+}
+
+// NewPartitionedAppender returns appender of file given name in partitions
+// of dir
+func NewPartitionedAppender(storage Storage, dir string, name string, partitioning Partitioning) PartitionedAppender {
+	return PartitionedAppender{
+		storage:      storage,
+		dir:          dir,
+		name:         name,
+		partitioning: partitioning,
+	}
+}
+
+// partition returns directory of partition covering given time relative to
+// dir
+func (appender PartitionedAppender) partition(at time.Time) string {
+	at = at.UTC()
+	if appender.partitioning == PartitionHourly {
+		return at.Format("2006-01-02/15")
+	}
+	return at.Format("2006-01-02")
+}
+
+// Path returns path of file in partition covering given time
+func (appender PartitionedAppender) Path(at time.Time) string {
+	return appender.dir + "/" + appender.partition(at) + "/" + appender.name
+}
+
+// Append appends data into partition of current time
+func (appender PartitionedAppender) Append(data []byte) error {
+	return appender.AppendAt(time.Now(), data)
+}
+
+// AppendAt appends data into partition covering given time
+func (appender PartitionedAppender) AppendAt(at time.Time, data []byte) error {
+	return appender.storage.AppendFile(appender.Path(at), data)
+}
+
+// Partitions returns ascending paths of existing files of partitions
+// overlapping time range [from, to)
+func (appender PartitionedAppender) Partitions(from time.Time, to time.Time) ([]string, error) {
+	result := make([]string, 0)
+	days, err := appender.storage.ListDirectory(appender.dir, true)
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, day := range days {
+		start, err := time.Parse("2006-01-02", day)
+		if err != nil || !overlaps(start, 24*time.Hour, from, to) {
+			continue
+		}
+		partitions := []string{day}
+		if appender.partitioning == PartitionHourly {
+			hours, err := appender.storage.ListDirectory(appender.dir+"/"+day, true)
+			if err != nil {
+				return nil, err
+			}
+			partitions = partitions[:0]
+			for _, hour := range hours {
+				if start, err := time.Parse("2006-01-02/15", day+"/"+hour); err == nil && overlaps(start, time.Hour, from, to) {
+					partitions = append(partitions, day+"/"+hour)
+				}
+			}
+		}
+		for _, partition := range partitions {
+			path := appender.dir + "/" + partition + "/" + appender.name
+			ok, err := appender.storage.Exists(path)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				result = append(result, path)
+			}
+		}
+	}
+	return result, nil
+}
+
+// overlaps returns true when period starting at start overlaps [from, to)
+func overlaps(start time.Time, period time.Duration, from time.Time, to time.Time) bool {
+	return start.Before(to) && start.Add(period).After(from)
+}
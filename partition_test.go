@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPartitionedAppender(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	base := time.Date(2024, 5, 1, 22, 30, 0, 0, time.UTC)
+
+	t.Log("daily partitions")
+	{
+		appender := NewPartitionedAppender(storage, "events", "journal", PartitionDaily)
+		for _, hours := range []int{0, 1, 2, 26, 50} {
+			if err := appender.AppendAt(base.Add(time.Duration(hours)*time.Hour), []byte("x")); err != nil {
+				t.Fatalf("unexpected error when calling AppendAt %+v", err)
+			}
+		}
+		if data, _ := storage.ReadFileFully("events/2024-05-01/journal"); string(data) != "xx" {
+			t.Errorf("expected two appends into first day got %q", data)
+		}
+		partitions, err := appender.Partitions(base, base.Add(27*time.Hour))
+		expected := []string{"events/2024-05-01/journal", "events/2024-05-02/journal", "events/2024-05-03/journal"}
+		if err != nil || !reflect.DeepEqual(partitions, expected) {
+			t.Errorf("expected %+v got %+v %+v", expected, partitions, err)
+		}
+		partitions, _ = appender.Partitions(base.Add(50*time.Hour), base.Add(100*time.Hour))
+		if !reflect.DeepEqual(partitions, []string{"events/2024-05-04/journal"}) {
+			t.Errorf("unexpected partitions %+v", partitions)
+		}
+		storage.Mkdir("events/not-a-date")
+		if partitions, _ = appender.Partitions(time.Time{}, base.Add(1000*time.Hour)); len(partitions) != 4 {
+			t.Errorf("expected 4 partitions got %+v", partitions)
+		}
+	}
+
+	t.Log("hourly partitions")
+	{
+		appender := NewPartitionedAppender(storage, "audit", "log", PartitionHourly)
+		for _, minutes := range []int{0, 20, 40, 90} {
+			appender.AppendAt(base.Add(time.Duration(minutes)*time.Minute), []byte("x"))
+		}
+		if appender.Path(base) != "audit/2024-05-01/22/log" {
+			t.Errorf("unexpected path %s", appender.Path(base))
+		}
+		partitions, err := appender.Partitions(base.Add(45*time.Minute), base.Add(3*time.Hour))
+		expected := []string{"audit/2024-05-01/23/log", "audit/2024-05-02/00/log"}
+		if err != nil || !reflect.DeepEqual(partitions, expected) {
+			t.Errorf("expected %+v got %+v %+v", expected, partitions, err)
+		}
+	}
+
+	t.Log("missing directory")
+	{
+		appender := NewPartitionedAppender(storage, "missing", "log", PartitionDaily)
+		if partitions, err := appender.Partitions(time.Time{}, time.Now()); err != nil || len(partitions) != 0 {
+			t.Errorf("expected no partitions got %+v %+v", partitions, err)
+		}
+	}
+}
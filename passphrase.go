@@ -0,0 +1,116 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// ErrInvalidPassphrase is returned when passphrase does not match one root
+// was initialized with
+var ErrInvalidPassphrase = errors.New("invalid passphrase")
+
+// passphraseParams are Argon2id parameters persisted next to salt so they
+// can be raised for new roots without breaking existing ones
+type passphraseParams struct {
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+	Check   []byte `json:"check"`
+}
+
+func (params passphraseParams) derive(passphrase []byte) []byte {
+	return argon2.IDKey(passphrase, params.Salt, params.Time, params.Memory, params.Threads, 32)
+}
+
+func passphraseCheck(key []byte) []byte {
+	sum := sha256.Sum256(append([]byte("localfs passphrase check\x00"), key...))
+	return sum[:16]
+}
+
+// NewEncryptedStorageFromPassphrase returns new storage over given root with
+// AES key derived from passphrase using Argon2id, salt is generated on first
+// use and persisted in control directory of root
+func NewEncryptedStorageFromPassphrase(root string, passphrase []byte) (Storage, error) {
+	if root == "" {
+		return NilStorage{}, fmt.Errorf("invalid root directory")
+	}
+	if len(passphrase) == 0 {
+		return NilStorage{}, fmt.Errorf("no passphrase setup")
+	}
+	filename := filepath.Join(filepath.Clean(root), ControlDirectory, saltFile)
+	var params passphraseParams
+	data, err := os.ReadFile(filename)
+	switch {
+	case err == nil:
+		if err = json.Unmarshal(data, &params); err != nil {
+			return NilStorage{}, fmt.Errorf("unable to read salt %w", err)
+		}
+		key := params.derive(passphrase)
+		if subtle.ConstantTimeCompare(passphraseCheck(key), params.Check) != 1 {
+			return NilStorage{}, ErrInvalidPassphrase
+		}
+		return NewEncryptedStorage(root, key)
+	case os.IsNotExist(err):
+		params = passphraseParams{
+			Salt:    make([]byte, 16),
+			Time:    3,
+			Memory:  64 * 1024,
+			Threads: 4,
+		}
+		if _, err = rand.Read(params.Salt); err != nil {
+			return NilStorage{}, err
+		}
+		key := params.derive(passphrase)
+		params.Check = passphraseCheck(key)
+		if data, err = json.Marshal(params); err != nil {
+			return NilStorage{}, err
+		}
+		if err = os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+			return NilStorage{}, err
+		}
+		// exclusive create so concurrent first use cannot persist two salts
+		file, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if os.IsExist(err) {
+			return NewEncryptedStorageFromPassphrase(root, passphrase)
+		}
+		if err != nil {
+			return NilStorage{}, err
+		}
+		if _, err = file.Write(data); err == nil {
+			err = file.Sync()
+		}
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(filename)
+			return NilStorage{}, err
+		}
+		return NewEncryptedStorage(root, key)
+	default:
+		return NilStorage{}, err
+	}
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestEncryptedStorageFromPassphrase(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, err := NewEncryptedStorageFromPassphrase(tmpdir, []byte("correct horse"))
+	if err != nil {
+		t.Fatalf("unexpected error when calling NewEncryptedStorageFromPassphrase %+v", err)
+	}
+	storage.WriteFile("file", []byte("ledger"))
+
+	if _, err := os.Stat(tmpdir + "/" + ControlDirectory + "/salt"); err != nil {
+		t.Errorf("expected salt to be persisted %+v", err)
+	}
+
+	t.Log("same passphrase derives same key")
+	{
+		reopened, err := NewEncryptedStorageFromPassphrase(tmpdir, []byte("correct horse"))
+		if err != nil {
+			t.Fatalf("unexpected error when calling NewEncryptedStorageFromPassphrase %+v", err)
+		}
+		data, err := reopened.ReadFileFully("file")
+		if err != nil || string(data) != "ledger" {
+			t.Errorf("expected ledger got %s %+v", string(data), err)
+		}
+	}
+
+	t.Log("wrong passphrase is rejected")
+	{
+		if _, err := NewEncryptedStorageFromPassphrase(tmpdir, []byte("battery staple")); err != ErrInvalidPassphrase {
+			t.Errorf("expected ErrInvalidPassphrase got %+v", err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"sync"
+)
+
+// pathStripes is number of mutexes paths are spread over
+const pathStripes = 256
+
+// pathLocks serializes writers of same path within process, flock alone
+// leaves ordering of goroutines to kernel, paths are striped so memory does
+// not grow with number of files
+var pathLocks [pathStripes]sync.Mutex
+
+// orderLocks serialize decorators pairing write of path with bookkeeping of
+// it, underlying write takes stripe of pathLocks itself and stripes are not
+// reentrant so decorators hold stripe of their own over both, every decorator
+// has table of its own so decorators stacked over each other do not deadlock
+type orderLocks [pathStripes]sync.Mutex
+
+var (
+	journalOrder orderLocks
+	quotaOrder   orderLocks
+	versionOrder orderLocks
+)
+
+// stripeOf returns stripe of cleaned absolute path
+func stripeOf(locks *[pathStripes]sync.Mutex, filename string) *sync.Mutex {
+	// FNV-1a inlined so hot write paths do not allocate hasher
+	hash := uint32(2166136261)
+	for i := 0; i < len(filename); i++ {
+		hash ^= uint32(filename[i])
+		hash *= 16777619
+	}
+	return &locks[hash%pathStripes]
+}
+
+// lockPath locks and returns stripe of cleaned absolute path
+func lockPath(filename string) *sync.Mutex {
+	stripe := stripeOf(&pathLocks, filename)
+	stripe.Lock()
+	return stripe
+}
+
+// lockPathOrder locks and returns ordering stripe of cleaned absolute path
+// in given table, it is taken before and never inside lockPath
+func lockPathOrder(locks *orderLocks, filename string) *sync.Mutex {
+	stripe := stripeOf((*[pathStripes]sync.Mutex)(locks), filename)
+	stripe.Lock()
+	return stripe
+}
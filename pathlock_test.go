@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestPathLocks(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	t.Log("same path maps to same stripe")
+	{
+		first := lockPath(tmpdir + "/file")
+		first.Unlock()
+		if second := lockPath(tmpdir + "/file"); second != first {
+			t.Errorf("expected same stripe for same path")
+		} else {
+			second.Unlock()
+		}
+	}
+
+	t.Log("concurrent appends do not interleave")
+	{
+		storage, _ := NewPlaintextStorage(tmpdir)
+		var wg sync.WaitGroup
+		for i := 0; i < 64; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				record := bytes.Repeat([]byte{byte('a' + i%26)}, 4096)
+				if err := storage.AppendFile("journal", append([]byte(fmt.Sprintf("%02d", i)), record...)); err != nil {
+					t.Errorf("unexpected error when calling AppendFile %+v", err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		data, _ := storage.ReadFileFully("journal")
+		if len(data) != 64*4098 {
+			t.Fatalf("expected %d bytes got %d", 64*4098, len(data))
+		}
+		for offset := 0; offset < len(data); offset += 4098 {
+			var i int
+			fmt.Sscanf(string(data[offset:offset+2]), "%02d", &i)
+			if !bytes.Equal(data[offset+2:offset+4098], bytes.Repeat([]byte{byte('a' + i%26)}, 4096)) {
+				t.Fatalf("expected record %d at offset %d to be whole", i, offset)
+			}
+		}
+	}
+}
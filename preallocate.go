@@ -0,0 +1,71 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"path/filepath"
+	"syscall"
+)
+
+// preallocator is implemented by storages able to reserve disk space
+type preallocator interface {
+	Preallocate(path string, size int64) error
+}
+
+// Preallocate reserves disk space for file to grow to size without changing
+// its size, ENOTSUP is returned by storages and filesystems without support
+func Preallocate(storage Storage, path string, size int64) error {
+	if candidate, ok := storage.(preallocator); ok {
+		return candidate.Preallocate(path, size)
+	}
+	return syscall.ENOTSUP
+}
+
+// preallocate reserves blocks of file up to size keeping its size so appends
+// land in reserved space
+func preallocate(storage PlaintextStorage, filename string, size int64) error {
+	if size < 0 {
+		return syscall.EINVAL
+	}
+	fd, release, err := storage.openLocked(filename, "fallocate")
+	if err != nil {
+		return err
+	}
+	defer release()
+	for {
+		err = reserveSpace(fd, size)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err == syscall.EOPNOTSUPP {
+		return syscall.ENOTSUP
+	}
+	return err
+}
+
+// Preallocate reserves contiguous disk space for file to grow to size
+// without changing its size, file is created when it does not exist
+func (storage PlaintextStorage) Preallocate(path string, size int64) error {
+	defer storage.barrier.enter()()
+	return preallocate(storage, filepath.Clean(storage.root+"/"+path), size)
+}
+
+// Preallocate reserves contiguous disk space for ciphertext of file to grow
+// to size without changing its size, file is created when it does not exist
+func (storage EncryptedStorage) Preallocate(path string, size int64) error {
+	defer storage.barrier.enter()()
+	return preallocate(PlaintextStorage{handles: storage.handles}, filepath.Clean(storage.root+"/"+path), size)
+}
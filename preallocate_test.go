@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestPreallocate(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	storage.WriteFile("journal", []byte("abc"))
+
+	err = Preallocate(storage, "journal", 1<<20)
+	if err == syscall.ENOTSUP {
+		t.Skip("filesystem does not support fallocate")
+	}
+	if err != nil {
+		t.Fatalf("unexpected error when calling Preallocate %+v", err)
+	}
+
+	t.Log("size is kept")
+	{
+		if size, _ := storage.FileSize("journal"); size != 3 {
+			t.Errorf("expected size 3 got %d", size)
+		}
+		storage.AppendFile("journal", []byte("def"))
+		if data, _ := storage.ReadFileFully("journal"); string(data) != "abcdef" {
+			t.Errorf("expected abcdef got %q", string(data))
+		}
+	}
+
+	t.Log("space is reserved")
+	{
+		usage, _ := storage.(PlaintextStorage).DiskUsage("")
+		if usage.Allocated < 1<<20 {
+			t.Errorf("expected at least 1MiB allocated got %+v", usage)
+		}
+	}
+
+	t.Log("unsupported storage")
+	{
+		if err := Preallocate(struct{ Storage }{storage}, "journal", 1); err != syscall.ENOTSUP {
+			t.Errorf("expected ENOTSUP got %+v", err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// prefetcher is implemented by storages able to prepare files ahead of read
+type prefetcher interface {
+	Prefetch(paths []string) <-chan struct{}
+}
+
+// Prefetch asynchronously prepares files expected to be read soon, e.g.
+// accounts referenced by incoming batch, using first storage of decorator
+// chain supporting it, it is only a hint and failures are ignored, returned
+// channel is closed once prefetch finished
+func Prefetch(storage Storage, paths []string) <-chan struct{} {
+	for storage != nil {
+		if candidate, ok := storage.(prefetcher); ok {
+			return candidate.Prefetch(paths)
+		}
+		decorator, ok := storage.(wrapper)
+		if !ok {
+			break
+		}
+		storage = decorator.unwrap()
+	}
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+// pageIn asks kernel to read files into page cache in background
+func pageIn(root string, paths []string) {
+	for _, path := range paths {
+		fd, err := unix.Open(filepath.Clean(root+"/"+path), unix.O_RDONLY|unix.O_NONBLOCK, 0)
+		if err != nil {
+			continue
+		}
+		defaultFadvise(fd, 0, 0, fadvWillNeed)
+		unix.Close(fd)
+	}
+}
+
+// pageInBackground pages files in from goroutine closing returned channel
+// when done
+func pageInBackground(root string, paths []string) <-chan struct{} {
+	paths = append([]string(nil), paths...)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pageIn(root, paths)
+	}()
+	return done
+}
+
+// Prefetch pages given files into page cache in background
+func (storage PlaintextStorage) Prefetch(paths []string) <-chan struct{} {
+	return pageInBackground(storage.root, paths)
+}
+
+// Prefetch pages given files into page cache in background
+func (storage EncryptedStorage) Prefetch(paths []string) <-chan struct{} {
+	return pageInBackground(storage.root, paths)
+}
+
+// Prefetch recalls evicted files from remote tier in background and pages
+// them in
+func (storage TieredStorage) Prefetch(paths []string) <-chan struct{} {
+	paths = append([]string(nil), paths...)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, path := range paths {
+			if ok, err := storage.Evicted(path); err == nil && ok {
+				storage.Recall(path)
+			}
+		}
+		<-Prefetch(storage.Storage, paths)
+	}()
+	return done
+}
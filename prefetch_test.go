@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPrefetch(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	local, _ := NewEncryptedStorage(tmpdir+"/local", getKey())
+	remote, _ := NewPlaintextStorage(tmpdir + "/remote")
+	underlying, _ := NewTieredStorage(local, remote)
+	tiered := underlying.(TieredStorage)
+	storage := NewPackedStorage(underlying)
+
+	storage.WriteFile("account/A", []byte("A"))
+	storage.WriteFile("account/B", []byte("B"))
+	tiered.Evict("account/A")
+	tiered.Evict("account/B")
+
+	t.Log("recalls evicted files through decorators")
+	{
+		select {
+		case <-Prefetch(storage, []string{"account/A", "missing"}):
+		case <-time.After(time.Second):
+			t.Fatalf("expected prefetch to finish")
+		}
+		if ok, _ := tiered.Evicted("account/A"); ok {
+			t.Fatalf("expected file to be recalled")
+		}
+		if ok, _ := tiered.Evicted("account/B"); !ok {
+			t.Errorf("expected other file to stay evicted")
+		}
+		data, err := storage.ReadFileFully("account/A")
+		if err != nil || string(data) != "A" {
+			t.Errorf("expected A got %s %+v", string(data), err)
+		}
+	}
+}
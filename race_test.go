@@ -0,0 +1,7 @@
+//go:build race
+
+package storage
+
+// raceEnabled reports race detector build where sync.Pool deliberately drops
+// pooled values
+const raceEnabled = true
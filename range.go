@@ -0,0 +1,187 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// rangeReader is implemented by storages able to read part of file without
+// reading whole file
+type rangeReader interface {
+	ReadFileRange(path string, offset int64, length int64) ([]byte, error)
+}
+
+// ReadFileRange returns at most length bytes of file starting at offset,
+// result is shorter when file ends sooner, storages not supporting ranged
+// reads read whole file
+func ReadFileRange(storage Storage, path string, offset int64, length int64) ([]byte, error) {
+	if candidate, ok := storage.(rangeReader); ok {
+		return candidate.ReadFileRange(path, offset, length)
+	}
+	if offset < 0 || length < 0 {
+		return nil, syscall.EINVAL
+	}
+	data, err := storage.ReadFileFully(path)
+	if err != nil {
+		return nil, err
+	}
+	return sliceRange(data, offset, length), nil
+}
+
+// sliceRange returns part of data within range
+func sliceRange(data []byte, offset int64, length int64) []byte {
+	if offset >= int64(len(data)) {
+		return []byte{}
+	}
+	end := offset + length
+	if end > int64(len(data)) || end < offset {
+		end = int64(len(data))
+	}
+	return data[offset:end]
+}
+
+// preadRange reads range of file clipped to its size
+func preadRange(fd int, size int64, offset int64, length int64) ([]byte, error) {
+	if offset >= size {
+		return []byte{}, nil
+	}
+	if length > size-offset {
+		length = size - offset
+	}
+	buf := make([]byte, length)
+	n, err := preadFull(fd, buf, offset)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// withSharedLock calls fn with descriptor and size of file held under shared
+// lock
+func withSharedLock(filename string, handles *handleRegistry, noAtime bool, timeout time.Duration, fn func(fd int, size int64) error) error {
+	fd, _, err := openFile(filename, syscall.O_RDONLY|syscall.O_NONBLOCK, 0600, false, noAtime)
+	if err != nil {
+		return err
+	}
+	defer handles.track(filename, "range")()
+	defer syscall.Close(fd)
+	if err = flock(fd, filename, syscall.LOCK_SH, timeout); err != nil {
+		return err
+	}
+	defer funlock(fd, filename)
+	var fs syscall.Stat_t
+	if err = syscall.Fstat(fd, &fs); err != nil {
+		return err
+	}
+	return fn(fd, fs.Size)
+}
+
+// ReadFileRange returns at most length bytes of file starting at offset
+// reading only requested range
+func (storage PlaintextStorage) ReadFileRange(path string, offset int64, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 {
+		return nil, syscall.EINVAL
+	}
+	var result []byte
+	err := withSharedLock(filepath.Clean(storage.root+"/"+path), storage.handles, storage.noAtime, storage.lockTimeout, func(fd int, size int64) (err error) {
+		result, err = preadRange(fd, size, offset, length)
+		return
+	})
+	return result, err
+}
+
+// ReadFileRange returns at most length bytes of plaintext starting at offset,
+// in plain CFB mode only blocks and in chunked format only chunks covering
+// range are read and decrypted, HMAC and AEAD modes authenticate whole file
+// so whole file is read
+func (storage EncryptedStorage) ReadFileRange(path string, offset int64, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 {
+		return nil, syscall.EINVAL
+	}
+	reader, chunked, err := storage.openChunked(path)
+	if err != nil {
+		return nil, err
+	}
+	if chunked {
+		defer reader.Close()
+		if offset >= reader.Size() {
+			return []byte{}, nil
+		}
+		if length > reader.Size()-offset {
+			length = reader.Size() - offset
+		}
+		result := make([]byte, length)
+		n, err := reader.ReadAt(result, offset)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return result[:n], nil
+	}
+	if storage.aead || storage.authenticate {
+		data, err := storage.ReadFileFully(path)
+		if err != nil {
+			return nil, err
+		}
+		return sliceRange(data, offset, length), nil
+	}
+	var result []byte
+	err = withSharedLock(filepath.Clean(storage.root+"/"+path), storage.handles, storage.noAtime, storage.lockTimeout, func(fd int, size int64) error {
+		prefix, err := preadRange(fd, size, 0, int64(len(keyHeaderMagic)+256))
+		if err != nil {
+			return err
+		}
+		header, _, key := storage.header(prefix)
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return err
+		}
+		// length is clamped to plaintext size first so end cannot overflow
+		available := size - int64(header) - aes.BlockSize
+		if offset >= available {
+			result = []byte{}
+			return nil
+		}
+		if length > available-offset {
+			length = available - offset
+		}
+		// CFB block is decrypted with preceding ciphertext block (IV for
+		// first) so range is extended to block boundary and one block before
+		aligned := offset / aes.BlockSize * aes.BlockSize
+		start := int64(header) + aligned
+		end := int64(header) + aes.BlockSize + offset + length
+		if end < start {
+			end = start
+		}
+		ciphertext, err := preadRange(fd, size, start, end-start)
+		if err != nil {
+			return err
+		}
+		if len(ciphertext) < aes.BlockSize {
+			result = []byte{}
+			return nil
+		}
+		plaintext := make([]byte, len(ciphertext)-aes.BlockSize)
+		cipher.NewCFBDecrypter(block, ciphertext[:aes.BlockSize]).XORKeyStream(plaintext, ciphertext[aes.BlockSize:])
+		result = sliceRange(plaintext, offset-aligned, length)
+		return nil
+	})
+	return result, err
+}
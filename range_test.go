@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestReadFileRange(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	content := make([]byte, 10000)
+	rand.Read(content)
+
+	ring := NewKeyRing()
+	ring.AddKey("2023-01", getKey())
+	plaintext, _ := NewPlaintextStorage(tmpdir + "/plaintext")
+	encrypted, _ := NewEncryptedStorage(tmpdir+"/encrypted", getKey())
+	keyring, _ := NewEncryptedStorageWithOptions(tmpdir+"/keyring", getKey(), EncryptionOptions{KeyRing: ring})
+	aead, _ := NewEncryptedStorageWithOptions(tmpdir+"/aead", getKey(), EncryptionOptions{AEAD: true})
+	chunked, _ := NewEncryptedStorageWithOptions(tmpdir+"/chunked", getKey(), EncryptionOptions{KeyRing: ring, AEAD: true, ChunkSize: 1000})
+
+	ranges := [][2]int64{{0, 10}, {5, 100}, {16, 16}, {17, 31}, {9990, 100}, {10000, 5}, {20000, 1}, {0, 10000}, {5, math.MaxInt64}}
+	for name, storage := range map[string]Storage{"plaintext": plaintext, "encrypted": encrypted, "keyring": keyring, "aead": aead, "chunked": chunked} {
+		if err = storage.WriteFile("journal", content); err != nil {
+			t.Fatalf("unexpected error when calling WriteFile %+v", err)
+		}
+		for _, r := range ranges {
+			data, err := ReadFileRange(storage, "journal", r[0], r[1])
+			if err != nil {
+				t.Fatalf("%s unexpected error when calling ReadFileRange %+v", name, err)
+			}
+			if expected := sliceRange(content, r[0], r[1]); !bytes.Equal(data, expected) {
+				t.Errorf("%s range %+v expected %d bytes got %d", name, r, len(expected), len(data))
+			}
+		}
+		if _, err := ReadFileRange(storage, "journal", -1, 1); err != syscall.EINVAL {
+			t.Errorf("%s expected EINVAL for negative offset got %+v", name, err)
+		}
+		if _, err := ReadFileRange(storage, "missing", 0, 1); !os.IsNotExist(err) {
+			t.Errorf("%s expected not exist error got %+v", name, err)
+		}
+	}
+
+	t.Log("decorated storage")
+	{
+		data, err := ReadFileRange(struct{ Storage }{plaintext}, "journal", 100, 10)
+		if err != nil || !bytes.Equal(data, content[100:110]) {
+			t.Errorf("unexpected result %+v %+v", data, err)
+		}
+	}
+}
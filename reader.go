@@ -0,0 +1,83 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// fileReader streams file holding shared lock on it until closed
+type fileReader struct {
+	file     *os.File
+	fd       int
+	filename string
+	release  func()
+}
+
+func (reader *fileReader) Read(p []byte) (int, error) {
+	return reader.file.Read(p)
+}
+
+// Seek sets offset of next Read
+func (reader *fileReader) Seek(offset int64, whence int) (int64, error) {
+	return reader.file.Seek(offset, whence)
+}
+
+// Close releases lock and descriptor of file, reader must be closed even
+// when it was read to the end
+func (reader *fileReader) Close() error {
+	if reader.release == nil {
+		return os.ErrClosed
+	}
+	funlock(reader.fd, reader.filename)
+	reader.release()
+	reader.release = nil
+	return reader.file.Close()
+}
+
+// openReader opens file for streaming under shared lock so writers wait
+// until reader is closed
+func openReader(storage PlaintextStorage, path string) (io.ReadCloser, error) {
+	filename := filepath.Clean(storage.root + "/" + path)
+	fd, _, err := openFile(filename, syscall.O_RDONLY|syscall.O_NONBLOCK, 0600, false, storage.noAtime)
+	if err != nil {
+		return nil, err
+	}
+	release := storage.handles.track(filename, "reader")
+	if err = flock(fd, filename, syscall.LOCK_SH, storage.lockTimeout); err != nil {
+		release()
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &fileReader{
+		file:     os.NewFile(uintptr(fd), filename),
+		fd:       fd,
+		filename: filename,
+		release:  release,
+	}, nil
+}
+
+// readerOf returns reader over data read fully, it is used by storages which
+// must see whole file (to decrypt or verify it) before returning first byte
+func readerOf(data []byte, err error) (io.ReadCloser, error) {
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"sync"
+)
+
+// recordHeaderSize is length of frame header, record is prefixed with its
+// length and crc32 (4 bytes each)
+const recordHeaderSize = 8
+
+// RecordLog is append-only log of length and crc32 framed binary records
+// stored in single file
+type RecordLog struct {
+	storage Storage
+	path    string
+	tail    *recordTail
+}
+
+// recordTail is end of log verified to hold only complete records
+type recordTail struct {
+	sync.Mutex
+	end int64
+}
+
+// NewRecordLog returns record log stored in file given path
+func NewRecordLog(storage Storage, path string) RecordLog {
+	return RecordLog{
+		storage: storage,
+		path:    path,
+		tail:    new(recordTail),
+	}
+}
+
+// AppendRecord appends data as single framed record, log file is created
+// when it does not exist, torn final record left by crash is cut off first
+// so it does not shadow appended record
+func (log RecordLog) AppendRecord(data []byte) error {
+	if uint64(len(data)) > math.MaxUint32 {
+		return fmt.Errorf("record of %d bytes is too large", len(data))
+	}
+	frame := make([]byte, recordHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(frame, uint32(len(data)))
+	binary.LittleEndian.PutUint32(frame[4:], crc32.ChecksumIEEE(data))
+	copy(frame[recordHeaderSize:], data)
+
+	log.tail.Lock()
+	defer log.tail.Unlock()
+	end, err := log.recover()
+	if err != nil {
+		return err
+	}
+	if err = log.storage.AppendFile(log.path, frame); err != nil {
+		log.tail.end = 0
+		return err
+	}
+	log.tail.end = end + int64(len(frame))
+	return nil
+}
+
+// recover returns end of last complete record and truncates torn record
+// following it, only part of log appended since last verified end is read
+func (log RecordLog) recover() (int64, error) {
+	size, err := log.storage.FileSize(log.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if size == log.tail.end {
+		return size, nil
+	}
+	from := log.tail.end
+	if from > size {
+		from = 0
+	}
+	end, err := log.IterateRecords(from, func(int64, []byte) bool { return true })
+	if err != nil || end == size {
+		return end, err
+	}
+	data, err := ReadFileRange(log.storage, log.path, 0, end)
+	if err != nil {
+		return 0, err
+	}
+	if err = log.storage.WriteFile(log.path, data); err != nil {
+		return 0, err
+	}
+	return end, nil
+}
+
+// IterateRecords calls fn for every record starting at fromOffset until fn
+// returns false, returns offset following last record passed to fn so
+// iteration can be resumed, torn final record left by crash during append is
+// not passed to fn while corrupted record followed by others is an error
+func (log RecordLog) IterateRecords(fromOffset int64, fn func(offset int64, data []byte) bool) (int64, error) {
+	size, err := log.storage.FileSize(log.path)
+	if os.IsNotExist(err) {
+		return fromOffset, nil
+	}
+	if err != nil {
+		return fromOffset, err
+	}
+	if fromOffset >= size {
+		return fromOffset, nil
+	}
+	data, err := ReadFileRange(log.storage, log.path, fromOffset, size-fromOffset)
+	if err != nil {
+		return fromOffset, err
+	}
+	offset := fromOffset
+	for len(data) >= recordHeaderSize {
+		length := int64(binary.LittleEndian.Uint32(data))
+		if length > int64(len(data)-recordHeaderSize) {
+			break
+		}
+		record := data[recordHeaderSize : recordHeaderSize+length]
+		if crc32.ChecksumIEEE(record) != binary.LittleEndian.Uint32(data[4:]) {
+			if recordHeaderSize+length == int64(len(data)) {
+				break
+			}
+			return offset, fmt.Errorf("corrupted record at offset %d", offset)
+		}
+		next := offset + recordHeaderSize + length
+		if !fn(offset, record) {
+			return next, nil
+		}
+		offset = next
+		data = data[recordHeaderSize+length:]
+	}
+	return offset, nil
+}
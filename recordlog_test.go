@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestRecordLog(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	plaintext, _ := NewPlaintextStorage(tmpdir + "/plaintext")
+	encrypted, _ := NewEncryptedStorage(tmpdir+"/encrypted", getKey())
+
+	for name, storage := range map[string]Storage{"plaintext": plaintext, "encrypted": encrypted} {
+		log := NewRecordLog(storage, "ledger/records")
+
+		t.Logf("%s missing log has no records", name)
+		{
+			next, err := log.IterateRecords(0, func(int64, []byte) bool { return true })
+			if err != nil || next != 0 {
+				t.Errorf("%s expected offset 0 got %d %+v", name, next, err)
+			}
+		}
+
+		t.Logf("%s append and iterate", name)
+		{
+			for i := 0; i < 5; i++ {
+				if err := log.AppendRecord([]byte(fmt.Sprintf("record %d", i))); err != nil {
+					t.Fatalf("%s unexpected error when calling AppendRecord %+v", name, err)
+				}
+			}
+			log.AppendRecord(nil)
+			var records [][]byte
+			var offsets []int64
+			next, err := log.IterateRecords(0, func(offset int64, data []byte) bool {
+				records = append(records, append([]byte(nil), data...))
+				offsets = append(offsets, offset)
+				return true
+			})
+			if err != nil || len(records) != 6 || string(records[4]) != "record 4" || len(records[5]) != 0 {
+				t.Fatalf("%s expected 6 records got %q %+v", name, records, err)
+			}
+			if size, _ := storage.FileSize("ledger/records"); next != size {
+				t.Errorf("%s expected next offset %d got %d", name, size, next)
+			}
+
+			t.Logf("%s resumes from offset", name)
+			seen := 0
+			log.IterateRecords(offsets[3], func(offset int64, data []byte) bool {
+				if seen == 0 && !bytes.Equal(data, []byte("record 3")) {
+					t.Errorf("%s expected record 3 got %q", name, data)
+				}
+				seen++
+				return true
+			})
+			if seen != 3 {
+				t.Errorf("%s expected 3 records got %d", name, seen)
+			}
+
+			t.Logf("%s stops when fn returns false", name)
+			stopped, _ := log.IterateRecords(0, func(offset int64, data []byte) bool { return false })
+			if stopped != offsets[1] {
+				t.Errorf("%s expected offset %d got %d", name, offsets[1], stopped)
+			}
+		}
+	}
+
+	t.Log("tolerates torn final record")
+	{
+		log := NewRecordLog(plaintext, "torn")
+		log.AppendRecord([]byte("complete"))
+		log.AppendRecord([]byte("torn record"))
+		raw, _ := os.ReadFile(tmpdir + "/plaintext/torn")
+		for _, cut := range []int{1, 5, recordHeaderSize + 3} {
+			os.WriteFile(tmpdir+"/plaintext/torn", raw[:len(raw)-cut], 0600)
+			seen := 0
+			next, err := log.IterateRecords(0, func(int64, []byte) bool {
+				seen++
+				return true
+			})
+			if err != nil || seen != 1 || next != recordHeaderSize+8 {
+				t.Errorf("cut %d expected single record got %d at %d %+v", cut, seen, next, err)
+			}
+		}
+		for _, cut := range []int{1, 5, recordHeaderSize + 3} {
+			os.WriteFile(tmpdir+"/plaintext/torn", raw[:len(raw)-cut], 0600)
+			if err := log.AppendRecord([]byte("after crash")); err != nil {
+				t.Fatalf("cut %d unexpected error when calling AppendRecord %+v", cut, err)
+			}
+			var records []string
+			_, err := log.IterateRecords(0, func(offset int64, data []byte) bool {
+				records = append(records, string(data))
+				return true
+			})
+			if err != nil || len(records) != 2 || records[0] != "complete" || records[1] != "after crash" {
+				t.Errorf("cut %d expected torn record to be replaced by appended got %q %+v", cut, records, err)
+			}
+		}
+		tampered := append([]byte(nil), raw...)
+		tampered[len(tampered)-1] ^= 0x01
+		os.WriteFile(tmpdir+"/plaintext/torn", tampered, 0600)
+		if _, err := log.IterateRecords(0, func(int64, []byte) bool { return true }); err != nil {
+			t.Errorf("expected garbled final record to be treated as torn got %+v", err)
+		}
+	}
+
+	t.Log("detects corrupted record")
+	{
+		log := NewRecordLog(plaintext, "corrupted")
+		log.AppendRecord([]byte("first"))
+		log.AppendRecord([]byte("second"))
+		raw, _ := os.ReadFile(tmpdir + "/plaintext/corrupted")
+		raw[recordHeaderSize] ^= 0x01
+		os.WriteFile(tmpdir+"/plaintext/corrupted", raw, 0600)
+		if _, err := log.IterateRecords(0, func(int64, []byte) bool { return true }); err == nil {
+			t.Errorf("expected error for corrupted record")
+		}
+	}
+}
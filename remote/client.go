@@ -0,0 +1,173 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"time"
+
+	localfs "github.com/jancajthaml-openbank/local-fs"
+	"google.golang.org/grpc"
+)
+
+// Client is a fascade to access storage exposed over gRPC
+type Client struct {
+	localfs.Storage
+	conn    grpc.ClientConnInterface
+	timeout time.Duration
+}
+
+// NewClient returns storage calling remote storage over given connection,
+// every call is bounded by given timeout, zero means unbounded
+func NewClient(conn grpc.ClientConnInterface, timeout time.Duration) localfs.Storage {
+	return Client{
+		conn:    conn,
+		timeout: timeout,
+	}
+}
+
+func (client Client) invoke(method string, request *Request) (*Response, error) {
+	ctx := context.Background()
+	if client.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.timeout)
+		defer cancel()
+	}
+	response := new(Response)
+	err := client.conn.Invoke(ctx, "/"+ServiceName+"/"+method, request, response, grpc.CallContentSubtype(codecName))
+	if err != nil {
+		return nil, fromStatus(method, request.Path, err)
+	}
+	return response, nil
+}
+
+// Chmod sets chmod flag on given file
+func (client Client) Chmod(path string, mod os.FileMode) error {
+	_, err := client.invoke("Chmod", &Request{Path: path, Mode: uint32(mod)})
+	return err
+}
+
+// ListDirectory returns sorted slice of item names in given absolute path
+// default sorting is ascending
+func (client Client) ListDirectory(path string, ascending bool) ([]string, error) {
+	response, err := client.invoke("ListDirectory", &Request{Path: path, Ascending: ascending})
+	if err != nil {
+		return nil, err
+	}
+	if response.Entries == nil {
+		return make([]string, 0), nil
+	}
+	return response.Entries, nil
+}
+
+// CountFiles returns number of items in directory
+func (client Client) CountFiles(path string) (int, error) {
+	response, err := client.invoke("CountFiles", &Request{Path: path})
+	if err != nil {
+		return 0, err
+	}
+	return response.Count, nil
+}
+
+// Exists returns true if path exists
+func (client Client) Exists(path string) (bool, error) {
+	response, err := client.invoke("Exists", &Request{Path: path})
+	if err != nil {
+		return false, err
+	}
+	return response.Exists, nil
+}
+
+// LastModification returns time of last modification
+func (client Client) LastModification(path string) (time.Time, error) {
+	response, err := client.invoke("LastModification", &Request{Path: path})
+	if err != nil {
+		return time.Now(), err
+	}
+	return response.Time, nil
+}
+
+// FileSize returns size of file in bytes
+func (client Client) FileSize(path string) (int64, error) {
+	response, err := client.invoke("FileSize", &Request{Path: path})
+	if err != nil {
+		return 0, err
+	}
+	return response.Size, nil
+}
+
+// TouchFile creates file given absolute path if file does not already exist
+func (client Client) TouchFile(path string) error {
+	_, err := client.invoke("TouchFile", &Request{Path: path})
+	return err
+}
+
+// Mkdir creates directory given absolute path
+func (client Client) Mkdir(path string) error {
+	_, err := client.invoke("Mkdir", &Request{Path: path})
+	return err
+}
+
+// Delete removes given absolute path
+func (client Client) Delete(path string) error {
+	_, err := client.invoke("Delete", &Request{Path: path})
+	return err
+}
+
+// ReadFileFully reads whole file given absolute path
+func (client Client) ReadFileFully(path string) ([]byte, error) {
+	response, err := client.invoke("ReadFileFully", &Request{Path: path})
+	if err != nil {
+		return nil, err
+	}
+	if response.Data == nil {
+		return make([]byte, 0), nil
+	}
+	return response.Data, nil
+}
+
+// GetFileReader returns reader of file given absolute path, whole file is
+// transferred before reader is returned
+func (client Client) GetFileReader(path string) (io.ReadCloser, error) {
+	data, err := client.ReadFileFully(path)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// WriteFileExclusive writes data given absolute path to a file if that file
+// does not already exists
+func (client Client) WriteFileExclusive(path string, data []byte) error {
+	_, err := client.invoke("WriteFileExclusive", &Request{Path: path, Data: data})
+	return err
+}
+
+// WriteFile writes data given absolute path to a file, creates it if it does
+// not exist
+func (client Client) WriteFile(path string, data []byte) error {
+	_, err := client.invoke("WriteFile", &Request{Path: path, Data: data})
+	return err
+}
+
+// AppendFile appends data given absolute path to a file, creates it if it
+// does not exist
+func (client Client) AppendFile(path string, data []byte) error {
+	_, err := client.invoke("AppendFile", &Request{Path: path, Data: data})
+	return err
+}
@@ -0,0 +1,102 @@
+package remote
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	localfs "github.com/jancajthaml-openbank/local-fs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestRoundTrip(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	underlying, _ := localfs.NewPlaintextStorage(tmpdir)
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	RegisterStorageServer(server, underlying)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error when dialing %+v", err)
+	}
+	defer conn.Close()
+
+	storage := NewClient(conn, time.Second)
+
+	t.Log("writes and reads")
+	{
+		if err := storage.WriteFile("a/b", []byte("abc")); err != nil {
+			t.Fatalf("unexpected error when calling WriteFile %+v", err)
+		}
+		if err := storage.AppendFile("a/b", []byte("def")); err != nil {
+			t.Fatalf("unexpected error when calling AppendFile %+v", err)
+		}
+		data, err := storage.ReadFileFully("a/b")
+		if err != nil {
+			t.Fatalf("unexpected error when calling ReadFileFully %+v", err)
+		}
+		if string(data) != "abcdef" {
+			t.Errorf("expected abcdef got %s", string(data))
+		}
+		if size, err := storage.FileSize("a/b"); err != nil || size != 6 {
+			t.Errorf("expected size 6 got %d %+v", size, err)
+		}
+		reader, err := storage.GetFileReader("a/b")
+		if err != nil {
+			t.Fatalf("unexpected error when calling GetFileReader %+v", err)
+		}
+		if data, _ = io.ReadAll(reader); string(data) != "abcdef" {
+			t.Errorf("expected abcdef from reader got %s", string(data))
+		}
+		reader.Close()
+	}
+
+	t.Log("lists directory")
+	{
+		storage.WriteFile("a/c", []byte("x"))
+		entries, err := storage.ListDirectory("a", false)
+		if err != nil {
+			t.Fatalf("unexpected error when calling ListDirectory %+v", err)
+		}
+		if len(entries) != 2 || entries[0] != "c" || entries[1] != "b" {
+			t.Errorf("expected [c b] got %+v", entries)
+		}
+		count, _ := storage.CountFiles("a")
+		if count != 2 {
+			t.Errorf("expected 2 files got %d", count)
+		}
+		if ok, _ := storage.Exists("a/c"); !ok {
+			t.Errorf("expected a/c to exist")
+		}
+	}
+
+	t.Log("preserves error kind")
+	{
+		if _, err := storage.ReadFileFully("missing"); !os.IsNotExist(err) {
+			t.Errorf("expected not exist error got %+v", err)
+		}
+		if err := storage.WriteFileExclusive("a/c", []byte("y")); !os.IsExist(err) {
+			t.Errorf("expected exist error got %+v", err)
+		}
+	}
+}
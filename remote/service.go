@@ -0,0 +1,201 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package remote exposes storage over gRPC and provides client implementing
+// same Storage interface
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	localfs "github.com/jancajthaml-openbank/local-fs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+)
+
+// ServiceName is fully qualified name of gRPC storage service
+const ServiceName = "localfs.Storage"
+
+// codecName is content subtype of messages, messages are JSON encoded so
+// service does not need generated protobuf code
+const codecName = "localfs-json"
+
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (codec) Name() string {
+	return codecName
+}
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+// Request is argument of every storage call
+type Request struct {
+	Path      string `json:"path"`
+	Data      []byte `json:"data,omitempty"`
+	Ascending bool   `json:"ascending,omitempty"`
+	Mode      uint32 `json:"mode,omitempty"`
+}
+
+// Response is result of every storage call
+type Response struct {
+	Entries []string  `json:"entries,omitempty"`
+	Count   int       `json:"count,omitempty"`
+	Exists  bool      `json:"exists,omitempty"`
+	Time    time.Time `json:"time,omitempty"`
+	Data    []byte    `json:"data,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+}
+
+type call func(storage localfs.Storage, request *Request) (*Response, error)
+
+var calls = map[string]call{
+	"Chmod": func(storage localfs.Storage, request *Request) (*Response, error) {
+		return new(Response), storage.Chmod(request.Path, os.FileMode(request.Mode))
+	},
+	"ListDirectory": func(storage localfs.Storage, request *Request) (*Response, error) {
+		entries, err := storage.ListDirectory(request.Path, request.Ascending)
+		return &Response{Entries: entries}, err
+	},
+	"CountFiles": func(storage localfs.Storage, request *Request) (*Response, error) {
+		count, err := storage.CountFiles(request.Path)
+		return &Response{Count: count}, err
+	},
+	"Exists": func(storage localfs.Storage, request *Request) (*Response, error) {
+		exists, err := storage.Exists(request.Path)
+		return &Response{Exists: exists}, err
+	},
+	"TouchFile": func(storage localfs.Storage, request *Request) (*Response, error) {
+		return new(Response), storage.TouchFile(request.Path)
+	},
+	"Mkdir": func(storage localfs.Storage, request *Request) (*Response, error) {
+		return new(Response), storage.Mkdir(request.Path)
+	},
+	"ReadFileFully": func(storage localfs.Storage, request *Request) (*Response, error) {
+		data, err := storage.ReadFileFully(request.Path)
+		return &Response{Data: data}, err
+	},
+	"WriteFileExclusive": func(storage localfs.Storage, request *Request) (*Response, error) {
+		return new(Response), storage.WriteFileExclusive(request.Path, request.Data)
+	},
+	"WriteFile": func(storage localfs.Storage, request *Request) (*Response, error) {
+		return new(Response), storage.WriteFile(request.Path, request.Data)
+	},
+	"Delete": func(storage localfs.Storage, request *Request) (*Response, error) {
+		return new(Response), storage.Delete(request.Path)
+	},
+	"AppendFile": func(storage localfs.Storage, request *Request) (*Response, error) {
+		return new(Response), storage.AppendFile(request.Path, request.Data)
+	},
+	"LastModification": func(storage localfs.Storage, request *Request) (*Response, error) {
+		at, err := storage.LastModification(request.Path)
+		return &Response{Time: at}, err
+	},
+	"FileSize": func(storage localfs.Storage, request *Request) (*Response, error) {
+		size, err := storage.FileSize(request.Path)
+		return &Response{Size: size}, err
+	},
+}
+
+// toStatus translates storage error to gRPC status preserving not exist and
+// already exist conditions
+func toStatus(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, os.ErrNotExist):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, os.ErrExist):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, os.ErrPermission):
+		return status.Error(codes.PermissionDenied, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// fromStatus translates gRPC status back to error recognized by os.IsNotExist
+// and os.IsExist
+func fromStatus(op string, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	switch status.Code(err) {
+	case codes.NotFound:
+		return &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+	case codes.AlreadyExists:
+		return &os.PathError{Op: op, Path: path, Err: os.ErrExist}
+	case codes.PermissionDenied:
+		return &os.PathError{Op: op, Path: path, Err: os.ErrPermission}
+	default:
+		return &os.PathError{Op: op, Path: path, Err: errors.New(status.Convert(err).Message())}
+	}
+}
+
+func handler(method string, fn call) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			request := new(Request)
+			if err := dec(request); err != nil {
+				return nil, err
+			}
+			invoke := func(ctx context.Context, req interface{}) (interface{}, error) {
+				response, err := fn(srv.(localfs.Storage), req.(*Request))
+				return response, toStatus(err)
+			}
+			if interceptor == nil {
+				return invoke(ctx, request)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + ServiceName + "/" + method,
+			}
+			return interceptor(ctx, request, info, invoke)
+		},
+	}
+}
+
+func serviceDesc() *grpc.ServiceDesc {
+	desc := &grpc.ServiceDesc{
+		ServiceName: ServiceName,
+		HandlerType: (*localfs.Storage)(nil),
+		Streams:     []grpc.StreamDesc{},
+		Metadata:    "localfs",
+	}
+	for method, fn := range calls {
+		desc.Methods = append(desc.Methods, handler(method, fn))
+	}
+	return desc
+}
+
+// RegisterStorageServer exposes storage as gRPC service on given server
+func RegisterStorageServer(server *grpc.Server, storage localfs.Storage) {
+	server.RegisterService(serviceDesc(), storage)
+}
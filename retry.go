@@ -0,0 +1,108 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls retrying of transient errors of opens and locks
+// inside storage
+type RetryPolicy struct {
+	// Attempts is maximum number of attempts, one or less disables retrying
+	Attempts int
+	// Backoff is delay before second attempt, doubled for every next one
+	Backoff time.Duration
+	// MaxBackoff caps backoff, zero means no cap
+	MaxBackoff time.Duration
+	// Retryable is set of errno considered transient
+	Retryable []syscall.Errno
+}
+
+// DefaultRetryPolicy retries interrupted and temporarily unavailable opens
+// and locks
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:   5,
+	Backoff:    time.Millisecond,
+	MaxBackoff: 50 * time.Millisecond,
+	Retryable:  []syscall.Errno{syscall.EINTR, syscall.EAGAIN},
+}
+
+var retryPolicy atomic.Pointer[RetryPolicy]
+
+func init() {
+	SetRetryPolicy(DefaultRetryPolicy)
+}
+
+// SetRetryPolicy replaces policy applied by all storages of process
+func SetRetryPolicy(policy RetryPolicy) {
+	retryPolicy.Store(&policy)
+}
+
+func (policy *RetryPolicy) retryable(err error) bool {
+	for _, errno := range policy.Retryable {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// retrier counts attempts of single operation, policy is loaded on first
+// failure so successful calls do not pay for it
+type retrier struct {
+	policy  *RetryPolicy
+	attempt int
+	backoff time.Duration
+}
+
+// again returns true when operation failed with err should be attempted
+// again, waiting for backoff first
+func (retry *retrier) again(err error) bool {
+	if retry.policy == nil {
+		retry.policy = retryPolicy.Load()
+		retry.attempt = 1
+		retry.backoff = retry.policy.Backoff
+	}
+	if retry.attempt >= retry.policy.Attempts || !retry.policy.retryable(err) {
+		return false
+	}
+	retry.attempt++
+	if retry.backoff > 0 {
+		time.Sleep(retry.backoff)
+		retry.backoff *= 2
+		if retry.policy.MaxBackoff > 0 && retry.backoff > retry.policy.MaxBackoff {
+			retry.backoff = retry.policy.MaxBackoff
+		}
+	}
+	return true
+}
+
+// indirection allowing tests to emulate transient errors
+var sysOpen = syscall.Open
+
+// openRetrying opens file retrying transient errors according to policy
+func openRetrying(filename string, flags int, mode uint32) (int, error) {
+	var retry retrier
+	for {
+		fd, err := sysOpen(filename, flags, mode)
+		if err == nil || !retry.again(err) {
+			return fd, err
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	defer func() {
+		sysOpen = syscall.Open
+		sysFlock = syscall.Flock
+		SetRetryPolicy(DefaultRetryPolicy)
+	}()
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	SetRetryPolicy(RetryPolicy{
+		Attempts:  3,
+		Backoff:   time.Microsecond,
+		Retryable: []syscall.Errno{syscall.EINTR, syscall.EAGAIN},
+	})
+
+	failing := func(errno syscall.Errno, times int) *int {
+		calls := 0
+		sysOpen = func(path string, mode int, perm uint32) (int, error) {
+			calls++
+			if calls <= times {
+				return -1, errno
+			}
+			return syscall.Open(path, mode, perm)
+		}
+		return &calls
+	}
+
+	t.Log("transient open error is retried")
+	{
+		calls := failing(syscall.EINTR, 2)
+		if err := storage.WriteFile("file", []byte("data")); err != nil {
+			t.Fatalf("unexpected error when calling WriteFile %+v", err)
+		}
+		if *calls != 3 {
+			t.Errorf("expected 3 attempts got %d", *calls)
+		}
+	}
+
+	t.Log("attempts are bounded")
+	{
+		calls := failing(syscall.EAGAIN, 3)
+		if _, err := storage.ReadFileFully("file"); err != syscall.EAGAIN {
+			t.Errorf("expected EAGAIN got %+v", err)
+		}
+		if *calls != 3 {
+			t.Errorf("expected 3 attempts got %d", *calls)
+		}
+	}
+
+	t.Log("other errors are not retried")
+	{
+		calls := failing(syscall.EIO, 1)
+		if _, err := storage.ReadFileFully("file"); err != syscall.EIO {
+			t.Errorf("expected EIO got %+v", err)
+		}
+		if *calls != 1 {
+			t.Errorf("expected single attempt got %d", *calls)
+		}
+		sysOpen = syscall.Open
+	}
+
+	t.Log("transient lock error is retried")
+	{
+		calls := 0
+		sysFlock = func(fd int, how int) error {
+			calls++
+			if calls == 1 && how != syscall.LOCK_UN {
+				return syscall.EINTR
+			}
+			return syscall.Flock(fd, how)
+		}
+		if data, err := storage.ReadFileFully("file"); err != nil || string(data) != "data" {
+			t.Errorf("unexpected result of ReadFileFully %q %+v", data, err)
+		}
+	}
+
+	t.Log("busy lock requested without blocking is not retried")
+	{
+		calls := 0
+		sysFlock = func(fd int, how int) error {
+			calls++
+			return syscall.EWOULDBLOCK
+		}
+		if err := flock(0, "", syscall.LOCK_EX|syscall.LOCK_NB, 0); err != syscall.EWOULDBLOCK || calls != 1 {
+			t.Errorf("expected single failed attempt got %d %+v", calls, err)
+		}
+		sysFlock = syscall.Flock
+	}
+}
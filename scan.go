@@ -0,0 +1,102 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !purego
+
+package storage
+
+import (
+	"bytes"
+	"path/filepath"
+	"syscall"
+)
+
+// scanDirectory calls fn for every entry of directory except "." and "..",
+// name is view into scratch buffer valid only until fn returns, scanning
+// stops when fn returns false
+func scanDirectory(absPath string, bufferSize int, fn func(name []byte, kind uint8) bool) (err error) {
+	var n int
+	// malformed dirent must not crash whole process
+	defer recoverInternal("scan", absPath, &err)
+
+	fd, err := openRetrying(filepath.Clean(absPath), syscall.O_RDONLY, 0600)
+	if err != nil {
+		return
+	}
+
+	scratch := getScratch(bufferSize)
+	defer putScratch(scratch)
+	scratchBuffer := *scratch
+
+	for {
+		n, err = syscall.ReadDirent(fd, scratchBuffer)
+		if err != nil {
+			if r := syscall.Close(fd); r != nil {
+				err = r
+			}
+			return
+		}
+		if n <= 0 {
+			break
+		}
+		buf := scratchBuffer[:n]
+		for len(buf) > 0 {
+			// dirent is decoded in place, record never extends past what kernel
+			// filled in so pointer must not be cast to whole syscall.Dirent
+			reclen := int(direntUint16(buf, direntReclenOffset))
+			if reclen <= direntNameOffset || reclen > len(buf) {
+				break
+			}
+			record := buf[:reclen]
+			buf = buf[reclen:]
+
+			if direntIno(record) == 0 {
+				continue
+			}
+
+			nameSlice := record[direntNameOffset:]
+			if reg := direntNameLen(record); reg < len(nameSlice) {
+				nameSlice = nameSlice[:reg]
+			}
+			if index := bytes.IndexByte(nameSlice, 0); index >= 0 {
+				nameSlice = nameSlice[:index]
+			}
+
+			switch len(nameSlice) {
+			case 0:
+				continue
+			case 1:
+				if nameSlice[0] == '.' {
+					continue
+				}
+			case 2:
+				if nameSlice[0] == '.' && nameSlice[1] == '.' {
+					continue
+				}
+			}
+			if !fn(nameSlice, record[direntTypeOffset]) {
+				if r := syscall.Close(fd); r != nil {
+					err = r
+				}
+				return
+			}
+		}
+	}
+
+	if r := syscall.Close(fd); r != nil {
+		err = r
+	}
+
+	return
+}
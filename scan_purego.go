@@ -0,0 +1,85 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build purego
+
+package storage
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// scanBatch is number of entries read from directory at once
+const scanBatch = 1024
+
+// scanDirectory calls fn for every entry of directory, it is safe
+// implementation selected by purego build tag reading entries with os.File
+// instead of parsing raw dirents, name is view into scratch buffer valid only
+// until fn returns, scanning stops when fn returns false
+func scanDirectory(absPath string, bufferSize int, fn func(name []byte, kind uint8) bool) (err error) {
+	defer recoverInternal("scan", absPath, &err)
+
+	dir, err := os.Open(filepath.Clean(absPath))
+	if err != nil {
+		return
+	}
+	defer func() {
+		if r := dir.Close(); r != nil && err == nil {
+			err = r
+		}
+	}()
+
+	var name []byte
+	for {
+		entries, readErr := dir.ReadDir(scanBatch)
+		for _, entry := range entries {
+			name = append(name[:0], entry.Name()...)
+			if !fn(name, direntKind(entry.Type())) {
+				return nil
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+// direntKind returns dirent type of entry with given type bits
+func direntKind(mode fs.FileMode) uint8 {
+	switch {
+	case mode.IsRegular():
+		return syscall.DT_REG
+	case mode&fs.ModeDir != 0:
+		return syscall.DT_DIR
+	case mode&fs.ModeSymlink != 0:
+		return syscall.DT_LNK
+	case mode&fs.ModeNamedPipe != 0:
+		return syscall.DT_FIFO
+	case mode&fs.ModeSocket != 0:
+		return syscall.DT_SOCK
+	case mode&fs.ModeCharDevice != 0:
+		return syscall.DT_CHR
+	case mode&fs.ModeDevice != 0:
+		return syscall.DT_BLK
+	default:
+		return syscall.DT_UNKNOWN
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Sequence hands out increasing numbers persisted in file of storage, numbers
+// are reserved in batches so file is rewritten once per batch, numbers
+// reserved but not handed out before crash or restart are skipped and never
+// reused so sequence may have gaps, storages unable to replace file
+// atomically fail with ENOTSUP
+type Sequence struct {
+	storage Storage
+	path    string
+	batch   uint64
+	mutex   sync.Mutex
+	next    uint64
+	limit   uint64
+}
+
+// NewSequence returns sequence persisted in file given path reserving batch
+// numbers at once, zero batch reserves single number per Next
+func NewSequence(storage Storage, path string, batch uint64) *Sequence {
+	if batch == 0 {
+		batch = 1
+	}
+	return &Sequence{
+		storage: storage,
+		path:    path,
+		batch:   batch,
+	}
+}
+
+// Next returns next number of sequence starting at 1, it is safe for
+// concurrent use and processes sharing file get disjoint numbers
+func (sequence *Sequence) Next() (uint64, error) {
+	sequence.mutex.Lock()
+	defer sequence.mutex.Unlock()
+	if sequence.next == sequence.limit {
+		if err := sequence.reserve(); err != nil {
+			return 0, err
+		}
+	}
+	value := sequence.next
+	sequence.next++
+	return value, nil
+}
+
+// reserve persists end of next batch under exclusive lock of lock file
+// beside sequence file, file is replaced atomically so crash leaves either
+// old or new batch end
+func (sequence *Sequence) reserve() error {
+	lock, err := LockFile(sequence.storage, sequence.path+".lock", true)
+	if err == nil {
+		defer lock.Unlock()
+	} else if err != syscall.ENOTSUP {
+		return err
+	}
+	next := uint64(1)
+	data, err := sequence.storage.ReadFileFully(sequence.path)
+	if err == nil {
+		if next, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64); err != nil {
+			return fmt.Errorf("corrupted sequence %s %w", sequence.path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	limit := next + sequence.batch
+	if limit < next {
+		return fmt.Errorf("sequence %s exhausted", sequence.path)
+	}
+	if err = replaceFile(sequence.storage, sequence.path, []byte(strconv.FormatUint(limit, 10))); err != nil {
+		return err
+	}
+	sequence.next, sequence.limit = next, limit
+	return nil
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+func TestSequence(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	plaintext, _ := NewPlaintextStorage(tmpdir + "/plaintext")
+	encrypted, _ := NewEncryptedStorageWithOptions(tmpdir+"/encrypted", getKey(), EncryptionOptions{AEAD: true})
+
+	for name, storage := range map[string]Storage{"plaintext": plaintext, "encrypted": encrypted} {
+		t.Logf("%s starts at 1 and increments", name)
+		{
+			sequence := NewSequence(storage, "sequence/tx", 10)
+			for expected := uint64(1); expected <= 25; expected++ {
+				if value, err := sequence.Next(); err != nil || value != expected {
+					t.Fatalf("%s expected %d got %d %+v", name, expected, value, err)
+				}
+			}
+		}
+
+		t.Logf("%s restart skips unused part of batch", name)
+		{
+			sequence := NewSequence(storage, "sequence/tx", 10)
+			if value, err := sequence.Next(); err != nil || value != 31 {
+				t.Errorf("%s expected 31 got %d %+v", name, value, err)
+			}
+		}
+
+		t.Logf("%s instances sharing file get disjoint numbers", name)
+		{
+			var (
+				wg    sync.WaitGroup
+				mutex sync.Mutex
+				seen  = make(map[uint64]bool)
+			)
+			for i := 0; i < 4; i++ {
+				sequence := NewSequence(storage, "sequence/shared", 3)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 50; j++ {
+						value, err := sequence.Next()
+						if err != nil {
+							t.Errorf("%s unexpected error when calling Next %+v", name, err)
+							return
+						}
+						mutex.Lock()
+						if seen[value] {
+							t.Errorf("%s value %d handed out twice", name, value)
+						}
+						seen[value] = true
+						mutex.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+		}
+	}
+
+	t.Log("corrupted sequence file")
+	{
+		plaintext.WriteFile("sequence/corrupted", []byte("garbage"))
+		if _, err := NewSequence(plaintext, "sequence/corrupted", 1).Next(); err == nil {
+			t.Errorf("expected error for corrupted sequence file")
+		}
+	}
+
+	t.Log("decorators replace file atomically or refuse")
+	{
+		journaled, _ := NewJournaledStorage(plaintext)
+		scoped, _ := Scope(journaled, "scope")
+		traced := NewTracedStorage(scoped, new(recordingTracer))
+		sequence := NewSequence(traced, "sequence/decorated", 1)
+		for i := uint64(1); i <= 3; i++ {
+			if value, err := sequence.Next(); err != nil || value != i {
+				t.Errorf("expected %d got %d %+v", i, value, err)
+			}
+		}
+		if data, _ := plaintext.ReadFileFully("scope/sequence/decorated"); string(data) != "4" {
+			t.Errorf("expected persisted limit 4 got %q", string(data))
+		}
+		compressed, _ := NewCompressedStorage(plaintext, CompressionOptions{})
+		if _, err := NewSequence(compressed, "sequence/compressed", 1).Next(); err != syscall.ENOTSUP {
+			t.Errorf("expected ENOTSUP got %+v", err)
+		}
+	}
+}
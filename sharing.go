@@ -0,0 +1,231 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrRootShared is returned when root is already opened by another instance
+// with incompatible options
+var ErrRootShared = errors.New("root shared with incompatible instance")
+
+// instancesDirectory is directory in control directory holding lock file of
+// every live instance
+const instancesDirectory = "instances"
+
+// InstanceProfile describes how instance reads and writes files of root
+type InstanceProfile struct {
+	Encrypted bool     `json:"encrypted"`
+	KeyID     string   `json:"keyId,omitempty"`
+	HMAC      bool     `json:"hmac,omitempty"`
+	AEAD      bool     `json:"aead,omitempty"`
+	Chunked   bool     `json:"chunked,omitempty"`
+	Layout    []string `json:"layout,omitempty"`
+}
+
+// InstanceInfo is metadata of instance holding root
+type InstanceInfo struct {
+	PID      int             `json:"pid"`
+	Hostname string          `json:"hostname"`
+	Started  time.Time       `json:"started"`
+	Profile  InstanceProfile `json:"profile"`
+}
+
+// RootClaim is held by instance for as long as it uses root
+type RootClaim struct {
+	file *os.File
+	path string
+}
+
+// profileOf describes encryption and layout of storage decorator chain
+func profileOf(storage Storage) InstanceProfile {
+	var profile InstanceProfile
+	for storage != nil {
+		switch candidate := storage.(type) {
+		case EncryptedStorage:
+			profile.Encrypted = true
+			profile.HMAC = candidate.authenticate
+			profile.AEAD = candidate.aead
+			profile.Chunked = candidate.chunkSize > 0
+			if len(candidate.encryptionKey) > 0 {
+				profile.KeyID = keyID(candidate.encryptionKey)
+			} else {
+				profile.KeyID = candidate.KeyID()
+			}
+		case PackedStorage:
+			profile.Layout = append(profile.Layout, "packed")
+		case TieredStorage:
+			profile.Layout = append(profile.Layout, "tiered")
+		case SignedStorage:
+			profile.Layout = append(profile.Layout, "signed")
+		case ShardedStorage:
+			profile.Layout = append(profile.Layout, "sharded")
+		case CompressedStorage:
+			profile.Layout = append(profile.Layout, "compressed")
+		}
+		decorator, ok := storage.(wrapper)
+		if !ok {
+			break
+		}
+		storage = decorator.unwrap()
+	}
+	sort.Strings(profile.Layout)
+	return profile
+}
+
+// incompatibility returns description of difference between profiles, empty
+// when instances can share root
+func incompatibility(ours InstanceProfile, theirs InstanceProfile) string {
+	switch {
+	case ours.Encrypted != theirs.Encrypted:
+		return fmt.Sprintf("encrypted %v vs %v", ours.Encrypted, theirs.Encrypted)
+	case ours.KeyID != theirs.KeyID:
+		return fmt.Sprintf("encryption key %s vs %s", ours.KeyID, theirs.KeyID)
+	case ours.HMAC != theirs.HMAC:
+		return fmt.Sprintf("hmac %v vs %v", ours.HMAC, theirs.HMAC)
+	case ours.AEAD != theirs.AEAD:
+		return fmt.Sprintf("aead %v vs %v", ours.AEAD, theirs.AEAD)
+	case ours.Chunked != theirs.Chunked:
+		return fmt.Sprintf("chunked %v vs %v", ours.Chunked, theirs.Chunked)
+	case strings.Join(ours.Layout, ",") != strings.Join(theirs.Layout, ","):
+		return fmt.Sprintf("layout [%s] vs [%s]", strings.Join(ours.Layout, ","), strings.Join(theirs.Layout, ","))
+	default:
+		return ""
+	}
+}
+
+// lockHeld returns true if lock file is locked by its instance
+func lockHeld(filename string) (bool, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err == syscall.EWOULDBLOCK {
+		return true, nil
+	}
+	return false, err
+}
+
+// liveInstances returns metadata of other instances holding lock of their
+// files, lock files of dead instances are removed
+func liveInstances(dir string, except string) ([]InstanceInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]InstanceInfo, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".lock") || entry.Name() == except {
+			continue
+		}
+		filename := filepath.Join(dir, entry.Name())
+		held, err := lockHeld(filename)
+		if err != nil {
+			continue
+		}
+		if !held {
+			os.Remove(filename)
+			continue
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			continue
+		}
+		var info InstanceInfo
+		if json.Unmarshal(data, &info) == nil {
+			result = append(result, info)
+		}
+	}
+	return result, nil
+}
+
+// ClaimRoot registers storage as instance using its root and fails with
+// ErrRootShared when root is held by live instance with different encryption
+// key, cipher options or layout, claim is held until Release or process exit
+func ClaimRoot(storage Storage) (*RootClaim, error) {
+	root, ok := rootOf(storage)
+	if !ok {
+		return nil, fmt.Errorf("claim requires local storage")
+	}
+	dir := filepath.Join(filepath.Clean(root), ControlDirectory, instancesDirectory)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	info := InstanceInfo{
+		PID:      os.Getpid(),
+		Hostname: hostname,
+		Started:  time.Now(),
+		Profile:  profileOf(storage),
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	name := strconv.Itoa(info.PID) + "-" + strconv.FormatInt(info.Started.UnixNano(), 36)
+	temporary := filepath.Join(dir, name+".tmp")
+	file, err := os.OpenFile(temporary, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	// lock and write metadata before publishing so concurrent claim never sees
+	// lock file unlocked or empty
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_SH); err == nil {
+		_, err = file.Write(data)
+	}
+	if err == nil {
+		err = os.Rename(temporary, filepath.Join(dir, name+".lock"))
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(temporary)
+		return nil, err
+	}
+	claim := &RootClaim{file: file, path: filepath.Join(dir, name+".lock")}
+	others, err := liveInstances(dir, name+".lock")
+	if err != nil {
+		claim.Release()
+		return nil, err
+	}
+	for _, other := range others {
+		if reason := incompatibility(info.Profile, other.Profile); reason != "" {
+			claim.Release()
+			return nil, fmt.Errorf("%w: instance pid %d on %s since %s, %s", ErrRootShared, other.PID, other.Hostname, other.Started.Format(time.RFC3339), reason)
+		}
+	}
+	return claim, nil
+}
+
+// Release gives up claim of root
+func (claim *RootClaim) Release() error {
+	if claim == nil || claim.file == nil {
+		return nil
+	}
+	os.Remove(claim.path)
+	err := claim.file.Close()
+	claim.file = nil
+	return err
+}
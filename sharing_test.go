@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestClaimRoot(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewEncryptedStorage(tmpdir, getKey())
+	first, err := ClaimRoot(storage)
+	if err != nil {
+		t.Fatalf("unexpected error when calling ClaimRoot %+v", err)
+	}
+
+	t.Log("shares root only with same layout")
+	{
+		second, err := ClaimRoot(NewPackedStorage(storage))
+		if err == nil {
+			t.Errorf("expected different layout to be rejected")
+			second.Release()
+		}
+		same, _ := NewEncryptedStorage(tmpdir, getKey())
+		third, err := ClaimRoot(same)
+		if err != nil {
+			t.Fatalf("unexpected error when calling ClaimRoot %+v", err)
+		}
+		third.Release()
+	}
+
+	t.Log("rejects different key")
+	{
+		key := make([]byte, 32)
+		rand.Read(key)
+		other, _ := NewEncryptedStorage(tmpdir, key)
+		if _, err := ClaimRoot(other); !errors.Is(err, ErrRootShared) {
+			t.Errorf("expected ErrRootShared got %+v", err)
+		}
+		plaintext, _ := NewPlaintextStorage(tmpdir)
+		if _, err := ClaimRoot(plaintext); !errors.Is(err, ErrRootShared) {
+			t.Errorf("expected ErrRootShared got %+v", err)
+		}
+	}
+
+	t.Log("released claim frees root")
+	{
+		if err := first.Release(); err != nil {
+			t.Fatalf("unexpected error when calling Release %+v", err)
+		}
+		plaintext, _ := NewPlaintextStorage(tmpdir)
+		claim, err := ClaimRoot(plaintext)
+		if err != nil {
+			t.Fatalf("unexpected error when calling ClaimRoot %+v", err)
+		}
+		claim.Release()
+	}
+
+	t.Log("ignores lock files of dead instances")
+	{
+		os.WriteFile(tmpdir+"/"+ControlDirectory+"/"+instancesDirectory+"/1-dead.lock", []byte(`{"pid":1,"profile":{"encrypted":false}}`), 0600)
+		claim, err := ClaimRoot(storage)
+		if err != nil {
+			t.Fatalf("unexpected error when calling ClaimRoot %+v", err)
+		}
+		claim.Release()
+		if _, err := os.Stat(tmpdir + "/" + ControlDirectory + "/" + instancesDirectory + "/1-dead.lock"); !os.IsNotExist(err) {
+			t.Errorf("expected stale lock file to be removed")
+		}
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// SnapshotDirectory is directory under storage root holding snapshots
+const SnapshotDirectory = ".snapshots"
+
+const (
+	btrfsSuperMagic = 0x9123683e
+	zfsSuperMagic   = 0x2fc12fc1
+)
+
+// Snapshotter creates and restores point in time copies of subtree of
+// storage root identified by id
+type Snapshotter interface {
+	Create(prefix string, id string) error
+	Restore(prefix string, id string) error
+	Delete(prefix string, id string) error
+	List(prefix string) ([]string, error)
+}
+
+// runCommand is indirection allowing tests to observe invoked tools
+var runCommand = defaultRunCommand
+
+func defaultRunCommand(name string, args ...string) ([]byte, error) {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("%s %s failed %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
+// DetectSnapshotter returns snapshotter best suited for filesystem of root,
+// CoW snapshots are used on btrfs and zfs and full copies elsewhere
+func DetectSnapshotter(root string) Snapshotter {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(filepath.Clean(root), &stat); err == nil {
+		switch uint32(stat.Type) {
+		case btrfsSuperMagic:
+			return BtrfsSnapshotter{root: filepath.Clean(root)}
+		case zfsSuperMagic:
+			return ZFSSnapshotter{root: filepath.Clean(root)}
+		}
+	}
+	return CopySnapshotter{root: filepath.Clean(root)}
+}
+
+// NewSnapshotID returns sortable snapshot id for given time
+func NewSnapshotID(at time.Time) string {
+	return at.UTC().Format("20060102T150405.000000000Z")
+}
+
+func listSnapshotDirectories(root string, prefix string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(root, SnapshotDirectory))
+	if os.IsNotExist(err) {
+		return make([]string, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if _, err := os.Lstat(filepath.Join(root, SnapshotDirectory, entry.Name(), prefix)); err == nil {
+			result = append(result, entry.Name())
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// CopySnapshotter snapshots subtree by copying it into snapshot directory,
+// copies are reflinks on filesystems supporting them, hardlinks are not used
+// because files are rewritten in place
+type CopySnapshotter struct {
+	root string
+}
+
+// checkPrefix rejects storage root and internal directories, copy of root
+// would land inside itself
+func (snapshotter CopySnapshotter) checkPrefix(prefix string) error {
+	if prefix = filepath.Clean(prefix); prefix == "." || prefix == "/" || isInternalPath(strings.TrimPrefix(prefix, "/")) {
+		return fmt.Errorf("cannot snapshot %q", prefix)
+	}
+	return nil
+}
+
+// Create copies subtree into .snapshots/<id>/<prefix>
+func (snapshotter CopySnapshotter) Create(prefix string, id string) error {
+	if err := snapshotter.checkPrefix(prefix); err != nil {
+		return err
+	}
+	target := filepath.Join(snapshotter.root, SnapshotDirectory, id, prefix)
+	if _, err := os.Lstat(target); err == nil {
+		return fmt.Errorf("snapshot %s of %s already exists", id, prefix)
+	}
+	return copyDirectory(filepath.Join(snapshotter.root, prefix), target, 8192, nil, 0)
+}
+
+// Restore replaces subtree with its snapshot, snapshot is copied next to
+// subtree first and swapped in with renames so failed copy leaves subtree
+// intact
+func (snapshotter CopySnapshotter) Restore(prefix string, id string) error {
+	if err := snapshotter.checkPrefix(prefix); err != nil {
+		return err
+	}
+	source := filepath.Join(snapshotter.root, SnapshotDirectory, id, prefix)
+	if _, err := os.Lstat(source); err != nil {
+		return err
+	}
+	target := filepath.Join(snapshotter.root, prefix)
+	restored := temporarySibling(target, "restore")
+	if err := copyDirectory(source, restored, 8192, nil, 0); err != nil {
+		os.RemoveAll(restored)
+		return err
+	}
+	replaced := temporarySibling(target, "replaced")
+	if err := os.Rename(target, replaced); err != nil && !os.IsNotExist(err) {
+		os.RemoveAll(restored)
+		return err
+	}
+	if err := os.Rename(restored, target); err != nil {
+		os.Rename(replaced, target)
+		os.RemoveAll(restored)
+		return err
+	}
+	return os.RemoveAll(replaced)
+}
+
+// Delete removes snapshot of subtree
+func (snapshotter CopySnapshotter) Delete(prefix string, id string) error {
+	if err := os.RemoveAll(filepath.Join(snapshotter.root, SnapshotDirectory, id, prefix)); err != nil {
+		return err
+	}
+	// remove snapshot directory once it holds no other prefixes
+	os.Remove(filepath.Join(snapshotter.root, SnapshotDirectory, id))
+	return nil
+}
+
+// List returns ascending ids of snapshots of subtree
+func (snapshotter CopySnapshotter) List(prefix string) ([]string, error) {
+	return listSnapshotDirectories(snapshotter.root, prefix)
+}
+
+// BtrfsSnapshotter snapshots subtree that is btrfs subvolume with instant
+// read-only CoW snapshot
+type BtrfsSnapshotter struct {
+	root string
+}
+
+// Create snapshots subvolume into .snapshots/<id>/<prefix>
+func (snapshotter BtrfsSnapshotter) Create(prefix string, id string) error {
+	target := filepath.Join(snapshotter.root, SnapshotDirectory, id, prefix)
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	_, err := runCommand("btrfs", "subvolume", "snapshot", "-r", filepath.Join(snapshotter.root, prefix), target)
+	return err
+}
+
+// Restore replaces subvolume with writable snapshot of its snapshot
+func (snapshotter BtrfsSnapshotter) Restore(prefix string, id string) error {
+	target := filepath.Join(snapshotter.root, prefix)
+	if _, err := runCommand("btrfs", "subvolume", "delete", target); err != nil {
+		return err
+	}
+	_, err := runCommand("btrfs", "subvolume", "snapshot", filepath.Join(snapshotter.root, SnapshotDirectory, id, prefix), target)
+	return err
+}
+
+// Delete removes snapshot subvolume
+func (snapshotter BtrfsSnapshotter) Delete(prefix string, id string) error {
+	_, err := runCommand("btrfs", "subvolume", "delete", filepath.Join(snapshotter.root, SnapshotDirectory, id, prefix))
+	os.Remove(filepath.Join(snapshotter.root, SnapshotDirectory, id))
+	return err
+}
+
+// List returns ascending ids of snapshots of subtree
+func (snapshotter BtrfsSnapshotter) List(prefix string) ([]string, error) {
+	return listSnapshotDirectories(snapshotter.root, prefix)
+}
+
+// ZFSSnapshotter snapshots dataset mounted at subtree with zfs snapshot
+type ZFSSnapshotter struct {
+	root string
+}
+
+func (snapshotter ZFSSnapshotter) dataset(prefix string) (string, error) {
+	out, err := runCommand("zfs", "list", "-H", "-o", "name", filepath.Join(snapshotter.root, prefix))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Create takes snapshot dataset@id of dataset mounted at subtree
+func (snapshotter ZFSSnapshotter) Create(prefix string, id string) error {
+	dataset, err := snapshotter.dataset(prefix)
+	if err != nil {
+		return err
+	}
+	_, err = runCommand("zfs", "snapshot", dataset+"@"+id)
+	return err
+}
+
+// Restore rolls dataset back to snapshot discarding newer snapshots
+func (snapshotter ZFSSnapshotter) Restore(prefix string, id string) error {
+	dataset, err := snapshotter.dataset(prefix)
+	if err != nil {
+		return err
+	}
+	_, err = runCommand("zfs", "rollback", "-r", dataset+"@"+id)
+	return err
+}
+
+// Delete destroys snapshot of dataset
+func (snapshotter ZFSSnapshotter) Delete(prefix string, id string) error {
+	dataset, err := snapshotter.dataset(prefix)
+	if err != nil {
+		return err
+	}
+	_, err = runCommand("zfs", "destroy", dataset+"@"+id)
+	return err
+}
+
+// List returns ascending ids of snapshots of dataset
+func (snapshotter ZFSSnapshotter) List(prefix string) ([]string, error) {
+	dataset, err := snapshotter.dataset(prefix)
+	if err != nil {
+		return nil, err
+	}
+	out, err := runCommand("zfs", "list", "-H", "-t", "snapshot", "-o", "name", "-d", "1", dataset)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if index := strings.IndexByte(line, '@'); index >= 0 {
+			result = append(result, line[index+1:])
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// Snapshots manages snapshots of subtrees of storage root
+type Snapshots struct {
+	snapshotter Snapshotter
+	root        string
+	barrier     *writeBarrier
+	mutex       sync.Mutex
+	retention   []SnapshotRetention
+	metrics     SnapshotMetrics
+}
+
+// NewSnapshots returns snapshots of given storage taken by given snapshotter,
+// nil snapshotter means detection by filesystem of root
+func NewSnapshots(storage Storage, snapshotter Snapshotter) (*Snapshots, error) {
+	root, ok := rootOf(storage)
+	if !ok {
+		return nil, fmt.Errorf("snapshots require local storage")
+	}
+	if snapshotter == nil {
+		snapshotter = DetectSnapshotter(root)
+	}
+	return &Snapshots{
+		snapshotter: snapshotter,
+		root:        filepath.Clean(root),
+		barrier:     barrierOf(storage),
+	}, nil
+}
+
+// Snapshotter returns snapshotter in use
+func (snapshots *Snapshots) Snapshotter() Snapshotter {
+	return snapshots.snapshotter
+}
+
+// exclusive freezes storage so writers of this process wait until returned
+// func is called, storage already frozen by someone else stays frozen
+func (snapshots *Snapshots) exclusive() func() {
+	if snapshots.barrier == nil || snapshots.barrier.freeze(DefaultMaxFreezeDuration) != nil {
+		return noop
+	}
+	return func() {
+		snapshots.barrier.unfreeze()
+	}
+}
+
+// Snapshot takes consistent point in time snapshot of subtree and returns its
+// id, writers of storage wait until snapshot is taken
+func (snapshots *Snapshots) Snapshot(prefix string) (string, error) {
+	defer snapshots.exclusive()()
+	id := NewSnapshotID(time.Now())
+	if err := snapshots.snapshotter.Create(filepath.Clean(prefix), id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Rollback replaces subtree with snapshot of given id, writers of storage
+// wait until subtree is restored
+func (snapshots *Snapshots) Rollback(prefix string, id string) error {
+	defer snapshots.exclusive()()
+	return snapshots.snapshotter.Restore(filepath.Clean(prefix), id)
+}
+
+// RestoreSnapshot replaces subtree with snapshot of given id, see Rollback
+func (snapshots *Snapshots) RestoreSnapshot(prefix string, id string) error {
+	return snapshots.Rollback(prefix, id)
+}
+
+// DeleteSnapshot removes snapshot of given id
+func (snapshots *Snapshots) DeleteSnapshot(prefix string, id string) error {
+	return snapshots.snapshotter.Delete(filepath.Clean(prefix), id)
+}
+
+// ListSnapshots returns ascending ids of snapshots of subtree
+func (snapshots *Snapshots) ListSnapshots(prefix string) ([]string, error) {
+	return snapshots.snapshotter.List(filepath.Clean(prefix))
+}
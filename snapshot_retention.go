@@ -0,0 +1,166 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SnapshotRetention selects snapshots of subtree kept by PruneSnapshots,
+// snapshot kept by any rule survives, policy without rules keeps everything
+type SnapshotRetention struct {
+	// Prefix is subtree whose snapshots policy applies to
+	Prefix string
+	// KeepLast keeps given number of newest snapshots
+	KeepLast int
+	// KeepDaily keeps newest snapshot of each of given number of most recent
+	// days (UTC)
+	KeepDaily int
+}
+
+// SnapshotMetrics represents space used by snapshots and pruning totals
+type SnapshotMetrics struct {
+	Snapshots   int       `json:"snapshots"`
+	Bytes       int64     `json:"bytes"`
+	Pruned      uint64    `json:"pruned"`
+	PrunedBytes int64     `json:"prunedBytes"`
+	LastPrune   time.Time `json:"lastPrune"`
+}
+
+// snapshotTime returns time snapshot of given id was taken
+func snapshotTime(id string) (time.Time, bool) {
+	at, err := time.Parse("20060102T150405.000000000Z", id)
+	return at, err == nil
+}
+
+// retained returns ids kept by policy, ids are ascending and ids that are
+// not snapshot times are always kept
+func (policy SnapshotRetention) retained(ids []string, now time.Time) map[string]bool {
+	keep := make(map[string]bool, len(ids))
+	if policy.KeepLast <= 0 && policy.KeepDaily <= 0 {
+		for _, id := range ids {
+			keep[id] = true
+		}
+		return keep
+	}
+	for i := len(ids) - 1; i >= 0 && i >= len(ids)-policy.KeepLast; i-- {
+		keep[ids[i]] = true
+	}
+	oldest := now.UTC().Truncate(24*time.Hour).AddDate(0, 0, 1-policy.KeepDaily)
+	days := make(map[string]bool)
+	for i := len(ids) - 1; i >= 0; i-- {
+		at, ok := snapshotTime(ids[i])
+		if !ok {
+			keep[ids[i]] = true
+			continue
+		}
+		if policy.KeepDaily <= 0 || at.Before(oldest) {
+			continue
+		}
+		day := at.Format("2006-01-02")
+		if !days[day] {
+			days[day] = true
+			keep[ids[i]] = true
+		}
+	}
+	return keep
+}
+
+// snapshotBytes returns bytes allocated by snapshot of subtree kept under
+// root, snapshots kept outside of root (zfs) count as zero
+func (snapshots *Snapshots) snapshotBytes(prefix string, id string) int64 {
+	usage, _ := treeUsage(snapshots.root, filepath.Join(snapshots.root, SnapshotDirectory, id, prefix))
+	return usage.Allocated
+}
+
+// AddRetention registers retention policy evaluated by PruneSnapshots
+func (snapshots *Snapshots) AddRetention(policy SnapshotRetention) {
+	snapshots.mutex.Lock()
+	defer snapshots.mutex.Unlock()
+	snapshots.retention = append(snapshots.retention, policy)
+}
+
+// SnapshotMetrics returns space used by snapshots as of last prune and
+// pruning totals
+func (snapshots *Snapshots) SnapshotMetrics() SnapshotMetrics {
+	snapshots.mutex.Lock()
+	defer snapshots.mutex.Unlock()
+	return snapshots.metrics
+}
+
+// PruneSnapshots deletes snapshots not kept by registered retention policies
+// and returns ids of deleted snapshots per prefix
+func (snapshots *Snapshots) PruneSnapshots() (map[string][]string, error) {
+	snapshots.mutex.Lock()
+	policies := append([]SnapshotRetention(nil), snapshots.retention...)
+	snapshots.mutex.Unlock()
+
+	var (
+		now     = time.Now()
+		pruned  = make(map[string][]string)
+		metrics SnapshotMetrics
+		errs    []error
+	)
+	for _, policy := range policies {
+		prefix := filepath.Clean(policy.Prefix)
+		ids, err := snapshots.ListSnapshots(prefix)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		keep := policy.retained(ids, now)
+		for _, id := range ids {
+			size := snapshots.snapshotBytes(prefix, id)
+			if keep[id] {
+				metrics.Snapshots++
+				metrics.Bytes += size
+				continue
+			}
+			if err := snapshots.DeleteSnapshot(prefix, id); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+				metrics.Snapshots++
+				metrics.Bytes += size
+				continue
+			}
+			pruned[prefix] = append(pruned[prefix], id)
+			metrics.Pruned++
+			metrics.PrunedBytes += size
+		}
+	}
+
+	snapshots.mutex.Lock()
+	snapshots.metrics.Snapshots = metrics.Snapshots
+	snapshots.metrics.Bytes = metrics.Bytes
+	snapshots.metrics.Pruned += metrics.Pruned
+	snapshots.metrics.PrunedBytes += metrics.PrunedBytes
+	snapshots.metrics.LastPrune = now
+	snapshots.mutex.Unlock()
+
+	return pruned, errors.Join(errs...)
+}
+
+// Name returns name of pruning as maintenance task
+func (snapshots *Snapshots) Name() string {
+	return "snapshot-retention"
+}
+
+// Run prunes snapshots so snapshots can be registered to Scheduler
+func (snapshots *Snapshots) Run() error {
+	_, err := snapshots.PruneSnapshots()
+	return err
+}
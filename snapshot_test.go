@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCopySnapshots(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	snapshots, err := NewSnapshots(storage, CopySnapshotter{root: tmpdir})
+	if err != nil {
+		t.Fatalf("unexpected error when calling NewSnapshots %+v", err)
+	}
+
+	storage.WriteFile("account/a", []byte("before"))
+
+	id, err := snapshots.Snapshot("account")
+	if err != nil {
+		t.Fatalf("unexpected error when calling Snapshot %+v", err)
+	}
+
+	storage.WriteFile("account/a", []byte("after"))
+	storage.WriteFile("account/b", []byte("new"))
+
+	ids, err := snapshots.ListSnapshots("account")
+	if err != nil || len(ids) != 1 || ids[0] != id {
+		t.Fatalf("expected snapshot %s to be listed got %+v %+v", id, ids, err)
+	}
+
+	if err = snapshots.RestoreSnapshot("account", id); err != nil {
+		t.Fatalf("unexpected error when calling RestoreSnapshot %+v", err)
+	}
+	if data, _ := storage.ReadFileFully("account/a"); string(data) != "before" {
+		t.Errorf("expected restored content before got %s", string(data))
+	}
+	if ok, _ := storage.Exists("account/b"); ok {
+		t.Errorf("expected file created after snapshot to be gone")
+	}
+
+	if err = snapshots.DeleteSnapshot("account", id); err != nil {
+		t.Fatalf("unexpected error when calling DeleteSnapshot %+v", err)
+	}
+	if ids, _ = snapshots.ListSnapshots("account"); len(ids) != 0 {
+		t.Errorf("expected no snapshots got %+v", ids)
+	}
+
+	t.Log("root and internal directories are rejected")
+	{
+		for _, prefix := range []string{"", "/", ".", SnapshotDirectory, TrashDirectory + "/1"} {
+			if _, err = snapshots.Snapshot(prefix); err == nil {
+				t.Errorf("expected error when snapshotting %q", prefix)
+			}
+			if err = snapshots.Rollback(prefix, id); err == nil {
+				t.Errorf("expected error when rolling back %q", prefix)
+			}
+		}
+		if entries, _ := os.ReadDir(tmpdir + "/" + SnapshotDirectory); len(entries) != 0 {
+			t.Errorf("expected no snapshot to be created got %d entries", len(entries))
+		}
+	}
+}
+
+// observedSnapshotter calls during while snapshot is created or restored
+type observedSnapshotter struct {
+	CopySnapshotter
+	during func()
+}
+
+func (snapshotter observedSnapshotter) Create(prefix string, id string) error {
+	snapshotter.during()
+	return snapshotter.CopySnapshotter.Create(prefix, id)
+}
+
+func (snapshotter observedSnapshotter) Restore(prefix string, id string) error {
+	snapshotter.during()
+	return snapshotter.CopySnapshotter.Restore(prefix, id)
+}
+
+func TestSnapshotRollback(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	storage.WriteFile("account/a", []byte("before"))
+
+	written := make(chan struct{})
+	snapshots, _ := NewSnapshots(storage, observedSnapshotter{
+		CopySnapshotter: CopySnapshotter{root: tmpdir},
+		during: func() {
+			go func() {
+				storage.WriteFile("account/a", []byte("concurrent"))
+				written <- struct{}{}
+			}()
+			select {
+			case <-written:
+				t.Errorf("expected writer to wait for snapshot")
+			case <-time.After(50 * time.Millisecond):
+			}
+		},
+	})
+
+	t.Log("snapshot excludes writers")
+	id, err := snapshots.Snapshot("account")
+	if err != nil {
+		t.Fatalf("unexpected error when calling Snapshot %+v", err)
+	}
+	<-written
+	if data, _ := os.ReadFile(tmpdir + "/" + SnapshotDirectory + "/" + id + "/account/a"); string(data) != "before" {
+		t.Errorf("expected snapshot to hold state before writer got %q", data)
+	}
+	if data, _ := storage.ReadFileFully("account/a"); string(data) != "concurrent" {
+		t.Errorf("expected writer to proceed after snapshot got %q", data)
+	}
+
+	t.Log("rollback excludes writers")
+	if err = snapshots.Rollback("account", id); err != nil {
+		t.Fatalf("unexpected error when calling Rollback %+v", err)
+	}
+	<-written
+	if data, _ := storage.ReadFileFully("account/a"); string(data) != "concurrent" {
+		t.Errorf("expected writer waiting for rollback to apply after it got %q", data)
+	}
+	if entries, _ := os.ReadDir(tmpdir); len(entries) != 3 {
+		t.Errorf("expected no leftovers of rollback got %d entries", len(entries))
+	}
+
+	t.Log("missing snapshot leaves subtree intact")
+	if err = snapshots.Rollback("account", "missing"); err == nil {
+		t.Errorf("expected error when rolling back to missing snapshot")
+	}
+	if data, _ := storage.ReadFileFully("account/a"); string(data) != "concurrent" {
+		t.Errorf("expected subtree to stay intact got %q", data)
+	}
+}
+
+func TestZFSSnapshotter(t *testing.T) {
+	var invoked []string
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		invoked = append(invoked, name+" "+strings.Join(args, " "))
+		if args[0] == "list" && args[len(args)-1] == "pool/ledger" {
+			return []byte("pool/ledger@1\npool/ledger@2\n"), nil
+		}
+		return []byte("pool/ledger\n"), nil
+	}
+	defer func() {
+		runCommand = defaultRunCommand
+	}()
+
+	snapshotter := ZFSSnapshotter{root: "/data"}
+	if err := snapshotter.Create("ledger", "3"); err != nil {
+		t.Fatalf("unexpected error when calling Create %+v", err)
+	}
+	if invoked[len(invoked)-1] != "zfs snapshot pool/ledger@3" {
+		t.Errorf("unexpected command %s", invoked[len(invoked)-1])
+	}
+	if err := snapshotter.Restore("ledger", "2"); err != nil {
+		t.Fatalf("unexpected error when calling Restore %+v", err)
+	}
+	if invoked[len(invoked)-1] != "zfs rollback -r pool/ledger@2" {
+		t.Errorf("unexpected command %s", invoked[len(invoked)-1])
+	}
+	ids, err := snapshotter.List("ledger")
+	if err != nil || len(ids) != 2 || ids[0] != "1" || ids[1] != "2" {
+		t.Errorf("expected [1 2] got %+v %+v", ids, err)
+	}
+}
+
+func TestPruneSnapshots(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	snapshotter := CopySnapshotter{root: tmpdir}
+	snapshots, err := NewSnapshots(storage, snapshotter)
+	if err != nil {
+		t.Fatalf("unexpected error when calling NewSnapshots %+v", err)
+	}
+	storage.WriteFile("account/a", []byte("data"))
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	taken := []time.Time{
+		today.AddDate(0, 0, -40),
+		today.AddDate(0, 0, -2).Add(time.Hour),
+		today.AddDate(0, 0, -2).Add(2 * time.Hour),
+		today.AddDate(0, 0, -1).Add(time.Hour),
+		today.Add(time.Second),
+		today.Add(2 * time.Second),
+	}
+	ids := make([]string, len(taken))
+	for i, at := range taken {
+		ids[i] = NewSnapshotID(at)
+		if err = snapshotter.Create("account", ids[i]); err != nil {
+			t.Fatalf("unexpected error when calling Create %+v", err)
+		}
+	}
+
+	t.Log("policy without rules keeps everything")
+	{
+		snapshots.AddRetention(SnapshotRetention{Prefix: "account"})
+		pruned, err := snapshots.PruneSnapshots()
+		if err != nil || len(pruned) != 0 {
+			t.Fatalf("expected nothing pruned got %+v %+v", pruned, err)
+		}
+	}
+
+	t.Log("keeps last and newest of each recent day")
+	{
+		scheduler := NewScheduler(time.Hour)
+		snapshots.AddRetention(SnapshotRetention{Prefix: "account", KeepLast: 1, KeepDaily: 30})
+		snapshots.retention = snapshots.retention[1:]
+		scheduler.Register(snapshots)
+		if err = scheduler.RunOnce(); err != nil {
+			t.Fatalf("unexpected error when calling RunOnce %+v", err)
+		}
+		left, _ := snapshots.ListSnapshots("account")
+		expected := []string{ids[2], ids[3], ids[5]}
+		if strings.Join(left, ",") != strings.Join(expected, ",") {
+			t.Errorf("expected snapshots %+v got %+v", expected, left)
+		}
+		metrics := snapshots.SnapshotMetrics()
+		if metrics.Snapshots != 3 || metrics.Pruned != 3 || metrics.Bytes <= 0 || metrics.PrunedBytes <= 0 {
+			t.Errorf("unexpected metrics %+v", metrics)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// holePuncher is implemented by storages able to deallocate range of file
+type holePuncher interface {
+	PunchHole(path string, offset int64, length int64) error
+}
+
+// PunchHole deallocates range of file so it reads as zeros and releases disk
+// space without changing file size, ENOTSUP is returned by storages (e.g.
+// encrypted where zeroed ciphertext is not zeroed plaintext) and filesystems
+// without support
+func PunchHole(storage Storage, path string, offset int64, length int64) error {
+	if candidate, ok := storage.(holePuncher); ok {
+		return candidate.PunchHole(path, offset, length)
+	}
+	return syscall.ENOTSUP
+}
+
+// PunchHole deallocates range of file so it reads as zeros and releases disk
+// space without changing file size
+func (storage PlaintextStorage) PunchHole(path string, offset int64, length int64) error {
+	if offset < 0 || length < 0 {
+		return syscall.EINVAL
+	}
+	defer storage.barrier.enter()()
+	filename := filepath.Clean(storage.root + "/" + path)
+	fd, err := openRetrying(filename, syscall.O_WRONLY|syscall.O_NONBLOCK, 0600)
+	if err != nil {
+		return err
+	}
+	defer storage.handles.track(filename, "punch")()
+	defer syscall.Close(fd)
+	if err = flock(fd, filename, syscall.LOCK_EX, storage.lockTimeout); err != nil {
+		return err
+	}
+	defer funlock(fd, filename)
+	for {
+		err = punchHole(fd, offset, length)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err == syscall.EOPNOTSUPP {
+		return syscall.ENOTSUP
+	}
+	return err
+}
+
+// copyExtents copies data extents of sparse source found with SEEK_DATA and
+// SEEK_HOLE to same offsets of target and sets target size, holes of source
+// are not written so they stay holes in target
+func copyExtents(source int, target int, size int64, bufferSize int) error {
+	var buf []byte
+	offset := int64(0)
+	for offset < size {
+		data, err := unix.Seek(source, offset, unix.SEEK_DATA)
+		if err == syscall.ENXIO {
+			// only hole remains
+			break
+		}
+		if err != nil {
+			return err
+		}
+		hole, err := unix.Seek(source, data, unix.SEEK_HOLE)
+		if err != nil {
+			return err
+		}
+		if hole > size {
+			hole = size
+		}
+		for data < hole {
+			in, out := data, data
+			n, err := copyFileRange(source, &in, target, &out, int(hole-data), 0)
+			if err != nil || n == 0 {
+				if buf == nil {
+					buf = make([]byte, bufferSize)
+				}
+				chunk := buf
+				if int64(len(chunk)) > hole-data {
+					chunk = chunk[:hole-data]
+				}
+				if n, err = preadFull(source, chunk, data); err != nil {
+					return err
+				}
+				if n == 0 {
+					return syscall.EIO
+				}
+				if err = pwriteFull(target, chunk[:n], data); err != nil {
+					return err
+				}
+			}
+			data += int64(n)
+		}
+		offset = hole
+	}
+	return syscall.Ftruncate(target, size)
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestPunchHoleAndSparseCopy(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	plaintext := storage.(PlaintextStorage)
+	data := bytes.Repeat([]byte("j"), 4<<20)
+	storage.WriteFile("segment", data)
+
+	err = PunchHole(storage, "segment", 1<<20, 2<<20)
+	if err == syscall.ENOTSUP {
+		t.Skip("filesystem does not support hole punching")
+	}
+	if err != nil {
+		t.Fatalf("unexpected error when calling PunchHole %+v", err)
+	}
+	copy(data[1<<20:3<<20], make([]byte, 2<<20))
+
+	t.Log("hole reads as zeros and releases space")
+	{
+		content, _ := storage.ReadFileFully("segment")
+		if !bytes.Equal(content, data) {
+			t.Errorf("expected punched range to read as zeros")
+		}
+		usage, _ := plaintext.DiskUsage("segment")
+		if usage.Bytes != 4<<20 || usage.Allocated > 3<<20 {
+			t.Errorf("expected size kept and space released got %+v", usage)
+		}
+	}
+
+	t.Log("copy keeps holes")
+	{
+		ioctlFileClone = func(destFd int, srcFd int) error {
+			return syscall.EOPNOTSUPP
+		}
+		defer func() {
+			ioctlFileClone = defaultIoctlFileClone
+		}()
+		if err = plaintext.CopyFile("segment", "copy"); err != nil {
+			t.Fatalf("unexpected error when calling CopyFile %+v", err)
+		}
+		content, _ := storage.ReadFileFully("copy")
+		if !bytes.Equal(content, data) {
+			t.Errorf("expected copy to equal source")
+		}
+		usage, _ := plaintext.DiskUsage("copy")
+		if usage.Bytes != 4<<20 || usage.Allocated > 3<<20 {
+			t.Errorf("expected sparse copy got %+v", usage)
+		}
+	}
+
+	t.Log("sparse copy falls back to buffered copy of extents")
+	{
+		ioctlFileClone = func(destFd int, srcFd int) error {
+			return syscall.EOPNOTSUPP
+		}
+		copyFileRange = func(rfd int, roff *int64, wfd int, woff *int64, len int, flags int) (int, error) {
+			return 0, syscall.EXDEV
+		}
+		sendFile = func(outfd int, infd int, offset *int64, count int) (int, error) {
+			return 0, syscall.EINVAL
+		}
+		defer func() {
+			ioctlFileClone = defaultIoctlFileClone
+			copyFileRange = defaultCopyFileRange
+			sendFile = defaultSendFile
+		}()
+		if err = plaintext.CopyFile("segment", "buffered"); err != nil {
+			t.Fatalf("unexpected error when calling CopyFile %+v", err)
+		}
+		content, _ := storage.ReadFileFully("buffered")
+		if !bytes.Equal(content, data) {
+			t.Errorf("expected copy to equal source")
+		}
+		usage, _ := plaintext.DiskUsage("buffered")
+		if usage.Allocated > 3<<20 {
+			t.Errorf("expected sparse copy got %+v", usage)
+		}
+	}
+
+	t.Log("encrypted storage is not supported")
+	{
+		encrypted, _ := NewEncryptedStorage(tmpdir+"/encrypted", getKey())
+		if err := PunchHole(encrypted, "segment", 0, 1); err != syscall.ENOTSUP {
+			t.Errorf("expected ENOTSUP got %+v", err)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// OpenMetricsContentType is content type of exposition written by
+// WriteMetrics
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// latencyBuckets are upper bounds of latency histogram buckets
+var latencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// LatencyHistogram represents distribution of time files were held open by
+// operation, Counts holds number of observations per bucket of
+// latencyBuckets (not cumulative) with last element counting observations
+// above highest bound
+type LatencyHistogram struct {
+	Counts []uint64      `json:"counts"`
+	Count  uint64        `json:"count"`
+	Sum    time.Duration `json:"sum"`
+}
+
+func newLatencyHistogram() *LatencyHistogram {
+	return &LatencyHistogram{
+		Counts: make([]uint64, len(latencyBuckets)+1),
+	}
+}
+
+func (histogram *LatencyHistogram) observe(elapsed time.Duration) {
+	index := sort.Search(len(latencyBuckets), func(i int) bool {
+		return elapsed <= latencyBuckets[i]
+	})
+	histogram.Counts[index]++
+	histogram.Count++
+	histogram.Sum += elapsed
+}
+
+// Stats represents runtime statistics of storage instance
+type Stats struct {
+	OpenHandles      int                         `json:"openHandles"`
+	OldestHandleAge  time.Duration               `json:"oldestHandleAge"`
+	OldestHandlePath string                      `json:"oldestHandlePath,omitempty"`
+	Latency          map[string]LatencyHistogram `json:"latency,omitempty"`
+}
+
+func collectStats(handles *handleRegistry) Stats {
+	result := Stats{}
+	open := handles.list()
+	result.OpenHandles = len(open)
+	if len(open) > 0 {
+		result.OldestHandleAge = open[0].Age
+		result.OldestHandlePath = open[0].Path
+	}
+	result.Latency = handles.latencies()
+	return result
+}
+
+func seconds(value time.Duration) string {
+	return strconv.FormatFloat(value.Seconds(), 'g', -1, 64)
+}
+
+// WriteMetrics renders statistics in OpenMetrics text exposition format
+// (compatible with Prometheus scraping) without any client library
+func (stats Stats) WriteMetrics(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	out.WriteString("# TYPE localfs_open_handles gauge\n")
+	out.WriteString("# HELP localfs_open_handles Files currently open by storage.\n")
+	out.WriteString("localfs_open_handles " + strconv.Itoa(stats.OpenHandles) + "\n")
+	out.WriteString("# TYPE localfs_oldest_handle_age_seconds gauge\n")
+	out.WriteString("# UNIT localfs_oldest_handle_age_seconds seconds\n")
+	out.WriteString("# HELP localfs_oldest_handle_age_seconds Age of longest open file.\n")
+	out.WriteString("localfs_oldest_handle_age_seconds " + seconds(stats.OldestHandleAge) + "\n")
+
+	operations := make([]string, 0, len(stats.Latency))
+	for operation := range stats.Latency {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+	out.WriteString("# TYPE localfs_operation_duration_seconds histogram\n")
+	out.WriteString("# UNIT localfs_operation_duration_seconds seconds\n")
+	out.WriteString("# HELP localfs_operation_duration_seconds Time file was held open by operation.\n")
+	for _, operation := range operations {
+		histogram := stats.Latency[operation]
+		label := "localfs_operation_duration_seconds_bucket{operation=\"" + operation + "\",le=\""
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			if i < len(histogram.Counts) {
+				cumulative += histogram.Counts[i]
+			}
+			out.WriteString(label + seconds(bound) + "\"} " + strconv.FormatUint(cumulative, 10) + "\n")
+		}
+		out.WriteString(label + "+Inf\"} " + strconv.FormatUint(histogram.Count, 10) + "\n")
+		out.WriteString("localfs_operation_duration_seconds_sum{operation=\"" + operation + "\"} " + seconds(histogram.Sum) + "\n")
+		out.WriteString("localfs_operation_duration_seconds_count{operation=\"" + operation + "\"} " + strconv.FormatUint(histogram.Count, 10) + "\n")
+	}
+	out.WriteString("# EOF\n")
+	return out.Flush()
+}
+
+// Stats returns runtime statistics of storage
+func (storage PlaintextStorage) Stats() Stats {
+	return collectStats(storage.handles)
+}
+
+// Stats returns runtime statistics of storage
+func (storage EncryptedStorage) Stats() Stats {
+	return collectStats(storage.handles)
+}
+
+// WriteMetrics renders runtime statistics of storage in OpenMetrics text
+// format
+func (storage PlaintextStorage) WriteMetrics(w io.Writer) error {
+	return storage.Stats().WriteMetrics(w)
+}
+
+// WriteMetrics renders runtime statistics of storage in OpenMetrics text
+// format
+func (storage EncryptedStorage) WriteMetrics(w io.Writer) error {
+	return storage.Stats().WriteMetrics(w)
+}
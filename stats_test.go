@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	plaintext := storage.(PlaintextStorage)
+	storage.WriteFile("foo", []byte("abc"))
+	storage.ReadFileFully("foo")
+	storage.ReadFileFully("foo")
+
+	t.Log("latency is recorded per operation")
+	{
+		stats := plaintext.Stats()
+		if stats.Latency["read"].Count != 2 || stats.Latency["write"].Count != 1 {
+			t.Errorf("unexpected latency histograms %+v", stats.Latency)
+		}
+	}
+
+	t.Log("exposition")
+	{
+		var out bytes.Buffer
+		if err = plaintext.WriteMetrics(&out); err != nil {
+			t.Fatalf("unexpected error when calling WriteMetrics %+v", err)
+		}
+		text := out.String()
+		for _, line := range []string{
+			"# TYPE localfs_open_handles gauge\n",
+			"localfs_open_handles 0\n",
+			"# TYPE localfs_operation_duration_seconds histogram\n",
+			"localfs_operation_duration_seconds_bucket{operation=\"read\",le=\"+Inf\"} 2\n",
+			"localfs_operation_duration_seconds_count{operation=\"write\"} 1\n",
+		} {
+			if !strings.Contains(text, line) {
+				t.Errorf("expected exposition to contain %q got %s", line, text)
+			}
+		}
+		if !strings.HasSuffix(text, "# EOF\n") {
+			t.Errorf("expected exposition to end with EOF marker")
+		}
+	}
+
+	t.Log("buckets are cumulative")
+	{
+		histogram := newLatencyHistogram()
+		histogram.observe(50 * time.Microsecond)
+		histogram.observe(3 * time.Millisecond)
+		histogram.observe(time.Minute)
+		var out bytes.Buffer
+		Stats{Latency: map[string]LatencyHistogram{"read": *histogram}}.WriteMetrics(&out)
+		text := out.String()
+		for _, line := range []string{
+			"le=\"0.0001\"} 1\n",
+			"le=\"0.005\"} 2\n",
+			"le=\"10\"} 2\n",
+			"le=\"+Inf\"} 3\n",
+			"localfs_operation_duration_seconds_sum{operation=\"read\"} 60.00305\n",
+		} {
+			if !strings.Contains(text, line) {
+				t.Errorf("expected exposition to contain %q got %s", line, text)
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrNoBirthTime is returned when filesystem does not record creation time
+var ErrNoBirthTime = errors.New("birth time not supported by filesystem")
+
+// FileTimes represents modification and creation time of file, Birth is zero
+// when filesystem does not record it
+type FileTimes struct {
+	Modified time.Time `json:"modified"`
+	Birth    time.Time `json:"birth"`
+}
+
+// fileMeta is metadata of file fetched by statx or fstatat
+type fileMeta struct {
+	mode     uint16
+	size     int64
+	modified time.Time
+	birth    time.Time
+}
+
+// statBatch fetches metadata of files relative to root opening every parent
+// directory once so path is not resolved from root for every file, errors
+// are reported per file
+func statBatch(root string, paths []string, mask int) ([]fileMeta, []error) {
+	result := make([]fileMeta, len(paths))
+	errs := make([]error, len(paths))
+	byDir := make(map[string][]int)
+	for i, path := range paths {
+		dir := filepath.Dir(filepath.Clean(root + "/" + path))
+		byDir[dir] = append(byDir[dir], i)
+	}
+	for dir, indices := range byDir {
+		dirfd, err := unix.Open(dir, unix.O_RDONLY|unix.O_DIRECTORY|oPath, 0)
+		if err != nil {
+			for _, i := range indices {
+				errs[i] = err
+			}
+			continue
+		}
+		for _, i := range indices {
+			result[i], errs[i] = statAt(dirfd, filepath.Base(filepath.Clean("/"+paths[i])), mask)
+		}
+		unix.Close(dirfd)
+	}
+	return result, errs
+}
+
+func birthTime(absPath string) (time.Time, error) {
+	meta, err := statAt(unix.AT_FDCWD, filepath.Clean(absPath), statxBtime)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if meta.birth.IsZero() {
+		return time.Time{}, ErrNoBirthTime
+	}
+	return meta.birth, nil
+}
+
+func fileTimes(root string, paths []string) ([]FileTimes, error) {
+	metas, errs := statBatch(root, paths, statxMtime|statxBtime)
+	result := make([]FileTimes, len(paths))
+	for i := range metas {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		result[i] = FileTimes{Modified: metas[i].modified, Birth: metas[i].birth}
+	}
+	return result, nil
+}
+
+// BirthTime returns time file was created, ErrNoBirthTime when filesystem
+// does not record it
+func (storage PlaintextStorage) BirthTime(path string) (time.Time, error) {
+	return birthTime(storage.root + "/" + path)
+}
+
+// BirthTime returns time file was created, ErrNoBirthTime when filesystem
+// does not record it
+func (storage EncryptedStorage) BirthTime(path string) (time.Time, error) {
+	return birthTime(storage.root + "/" + path)
+}
+
+// FileTimes returns modification and creation times of given files fetched
+// in batch, it is meant for reporting jobs touching many files
+func (storage PlaintextStorage) FileTimes(paths []string) ([]FileTimes, error) {
+	return fileTimes(storage.root, paths)
+}
+
+// FileTimes returns modification and creation times of given files fetched
+// in batch, it is meant for reporting jobs touching many files
+func (storage EncryptedStorage) FileTimes(paths []string) ([]FileTimes, error) {
+	return fileTimes(storage.root, paths)
+}
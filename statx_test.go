@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFileTimes(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	plaintext := storage.(PlaintextStorage)
+	storage.WriteFile("a/one", []byte("1"))
+	storage.WriteFile("a/two", []byte("2"))
+	storage.WriteFile("b/three", []byte("3"))
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(tmpdir+"/a/two", old, old)
+
+	t.Log("birth time")
+	{
+		born, err := plaintext.BirthTime("a/one")
+		if err != ErrNoBirthTime {
+			if err != nil {
+				t.Fatalf("unexpected error when calling BirthTime %+v", err)
+			}
+			modified, _ := storage.LastModification("a/one")
+			if born.After(modified) {
+				t.Errorf("expected birth %v not after modification %v", born, modified)
+			}
+		}
+	}
+
+	t.Log("batch")
+	{
+		times, err := plaintext.FileTimes([]string{"a/one", "b/three", "a/two"})
+		if err != nil {
+			t.Fatalf("unexpected error when calling FileTimes %+v", err)
+		}
+		if len(times) != 3 || !times[2].Modified.Equal(old) {
+			t.Errorf("unexpected file times %+v", times)
+		}
+		for i, path := range []string{"a/one", "b/three"} {
+			modified, _ := storage.LastModification(path)
+			if !times[i].Modified.Equal(modified) {
+				t.Errorf("expected %s modified at %v got %v", path, modified, times[i].Modified)
+			}
+		}
+	}
+
+	t.Log("missing file")
+	{
+		if _, err := plaintext.FileTimes([]string{"a/one", "missing/file"}); !os.IsNotExist(err) {
+			t.Errorf("expected not exist error got %+v", err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+//go:build !purego
+
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+)
+
+// allocation budgets of directory scans, they must not depend on number of
+// entries, safe scan selected by purego build tag does not meet them
+const (
+	existsAllocBudget       = 2
+	countFilesAllocBudget   = 2
+	forEachEntryAllocBudget = 2
+	// scratch buffers are pooled so scans do not allocate bufferSize bytes
+	scanBytesBudget = 1024
+)
+
+func TestHotPathAllocations(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	plaintext := storage.(PlaintextStorage)
+	for i := 0; i < 500; i++ {
+		if err = storage.TouchFile(fmt.Sprintf("dir/%010d", i)); err != nil {
+			t.Fatalf("unexpected error when calling TouchFile %+v", err)
+		}
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		plaintext.Exists("dir/0000000001")
+	})
+	if allocs > existsAllocBudget {
+		t.Errorf("Exists allocates %v times per run, budget is %d", allocs, existsAllocBudget)
+	}
+
+	allocs = testing.AllocsPerRun(100, func() {
+		plaintext.CountFiles("dir")
+	})
+	if allocs > countFilesAllocBudget {
+		t.Errorf("CountFiles allocates %v times per run, budget is %d", allocs, countFilesAllocBudget)
+	}
+
+	entries := 0
+	visit := func(name string) bool {
+		entries++
+		return true
+	}
+	allocs = testing.AllocsPerRun(100, func() {
+		plaintext.ForEachEntry("dir", visit)
+	})
+	if allocs > forEachEntryAllocBudget {
+		t.Errorf("ForEachEntry allocates %v times per run, budget is %d", allocs, forEachEntryAllocBudget)
+	}
+	if entries != 500*101 {
+		t.Errorf("expected to visit %d entries got %d instead", 500*101, entries)
+	}
+
+	if raceEnabled {
+		// pooled scratch buffers are dropped at random under race detector
+		return
+	}
+
+	var before, after runtime.MemStats
+	plaintext.CountFiles("dir")
+	runtime.ReadMemStats(&before)
+	for i := 0; i < 100; i++ {
+		plaintext.CountFiles("dir")
+		plaintext.ListDirectory("", true)
+	}
+	runtime.ReadMemStats(&after)
+	if perRun := (after.TotalAlloc - before.TotalAlloc) / 100; perRun > scanBytesBudget {
+		t.Errorf("directory scans allocate %d bytes per run, budget is %d", perRun, scanBytesBudget)
+	}
+}
+
+func TestListDirectoryFilteredAllocations(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+
+	t.Log("rejected names are not allocated")
+	{
+		for i := 0; i < 500; i++ {
+			storage.TouchFile(fmt.Sprintf("many/%010d", i))
+		}
+		storage.TouchFile("many/x")
+		plaintext := storage.(PlaintextStorage)
+		match := func(name string) bool {
+			return name[0] == 'x'
+		}
+		allocs := testing.AllocsPerRun(100, func() {
+			plaintext.ListDirectoryFiltered("many", match)
+		})
+		if allocs > 8 {
+			t.Errorf("ListDirectoryFiltered allocates %v times per run", allocs)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chargebackFile is file in control directory holding persisted usage
+const chargebackFile = "chargeback.json"
+
+// ChargebackUsage represents I/O of tenant in calendar month (UTC)
+type ChargebackUsage struct {
+	Month        string `json:"month"`
+	Tenant       string `json:"tenant"`
+	Reads        uint64 `json:"reads"`
+	Writes       uint64 `json:"writes"`
+	BytesRead    uint64 `json:"bytesRead"`
+	BytesWritten uint64 `json:"bytesWritten"`
+}
+
+type chargebackKey struct {
+	month  string
+	tenant string
+}
+
+type chargebackLedger struct {
+	sync.Mutex
+	filename string
+	usage    map[chargebackKey]*ChargebackUsage
+	dirty    bool
+	done     chan struct{}
+	stopped  sync.WaitGroup
+}
+
+// ChargebackStorage is a fascade accounting bytes read and written per
+// tenant, usage is persisted in control directory so it survives restarts
+type ChargebackStorage struct {
+	Storage
+	tenant func(path string) string
+	ledger *chargebackLedger
+}
+
+// TenantOf returns first segment of path, which is tenant directory in usual
+// layout
+func TenantOf(path string) string {
+	path = strings.TrimPrefix(filepath.Clean("/"+path), "/")
+	if index := strings.IndexByte(path, '/'); index >= 0 {
+		return path[:index]
+	}
+	return path
+}
+
+// NewChargebackStorage returns storage accounting I/O per tenant given by
+// tenant func (TenantOf when nil), usage is persisted every interval and on
+// Close
+func NewChargebackStorage(underlying Storage, tenant func(path string) string, interval time.Duration) (Storage, error) {
+	root, ok := rootOf(underlying)
+	if !ok {
+		return NilStorage{}, fmt.Errorf("chargeback requires local storage")
+	}
+	if tenant == nil {
+		tenant = TenantOf
+	}
+	ledger := &chargebackLedger{
+		filename: filepath.Join(filepath.Clean(root), ControlDirectory, chargebackFile),
+		usage:    make(map[chargebackKey]*ChargebackUsage),
+		done:     make(chan struct{}),
+	}
+	if err := ledger.load(); err != nil {
+		return NilStorage{}, err
+	}
+	if interval > 0 {
+		ledger.stopped.Add(1)
+		go ledger.loop(interval)
+	}
+	return ChargebackStorage{
+		Storage: underlying,
+		tenant:  tenant,
+		ledger:  ledger,
+	}, nil
+}
+
+func (storage ChargebackStorage) unwrap() Storage {
+	return storage.Storage
+}
+
+func (ledger *chargebackLedger) load() error {
+	data, err := os.ReadFile(ledger.filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var usage []ChargebackUsage
+	if err = json.Unmarshal(data, &usage); err != nil {
+		return err
+	}
+	for i := range usage {
+		ledger.usage[chargebackKey{month: usage[i].Month, tenant: usage[i].Tenant}] = &usage[i]
+	}
+	return nil
+}
+
+func (ledger *chargebackLedger) loop(interval time.Duration) {
+	defer ledger.stopped.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ledger.done:
+			return
+		case <-ticker.C:
+			ledger.flush()
+		}
+	}
+}
+
+func (ledger *chargebackLedger) record(tenant string, read int, written int) {
+	key := chargebackKey{month: time.Now().UTC().Format("2006-01"), tenant: tenant}
+	ledger.Lock()
+	defer ledger.Unlock()
+	usage, ok := ledger.usage[key]
+	if !ok {
+		usage = &ChargebackUsage{Month: key.month, Tenant: tenant}
+		ledger.usage[key] = usage
+	}
+	if read >= 0 {
+		usage.Reads++
+		usage.BytesRead += uint64(read)
+	}
+	if written >= 0 {
+		usage.Writes++
+		usage.BytesWritten += uint64(written)
+	}
+	ledger.dirty = true
+}
+
+// snapshot returns copy of usage sorted by month and tenant
+func (ledger *chargebackLedger) snapshot() []ChargebackUsage {
+	result := make([]ChargebackUsage, 0, len(ledger.usage))
+	for _, usage := range ledger.usage {
+		result = append(result, *usage)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Month != result[j].Month {
+			return result[i].Month < result[j].Month
+		}
+		return result[i].Tenant < result[j].Tenant
+	})
+	return result
+}
+
+// flush persists usage atomically when it changed since last flush
+func (ledger *chargebackLedger) flush() error {
+	ledger.Lock()
+	if !ledger.dirty {
+		ledger.Unlock()
+		return nil
+	}
+	usage := ledger.snapshot()
+	ledger.dirty = false
+	ledger.Unlock()
+
+	data, err := json.Marshal(usage)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(ledger.filename), os.ModePerm)
+	}
+	if err == nil {
+		err = writeSynced(ledger.filename+".tmp", data)
+	}
+	if err == nil {
+		err = os.Rename(ledger.filename+".tmp", ledger.filename)
+	}
+	if err != nil {
+		ledger.Lock()
+		ledger.dirty = true
+		ledger.Unlock()
+	}
+	return err
+}
+
+// Flush persists accounted usage
+func (storage ChargebackStorage) Flush() error {
+	return storage.ledger.flush()
+}
+
+// Close stops periodic persistence and persists accounted usage
+func (storage ChargebackStorage) Close() error {
+	storage.ledger.Lock()
+	select {
+	case <-storage.ledger.done:
+	default:
+		close(storage.ledger.done)
+	}
+	storage.ledger.Unlock()
+	storage.ledger.stopped.Wait()
+	return storage.ledger.flush()
+}
+
+// Chargeback returns monthly usage of tenants for months between from and to
+// inclusive, ordered by month and tenant
+func (storage ChargebackStorage) Chargeback(from time.Time, to time.Time) []ChargebackUsage {
+	first, last := from.UTC().Format("2006-01"), to.UTC().Format("2006-01")
+	storage.ledger.Lock()
+	usage := storage.ledger.snapshot()
+	storage.ledger.Unlock()
+	result := usage[:0]
+	for _, entry := range usage {
+		if entry.Month >= first && entry.Month <= last {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// ReadFileFully reads whole file accounting bytes read
+func (storage ChargebackStorage) ReadFileFully(path string) ([]byte, error) {
+	data, err := storage.Storage.ReadFileFully(path)
+	if err == nil {
+		storage.ledger.record(storage.tenant(path), len(data), -1)
+	}
+	return data, err
+}
+
+// GetFileReader returns reader of file accounting bytes read when it is
+// closed
+func (storage ChargebackStorage) GetFileReader(path string) (io.ReadCloser, error) {
+	reader, err := storage.Storage.GetFileReader(path)
+	if err != nil {
+		return nil, err
+	}
+	return &chargedReader{
+		ReadCloser: reader,
+		record: func(read int) {
+			storage.ledger.record(storage.tenant(path), read, -1)
+		},
+	}, nil
+}
+
+// WriteFileExclusive writes data accounting bytes written
+func (storage ChargebackStorage) WriteFileExclusive(path string, data []byte) error {
+	err := storage.Storage.WriteFileExclusive(path, data)
+	if err == nil {
+		storage.ledger.record(storage.tenant(path), -1, len(data))
+	}
+	return err
+}
+
+// WriteFile writes data accounting bytes written
+func (storage ChargebackStorage) WriteFile(path string, data []byte) error {
+	err := storage.Storage.WriteFile(path, data)
+	if err == nil {
+		storage.ledger.record(storage.tenant(path), -1, len(data))
+	}
+	return err
+}
+
+// AppendFile appends data accounting bytes written
+func (storage ChargebackStorage) AppendFile(path string, data []byte) error {
+	err := storage.Storage.AppendFile(path, data)
+	if err == nil {
+		storage.ledger.record(storage.tenant(path), -1, len(data))
+	}
+	return err
+}
+
+// chargedReader counts bytes read and records them once closed
+type chargedReader struct {
+	io.ReadCloser
+	read   int
+	record func(read int)
+}
+
+func (reader *chargedReader) Read(p []byte) (int, error) {
+	n, err := reader.ReadCloser.Read(p)
+	reader.read += n
+	return n, err
+}
+
+func (reader *chargedReader) Close() error {
+	if reader.record != nil {
+		reader.record(reader.read)
+		reader.record = nil
+	}
+	return reader.ReadCloser.Close()
+}
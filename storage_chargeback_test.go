@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestChargebackStorage(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	underlying, _ := NewPlaintextStorage(tmpdir)
+	storage, err := NewChargebackStorage(underlying, nil, 0)
+	if err != nil {
+		t.Fatalf("unexpected error when calling NewChargebackStorage %+v", err)
+	}
+
+	storage.WriteFile("t_alpha/account/A", []byte("12345"))
+	storage.AppendFile("t_alpha/account/A", []byte("678"))
+	storage.ReadFileFully("t_alpha/account/A")
+	storage.WriteFile("t_beta/account/B", []byte("12"))
+	storage.ReadFileFully("t_beta/account/missing")
+
+	now := time.Now()
+
+	t.Log("accounts bytes per tenant")
+	{
+		report := storage.(ChargebackStorage).Chargeback(now, now)
+		if len(report) != 2 {
+			t.Fatalf("expected two tenants got %+v", report)
+		}
+		alpha, beta := report[0], report[1]
+		if alpha.Tenant != "t_alpha" || alpha.Writes != 2 || alpha.BytesWritten != 8 || alpha.Reads != 1 || alpha.BytesRead != 8 {
+			t.Errorf("unexpected usage of alpha %+v", alpha)
+		}
+		if beta.Tenant != "t_beta" || beta.Writes != 1 || beta.BytesWritten != 2 || beta.Reads != 0 {
+			t.Errorf("unexpected usage of beta %+v", beta)
+		}
+		if alpha.Month != now.UTC().Format("2006-01") {
+			t.Errorf("expected current month got %s", alpha.Month)
+		}
+		if len(storage.(ChargebackStorage).Chargeback(now.AddDate(0, -2, 0), now.AddDate(0, -1, 0))) != 0 {
+			t.Errorf("expected no usage in previous months")
+		}
+	}
+
+	t.Log("persists usage across restarts")
+	{
+		if err := storage.(ChargebackStorage).Close(); err != nil {
+			t.Fatalf("unexpected error when calling Close %+v", err)
+		}
+		reopened, err := NewChargebackStorage(underlying, nil, time.Hour)
+		if err != nil {
+			t.Fatalf("unexpected error when calling NewChargebackStorage %+v", err)
+		}
+		defer reopened.(ChargebackStorage).Close()
+		reopened.WriteFile("t_beta/account/B", []byte("34"))
+		report := reopened.(ChargebackStorage).Chargeback(now, now)
+		if len(report) != 2 || report[1].Writes != 2 || report[1].BytesWritten != 4 {
+			t.Errorf("unexpected usage after restart %+v", report)
+		}
+	}
+}
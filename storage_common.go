@@ -15,146 +15,139 @@
 package storage
 
 import (
-	"bytes"
 	"os"
 	"path/filepath"
-	"reflect"
-	"sort"
+	"sync"
 	"syscall"
 	"time"
 	"unsafe"
 )
 
-func listDirectory(absPath string, bufferSize int, ascending bool) (result []string, err error) {
-	var (
-		n  int
-		de *syscall.Dirent
-	)
+// rooted is implemented by storages backed by local directory
+type rooted interface {
+	rootDir() string
+}
 
-	fd, err := syscall.Open(filepath.Clean(absPath), syscall.O_RDONLY, 0600)
-	if err != nil {
-		return
+// wrapper is implemented by fascades decorating other storage
+type wrapper interface {
+	unwrap() Storage
+}
+
+// rootOf returns local root directory of storage looking through decorators
+func rootOf(storage Storage) (string, bool) {
+	for storage != nil {
+		if local, ok := storage.(rooted); ok {
+			return local.rootDir(), true
+		}
+		decorator, ok := storage.(wrapper)
+		if !ok {
+			break
+		}
+		storage = decorator.unwrap()
 	}
+	return "", false
+}
 
-	result = make([]string, 0)
-	scratchBuffer := make([]byte, bufferSize)
+// barriered is implemented by storages with write barrier
+type barriered interface {
+	writeBarrier() *writeBarrier
+}
 
-	for {
-		n, err = syscall.ReadDirent(fd, scratchBuffer)
-		if err != nil {
-			if r := syscall.Close(fd); r != nil {
-				err = r
-			}
-			return
+// barrierOf returns write barrier of local storage looking through
+// decorators
+func barrierOf(storage Storage) *writeBarrier {
+	for storage != nil {
+		if local, ok := storage.(barriered); ok {
+			return local.writeBarrier()
 		}
-		if n <= 0 {
+		decorator, ok := storage.(wrapper)
+		if !ok {
 			break
 		}
-		buf := scratchBuffer[:n]
-		for len(buf) > 0 {
-			de = (*syscall.Dirent)(unsafe.Pointer(&buf[0]))
-			buf = buf[de.Reclen:]
-
-			if de.Ino == 0 {
-				continue
-			}
-
-			reg := int(uint64(de.Reclen) - uint64(unsafe.Offsetof(syscall.Dirent{}.Name)))
-
-			var nameSlice []byte
-			header := (*reflect.SliceHeader)(unsafe.Pointer(&nameSlice))
-			header.Cap = reg
-			header.Len = reg
-			header.Data = uintptr(unsafe.Pointer(&de.Name[0]))
-
-			if index := bytes.IndexByte(nameSlice, 0); index >= 0 {
-				header.Cap = index
-				header.Len = index
-			}
-
-			switch len(nameSlice) {
-			case 0:
-				continue
-			case 1:
-				if nameSlice[0] == '.' {
-					continue
-				}
-			case 2:
-				if nameSlice[0] == '.' && nameSlice[1] == '.' {
-					continue
-				}
-			}
-			result = append(result, string(nameSlice))
-		}
+		storage = decorator.unwrap()
 	}
+	return nil
+}
 
-	if r := syscall.Close(fd); r != nil {
-		err = r
-		return
+// scratchPools holds *sync.Pool of scratch buffers per buffer size so hot
+// directory scans do not churn allocator
+var scratchPools sync.Map
+
+// getScratch returns pooled scratch buffer of given size
+func getScratch(size int) *[]byte {
+	pool, ok := scratchPools.Load(size)
+	if !ok {
+		pool, _ = scratchPools.LoadOrStore(size, &sync.Pool{
+			New: func() interface{} {
+				buffer := make([]byte, size)
+				return &buffer
+			},
+		})
 	}
+	return pool.(*sync.Pool).Get().(*[]byte)
+}
 
-	if ascending {
-		sort.Slice(result, func(i, j int) bool {
-			return result[i] < result[j]
-		})
-	} else {
-		sort.Slice(result, func(i, j int) bool {
-			return result[i] > result[j]
-		})
+// putScratch returns scratch buffer to pool of its size
+func putScratch(buffer *[]byte) {
+	if pool, ok := scratchPools.Load(len(*buffer)); ok {
+		pool.(*sync.Pool).Put(buffer)
 	}
+}
 
+func listDirectory(absPath string, bufferSize int, mode SortMode, ascending bool) (result []string, err error) {
+	result = make([]string, 0)
+	err = scanDirectory(absPath, bufferSize, func(name []byte, kind uint8) bool {
+		result = append(result, string(name))
+		return true
+	})
+	if err != nil {
+		return
+	}
+	sortNames(result, mode, ascending)
 	return
 }
 
-func countFiles(absPath string, bufferSize int) (result int, err error) {
-	var (
-		n  int
-		de *syscall.Dirent
-	)
-
-	fd, err := syscall.Open(filepath.Clean(absPath), syscall.O_RDONLY, 0600)
+// listDirectoryFiltered returns ascending names accepted by match, name
+// passed to match is valid only until it returns so only accepted names are
+// allocated
+func listDirectoryFiltered(absPath string, bufferSize int, match func(name string) bool) (result []string, err error) {
+	result = make([]string, 0)
+	err = scanDirectory(absPath, bufferSize, func(name []byte, kind uint8) bool {
+		if match(unsafe.String(&name[0], len(name))) {
+			result = append(result, string(name))
+		}
+		return true
+	})
 	if err != nil {
 		return
 	}
+	sortNames(result, SortLexicographic, true)
+	return
+}
 
-	scratchBuffer := make([]byte, bufferSize)
-
-	for {
-		n, err = syscall.ReadDirent(fd, scratchBuffer)
-		if err != nil {
-			if r := syscall.Close(fd); r != nil {
-				err = r
-			}
-			return
-		}
-		if n <= 0 {
-			break
-		}
-		buf := scratchBuffer[:n]
-		for len(buf) > 0 {
-			de = (*syscall.Dirent)(unsafe.Pointer(&buf[0]))
-			buf = buf[de.Reclen:]
-			if de.Ino == 0 || de.Type != syscall.DT_REG {
-				continue
-			}
+func countFiles(absPath string, bufferSize int) (result int, err error) {
+	err = scanDirectory(absPath, bufferSize, func(name []byte, kind uint8) bool {
+		if kind == syscall.DT_REG {
 			result++
 		}
-	}
-
-	if r := syscall.Close(fd); r != nil {
-		err = r
-	}
-
+		return true
+	})
 	return
 }
 
+func forEachEntry(absPath string, bufferSize int, fn func(name string) bool) error {
+	return scanDirectory(absPath, bufferSize, func(name []byte, kind uint8) bool {
+		return fn(unsafe.String(&name[0], len(name)))
+	})
+}
+
 func nodeExists(absPath string) (bool, error) {
 	var (
-		trusted = new(syscall.Stat_t)
+		trusted syscall.Stat_t
 		cleaned = filepath.Clean(absPath)
 		err     error
 	)
-	err = syscall.Stat(cleaned, trusted)
+	err = syscall.Stat(cleaned, &trusted)
 	if err == nil {
 		return true, nil
 	}
@@ -174,7 +167,15 @@ func modTime(absPath string) (time.Time, error) {
 	if err != nil {
 		return time.Now(), err
 	}
-	return time.Unix(int64(trusted.Mtim.Sec), int64(trusted.Mtim.Nsec)), nil
+	return statMtime(trusted), nil
+}
+
+func fileSize(absPath string) (int64, error) {
+	var trusted syscall.Stat_t
+	if err := syscall.Stat(filepath.Clean(absPath), &trusted); err != nil {
+		return 0, err
+	}
+	return trusted.Size, nil
 }
 
 func mkdir(absPath string) error {
@@ -199,3 +200,32 @@ func chmod(absPath string, mod os.FileMode) error {
 	cleanedPath := filepath.Clean(absPath)
 	return os.Chmod(cleanedPath, mod)
 }
+
+// openFile opens file with O_DIRECT when direct is requested and O_NOATIME
+// when noAtime is requested, O_DIRECT is dropped on filesystems without
+// direct IO support and O_NOATIME for files not owned by process or on
+// platforms without it, returns whether descriptor bypasses page cache
+func openFile(filename string, flags int, mode uint32, direct bool, noAtime bool) (int, bool, error) {
+	if noAtime {
+		flags |= oNoAtime
+	}
+	for {
+		var (
+			fd  int
+			err error
+		)
+		if direct {
+			fd, err = openDirect(filename, flags, mode)
+		} else {
+			fd, err = openRetrying(filename, flags, mode)
+		}
+		switch {
+		case err == syscall.EPERM && oNoAtime != 0 && flags&oNoAtime != 0:
+			flags &^= oNoAtime
+		case err == syscall.EINVAL && direct:
+			direct = false
+		default:
+			return fd, direct && err == nil, err
+		}
+	}
+}
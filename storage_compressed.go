@@ -0,0 +1,331 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressionMagic starts header of compressed file, it is followed by byte
+// of algorithm
+const compressionMagic = "LFZ\x01"
+
+// compressionHeaderSize is length of magic and algorithm
+const compressionHeaderSize = len(compressionMagic) + 1
+
+// algorithm bytes of compressed file
+const (
+	compressionGzip = 'g'
+	compressionZstd = 'z'
+)
+
+// zstdDictMagic starts dictionary trained with "zstd --train"
+const zstdDictMagic = "\x37\xa4\x30\xec"
+
+// CompressionAlgorithm selects compression of CompressedStorage
+type CompressionAlgorithm int
+
+const (
+	// CompressionGzip compresses with gzip
+	CompressionGzip CompressionAlgorithm = iota
+	// CompressionZstd compresses with zstd, which is faster and compresses
+	// snapshots better than gzip
+	CompressionZstd
+)
+
+// CompressionOptions customizes CompressedStorage
+type CompressionOptions struct {
+	// Algorithm of newly written files, files are decompressed with algorithm
+	// they were written with
+	Algorithm CompressionAlgorithm
+	// Level is compression level of algorithm, zero means its default, gzip
+	// takes 1 to 9, zstd takes levels 1 to 22 of reference implementation
+	Level int
+	// Dictionary primes zstd with content typical for stored files, either
+	// dictionary trained with "zstd --train" or raw sample content, files
+	// written with dictionary can be read only with the same dictionary
+	Dictionary []byte
+}
+
+// CompressedStorage is a fascade compressing files on write and
+// decompressing them on read, files without compression header written
+// before storage was compressed are read as they are, appends are
+// compressed as separate gzip members or zstd frames so they never rewrite
+// file
+type CompressedStorage struct {
+	Storage
+	algorithm byte
+	level     int
+	encoder   *zstd.Encoder
+	decoder   *zstd.Decoder
+	dict      []zstd.DOption
+}
+
+// NewCompressedStorage returns storage compressing files of underlying
+// storage
+func NewCompressedStorage(underlying Storage, options CompressionOptions) (Storage, error) {
+	switch options.Algorithm {
+	case CompressionGzip:
+		level := options.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+			return NilStorage{}, fmt.Errorf("invalid gzip compression level %d", options.Level)
+		}
+		if len(options.Dictionary) > 0 {
+			return NilStorage{}, fmt.Errorf("dictionary is supported only by zstd")
+		}
+		return CompressedStorage{
+			Storage:   underlying,
+			algorithm: compressionGzip,
+			level:     level,
+		}, nil
+	case CompressionZstd:
+		level := options.Level
+		if level == 0 {
+			level = 3
+		}
+		if level < 1 || level > 22 {
+			return NilStorage{}, fmt.Errorf("invalid zstd compression level %d", options.Level)
+		}
+		encoding := []zstd.EOption{zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level))}
+		var decoding []zstd.DOption
+		if len(options.Dictionary) > 0 {
+			if bytes.HasPrefix(options.Dictionary, []byte(zstdDictMagic)) {
+				encoding = append(encoding, zstd.WithEncoderDict(options.Dictionary))
+				decoding = append(decoding, zstd.WithDecoderDicts(options.Dictionary))
+			} else {
+				// raw dictionary is identified by its checksum, zero is reserved
+				id := crc32.ChecksumIEEE(options.Dictionary) | 1
+				encoding = append(encoding, zstd.WithEncoderDictRaw(id, options.Dictionary))
+				decoding = append(decoding, zstd.WithDecoderDictRaw(id, options.Dictionary))
+			}
+		}
+		encoder, err := zstd.NewWriter(nil, encoding...)
+		if err != nil {
+			return NilStorage{}, err
+		}
+		decoder, err := zstd.NewReader(nil, append(decoding, zstd.WithDecoderConcurrency(0))...)
+		if err != nil {
+			return NilStorage{}, err
+		}
+		return CompressedStorage{
+			Storage:   underlying,
+			algorithm: compressionZstd,
+			level:     level,
+			encoder:   encoder,
+			decoder:   decoder,
+			dict:      decoding,
+		}, nil
+	default:
+		return NilStorage{}, fmt.Errorf("unknown compression algorithm %d", options.Algorithm)
+	}
+}
+
+func (storage CompressedStorage) unwrap() Storage {
+	return storage.Storage
+}
+
+// compress returns data compressed as single member (gzip) or frame (zstd),
+// with header when requested
+func (storage CompressedStorage) compress(data []byte, header bool) ([]byte, error) {
+	var buffer bytes.Buffer
+	if header {
+		buffer.WriteString(compressionMagic)
+		buffer.WriteByte(storage.algorithm)
+	}
+	if storage.algorithm == compressionZstd {
+		return storage.encoder.EncodeAll(data, buffer.Bytes()), nil
+	}
+	writer, err := gzip.NewWriterLevel(&buffer, storage.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// compressed returns true when data starts with compression header
+func compressed(data []byte) bool {
+	return len(data) >= compressionHeaderSize && string(data[:len(compressionMagic)]) == compressionMagic
+}
+
+// zstdReader adapts Close of zstd decoder to io.Closer
+type zstdReader struct {
+	*zstd.Decoder
+}
+
+func (reader zstdReader) Close() error {
+	reader.Decoder.Close()
+	return nil
+}
+
+// decompressor returns reader of decompressed content following header
+func (storage CompressedStorage) decompressor(algorithm byte, r io.Reader) (io.ReadCloser, error) {
+	switch algorithm {
+	case compressionGzip:
+		return gzip.NewReader(r)
+	case compressionZstd:
+		decoder, err := zstd.NewReader(r, storage.dict...)
+		if err != nil {
+			return nil, err
+		}
+		return zstdReader{decoder}, nil
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %q", algorithm)
+	}
+}
+
+// decompress returns content of file, files without header are returned as
+// they are
+func (storage CompressedStorage) decompress(data []byte) ([]byte, error) {
+	if !compressed(data) {
+		return data, nil
+	}
+	algorithm, body := data[len(compressionMagic)], data[compressionHeaderSize:]
+	if algorithm == compressionZstd && storage.decoder != nil {
+		return storage.decoder.DecodeAll(body, nil)
+	}
+	reader, err := storage.decompressor(algorithm, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// decompressedReader closes both decompressor and underlying reader
+type decompressedReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (reader decompressedReader) Close() error {
+	var err error
+	for _, closer := range reader.closers {
+		if e := closer.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// FileSize returns size of decompressed content, compressed file is
+// decompressed to count it
+func (storage CompressedStorage) FileSize(path string) (int64, error) {
+	reader, err := storage.GetFileReader(path)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	return io.Copy(io.Discard, reader)
+}
+
+// ReadFileFully reads and decompresses whole file
+func (storage CompressedStorage) ReadFileFully(path string) ([]byte, error) {
+	data, err := storage.Storage.ReadFileFully(path)
+	if err != nil {
+		return nil, err
+	}
+	return storage.decompress(data)
+}
+
+// GetFileReader returns reader decompressing file as it is read
+func (storage CompressedStorage) GetFileReader(path string) (io.ReadCloser, error) {
+	underlying, err := storage.Storage.GetFileReader(path)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewReader(underlying)
+	header, err := buffered.Peek(compressionHeaderSize)
+	if err != nil && err != io.EOF {
+		underlying.Close()
+		return nil, err
+	}
+	if !compressed(header) {
+		return decompressedReader{Reader: buffered, closers: []io.Closer{underlying}}, nil
+	}
+	algorithm := header[len(compressionMagic)]
+	buffered.Discard(compressionHeaderSize)
+	reader, err := storage.decompressor(algorithm, buffered)
+	if err != nil {
+		underlying.Close()
+		return nil, err
+	}
+	return decompressedReader{Reader: reader, closers: []io.Closer{reader, underlying}}, nil
+}
+
+// TouchFile creates compressed empty file if file does not already exist
+func (storage CompressedStorage) TouchFile(path string) error {
+	return storage.WriteFileExclusive(path, nil)
+}
+
+// WriteFileExclusive compresses data and writes it if file does not already
+// exists
+func (storage CompressedStorage) WriteFileExclusive(path string, data []byte) error {
+	out, err := storage.compress(data, true)
+	if err != nil {
+		return err
+	}
+	return storage.Storage.WriteFileExclusive(path, out)
+}
+
+// WriteFile compresses data and writes it, creates file if it does not exist
+func (storage CompressedStorage) WriteFile(path string, data []byte) error {
+	out, err := storage.compress(data, true)
+	if err != nil {
+		return err
+	}
+	return storage.Storage.WriteFile(path, out)
+}
+
+// AppendFile appends data compressed as new member to compressed file,
+// files without compression header are appended as they are, missing file
+// is created compressed
+func (storage CompressedStorage) AppendFile(path string, data []byte) error {
+	err := storage.WriteFileExclusive(path, data)
+	if !os.IsExist(err) {
+		return err
+	}
+	header, err := ReadFileRange(storage.Storage, path, 0, int64(compressionHeaderSize))
+	if err != nil {
+		return err
+	}
+	if !compressed(header) {
+		return storage.Storage.AppendFile(path, data)
+	}
+	if header[len(compressionMagic)] != storage.algorithm {
+		return fmt.Errorf("unable to append to %s compressed with %q", path, header[len(compressionMagic)])
+	}
+	out, err := storage.compress(data, false)
+	if err != nil {
+		return err
+	}
+	return storage.Storage.AppendFile(path, out)
+}
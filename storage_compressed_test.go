@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCompressedStorage(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	content := bytes.Repeat([]byte(`{"account":"A","balance":100}`), 1000)
+
+	plaintext, _ := NewPlaintextStorage(tmpdir + "/plaintext")
+	encrypted, _ := NewEncryptedStorage(tmpdir+"/encrypted", getKey())
+
+	for name, setup := range map[string]struct {
+		underlying Storage
+		options    CompressionOptions
+	}{
+		"gzip":           {plaintext, CompressionOptions{Level: 9}},
+		"gzip encrypted": {encrypted, CompressionOptions{}},
+		"zstd":           {plaintext, CompressionOptions{Algorithm: CompressionZstd, Level: 19}},
+		"zstd encrypted": {encrypted, CompressionOptions{Algorithm: CompressionZstd, Dictionary: []byte(`{"account":"A"}`)}},
+	} {
+		underlying := setup.underlying
+		storage, err := NewCompressedStorage(underlying, setup.options)
+		if err != nil {
+			t.Fatalf("%s unexpected error when calling NewCompressedStorage %+v", name, err)
+		}
+
+		storage, _ = Scope(storage, name)
+		underlying, _ = Scope(underlying, name)
+
+		t.Logf("%s roundtrip", name)
+		{
+			if err := storage.WriteFile("snapshot", content); err != nil {
+				t.Fatalf("%s unexpected error when calling WriteFile %+v", name, err)
+			}
+			raw, _ := underlying.ReadFileFully("snapshot")
+			if len(raw) >= len(content)/10 {
+				t.Errorf("%s expected compressed file got %d bytes", name, len(raw))
+			}
+			if data, err := storage.ReadFileFully("snapshot"); err != nil || !bytes.Equal(data, content) {
+				t.Errorf("%s expected roundtrip got %d bytes %+v", name, len(data), err)
+			}
+			if size, err := storage.FileSize("snapshot"); err != nil || size != int64(len(content)) {
+				t.Errorf("%s expected size %d got %d %+v", name, len(content), size, err)
+			}
+			reader, err := storage.GetFileReader("snapshot")
+			if err != nil {
+				t.Fatalf("%s unexpected error when calling GetFileReader %+v", name, err)
+			}
+			data, err := io.ReadAll(reader)
+			reader.Close()
+			if err != nil || !bytes.Equal(data, content) {
+				t.Errorf("%s expected streamed roundtrip got %d bytes %+v", name, len(data), err)
+			}
+		}
+
+		t.Logf("%s append", name)
+		{
+			storage.AppendFile("journal", []byte("abc"))
+			storage.AppendFile("journal", []byte("def"))
+			if data, err := storage.ReadFileFully("journal"); err != nil || string(data) != "abcdef" {
+				t.Errorf("%s expected abcdef got %q %+v", name, data, err)
+			}
+			storage.TouchFile("touched")
+			storage.AppendFile("touched", []byte("abc"))
+			if data, err := storage.ReadFileFully("touched"); err != nil || string(data) != "abc" {
+				t.Errorf("%s expected abc got %q %+v", name, data, err)
+			}
+		}
+
+		t.Logf("%s reads files written before compression", name)
+		{
+			underlying.WriteFile("legacy", []byte("uncompressed"))
+			storage.AppendFile("legacy", []byte("!"))
+			if data, err := storage.ReadFileFully("legacy"); err != nil || string(data) != "uncompressed!" {
+				t.Errorf("%s expected legacy content got %q %+v", name, data, err)
+			}
+			reader, _ := storage.GetFileReader("legacy")
+			data, _ := io.ReadAll(reader)
+			reader.Close()
+			if string(data) != "uncompressed!" {
+				t.Errorf("%s expected streamed legacy content got %q", name, data)
+			}
+			underlying.WriteFile("short", []byte("ab"))
+			if data, err := storage.ReadFileFully("short"); err != nil || string(data) != "ab" {
+				t.Errorf("%s expected short legacy content got %q %+v", name, data, err)
+			}
+		}
+	}
+
+	t.Log("zstd dictionary")
+	{
+		dictionary := []byte(`{"account":"A","balance":`)
+		withDictionary, _ := NewCompressedStorage(plaintext, CompressionOptions{Algorithm: CompressionZstd, Dictionary: dictionary})
+		withoutDictionary, _ := NewCompressedStorage(plaintext, CompressionOptions{Algorithm: CompressionZstd})
+		withDictionary.WriteFile("dictionary", content)
+		if data, err := withDictionary.ReadFileFully("dictionary"); err != nil || !bytes.Equal(data, content) {
+			t.Errorf("expected roundtrip with dictionary got %d bytes %+v", len(data), err)
+		}
+		if _, err := withoutDictionary.ReadFileFully("dictionary"); err == nil {
+			t.Errorf("expected error when reading without dictionary")
+		}
+	}
+
+	t.Log("reads files of other algorithm")
+	{
+		gzipped, _ := NewCompressedStorage(plaintext, CompressionOptions{})
+		zstandard, _ := NewCompressedStorage(plaintext, CompressionOptions{Algorithm: CompressionZstd})
+		zstandard.WriteFile("mixed", content)
+		if data, err := gzipped.ReadFileFully("mixed"); err != nil || !bytes.Equal(data, content) {
+			t.Errorf("expected gzip storage to read zstd file got %d bytes %+v", len(data), err)
+		}
+		if err := gzipped.AppendFile("mixed", []byte("!")); err == nil {
+			t.Errorf("expected error when appending with other algorithm")
+		}
+	}
+
+	t.Log("rejects invalid options")
+	{
+		for _, options := range []CompressionOptions{
+			{Level: 42},
+			{Algorithm: CompressionZstd, Level: 23},
+			{Dictionary: []byte("gzip has no dictionary")},
+			{Algorithm: 7},
+		} {
+			if _, err := NewCompressedStorage(nil, options); err == nil {
+				t.Errorf("expected error for %+v", options)
+			}
+		}
+	}
+}
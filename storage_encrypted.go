@@ -15,63 +15,232 @@
 package storage
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 )
 
+// ErrIntegrity is returned when authentication tag of encrypted file does
+// not match its content, either because of corruption or wrong key
+var ErrIntegrity = errors.New("integrity check failed")
+
+// EncryptionOptions customizes EncryptedStorage
+type EncryptionOptions struct {
+	// HMAC appends HMAC-SHA256 over IV and ciphertext on write and verifies
+	// it on read
+	HMAC bool
+	// KeyRing encrypts new files with its newest key and records key id in
+	// file header, files without header are decrypted with key passed to
+	// constructor
+	KeyRing *KeyRing
+	// AEAD encrypts with AES-GCM using relative path of file as associated
+	// data so ciphertext copied or renamed to another path fails
+	// authentication, HMAC is redundant in this mode
+	AEAD bool
+	// DirectIO makes ReadFileFully and WriteFile bypass page cache with
+	// O_DIRECT, see PlaintextOptions
+	DirectIO bool
+	// NoAtime opens files for reading with O_NOATIME, see PlaintextOptions
+	NoAtime bool
+	// LockTimeout bounds how long operations wait for lock, see
+	// PlaintextOptions
+	LockTimeout time.Duration
+	// ChunkSize when positive encrypts new files with AES-GCM in chunks of
+	// given number of plaintext bytes so ReadFileRange and GetSeekableReader
+	// decrypt only chunks covering requested range, every chunk carries own
+	// 16 byte tag and AEAD binds path as well
+	ChunkSize int
+}
+
+// keyHeaderMagic starts header carrying id of key file is encrypted with
+const keyHeaderMagic = "LFK\x01"
+
 // EncryptedStorage is a fascade to access encrypted storage
 type EncryptedStorage struct {
 	Storage
 	root          string
 	bufferSize    int
 	encryptionKey []byte
+	authenticate  bool
+	aead          bool
+	ring          *KeyRing
+	handles       *handleRegistry
+	barrier       *writeBarrier
+	directIO      bool
+	noAtime       bool
+	lockTimeout   time.Duration
+	chunkSize     int
 }
 
 // NewEncryptedStorage returns new storage over given root
 func NewEncryptedStorage(root string, key []byte) (Storage, error) {
+	return NewEncryptedStorageWithOptions(root, key, EncryptionOptions{})
+}
+
+// NewEncryptedStorageWithOptions returns new storage over given root
+// customized by options
+func NewEncryptedStorageWithOptions(root string, key []byte, options EncryptionOptions) (Storage, error) {
 	if root == "" {
 		return NilStorage{}, fmt.Errorf("invalid root directory")
 	}
 	if os.MkdirAll(filepath.Clean(root), os.ModePerm) != nil {
 		return NilStorage{}, fmt.Errorf("unable to assert root storage directory")
 	}
-	if len(key) == 0 {
+	if len(key) == 0 && (options.KeyRing == nil || options.KeyRing.Len() == 0) {
 		return NilStorage{}, fmt.Errorf("no encryption key setup")
 	}
+	if options.ChunkSize < 0 || options.ChunkSize > maxChunkSize {
+		return NilStorage{}, fmt.Errorf("invalid chunk size %d", options.ChunkSize)
+	}
+	if err := ensureFormat(root); err != nil {
+		return NilStorage{}, err
+	}
 	return EncryptedStorage{
 		root:          root,
 		bufferSize:    8192,
 		encryptionKey: key,
+		authenticate:  options.HMAC,
+		aead:          options.AEAD,
+		ring:          options.KeyRing,
+		handles:       newHandleRegistry(),
+		barrier:       newWriteBarrier(),
+		directIO:      options.DirectIO,
+		noAtime:       options.NoAtime,
+		lockTimeout:   options.LockTimeout,
+		chunkSize:     options.ChunkSize,
 	}, nil
 }
 
-func (storage EncryptedStorage) encrypt(data []byte) ([]byte, error) {
-	block, err := aes.NewCipher(storage.encryptionKey)
+// tag authenticates data with key derived from encryption key so encryption
+// key is not reused
+func tag(key []byte, parts ...[]byte) []byte {
+	mac := newTag(key)
+	for _, part := range parts {
+		mac.Write(part)
+	}
+	return mac.Sum(nil)
+}
+
+// newTag returns HMAC keyed with key derived from encryption key
+func newTag(key []byte) hash.Hash {
+	derived := sha256.Sum256(append([]byte("localfs hmac\x00"), key...))
+	return hmac.New(sha256.New, derived[:])
+}
+
+// header returns length of key header of data and key to decrypt it with,
+// data without known key header uses key passed to constructor
+func (storage EncryptedStorage) header(data []byte) (int, string, []byte) {
+	if storage.ring != nil && len(data) > len(keyHeaderMagic) && string(data[:len(keyHeaderMagic)]) == keyHeaderMagic {
+		length := int(data[len(keyHeaderMagic)])
+		end := len(keyHeaderMagic) + 1 + length
+		if end <= len(data) {
+			id := string(data[len(keyHeaderMagic)+1 : end])
+			if key, ok := storage.ring.Key(id); ok {
+				return end, id, key
+			}
+		}
+	}
+	return 0, "", storage.encryptionKey
+}
+
+// associatedData returns relative path of file authenticated in AEAD mode
+func associatedData(path string) []byte {
+	return []byte(strings.TrimPrefix(filepath.Clean("/"+path), "/"))
+}
+
+// writeKey returns id and key new files are encrypted with, newest key of
+// ring when present
+func (storage EncryptedStorage) writeKey() (string, []byte) {
+	if storage.ring != nil {
+		if id, key, ok := storage.ring.Newest(); ok {
+			return id, key
+		}
+	}
+	return "", storage.encryptionKey
+}
+
+// encryptSegments encrypts data into header (key header and IV or nonce),
+// ciphertext and optional HMAC tag so they can be written with single writev
+// without copying into combined buffer
+func (storage EncryptedStorage) encryptSegments(path string, data []byte) ([][]byte, error) {
+	id, key := storage.writeKey()
+	offset := 0
+	if id != "" {
+		offset = len(keyHeaderMagic) + 1 + len(id)
+	}
+	if storage.chunkSize > 0 {
+		return sealChunks(key, id, offset, storage.chunkSize, storage.chunkAD(path), data)
+	}
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
-	ciphertext := make([]byte, aes.BlockSize+len(data))
-	iv := ciphertext[:aes.BlockSize]
+	if storage.aead {
+		return sealAEAD(block, id, offset, path, data)
+	}
+	header := make([]byte, offset+aes.BlockSize)
+	writeKeyHeader(header, id)
+	iv := header[offset:]
 	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
 		return nil, err
 	}
+	ciphertext := make([]byte, len(data))
 	cfb := cipher.NewCFBEncrypter(block, iv)
-	cfb.XORKeyStream(ciphertext[aes.BlockSize:], []byte(data))
-	return ciphertext, nil
+	cfb.XORKeyStream(ciphertext, data)
+	if storage.authenticate {
+		return [][]byte{header, ciphertext, tag(key, header, ciphertext)}, nil
+	}
+	return [][]byte{header, ciphertext}, nil
 }
 
-func (storage EncryptedStorage) decrypt(data []byte) ([]byte, error) {
-	block, err := aes.NewCipher(storage.encryptionKey)
+// encrypt returns encrypted data as single buffer
+func (storage EncryptedStorage) encrypt(path string, data []byte) ([]byte, error) {
+	segments, err := storage.encryptSegments(path, data)
 	if err != nil {
 		return nil, err
 	}
+	return bytes.Join(segments, nil), nil
+}
+
+func (storage EncryptedStorage) decrypt(path string, data []byte) ([]byte, error) {
+	offset, _, key := storage.header(data)
+	layout, ok, err := parseChunkLayout(data, offset, int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return openChunks(key, layout, data, storage.chunkAD(path))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if storage.aead {
+		return openAEAD(block, data[offset:], path)
+	}
+	if storage.authenticate {
+		if len(data) < offset+aes.BlockSize+sha256.Size {
+			return nil, ErrIntegrity
+		}
+		body, mac := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+		if !hmac.Equal(mac, tag(key, body)) {
+			return nil, ErrIntegrity
+		}
+		data = body
+	}
+	data = data[offset:]
 	if len(data) < aes.BlockSize {
 		return nil, fmt.Errorf("invalid blocksize expected %d but actual is %d", aes.BlockSize, len(data))
 	}
@@ -84,15 +253,105 @@ func (storage EncryptedStorage) decrypt(data []byte) ([]byte, error) {
 	return plaintext, nil
 }
 
+func writeKeyHeader(ciphertext []byte, id string) {
+	if id == "" {
+		return
+	}
+	copy(ciphertext, keyHeaderMagic)
+	ciphertext[len(keyHeaderMagic)] = byte(len(id))
+	copy(ciphertext[len(keyHeaderMagic)+1:], id)
+}
+
+// sealAEAD encrypts data into header with key header and nonce and AES-GCM
+// ciphertext authenticating path
+func sealAEAD(block cipher.Block, id string, offset int, path string, data []byte) ([][]byte, error) {
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, offset+gcm.NonceSize())
+	writeKeyHeader(header, id)
+	nonce := header[offset:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return [][]byte{header, gcm.Seal(nil, nonce, data, associatedData(path))}, nil
+}
+
+// openAEAD decrypts nonce and AES-GCM ciphertext failing with ErrIntegrity
+// when content or path does not match
+func openAEAD(block cipher.Block, data []byte, path string) ([]byte, error) {
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, ErrIntegrity
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], associatedData(path))
+	if err != nil {
+		return nil, ErrIntegrity
+	}
+	return plaintext, nil
+}
+
+func (storage EncryptedStorage) rootDir() string {
+	return storage.root
+}
+
+func (storage EncryptedStorage) writeBarrier() *writeBarrier {
+	return storage.barrier
+}
+
 // Chmod sets chmod flag on given file
 func (storage EncryptedStorage) Chmod(path string, mod os.FileMode) error {
+	defer storage.barrier.enter()()
 	return chmod(storage.root+"/"+path, mod)
 }
 
 // ListDirectory returns sorted slice of item names in given absolute path
 // default sorting is ascending
 func (storage EncryptedStorage) ListDirectory(path string, ascending bool) ([]string, error) {
-	return listDirectory(storage.root+"/"+path, storage.bufferSize, ascending)
+	names, err := listDirectory(storage.root+"/"+path, storage.bufferSize, SortLexicographic, ascending)
+	return hideControl(path, names, err)
+}
+
+// ListDirectorySorted returns slice of item names in given path ordered by
+// given sort mode
+func (storage EncryptedStorage) ListDirectorySorted(path string, mode SortMode, ascending bool) ([]string, error) {
+	names, err := listDirectory(storage.root+"/"+path, storage.bufferSize, mode, ascending)
+	return hideControl(path, names, err)
+}
+
+// ListDirectoryUnsorted returns slice of item names in given path in kernel
+// readdir order, which is unspecified and not stable across modifications
+func (storage EncryptedStorage) ListDirectoryUnsorted(path string) ([]string, error) {
+	names, err := listDirectory(storage.root+"/"+path, storage.bufferSize, SortNone, true)
+	return hideControl(path, names, err)
+}
+
+// ListDirectoryFiltered returns ascending slice of item names in given path
+// accepted by match, names are filtered during scan and match must not
+// retain name
+func (storage EncryptedStorage) ListDirectoryFiltered(path string, match func(name string) bool) ([]string, error) {
+	if visible := visibleEntry(path); visible != nil {
+		return listDirectoryFiltered(storage.root+"/"+path, storage.bufferSize, func(name string) bool {
+			return visible(name) && match(name)
+		})
+	}
+	return listDirectoryFiltered(storage.root+"/"+path, storage.bufferSize, match)
+}
+
+// ForEachEntry calls fn for every item in given path in kernel order without
+// allocating, name is valid only until fn returns, iteration stops when fn
+// returns false
+func (storage EncryptedStorage) ForEachEntry(path string, fn func(name string) bool) error {
+	if visible := visibleEntry(path); visible != nil {
+		return forEachEntry(storage.root+"/"+path, storage.bufferSize, func(name string) bool {
+			return !visible(name) || fn(name)
+		})
+	}
+	return forEachEntry(storage.root+"/"+path, storage.bufferSize, fn)
 }
 
 // CountFiles returns number of items in directory
@@ -110,149 +369,232 @@ func (storage EncryptedStorage) LastModification(path string) (time.Time, error)
 	return modTime(storage.root + "/" + path)
 }
 
+// overhead returns number of bytes ciphertext of file starting with given
+// prefix has on top of plaintext
+func (storage EncryptedStorage) overhead(prefix []byte) int64 {
+	offset, _, _ := storage.header(prefix)
+	if storage.aead {
+		// 12 bytes of nonce and 16 bytes of GCM tag
+		return int64(offset + 12 + 16)
+	}
+	if storage.authenticate {
+		return int64(offset + aes.BlockSize + sha256.Size)
+	}
+	return int64(offset + aes.BlockSize)
+}
+
+// FileSize returns size of plaintext of file in bytes, only key header of
+// file is read, empty file created by TouchFile has zero size
+func (storage EncryptedStorage) FileSize(path string) (int64, error) {
+	filename := filepath.Clean(storage.root + "/" + path)
+	fd, _, err := openFile(filename, syscall.O_RDONLY|syscall.O_NONBLOCK, 0600, false, storage.noAtime)
+	if err != nil {
+		return 0, err
+	}
+	defer syscall.Close(fd)
+	var fs syscall.Stat_t
+	if err = syscall.Fstat(fd, &fs); err != nil {
+		return 0, err
+	}
+	if fs.Size == 0 {
+		return 0, nil
+	}
+	prefix := make([]byte, len(keyHeaderMagic)+256+chunkHeaderSize)
+	n, err := syscall.Pread(fd, prefix, 0)
+	if err != nil {
+		return 0, err
+	}
+	offset, _, _ := storage.header(prefix[:n])
+	layout, ok, err := parseChunkLayout(prefix[:n], offset, fs.Size)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		return layout.size, nil
+	}
+	size := fs.Size - storage.overhead(prefix[:n])
+	if size < 0 {
+		return 0, ErrIntegrity
+	}
+	return size, nil
+}
+
 // TouchFile creates file given absolute path if file does not already exist
 func (storage EncryptedStorage) TouchFile(path string) error {
+	defer storage.barrier.enter()()
 	return touch(storage.root + "/" + path)
 }
 
 // Mkdir creates directory given absolute path
 func (storage EncryptedStorage) Mkdir(path string) error {
+	defer storage.barrier.enter()()
 	return mkdir(storage.root + "/" + path)
 }
 
 // Delete removes given absolute path if that file does exists
 func (storage EncryptedStorage) Delete(path string) error {
+	defer storage.barrier.enter()()
 	return os.RemoveAll(filepath.Clean(storage.root + "/" + path))
 }
 
 // ReadFileFully reads whole file given path
 func (storage EncryptedStorage) ReadFileFully(path string) ([]byte, error) {
 	filename := filepath.Clean(storage.root + "/" + path)
-	fd, err := syscall.Open(filename, syscall.O_RDONLY|syscall.O_NONBLOCK, 0600)
+	fd, direct, err := openFile(filename, syscall.O_RDONLY|syscall.O_NONBLOCK, 0600, storage.directIO, storage.noAtime)
 	if err != nil {
 		return nil, err
 	}
+	defer storage.handles.track(filename, "read")()
 	defer syscall.Close(fd)
-	if err = syscall.Flock(fd, syscall.LOCK_EX); err != nil {
+	if err = flock(fd, filename, syscall.LOCK_EX, storage.lockTimeout); err != nil {
 		return nil, err
 	}
-	defer syscall.Flock(fd, syscall.LOCK_UN)
+	defer funlock(fd, filename)
 	var fs syscall.Stat_t
 	if err = syscall.Fstat(fd, &fs); err != nil {
 		return nil, err
 	}
+	if direct {
+		buf, err := readDirect(fd, fs.Size)
+		if err != nil {
+			return nil, err
+		}
+		return storage.decrypt(path, buf)
+	}
 	buf := make([]byte, fs.Size)
-	_, err = syscall.Read(fd, buf)
-	if err != nil && err != io.EOF {
+	n, err := readFull(fd, buf)
+	if err != nil {
 		return nil, err
 	}
 	// FIXME inline
-	return storage.decrypt(buf)
+	return storage.decrypt(path, buf[:n])
+}
+
+// GetFileReader returns reader of plaintext of file given path, file in
+// chunked format is decrypted chunk by chunk as it is read, other files are
+// decrypted and authenticated whole before reader is returned
+func (storage EncryptedStorage) GetFileReader(path string) (io.ReadCloser, error) {
+	return storage.GetSeekableReader(path)
 }
 
 // WriteFileExclusive writes data given path to a file if that file does not
 // already exists
 func (storage EncryptedStorage) WriteFileExclusive(path string, data []byte) error {
+	defer storage.barrier.enter()()
 	filename := filepath.Clean(storage.root + "/" + path)
+	defer lockPath(filename).Unlock()
 	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
 		return err
 	}
 	// FIXME inline
-	out, err := storage.encrypt(data)
+	out, err := storage.encryptSegments(path, data)
 	if err != nil {
 		return err
 	}
-	fd, err := syscall.Open(filename, syscall.O_CREAT|syscall.O_WRONLY|syscall.O_EXCL|syscall.O_NONBLOCK, 0600)
+	fd, err := openRetrying(filename, syscall.O_CREAT|syscall.O_WRONLY|syscall.O_EXCL|syscall.O_NONBLOCK, 0600)
 	if err != nil {
 		return err
 	}
+	defer storage.handles.track(filename, "exclusive")()
 	defer func() {
 		syscall.Close(fd)
 		syscall.Fsync(fd)
 	}()
-	if err = syscall.Flock(fd, syscall.LOCK_EX); err != nil {
+	if err = flock(fd, filename, syscall.LOCK_EX, storage.lockTimeout); err != nil {
 		return err
 	}
-	defer syscall.Flock(fd, syscall.LOCK_UN)
-	if _, err := syscall.Write(fd, out); err != nil {
-		return err
-	}
-	return nil
+	defer funlock(fd, filename)
+	return writevFull(fd, out)
 }
 
 // WriteFile writes data given absolute path to a file, creates it if it does
 // not exist
 func (storage EncryptedStorage) WriteFile(path string, data []byte) error {
+	defer storage.barrier.enter()()
 	filename := filepath.Clean(storage.root + "/" + path)
+	defer lockPath(filename).Unlock()
 	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
 		return err
 	}
 	// FIXME inline
-	out, err := storage.encrypt(data)
+	out, err := storage.encryptSegments(path, data)
 	if err != nil {
 		return err
 	}
-	fd, err := syscall.Open(filename, syscall.O_CREAT|syscall.O_WRONLY|syscall.O_TRUNC|syscall.O_NONBLOCK, 0600)
+	fd, direct, err := openFile(filename, syscall.O_CREAT|syscall.O_WRONLY|syscall.O_NONBLOCK, 0600, storage.directIO, false)
 	if err != nil {
 		return err
 	}
+	defer storage.handles.track(filename, "write")()
 	defer func() {
 		syscall.Close(fd)
 		syscall.Fsync(fd)
 	}()
-	if err = syscall.Flock(fd, syscall.LOCK_EX); err != nil {
+	if err = flock(fd, filename, syscall.LOCK_EX, storage.lockTimeout); err != nil {
 		return err
 	}
-	defer syscall.Flock(fd, syscall.LOCK_UN)
-	if _, err := syscall.Write(fd, out); err != nil {
+	defer funlock(fd, filename)
+	// truncate under lock so mapped readers never see file shrink
+	if err = syscall.Ftruncate(fd, 0); err != nil {
 		return err
 	}
-	return nil
+	if direct {
+		return writeDirect(fd, out)
+	}
+	return writevFull(fd, out)
 }
 
 // AppendFile appens data given absolute path to a file, creates it if it does
 // not exist
 func (storage EncryptedStorage) AppendFile(path string, data []byte) error {
+	defer storage.barrier.enter()()
 	filename := filepath.Clean(storage.root + "/" + path)
+	defer lockPath(filename).Unlock()
 	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
 		return err
 	}
-	fd, err := syscall.Open(filename, syscall.O_CREAT|syscall.O_WRONLY|syscall.O_TRUNC|syscall.O_NONBLOCK, 0600)
+	fd, err := openRetrying(filename, syscall.O_CREAT|syscall.O_RDWR|syscall.O_NONBLOCK, 0600)
 	if err != nil {
 		return err
 	}
+	defer storage.handles.track(filename, "append")()
 	defer func() {
 		syscall.Close(fd)
 		syscall.Fsync(fd)
 	}()
-	if err = syscall.Flock(fd, syscall.LOCK_EX); err != nil {
+	if err = flock(fd, filename, syscall.LOCK_EX, storage.lockTimeout); err != nil {
 		return err
 	}
-	defer syscall.Flock(fd, syscall.LOCK_UN)
+	defer funlock(fd, filename)
 	var fs syscall.Stat_t
 	if err = syscall.Fstat(fd, &fs); err != nil {
 		return err
 	}
 	buf := make([]byte, fs.Size)
-	_, err = syscall.Read(fd, buf)
-	if err != nil && err != io.EOF {
-		return err
-	}
-	// FIXME inline
-	head, err := storage.decrypt(buf)
+	n, err := readFull(fd, buf)
 	if err != nil {
 		return err
 	}
-	var tail = make([]byte, len(head)+1)
+	var head []byte
+	if n > 0 {
+		// FIXME inline
+		if head, err = storage.decrypt(path, buf[:n]); err != nil {
+			return err
+		}
+	}
+	tail := make([]byte, 0, len(head)+len(data))
 	tail = append(tail, head...)
 	tail = append(tail, data...)
 	// FIXME inline
-	out, err := storage.encrypt(tail)
+	out, err := storage.encryptSegments(path, tail)
 	if err != nil {
 		return err
 	}
-	if _, err := syscall.Write(fd, out); err != nil {
+	if err = syscall.Ftruncate(fd, 0); err != nil {
+		return err
+	}
+	if _, err = syscall.Seek(fd, 0, io.SeekStart); err != nil {
 		return err
 	}
-	return nil
+	return writevFull(fd, out)
 }
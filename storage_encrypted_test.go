@@ -1,9 +1,11 @@
 package storage
 
 import (
+	"crypto/aes"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -87,6 +89,136 @@ func TestReadFileFullyEncrypted(t *testing.T) {
 	}
 }
 
+func TestHMACEncrypted(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewEncryptedStorageWithOptions(tmpdir, getKey(), EncryptionOptions{HMAC: true})
+
+	if err := storage.WriteFile("file", []byte("ledger")); err != nil {
+		t.Fatalf("unexpected error when calling WriteFile %+v", err)
+	}
+	data, err := storage.ReadFileFully("file")
+	if err != nil || string(data) != "ledger" {
+		t.Fatalf("expected ledger got %s %+v", string(data), err)
+	}
+
+	t.Log("detects bit rot")
+	{
+		raw, _ := os.ReadFile(tmpdir + "/file")
+		raw[aes.BlockSize] ^= 0x01
+		os.WriteFile(tmpdir+"/file", raw, 0600)
+		if _, err := storage.ReadFileFully("file"); err != ErrIntegrity {
+			t.Errorf("expected ErrIntegrity got %+v", err)
+		}
+	}
+
+	t.Log("detects wrong key")
+	{
+		storage.WriteFile("file", []byte("ledger"))
+		key := make([]byte, 32)
+		rand.Read(key)
+		other, _ := NewEncryptedStorageWithOptions(tmpdir, key, EncryptionOptions{HMAC: true})
+		if _, err := other.ReadFileFully("file"); err != ErrIntegrity {
+			t.Errorf("expected ErrIntegrity got %+v", err)
+		}
+	}
+}
+
+func TestAEADEncrypted(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewEncryptedStorageWithOptions(tmpdir, getKey(), EncryptionOptions{AEAD: true})
+
+	if err := storage.WriteFile("account/A", []byte("balance 100")); err != nil {
+		t.Fatalf("unexpected error when calling WriteFile %+v", err)
+	}
+	storage.WriteFile("account/B", []byte("balance 0"))
+	data, err := storage.ReadFileFully("/account//A")
+	if err != nil || string(data) != "balance 100" {
+		t.Fatalf("expected balance 100 got %s %+v", string(data), err)
+	}
+
+	t.Log("rejects ciphertext swapped to another path")
+	{
+		raw, _ := os.ReadFile(tmpdir + "/account/A")
+		os.WriteFile(tmpdir+"/account/B", raw, 0600)
+		if _, err := storage.ReadFileFully("account/B"); err != ErrIntegrity {
+			t.Errorf("expected ErrIntegrity got %+v", err)
+		}
+	}
+
+	t.Log("detects bit rot")
+	{
+		raw, _ := os.ReadFile(tmpdir + "/account/A")
+		raw[len(raw)-1] ^= 0x01
+		os.WriteFile(tmpdir+"/account/A", raw, 0600)
+		if _, err := storage.ReadFileFully("account/A"); err != ErrIntegrity {
+			t.Errorf("expected ErrIntegrity got %+v", err)
+		}
+	}
+}
+
+func TestKeyRingEncrypted(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	legacy, _ := NewEncryptedStorage(tmpdir, getKey())
+	legacy.WriteFile("legacy", []byte("old"))
+
+	ring := NewKeyRing()
+	first := make([]byte, 32)
+	rand.Read(first)
+	ring.AddKey("2023-01", first)
+
+	storage, err := NewEncryptedStorageWithOptions(tmpdir, getKey(), EncryptionOptions{KeyRing: ring, HMAC: true})
+	if err != nil {
+		t.Fatalf("unexpected error when calling NewEncryptedStorageWithOptions %+v", err)
+	}
+	storage.WriteFile("first", []byte("one"))
+
+	second := make([]byte, 32)
+	rand.Read(second)
+	ring.AddKey("2023-06", second)
+	storage.WriteFile("second", []byte("two"))
+
+	t.Log("reads files of every key")
+	{
+		for path, expected := range map[string]string{"first": "one", "second": "two"} {
+			data, err := storage.ReadFileFully(path)
+			if err != nil || string(data) != expected {
+				t.Errorf("expected %s got %s %+v", expected, string(data), err)
+			}
+		}
+		plain, _ := NewEncryptedStorageWithOptions(tmpdir, getKey(), EncryptionOptions{KeyRing: ring})
+		if data, err := plain.ReadFileFully("legacy"); err != nil || string(data) != "old" {
+			t.Errorf("expected legacy file to be readable got %s %+v", string(data), err)
+		}
+	}
+
+	t.Log("records key id in header")
+	{
+		inspection, _ := storage.(EncryptedStorage).Inspect("first")
+		if inspection.KeyID != "2023-01" || inspection.PayloadSize != 3 {
+			t.Errorf("expected key 2023-01 and payload 3 got %+v", inspection)
+		}
+		inspection, _ = storage.(EncryptedStorage).Inspect("second")
+		if inspection.KeyID != "2023-06" {
+			t.Errorf("expected key 2023-06 got %+v", inspection)
+		}
+	}
+}
+
 func TestListDirectoryEncrypted(t *testing.T) {
 	tmpDir := os.TempDir()
 
@@ -327,3 +459,85 @@ func BenchmarkReadFileFullyEncrypted(b *testing.B) {
 		storage.ReadFileFully(basePath)
 	}
 }
+
+func TestFileSizeEncrypted(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	ring := NewKeyRing()
+	ring.AddKey("2023-01", getKey())
+	for name, options := range map[string]EncryptionOptions{
+		"cfb":     {},
+		"hmac":    {HMAC: true},
+		"aead":    {AEAD: true},
+		"keyring": {KeyRing: ring, HMAC: true},
+		"chunked": {KeyRing: ring, ChunkSize: 100},
+	} {
+		storage, _ := NewEncryptedStorageWithOptions(tmpdir+"/"+name, getKey(), options)
+		storage.WriteFile("foo", make([]byte, 1234))
+		if size, err := storage.FileSize("foo"); err != nil || size != 1234 {
+			t.Errorf("%s expected size 1234 got %d %+v", name, size, err)
+		}
+		storage.TouchFile("empty")
+		if size, err := storage.FileSize("empty"); err != nil || size != 0 {
+			t.Errorf("%s expected size 0 got %d %+v", name, size, err)
+		}
+	}
+}
+
+func TestAppendFileEncrypted(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for name, options := range map[string]EncryptionOptions{
+		"cfb":     {},
+		"hmac":    {HMAC: true},
+		"aead":    {AEAD: true},
+		"chunked": {ChunkSize: 4},
+	} {
+		storage, _ := NewEncryptedStorageWithOptions(tmpdir+"/"+name, getKey(), options)
+		if err := storage.AppendFile("foo", []byte("abc")); err != nil {
+			t.Fatalf("%s unexpected error when calling AppendFile %+v", name, err)
+		}
+		if err := storage.AppendFile("foo", []byte("def")); err != nil {
+			t.Fatalf("%s unexpected error when calling AppendFile %+v", name, err)
+		}
+		if data, err := storage.ReadFileFully("foo"); err != nil || string(data) != "abcdef" {
+			t.Errorf("%s expected abcdef got %q %+v", name, string(data), err)
+		}
+	}
+}
+
+func TestGetFileReaderEncrypted(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for name, options := range map[string]EncryptionOptions{
+		"cfb":  {},
+		"hmac": {HMAC: true},
+		"aead": {AEAD: true},
+	} {
+		storage, _ := NewEncryptedStorageWithOptions(tmpdir+"/"+name, getKey(), options)
+		storage.WriteFile("foo", []byte("abcdef"))
+		reader, err := storage.GetFileReader("foo")
+		if err != nil {
+			t.Fatalf("%s unexpected error when calling GetFileReader %+v", name, err)
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil || string(data) != "abcdef" {
+			t.Errorf("%s expected abcdef got %q %+v", name, data, err)
+		}
+		if err = reader.Close(); err != nil {
+			t.Errorf("%s unexpected error when calling Close %+v", name, err)
+		}
+	}
+}
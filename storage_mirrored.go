@@ -0,0 +1,182 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// MirroredStorage is a fascade writing every mutation to two storages and
+// reading from primary with fallback to secondary
+type MirroredStorage struct {
+	Storage
+	secondary Storage
+	strict    bool
+}
+
+// NewMirroredStorage returns storage mirroring primary to secondary, in
+// strict mode mutation fails unless both storages succeed otherwise it
+// succeeds when at least one of them does
+func NewMirroredStorage(primary Storage, secondary Storage, strict bool) Storage {
+	return MirroredStorage{
+		Storage:   primary,
+		secondary: secondary,
+		strict:    strict,
+	}
+}
+
+func (storage MirroredStorage) unwrap() Storage {
+	return storage.Storage
+}
+
+func (storage MirroredStorage) mutate(action func(Storage) error) error {
+	primary := action(storage.Storage)
+	secondary := action(storage.secondary)
+	if primary == nil && secondary == nil {
+		return nil
+	}
+	if !storage.strict && (primary == nil || secondary == nil) {
+		return nil
+	}
+	if primary != nil && secondary != nil {
+		return errors.Join(primary, secondary)
+	}
+	if primary != nil {
+		return primary
+	}
+	return secondary
+}
+
+// Chmod sets chmod flag on given file in both storages
+func (storage MirroredStorage) Chmod(path string, mod os.FileMode) error {
+	return storage.mutate(func(target Storage) error {
+		return target.Chmod(path, mod)
+	})
+}
+
+// ListDirectory returns sorted slice of item names in given path
+func (storage MirroredStorage) ListDirectory(path string, ascending bool) ([]string, error) {
+	result, err := storage.Storage.ListDirectory(path, ascending)
+	if err != nil {
+		return storage.secondary.ListDirectory(path, ascending)
+	}
+	return result, nil
+}
+
+// CountFiles returns number of items in directory
+func (storage MirroredStorage) CountFiles(path string) (int, error) {
+	result, err := storage.Storage.CountFiles(path)
+	if err != nil {
+		return storage.secondary.CountFiles(path)
+	}
+	return result, nil
+}
+
+// Exists returns true if path exists
+func (storage MirroredStorage) Exists(path string) (bool, error) {
+	result, err := storage.Storage.Exists(path)
+	if err != nil {
+		return storage.secondary.Exists(path)
+	}
+	return result, nil
+}
+
+// LastModification returns time of last modification
+func (storage MirroredStorage) LastModification(path string) (time.Time, error) {
+	result, err := storage.Storage.LastModification(path)
+	if err != nil {
+		return storage.secondary.LastModification(path)
+	}
+	return result, nil
+}
+
+// FileSize returns size of file in bytes
+func (storage MirroredStorage) FileSize(path string) (int64, error) {
+	result, err := storage.Storage.FileSize(path)
+	if err != nil {
+		return storage.secondary.FileSize(path)
+	}
+	return result, nil
+}
+
+// ReadFileFully reads whole file given path
+func (storage MirroredStorage) ReadFileFully(path string) ([]byte, error) {
+	result, err := storage.Storage.ReadFileFully(path)
+	if err != nil {
+		return storage.secondary.ReadFileFully(path)
+	}
+	return result, nil
+}
+
+// GetFileReader returns reader of file given path
+func (storage MirroredStorage) GetFileReader(path string) (io.ReadCloser, error) {
+	result, err := storage.Storage.GetFileReader(path)
+	if err != nil {
+		return storage.secondary.GetFileReader(path)
+	}
+	return result, nil
+}
+
+// TouchFile creates file given path in both storages
+func (storage MirroredStorage) TouchFile(path string) error {
+	return storage.mutate(func(target Storage) error {
+		return target.TouchFile(path)
+	})
+}
+
+// Mkdir creates directory given path in both storages
+func (storage MirroredStorage) Mkdir(path string) error {
+	return storage.mutate(func(target Storage) error {
+		return target.Mkdir(path)
+	})
+}
+
+// Delete removes given path from both storages
+func (storage MirroredStorage) Delete(path string) error {
+	return storage.mutate(func(target Storage) error {
+		return target.Delete(path)
+	})
+}
+
+// WriteFileExclusive writes data given path to a file in both storages if
+// that file does not already exists, primary decides who created the file so
+// its failure is failure regardless of strict mode and secondary is untouched
+func (storage MirroredStorage) WriteFileExclusive(path string, data []byte) error {
+	if err := storage.Storage.WriteFileExclusive(path, data); err != nil {
+		return err
+	}
+	err := storage.secondary.WriteFileExclusive(path, data)
+	if err != nil && storage.strict {
+		return err
+	}
+	return nil
+}
+
+// WriteFile writes data given path to a file in both storages
+func (storage MirroredStorage) WriteFile(path string, data []byte) error {
+	return storage.mutate(func(target Storage) error {
+		return target.WriteFile(path, data)
+	})
+}
+
+// AppendFile appends data given path to a file in both storages
+func (storage MirroredStorage) AppendFile(path string, data []byte) error {
+	return storage.mutate(func(target Storage) error {
+		return target.AppendFile(path, data)
+	})
+}
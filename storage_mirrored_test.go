@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMirroredStorage(t *testing.T) {
+	primaryDir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(primaryDir)
+	secondaryDir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(secondaryDir)
+
+	primary, _ := NewPlaintextStorage(primaryDir)
+	secondary, _ := NewPlaintextStorage(secondaryDir)
+
+	t.Log("mirrors writes")
+	{
+		storage := NewMirroredStorage(primary, secondary, true)
+		if err = storage.WriteFile("foo", []byte("abc")); err != nil {
+			t.Fatalf("unexpected error when calling WriteFile %+v", err)
+		}
+		for _, target := range []Storage{primary, secondary} {
+			if data, _ := target.ReadFileFully("foo"); string(data) != "abc" {
+				t.Errorf("expected mirrored content abc got %s", string(data))
+			}
+		}
+	}
+
+	t.Log("falls back to secondary")
+	{
+		storage := NewMirroredStorage(primary, secondary, true)
+		if err = primary.Delete("foo"); err != nil {
+			t.Fatalf("unexpected error when calling Delete %+v", err)
+		}
+		data, err := storage.ReadFileFully("foo")
+		if err != nil || string(data) != "abc" {
+			t.Errorf("expected fallback read of abc got %s %+v", string(data), err)
+		}
+	}
+
+	t.Log("strict mode")
+	{
+		if err = secondary.WriteFile("bar", []byte("x")); err != nil {
+			t.Fatalf("unexpected error when calling WriteFile %+v", err)
+		}
+		strict := NewMirroredStorage(primary, secondary, true)
+		if err = strict.WriteFileExclusive("bar", []byte("y")); err == nil {
+			t.Errorf("expected strict mode to fail when secondary fails")
+		}
+		lenient := NewMirroredStorage(primary, secondary, false)
+		if err = lenient.WriteFileExclusive("baz", []byte("y")); err != nil {
+			t.Errorf("unexpected error in lenient mode %+v", err)
+		}
+		secondary.WriteFile("qux", []byte("x"))
+		if err = lenient.WriteFileExclusive("qux", []byte("y")); err != nil {
+			t.Errorf("expected lenient mode to succeed when primary succeeds got %+v", err)
+		}
+	}
+
+	t.Log("exclusive write fails when primary fails")
+	{
+		primary.WriteFile("taken", []byte("first"))
+		lenient := NewMirroredStorage(primary, secondary, false)
+		if err = lenient.WriteFileExclusive("taken", []byte("second")); !os.IsExist(err) {
+			t.Errorf("expected primary EEXIST to fail exclusive write got %+v", err)
+		}
+		if ok, _ := secondary.Exists("taken"); ok {
+			t.Errorf("expected secondary to stay untouched")
+		}
+	}
+}
@@ -15,67 +15,93 @@
 package storage
 
 import (
-	"fmt"
+	"errors"
+	"io"
 	"os"
 	"time"
 )
 
+// ErrNotInitialized is returned by every method of NilStorage, constructors
+// return NilStorage together with cause of failure so misconfigured storage
+// used anyway fails with this error
+var ErrNotInitialized = errors.New("storage not initialized properly")
+
 // NilStorage is a nil storage fascade
-type NilStorage struct {
-	Storage
-}
+type NilStorage struct{}
 
-// Chmod sbut
+// Chmod stub
 func (storage NilStorage) Chmod(path string, mod os.FileMode) error {
-	return fmt.Errorf("storage not initialized properly")
+	return ErrNotInitialized
 }
 
 // ListDirectory stub
 func (storage NilStorage) ListDirectory(path string, ascending bool) ([]string, error) {
-	return nil, fmt.Errorf("storage not initialized properly")
+	return nil, ErrNotInitialized
 }
 
 // CountFiles stub
 func (storage NilStorage) CountFiles(path string) (int, error) {
-	return 0, fmt.Errorf("storage not initialized properly")
+	return 0, ErrNotInitialized
 }
 
 // Exists stub
 func (storage NilStorage) Exists(path string) (bool, error) {
-	return false, fmt.Errorf("storage not initialized properly")
+	return false, ErrNotInitialized
 }
 
 // LastModification stub
 func (storage NilStorage) LastModification(path string) (time.Time, error) {
-	return time.Now(), fmt.Errorf("storage not initialized properly")
+	return time.Now(), ErrNotInitialized
 }
 
 // TouchFile stub
 func (storage NilStorage) TouchFile(path string) error {
-	return fmt.Errorf("storage not initialized properly")
+	return ErrNotInitialized
+}
+
+// Mkdir stub
+func (storage NilStorage) Mkdir(path string) error {
+	return ErrNotInitialized
+}
+
+// Delete stub
+func (storage NilStorage) Delete(path string) error {
+	return ErrNotInitialized
 }
 
 // DeleteFile stub
+//
+// Deprecated: use Delete, DeleteFile is not part of Storage contract
 func (storage NilStorage) DeleteFile(path string) error {
-	return fmt.Errorf("storage not initialized properly")
+	return ErrNotInitialized
+}
+
+// FileSize stub
+func (storage NilStorage) FileSize(path string) (int64, error) {
+	return 0, ErrNotInitialized
 }
 
 // ReadFileFully stub
 func (storage NilStorage) ReadFileFully(path string) ([]byte, error) {
-	return nil, fmt.Errorf("storage not initialized properly")
+	return nil, ErrNotInitialized
+}
+
+// GetFileReader stub
+func (storage NilStorage) GetFileReader(path string) (io.ReadCloser, error) {
+	return nil, ErrNotInitialized
 }
 
 // WriteFileExclusive stub
 func (storage NilStorage) WriteFileExclusive(path string, data []byte) error {
-	return fmt.Errorf("storage not initialized properly")
+	return ErrNotInitialized
 }
 
 // WriteFile stub
 func (storage NilStorage) WriteFile(path string, data []byte) error {
-	return fmt.Errorf("storage not initialized properly")
+	return ErrNotInitialized
 }
 
 // AppendFile stub
 func (storage NilStorage) AppendFile(path string, data []byte) error {
-	return fmt.Errorf("storage not initialized properly")
+	return ErrNotInitialized
 }
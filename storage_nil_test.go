@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNilStorage(t *testing.T) {
+	t.Log("failed constructor returns NilStorage")
+	{
+		storage, err := NewPlaintextStorage("")
+		if err == nil {
+			t.Fatalf("expected error when calling NewPlaintextStorage with empty root")
+		}
+		if _, ok := storage.(NilStorage); !ok {
+			t.Fatalf("expected NilStorage got %T", storage)
+		}
+	}
+
+	t.Log("every operation fails with ErrNotInitialized")
+	{
+		var storage Storage = NilStorage{}
+		errs := make([]error, 0)
+		errs = append(errs, storage.Chmod("foo", 0600))
+		_, err := storage.ListDirectory("foo", true)
+		errs = append(errs, err)
+		_, err = storage.CountFiles("foo")
+		errs = append(errs, err)
+		_, err = storage.Exists("foo")
+		errs = append(errs, err)
+		errs = append(errs, storage.TouchFile("foo"))
+		errs = append(errs, storage.Mkdir("foo"))
+		_, err = storage.ReadFileFully("foo")
+		errs = append(errs, err)
+		errs = append(errs, storage.WriteFileExclusive("foo", nil))
+		errs = append(errs, storage.WriteFile("foo", nil))
+		errs = append(errs, storage.Delete("foo"))
+		errs = append(errs, storage.AppendFile("foo", nil))
+		_, err = storage.LastModification("foo")
+		errs = append(errs, err)
+		_, err = storage.FileSize("foo")
+		errs = append(errs, err)
+		_, err = storage.GetFileReader("foo")
+		errs = append(errs, err)
+		for i, err := range errs {
+			if !errors.Is(err, ErrNotInitialized) {
+				t.Errorf("expected ErrNotInitialized from operation %d got %+v", i, err)
+			}
+		}
+	}
+}
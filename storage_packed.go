@@ -0,0 +1,518 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// packing serializes rewrites of packs within process, packs of plaintext
+// storage are also excluded against other processes by flock of directory
+var packing sync.Mutex
+
+// PackedStorage is a fascade presenting files packed into single pack file
+// per directory under their original paths, loose files take precedence over
+// packed ones
+type PackedStorage struct {
+	Storage
+}
+
+// NewPackedStorage returns storage reading through packs of underlying
+// storage
+func NewPackedStorage(underlying Storage) Storage {
+	return PackedStorage{
+		Storage: underlying,
+	}
+}
+
+func (storage PackedStorage) unwrap() Storage {
+	return storage.Storage
+}
+
+func splitPath(name string) (string, string) {
+	dir, base := path.Split(strings.TrimPrefix(path.Clean("/"+name), "/"))
+	return strings.TrimSuffix(dir, "/"), base
+}
+
+func packPath(dir string) string {
+	if dir == "" {
+		return PackName
+	}
+	return dir + "/" + PackName
+}
+
+// plainRoot returns root of underlying plaintext storage whose packs can be
+// memory mapped
+func (storage PackedStorage) plainRoot() (string, bool) {
+	for candidate := storage.Storage; candidate != nil; {
+		if plaintext, ok := candidate.(PlaintextStorage); ok {
+			return plaintext.root, true
+		}
+		decorator, ok := candidate.(wrapper)
+		if !ok {
+			break
+		}
+		candidate = decorator.unwrap()
+	}
+	return "", false
+}
+
+// loadPack opens pack of directory, packs of plaintext storage are memory
+// mapped and others are read through underlying storage, view is valid until
+// release is called
+func (storage PackedStorage) loadPack(dir string) (packView, func(), bool, error) {
+	if root, ok := storage.plainRoot(); ok {
+		view, release, err := mapPack(filepath.Clean(root + "/" + packPath(dir)))
+		if os.IsNotExist(err) {
+			return view, release, false, nil
+		}
+		return view, release, err == nil, err
+	}
+	data, err := storage.Storage.ReadFileFully(packPath(dir))
+	if os.IsNotExist(err) {
+		return packView{}, noop, false, nil
+	}
+	if err != nil {
+		return packView{}, noop, false, err
+	}
+	view, err := openPack(data)
+	if err != nil {
+		return view, noop, false, err
+	}
+	return view, noop, true, nil
+}
+
+// packed returns copy of content and modification time of packed file
+func (storage PackedStorage) packed(name string) ([]byte, time.Time, bool, error) {
+	dir, base := splitPath(name)
+	view, release, ok, err := storage.loadPack(dir)
+	defer release()
+	if err != nil || !ok {
+		return nil, time.Time{}, false, err
+	}
+	i, ok := view.lookup(base)
+	if !ok {
+		return nil, time.Time{}, false, nil
+	}
+	data, modified, err := view.entry(i)
+	if err != nil {
+		return nil, modified, false, err
+	}
+	return append([]byte(nil), data...), modified, true, nil
+}
+
+// contains returns true if directory pack contains file
+func (storage PackedStorage) contains(name string) (bool, error) {
+	dir, base := splitPath(name)
+	view, release, ok, err := storage.loadPack(dir)
+	defer release()
+	if err != nil || !ok {
+		return false, err
+	}
+	_, ok = view.lookup(base)
+	return ok, nil
+}
+
+// writePack replaces pack of directory with given entries, packs of
+// plaintext storage are replaced atomically so mapped readers are not
+// disturbed
+func (storage PackedStorage) writePack(dir string, entries []packEntry) error {
+	if len(entries) == 0 {
+		if ok, _ := storage.Storage.Exists(packPath(dir)); ok {
+			return storage.Storage.Delete(packPath(dir))
+		}
+		return nil
+	}
+	if root, ok := storage.plainRoot(); ok {
+		return writePackFile(filepath.Clean(root+"/"+packPath(dir)), encodePack(entries))
+	}
+	return storage.Storage.WriteFile(packPath(dir), encodePack(entries))
+}
+
+// lockPack excludes other rewrites of pack of directory until returned func
+// is called
+func (storage PackedStorage) lockPack(dir string) (func(), error) {
+	packing.Lock()
+	root, ok := storage.plainRoot()
+	if !ok {
+		return packing.Unlock, nil
+	}
+	absPath := filepath.Clean(root + "/" + dir)
+	fd, err := openRetrying(absPath, syscall.O_RDONLY, 0)
+	if os.IsNotExist(err) {
+		return packing.Unlock, nil
+	}
+	if err != nil {
+		packing.Unlock()
+		return noop, err
+	}
+	if err = flock(fd, absPath, syscall.LOCK_EX, 0); err != nil {
+		syscall.Close(fd)
+		packing.Unlock()
+		return noop, err
+	}
+	return func() {
+		funlock(fd, absPath)
+		syscall.Close(fd)
+		packing.Unlock()
+	}, nil
+}
+
+// unpack removes file from pack of its directory
+func (storage PackedStorage) unpack(name string) error {
+	dir, base := splitPath(name)
+	unlock, err := storage.lockPack(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	view, release, ok, err := storage.loadPack(dir)
+	defer release()
+	if err != nil || !ok {
+		return err
+	}
+	if _, found := view.lookup(base); !found {
+		return nil
+	}
+	entries, err := view.entries()
+	release()
+	release = noop
+	if err != nil {
+		return err
+	}
+	remaining := entries[:0]
+	for _, entry := range entries {
+		if entry.name != base {
+			remaining = append(remaining, entry)
+		}
+	}
+	return storage.writePack(dir, remaining)
+}
+
+// Pack moves loose files of directory into its pack, subdirectories are left
+// untouched
+func (storage PackedStorage) Pack(dir string) error {
+	dir, _ = splitPath(dir + "/" + PackName)
+	names, err := storage.Storage.ListDirectory(dir, true)
+	if err != nil {
+		return err
+	}
+	loose := make([]string, 0, len(names))
+	for _, name := range names {
+		if name == PackName {
+			continue
+		}
+		if _, err := storage.Storage.ListDirectory(strings.TrimPrefix(dir+"/"+name, "/"), true); err == nil {
+			continue
+		}
+		loose = append(loose, name)
+	}
+	return storage.packFiles(dir, loose)
+}
+
+// packFiles moves given loose files of directory into its pack, loose file
+// changed while being packed is kept because it shadows packed copy
+func (storage PackedStorage) packFiles(dir string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	unlock, err := storage.lockPack(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	view, release, ok, err := storage.loadPack(dir)
+	if err != nil {
+		release()
+		return err
+	}
+	merged := make(map[string]packEntry)
+	if ok {
+		entries, err := view.entries()
+		release()
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			merged[entry.name] = entry
+		}
+	}
+	type looseFile struct {
+		path     string
+		size     int64
+		modified time.Time
+	}
+	loose := make([]looseFile, 0, len(names))
+	for _, name := range names {
+		child := strings.TrimPrefix(dir+"/"+name, "/")
+		// stat before read so write racing with read shows up as change
+		size, err := storage.Storage.FileSize(child)
+		if err != nil {
+			return err
+		}
+		modified, err := storage.Storage.LastModification(child)
+		if err != nil {
+			return err
+		}
+		data, err := storage.Storage.ReadFileFully(child)
+		if err != nil {
+			return err
+		}
+		merged[name] = packEntry{name: name, data: data, modified: modified}
+		loose = append(loose, looseFile{path: child, size: size, modified: modified})
+	}
+	entries := make([]packEntry, 0, len(merged))
+	for _, entry := range merged {
+		entries = append(entries, entry)
+	}
+	if err = storage.writePack(dir, entries); err != nil {
+		return err
+	}
+	for _, file := range loose {
+		if err = storage.deleteLoose(file.path, file.size, file.modified); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteLoose deletes packed loose file unless its size or modification time
+// differs from packed copy, it runs under lock of pack and file already gone
+// counts as deleted, loose file itself is never locked because locking
+// creates it when missing and empty loose file would shadow packed copy
+func (storage PackedStorage) deleteLoose(name string, size int64, modified time.Time) error {
+	current, err := storage.Storage.FileSize(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil || current != size {
+		return err
+	}
+	changed, err := storage.Storage.LastModification(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil || !changed.Equal(modified) {
+		return err
+	}
+	if err = storage.Storage.Delete(name); os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// ListDirectory returns sorted slice of loose and packed item names
+func (storage PackedStorage) ListDirectory(dir string, ascending bool) ([]string, error) {
+	names, err := storage.Storage.ListDirectory(dir, ascending)
+	if err != nil {
+		return nil, err
+	}
+	dir, _ = splitPath(dir + "/" + PackName)
+	view, release, ok, err := storage.loadPack(dir)
+	defer release()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return names, nil
+	}
+	seen := make(map[string]bool, len(names)+view.count)
+	result := make([]string, 0, len(names)+view.count)
+	for _, name := range names {
+		if name != PackName {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+	for i := 0; i < view.count; i++ {
+		if name := string(view.name(i)); !seen[name] {
+			result = append(result, name)
+		}
+	}
+	if ascending {
+		sort.Strings(result)
+	} else {
+		sort.Sort(sort.Reverse(sort.StringSlice(result)))
+	}
+	return result, nil
+}
+
+// CountFiles returns number of loose and packed files in directory,
+// directories are not counted
+func (storage PackedStorage) CountFiles(dir string) (int, error) {
+	count, err := storage.Storage.CountFiles(dir)
+	if err != nil {
+		return 0, err
+	}
+	names, err := storage.Storage.ListDirectory(dir, true)
+	if err != nil {
+		return 0, err
+	}
+	dir, _ = splitPath(dir + "/" + PackName)
+	view, release, ok, err := storage.loadPack(dir)
+	defer release()
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return count, nil
+	}
+	// pack itself is file of underlying storage
+	count--
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		seen[name] = true
+	}
+	for i := 0; i < view.count; i++ {
+		if !seen[string(view.name(i))] {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Exists returns true if path exists loose or packed
+func (storage PackedStorage) Exists(name string) (bool, error) {
+	if ok, err := storage.Storage.Exists(name); err != nil || ok {
+		return ok, err
+	}
+	return storage.contains(name)
+}
+
+// LastModification returns time of last modification
+func (storage PackedStorage) LastModification(name string) (time.Time, error) {
+	modified, err := storage.Storage.LastModification(name)
+	if !os.IsNotExist(err) {
+		return modified, err
+	}
+	_, modified, ok, perr := storage.packed(name)
+	if perr != nil {
+		return modified, perr
+	}
+	if !ok {
+		return modified, err
+	}
+	return modified, nil
+}
+
+// FileSize returns size of loose or packed file
+func (storage PackedStorage) FileSize(name string) (int64, error) {
+	size, err := storage.Storage.FileSize(name)
+	if !os.IsNotExist(err) {
+		return size, err
+	}
+	packed, _, ok, perr := storage.packed(name)
+	if perr != nil {
+		return 0, perr
+	}
+	if !ok {
+		return 0, err
+	}
+	return int64(len(packed)), nil
+}
+
+// ReadFileFully reads whole loose or packed file
+func (storage PackedStorage) ReadFileFully(name string) ([]byte, error) {
+	data, err := storage.Storage.ReadFileFully(name)
+	if !os.IsNotExist(err) {
+		return data, err
+	}
+	packed, _, ok, perr := storage.packed(name)
+	if perr != nil {
+		return nil, perr
+	}
+	if !ok {
+		return nil, err
+	}
+	return packed, nil
+}
+
+// GetFileReader returns reader of loose or packed file
+func (storage PackedStorage) GetFileReader(name string) (io.ReadCloser, error) {
+	reader, err := storage.Storage.GetFileReader(name)
+	if !os.IsNotExist(err) {
+		return reader, err
+	}
+	packed, _, ok, perr := storage.packed(name)
+	if perr != nil {
+		return nil, perr
+	}
+	if !ok {
+		return nil, err
+	}
+	return readerOf(packed, nil)
+}
+
+// TouchFile creates file if it does not exist loose or packed
+func (storage PackedStorage) TouchFile(name string) error {
+	if ok, err := storage.contains(name); err != nil || ok {
+		return err
+	}
+	return storage.Storage.TouchFile(name)
+}
+
+// WriteFileExclusive writes data if file does not exist loose or packed
+func (storage PackedStorage) WriteFileExclusive(name string, data []byte) error {
+	ok, err := storage.contains(name)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	}
+	return storage.Storage.WriteFileExclusive(name, data)
+}
+
+// AppendFile appends data, packed file is unpacked into loose file first
+func (storage PackedStorage) AppendFile(name string, data []byte) error {
+	if ok, err := storage.Storage.Exists(name); err != nil || ok {
+		if err != nil {
+			return err
+		}
+		return storage.Storage.AppendFile(name, data)
+	}
+	packed, _, ok, err := storage.packed(name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return storage.Storage.AppendFile(name, data)
+	}
+	content := make([]byte, 0, len(packed)+len(data))
+	content = append(content, packed...)
+	content = append(content, data...)
+	if err = storage.Storage.WriteFile(name, content); err != nil {
+		return err
+	}
+	return storage.unpack(name)
+}
+
+// Delete removes loose and packed file
+func (storage PackedStorage) Delete(name string) error {
+	if ok, _ := storage.Storage.Exists(name); ok {
+		if err := storage.Storage.Delete(name); err != nil {
+			return err
+		}
+	}
+	return storage.unpack(name)
+}
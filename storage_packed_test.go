@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// packLookupAllocBudget must not depend on number of entries in pack
+const packLookupAllocBudget = 2
+
+func TestPackedStorage(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	underlying, _ := NewEncryptedStorage(tmpdir, getKey())
+	storage := NewPackedStorage(underlying)
+	packed := storage.(PackedStorage)
+
+	for i := 0; i < 100; i++ {
+		storage.WriteFile(fmt.Sprintf("account/events/%03d", i), []byte(fmt.Sprintf("event %d", i)))
+	}
+	storage.WriteFile("account/events/nested/x", []byte("nested"))
+
+	if err := packed.Pack("account/events"); err != nil {
+		t.Fatalf("unexpected error when calling Pack %+v", err)
+	}
+
+	t.Log("packs loose files into single file")
+	{
+		raw, _ := underlying.ListDirectory("account/events", true)
+		if len(raw) != 2 || raw[0] != PackName || raw[1] != "nested" {
+			t.Errorf("expected only pack and subdirectory on disk got %+v", raw)
+		}
+	}
+
+	t.Log("preserves api over virtual paths")
+	{
+		names, err := storage.ListDirectory("account/events", true)
+		if err != nil || len(names) != 101 || names[0] != "000" || names[100] != "nested" {
+			t.Errorf("unexpected listing %d %+v", len(names), err)
+		}
+		if count, _ := storage.CountFiles("account/events"); count != 100 {
+			t.Errorf("expected 100 files without subdirectory got %d", count)
+		}
+		data, err := storage.ReadFileFully("account/events/042")
+		if err != nil || string(data) != "event 42" {
+			t.Errorf("expected event 42 got %s %+v", string(data), err)
+		}
+		if ok, _ := storage.Exists("account/events/099"); !ok {
+			t.Errorf("expected packed file to exist")
+		}
+		if _, err := storage.LastModification("account/events/001"); err != nil {
+			t.Errorf("unexpected error when calling LastModification %+v", err)
+		}
+		if size, err := storage.FileSize("account/events/042"); err != nil || size != int64(len("event 42")) {
+			t.Errorf("expected packed file size got %d %+v", size, err)
+		}
+		if reader, err := storage.GetFileReader("account/events/042"); err != nil {
+			t.Errorf("unexpected error when calling GetFileReader %+v", err)
+		} else if data, _ := io.ReadAll(reader); string(data) != "event 42" {
+			t.Errorf("expected event 42 from reader got %s", string(data))
+		}
+		if err := storage.WriteFileExclusive("account/events/001", []byte("x")); !os.IsExist(err) {
+			t.Errorf("expected exist error got %+v", err)
+		}
+		if _, err := storage.ReadFileFully("account/events/missing"); !os.IsNotExist(err) {
+			t.Errorf("expected not exist error got %+v", err)
+		}
+	}
+
+	t.Log("modifies packed files")
+	{
+		storage.WriteFile("account/events/000", []byte("rewritten"))
+		if data, _ := storage.ReadFileFully("account/events/000"); string(data) != "rewritten" {
+			t.Errorf("expected loose file to shadow packed got %s", string(data))
+		}
+		storage.AppendFile("account/events/001", []byte("!"))
+		if data, _ := storage.ReadFileFully("account/events/001"); string(data) != "event 1!" {
+			t.Errorf("expected appended content got %s", string(data))
+		}
+		storage.Delete("account/events/000")
+		storage.Delete("account/events/002")
+		if ok, _ := storage.Exists("account/events/000"); ok {
+			t.Errorf("expected deleted file not to exist")
+		}
+		if ok, _ := storage.Exists("account/events/002"); ok {
+			t.Errorf("expected deleted packed file not to exist")
+		}
+		packed.Pack("account/events")
+		if count, _ := storage.CountFiles("account/events"); count != 98 {
+			t.Errorf("expected 98 files without subdirectory got %d", count)
+		}
+		if data, _ := storage.ReadFileFully("account/events/001"); string(data) != "event 1!" {
+			t.Errorf("expected repacked content got %s", string(data))
+		}
+	}
+
+	t.Log("loose file deleted while being packed stays deleted")
+	{
+		if err := packed.deleteLoose("account/events/gone", 1, time.Now()); err != nil {
+			t.Errorf("expected missing loose file to count as deleted got %+v", err)
+		}
+		if ok, _ := underlying.Exists("account/events/gone"); ok {
+			t.Errorf("expected no loose file to be created")
+		}
+	}
+
+	t.Log("keeps loose file written while being packed")
+	{
+		storage.WriteFile("account/racing/a", []byte("before"))
+		racing := NewPackedStorage(racingStorage{
+			Storage: underlying,
+			read: func(path string) {
+				if path == "account/racing/a" {
+					underlying.WriteFile(path, []byte("written while packing"))
+				}
+			},
+		}).(PackedStorage)
+		if err := racing.Pack("account/racing"); err != nil {
+			t.Fatalf("unexpected error when calling Pack %+v", err)
+		}
+		if data, _ := storage.ReadFileFully("account/racing/a"); string(data) != "written while packing" {
+			t.Errorf("expected concurrent write to survive packing got %q", data)
+		}
+	}
+}
+
+// racingStorage calls read after every ReadFileFully
+type racingStorage struct {
+	Storage
+	read func(string)
+}
+
+func (storage racingStorage) ReadFileFully(path string) ([]byte, error) {
+	data, err := storage.Storage.ReadFileFully(path)
+	storage.read(path)
+	return data, err
+}
+
+func TestPackIndexMapped(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	entries := make([]packEntry, 100000)
+	for i := range entries {
+		entries[i] = packEntry{name: fmt.Sprintf("%08d", i), data: []byte(fmt.Sprintf("%d", i))}
+	}
+	if err := writePackFile(tmpdir+"/"+PackName, encodePack(entries)); err != nil {
+		t.Fatalf("unexpected error when calling writePackFile %+v", err)
+	}
+
+	path := tmpdir + "/" + PackName
+	found := false
+	allocs := testing.AllocsPerRun(100, func() {
+		view, release, err := mapPack(path)
+		if err != nil {
+			return
+		}
+		_, found = view.lookup("00054321")
+		release()
+	})
+	if !found {
+		t.Errorf("expected entry to be found")
+	}
+	if allocs > packLookupAllocBudget {
+		t.Errorf("opening and searching pack allocates %v times per run, budget is %d", allocs, packLookupAllocBudget)
+	}
+
+	storage := NewPackedStorage(func() Storage {
+		s, _ := NewPlaintextStorage(tmpdir)
+		return s
+	}())
+	data, err := storage.ReadFileFully("00099999")
+	if err != nil || string(data) != "99999" {
+		t.Errorf("expected 99999 got %s %+v", string(data), err)
+	}
+}
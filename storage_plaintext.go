@@ -26,33 +26,115 @@ import (
 // PlaintextStorage is a fascade to access plaintext storage
 type PlaintextStorage struct {
 	Storage
-	root       string
-	bufferSize int
+	root        string
+	bufferSize  int
+	handles     *handleRegistry
+	barrier     *writeBarrier
+	directIO    bool
+	noAtime     bool
+	lockTimeout time.Duration
+}
+
+// PlaintextOptions customizes plaintext storage
+type PlaintextOptions struct {
+	// DirectIO makes ReadFileFully and WriteFile bypass page cache with
+	// O_DIRECT so bulk import or export does not evict page cache of host,
+	// filesystems without direct IO support fall back to page cache
+	DirectIO bool
+	// NoAtime opens files for reading with O_NOATIME so reads of root
+	// mounted without noatime do not write access time, files not owned by
+	// process are opened normally
+	NoAtime bool
+	// LockTimeout bounds how long operations wait for lock of file held by
+	// another process before failing with ErrLockTimeout, zero waits forever
+	LockTimeout time.Duration
 }
 
 // NewPlaintextStorage returns new storage over given root
 func NewPlaintextStorage(root string) (Storage, error) {
+	return NewPlaintextStorageWithOptions(root, PlaintextOptions{})
+}
+
+// NewPlaintextStorageWithOptions returns new storage over given root
+// customized by options
+func NewPlaintextStorageWithOptions(root string, options PlaintextOptions) (Storage, error) {
 	if root == "" {
 		return NilStorage{}, fmt.Errorf("invalid root directory")
 	}
 	if os.MkdirAll(filepath.Clean(root), os.ModePerm) != nil {
 		return NilStorage{}, fmt.Errorf("unable to assert root storage directory")
 	}
+	if err := ensureFormat(root); err != nil {
+		return NilStorage{}, err
+	}
 	return PlaintextStorage{
-		root:       root,
-		bufferSize: 8192,
+		root:        root,
+		bufferSize:  8192,
+		handles:     newHandleRegistry(),
+		barrier:     newWriteBarrier(),
+		directIO:    options.DirectIO,
+		noAtime:     options.NoAtime,
+		lockTimeout: options.LockTimeout,
 	}, nil
 }
 
+func (storage PlaintextStorage) rootDir() string {
+	return storage.root
+}
+
+func (storage PlaintextStorage) writeBarrier() *writeBarrier {
+	return storage.barrier
+}
+
 // Chmod sets chmod flag on given file
 func (storage PlaintextStorage) Chmod(path string, mod os.FileMode) error {
+	defer storage.barrier.enter()()
 	return chmod(storage.root+"/"+path, mod)
 }
 
 // ListDirectory returns sorted slice of item names in given absolute path
 // default sorting is ascending
 func (storage PlaintextStorage) ListDirectory(path string, ascending bool) ([]string, error) {
-	return listDirectory(storage.root+"/"+path, storage.bufferSize, ascending)
+	names, err := listDirectory(storage.root+"/"+path, storage.bufferSize, SortLexicographic, ascending)
+	return hideControl(path, names, err)
+}
+
+// ListDirectorySorted returns slice of item names in given path ordered by
+// given sort mode
+func (storage PlaintextStorage) ListDirectorySorted(path string, mode SortMode, ascending bool) ([]string, error) {
+	names, err := listDirectory(storage.root+"/"+path, storage.bufferSize, mode, ascending)
+	return hideControl(path, names, err)
+}
+
+// ListDirectoryUnsorted returns slice of item names in given path in kernel
+// readdir order, which is unspecified and not stable across modifications
+func (storage PlaintextStorage) ListDirectoryUnsorted(path string) ([]string, error) {
+	names, err := listDirectory(storage.root+"/"+path, storage.bufferSize, SortNone, true)
+	return hideControl(path, names, err)
+}
+
+// ListDirectoryFiltered returns ascending slice of item names in given path
+// accepted by match, names are filtered during scan and match must not
+// retain name
+func (storage PlaintextStorage) ListDirectoryFiltered(path string, match func(name string) bool) ([]string, error) {
+	if visible := visibleEntry(path); visible != nil {
+		return listDirectoryFiltered(storage.root+"/"+path, storage.bufferSize, func(name string) bool {
+			return visible(name) && match(name)
+		})
+	}
+	return listDirectoryFiltered(storage.root+"/"+path, storage.bufferSize, match)
+}
+
+// ForEachEntry calls fn for every item in given path in kernel order without
+// allocating, name is valid only until fn returns, iteration stops when fn
+// returns false
+func (storage PlaintextStorage) ForEachEntry(path string, fn func(name string) bool) error {
+	if visible := visibleEntry(path); visible != nil {
+		return forEachEntry(storage.root+"/"+path, storage.bufferSize, func(name string) bool {
+			return !visible(name) || fn(name)
+		})
+	}
+	return forEachEntry(storage.root+"/"+path, storage.bufferSize, fn)
 }
 
 // CountFiles returns number of items in directory
@@ -70,115 +152,141 @@ func (storage PlaintextStorage) LastModification(path string) (time.Time, error)
 	return modTime(storage.root + "/" + path)
 }
 
+// FileSize returns size of file in bytes
+func (storage PlaintextStorage) FileSize(path string) (int64, error) {
+	return fileSize(storage.root + "/" + path)
+}
+
 // TouchFile creates files given absolute path if file does not already exist
 func (storage PlaintextStorage) TouchFile(path string) error {
+	defer storage.barrier.enter()()
 	return touch(storage.root + "/" + path)
 }
 
 // Mkdir creates directory given absolute path
 func (storage PlaintextStorage) Mkdir(path string) error {
+	defer storage.barrier.enter()()
 	return mkdir(storage.root + "/" + path)
 }
 
 // Delete removes given absolute path if that file does exists
 func (storage PlaintextStorage) Delete(path string) error {
+	defer storage.barrier.enter()()
 	return os.RemoveAll(filepath.Clean(storage.root + "/" + path))
 }
 
 // ReadFileFully reads whole file given path
 func (storage PlaintextStorage) ReadFileFully(path string) ([]byte, error) {
 	filename := filepath.Clean(storage.root + "/" + path)
-	fd, err := syscall.Open(filename, syscall.O_RDONLY|syscall.O_NONBLOCK, 0600)
+	fd, direct, err := openFile(filename, syscall.O_RDONLY|syscall.O_NONBLOCK, 0600, storage.directIO, storage.noAtime)
 	if err != nil {
 		return nil, err
 	}
+	defer storage.handles.track(filename, "read")()
 	defer syscall.Close(fd)
-	if err = syscall.Flock(fd, syscall.LOCK_EX); err != nil {
+	if err = flock(fd, filename, syscall.LOCK_EX, storage.lockTimeout); err != nil {
 		return nil, err
 	}
-	defer syscall.Flock(fd, syscall.LOCK_UN)
+	defer funlock(fd, filename)
 	var fs syscall.Stat_t
 	if err = syscall.Fstat(fd, &fs); err != nil {
 		return nil, err
 	}
+	if direct {
+		return readDirect(fd, fs.Size)
+	}
 	buf := make([]byte, fs.Size)
-	if _, err = syscall.Read(fd, buf); err != nil && err != io.EOF {
+	n, err := readFull(fd, buf)
+	if err != nil {
 		return nil, err
 	}
-	return buf, nil
+	return buf[:n], nil
+}
+
+// GetFileReader returns reader streaming file given path, file is share
+// locked until reader is closed
+func (storage PlaintextStorage) GetFileReader(path string) (io.ReadCloser, error) {
+	return openReader(storage, path)
 }
 
 // WriteFileExclusive writes data given path to a file if that file does not
 // already exists
 func (storage PlaintextStorage) WriteFileExclusive(path string, data []byte) error {
+	defer storage.barrier.enter()()
 	filename := filepath.Clean(storage.root + "/" + path)
+	defer lockPath(filename).Unlock()
 	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
 		return err
 	}
-	fd, err := syscall.Open(filename, syscall.O_CREAT|syscall.O_WRONLY|syscall.O_EXCL|syscall.O_NONBLOCK, 0600)
+	fd, err := openRetrying(filename, syscall.O_CREAT|syscall.O_WRONLY|syscall.O_EXCL|syscall.O_NONBLOCK, 0600)
 	if err != nil {
 		return err
 	}
+	defer storage.handles.track(filename, "exclusive")()
 	defer func() {
 		syscall.Close(fd)
 		syscall.Fsync(fd)
 	}()
-	if err = syscall.Flock(fd, syscall.LOCK_EX); err != nil {
-		return err
-	}
-	defer syscall.Flock(fd, syscall.LOCK_UN)
-	if _, err := syscall.Write(fd, data); err != nil {
+	if err = flock(fd, filename, syscall.LOCK_EX, storage.lockTimeout); err != nil {
 		return err
 	}
-	return nil
+	defer funlock(fd, filename)
+	return writeFull(fd, data)
 }
 
 // WriteFile writes data given absolute path to a file, creates it if it does
 // not exist
 func (storage PlaintextStorage) WriteFile(path string, data []byte) error {
+	defer storage.barrier.enter()()
 	filename := filepath.Clean(storage.root + "/" + path)
+	defer lockPath(filename).Unlock()
 	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
 		return err
 	}
-	fd, err := syscall.Open(filename, syscall.O_CREAT|syscall.O_WRONLY|syscall.O_TRUNC|syscall.O_NONBLOCK, 0600)
+	fd, direct, err := openFile(filename, syscall.O_CREAT|syscall.O_WRONLY|syscall.O_NONBLOCK, 0600, storage.directIO, false)
 	if err != nil {
 		return err
 	}
+	defer storage.handles.track(filename, "write")()
 	defer func() {
 		syscall.Close(fd)
 		syscall.Fsync(fd)
 	}()
-	if err = syscall.Flock(fd, syscall.LOCK_EX); err != nil {
+	if err = flock(fd, filename, syscall.LOCK_EX, storage.lockTimeout); err != nil {
 		return err
 	}
-	defer syscall.Flock(fd, syscall.LOCK_UN)
-	if _, err := syscall.Write(fd, data); err != nil {
+	defer funlock(fd, filename)
+	// truncate under lock so mapped readers never see file shrink
+	if err = syscall.Ftruncate(fd, 0); err != nil {
 		return err
 	}
-	return nil
+	if direct {
+		return writeDirect(fd, [][]byte{data})
+	}
+	return writeFull(fd, data)
 }
 
 // AppendFile appens data given absolute path to a file, creates it if it does
 // not exist
 func (storage PlaintextStorage) AppendFile(path string, data []byte) error {
+	defer storage.barrier.enter()()
 	filename := filepath.Clean(storage.root + "/" + path)
+	defer lockPath(filename).Unlock()
 	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
 		return err
 	}
-	fd, err := syscall.Open(filename, syscall.O_CREAT|syscall.O_WRONLY|syscall.O_APPEND|syscall.O_NONBLOCK, 0600)
+	fd, err := openRetrying(filename, syscall.O_CREAT|syscall.O_WRONLY|syscall.O_APPEND|syscall.O_NONBLOCK, 0600)
 	if err != nil {
 		return err
 	}
+	defer storage.handles.track(filename, "append")()
 	defer func() {
 		syscall.Close(fd)
 		syscall.Fsync(fd)
 	}()
-	if err = syscall.Flock(fd, syscall.LOCK_EX); err != nil {
-		return err
-	}
-	defer syscall.Flock(fd, syscall.LOCK_UN)
-	if _, err := syscall.Write(fd, data); err != nil {
+	if err = flock(fd, filename, syscall.LOCK_EX, storage.lockTimeout); err != nil {
 		return err
 	}
-	return nil
+	defer funlock(fd, filename)
+	return writeFull(fd, data)
 }
@@ -3,6 +3,7 @@ package storage
 import (
 	"crypto/rand"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -319,3 +320,57 @@ func BenchmarkReadFileFullyPlaintext(b *testing.B) {
 		storage.ReadFileFully(basePath)
 	}
 }
+
+func TestFileSizePlaintext(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	storage.WriteFile("foo", make([]byte, 1234))
+
+	if size, err := storage.FileSize("foo"); err != nil || size != 1234 {
+		t.Errorf("expected size 1234 got %d %+v", size, err)
+	}
+	if _, err := storage.FileSize("missing"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error got %+v", err)
+	}
+}
+
+func TestGetFileReaderPlaintext(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	storage.WriteFile("foo", []byte("abcdef"))
+
+	reader, err := storage.GetFileReader("foo")
+	if err != nil {
+		t.Fatalf("unexpected error when calling GetFileReader %+v", err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil || string(data) != "abcdef" {
+		t.Errorf("expected abcdef got %q %+v", data, err)
+	}
+	status, _ := storage.(PlaintextStorage).LockStatus("foo")
+	if !status.Locked || status.Exclusive {
+		t.Errorf("expected shared lock held until Close got %+v", status)
+	}
+	if err = reader.Close(); err != nil {
+		t.Errorf("unexpected error when calling Close %+v", err)
+	}
+	if status, _ = storage.(PlaintextStorage).LockStatus("foo"); status.Locked {
+		t.Errorf("expected lock released by Close got %+v", status)
+	}
+	if err = reader.Close(); err != os.ErrClosed {
+		t.Errorf("expected ErrClosed from second Close got %+v", err)
+	}
+	if _, err = storage.GetFileReader("missing"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error got %+v", err)
+	}
+}
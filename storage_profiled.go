@@ -0,0 +1,209 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DevProfile describes production like characteristics emulated on
+// developer machine
+type DevProfile struct {
+	// MedianLatency is median of log-normal latency added to every operation
+	MedianLatency time.Duration
+	// LatencySigma is shape of log-normal latency distribution, higher value
+	// means longer tail
+	LatencySigma float64
+	// ErrorRate is probability in [0, 1] that operation fails with EIO
+	ErrorRate float64
+	// FsyncDelay is added to every mutating operation to emulate slow fsync
+	FsyncDelay time.Duration
+	// Seed makes sequence of latencies and failures reproducible
+	Seed int64
+}
+
+// NFSProfile emulates NFS mounted storage root
+var NFSProfile = DevProfile{
+	MedianLatency: 2 * time.Millisecond,
+	LatencySigma:  0.8,
+	ErrorRate:     0.001,
+	FsyncDelay:    10 * time.Millisecond,
+}
+
+// ProfiledStorage is a fascade that emulates latency and failures of
+// underlying storage given DevProfile
+type ProfiledStorage struct {
+	Storage
+	profile DevProfile
+	mutex   *sync.Mutex
+	random  *rand.Rand
+}
+
+// NewProfiledStorage returns storage emulating given profile over underlying
+// storage, never use it in production
+func NewProfiledStorage(underlying Storage, profile DevProfile) Storage {
+	return ProfiledStorage{
+		Storage: underlying,
+		profile: profile,
+		mutex:   new(sync.Mutex),
+		random:  rand.New(rand.NewSource(profile.Seed)),
+	}
+}
+
+func (storage ProfiledStorage) unwrap() Storage {
+	return storage.Storage
+}
+
+// simulate sleeps for sampled latency and returns EIO with configured
+// probability
+func (storage ProfiledStorage) simulate(op string, path string, mutation bool) error {
+	storage.mutex.Lock()
+	latency := time.Duration(0)
+	if storage.profile.MedianLatency > 0 {
+		latency = time.Duration(float64(storage.profile.MedianLatency) * math.Exp(storage.random.NormFloat64()*storage.profile.LatencySigma))
+	}
+	failed := storage.profile.ErrorRate > 0 && storage.random.Float64() < storage.profile.ErrorRate
+	storage.mutex.Unlock()
+	if mutation {
+		latency += storage.profile.FsyncDelay
+	}
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	if failed {
+		return &os.PathError{Op: op, Path: path, Err: syscall.EIO}
+	}
+	return nil
+}
+
+// Chmod sets chmod flag on given file
+func (storage ProfiledStorage) Chmod(path string, mod os.FileMode) error {
+	if err := storage.simulate("chmod", path, true); err != nil {
+		return err
+	}
+	return storage.Storage.Chmod(path, mod)
+}
+
+// ListDirectory returns sorted slice of item names in given path
+func (storage ProfiledStorage) ListDirectory(path string, ascending bool) ([]string, error) {
+	if err := storage.simulate("readdirent", path, false); err != nil {
+		return nil, err
+	}
+	return storage.Storage.ListDirectory(path, ascending)
+}
+
+// CountFiles returns number of items in directory
+func (storage ProfiledStorage) CountFiles(path string) (int, error) {
+	if err := storage.simulate("readdirent", path, false); err != nil {
+		return 0, err
+	}
+	return storage.Storage.CountFiles(path)
+}
+
+// Exists returns true if path exists
+func (storage ProfiledStorage) Exists(path string) (bool, error) {
+	if err := storage.simulate("stat", path, false); err != nil {
+		return false, err
+	}
+	return storage.Storage.Exists(path)
+}
+
+// LastModification returns time of last modification
+func (storage ProfiledStorage) LastModification(path string) (time.Time, error) {
+	if err := storage.simulate("stat", path, false); err != nil {
+		return time.Now(), err
+	}
+	return storage.Storage.LastModification(path)
+}
+
+// TouchFile creates file given path if file does not already exist
+func (storage ProfiledStorage) TouchFile(path string) error {
+	if err := storage.simulate("open", path, true); err != nil {
+		return err
+	}
+	return storage.Storage.TouchFile(path)
+}
+
+// Mkdir creates directory given path
+func (storage ProfiledStorage) Mkdir(path string) error {
+	if err := storage.simulate("mkdir", path, true); err != nil {
+		return err
+	}
+	return storage.Storage.Mkdir(path)
+}
+
+// Delete removes given path
+func (storage ProfiledStorage) Delete(path string) error {
+	if err := storage.simulate("unlink", path, true); err != nil {
+		return err
+	}
+	return storage.Storage.Delete(path)
+}
+
+// FileSize returns size of file in bytes
+func (storage ProfiledStorage) FileSize(path string) (int64, error) {
+	if err := storage.simulate("stat", path, false); err != nil {
+		return 0, err
+	}
+	return storage.Storage.FileSize(path)
+}
+
+// ReadFileFully reads whole file given path
+func (storage ProfiledStorage) ReadFileFully(path string) ([]byte, error) {
+	if err := storage.simulate("read", path, false); err != nil {
+		return nil, err
+	}
+	return storage.Storage.ReadFileFully(path)
+}
+
+// GetFileReader returns reader of file given path
+func (storage ProfiledStorage) GetFileReader(path string) (io.ReadCloser, error) {
+	if err := storage.simulate("read", path, false); err != nil {
+		return nil, err
+	}
+	return storage.Storage.GetFileReader(path)
+}
+
+// WriteFileExclusive writes data given path to a file if that file does not
+// already exists
+func (storage ProfiledStorage) WriteFileExclusive(path string, data []byte) error {
+	if err := storage.simulate("write", path, true); err != nil {
+		return err
+	}
+	return storage.Storage.WriteFileExclusive(path, data)
+}
+
+// WriteFile writes data given path to a file, creates it if it does not exist
+func (storage ProfiledStorage) WriteFile(path string, data []byte) error {
+	if err := storage.simulate("write", path, true); err != nil {
+		return err
+	}
+	return storage.Storage.WriteFile(path, data)
+}
+
+// AppendFile appends data given path to a file, creates it if it does not
+// exist
+func (storage ProfiledStorage) AppendFile(path string, data []byte) error {
+	if err := storage.simulate("write", path, true); err != nil {
+		return err
+	}
+	return storage.Storage.AppendFile(path, data)
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestProfiledStorage(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	underlying, _ := NewPlaintextStorage(tmpdir)
+
+	t.Log("slow fsync")
+	{
+		storage := NewProfiledStorage(underlying, DevProfile{FsyncDelay: 20 * time.Millisecond})
+		start := time.Now()
+		if err = storage.WriteFile("foo", []byte("abc")); err != nil {
+			t.Fatalf("unexpected error when calling WriteFile %+v", err)
+		}
+		if time.Since(start) < 20*time.Millisecond {
+			t.Errorf("expected WriteFile to be delayed by fsync delay")
+		}
+	}
+
+	t.Log("failures")
+	{
+		storage := NewProfiledStorage(underlying, DevProfile{ErrorRate: 1})
+		_, err = storage.ReadFileFully("foo")
+		if !errors.Is(err, syscall.EIO) {
+			t.Errorf("expected EIO got %+v", err)
+		}
+	}
+
+	t.Log("reproducible")
+	{
+		profile := DevProfile{ErrorRate: 0.5, Seed: 42}
+		a := NewProfiledStorage(underlying, profile)
+		b := NewProfiledStorage(underlying, profile)
+		for i := 0; i < 20; i++ {
+			_, errA := a.Exists("foo")
+			_, errB := b.Exists("foo")
+			if (errA == nil) != (errB == nil) {
+				t.Fatalf("expected same failure sequence given same seed")
+			}
+		}
+	}
+}
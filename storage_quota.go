@@ -0,0 +1,223 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrQuotaExceeded is returned when mutation would exceed storage quota
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+type quotaUsage struct {
+	sync.Mutex
+	bytes int64
+	files int64
+}
+
+// QuotaStorage is a fascade enforcing limits of total bytes and total number
+// of files under root
+type QuotaStorage struct {
+	Storage
+	root     string
+	maxBytes int64
+	maxFiles int64
+	usage    *quotaUsage
+}
+
+// NewQuotaStorage returns storage enforcing given limits over underlying
+// storage, non positive limit means unlimited
+func NewQuotaStorage(underlying Storage, maxBytes int64, maxFiles int64) (Storage, error) {
+	root, ok := rootOf(underlying)
+	if !ok {
+		return NilStorage{}, fmt.Errorf("quota requires local storage")
+	}
+	usage := new(quotaUsage)
+	err := filepath.WalkDir(filepath.Clean(root), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && entry.Name() == ControlDirectory && filepath.Dir(path) == filepath.Clean(root) {
+			return filepath.SkipDir
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		usage.bytes += info.Size()
+		usage.files++
+		return nil
+	})
+	if err != nil {
+		return NilStorage{}, err
+	}
+	return QuotaStorage{
+		Storage:  underlying,
+		root:     root,
+		maxBytes: maxBytes,
+		maxFiles: maxFiles,
+		usage:    usage,
+	}, nil
+}
+
+func (storage QuotaStorage) unwrap() Storage {
+	return storage.Storage
+}
+
+// Usage returns currently used bytes and files
+func (storage QuotaStorage) Usage() (int64, int64) {
+	storage.usage.Lock()
+	defer storage.usage.Unlock()
+	return storage.usage.bytes, storage.usage.files
+}
+
+func (storage QuotaStorage) size(path string) (int64, bool) {
+	info, err := os.Stat(filepath.Clean(storage.root + "/" + path))
+	if err != nil || !info.Mode().IsRegular() {
+		return 0, false
+	}
+	return info.Size(), true
+}
+
+// reserve checks that growth by given delta fits quota and accounts it right
+// away so concurrent mutations see each other, shrinking is accounted only
+// once it happened
+func (storage QuotaStorage) reserve(bytes int64, files int64) (int64, int64, error) {
+	if bytes < 0 {
+		bytes = 0
+	}
+	if files < 0 {
+		files = 0
+	}
+	storage.usage.Lock()
+	defer storage.usage.Unlock()
+	if storage.maxBytes > 0 && bytes > 0 && storage.usage.bytes+bytes > storage.maxBytes {
+		return 0, 0, ErrQuotaExceeded
+	}
+	if storage.maxFiles > 0 && files > 0 && storage.usage.files+files > storage.maxFiles {
+		return 0, 0, ErrQuotaExceeded
+	}
+	storage.usage.bytes += bytes
+	storage.usage.files += files
+	return bytes, files, nil
+}
+
+// mutate performs mutation of single file and reconciles reserved usage with
+// actual size on disk afterwards, size is measured and reconciled under
+// ordering lock of path so concurrent mutations of same path do not account
+// growth from same size
+func (storage QuotaStorage) mutate(path string, growth func(int64) int64, action func() error) error {
+	defer lockPathOrder(&quotaOrder, filepath.Clean(storage.root+"/"+path)).Unlock()
+	before, existed := storage.size(path)
+	var files int64
+	if !existed {
+		files = 1
+	}
+	reservedBytes, reservedFiles, err := storage.reserve(growth(before), files)
+	if err != nil {
+		return err
+	}
+	err = action()
+	after, exists := storage.size(path)
+	files = 0
+	if exists && !existed {
+		files = 1
+	} else if !exists && existed {
+		files = -1
+	}
+	storage.usage.Lock()
+	storage.usage.bytes += after - before - reservedBytes
+	storage.usage.files += files - reservedFiles
+	storage.usage.Unlock()
+	return err
+}
+
+// TouchFile creates file given path if file does not already exist
+func (storage QuotaStorage) TouchFile(path string) error {
+	return storage.mutate(path, func(int64) int64 {
+		return 0
+	}, func() error {
+		return storage.Storage.TouchFile(path)
+	})
+}
+
+// WriteFileExclusive writes data given path to a file if that file does not
+// already exists
+func (storage QuotaStorage) WriteFileExclusive(path string, data []byte) error {
+	return storage.mutate(path, func(int64) int64 {
+		return int64(len(data))
+	}, func() error {
+		return storage.Storage.WriteFileExclusive(path, data)
+	})
+}
+
+// WriteFile writes data given path to a file, creates it if it does not exist
+func (storage QuotaStorage) WriteFile(path string, data []byte) error {
+	return storage.mutate(path, func(before int64) int64 {
+		return int64(len(data)) - before
+	}, func() error {
+		return storage.Storage.WriteFile(path, data)
+	})
+}
+
+func (storage QuotaStorage) replaceFile(path string, data []byte) error {
+	return storage.mutate(path, func(before int64) int64 {
+		return int64(len(data)) - before
+	}, func() error {
+		return replaceFile(storage.Storage, path, data)
+	})
+}
+
+// AppendFile appends data given path to a file, creates it if it does not
+// exist
+func (storage QuotaStorage) AppendFile(path string, data []byte) error {
+	return storage.mutate(path, func(int64) int64 {
+		return int64(len(data))
+	}, func() error {
+		return storage.Storage.AppendFile(path, data)
+	})
+}
+
+// Delete removes given path and releases its usage
+func (storage QuotaStorage) Delete(path string) error {
+	defer lockPathOrder(&quotaOrder, filepath.Clean(storage.root+"/"+path)).Unlock()
+	var bytes, files int64
+	filepath.WalkDir(filepath.Clean(storage.root+"/"+path), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			bytes += info.Size()
+			files++
+		}
+		return nil
+	})
+	if err := storage.Storage.Delete(path); err != nil {
+		return err
+	}
+	storage.usage.Lock()
+	storage.usage.bytes -= bytes
+	storage.usage.files -= files
+	storage.usage.Unlock()
+	return nil
+}
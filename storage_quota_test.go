@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestQuotaStorage(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	underlying, _ := NewPlaintextStorage(tmpdir)
+	if err = underlying.WriteFile("existing", make([]byte, 10)); err != nil {
+		t.Fatalf("unexpected error when calling WriteFile %+v", err)
+	}
+
+	storage, err := NewQuotaStorage(underlying, 100, 3)
+	if err != nil {
+		t.Fatalf("unexpected error when calling NewQuotaStorage %+v", err)
+	}
+	quota := storage.(QuotaStorage)
+
+	if bytes, files := quota.Usage(); bytes != 10 || files != 1 {
+		t.Errorf("expected initial usage 10 bytes in 1 file got %d bytes in %d files", bytes, files)
+	}
+
+	if err = storage.WriteFile("a", make([]byte, 50)); err != nil {
+		t.Fatalf("unexpected error when calling WriteFile %+v", err)
+	}
+	if err = storage.AppendFile("a", make([]byte, 50)); err != ErrQuotaExceeded {
+		t.Errorf("expected ErrQuotaExceeded got %+v", err)
+	}
+	if err = storage.WriteFile("a", make([]byte, 80)); err != nil {
+		t.Errorf("expected overwrite within quota to succeed got %+v", err)
+	}
+	if err = storage.TouchFile("b"); err != nil {
+		t.Fatalf("unexpected error when calling TouchFile %+v", err)
+	}
+	if err = storage.TouchFile("c"); err != ErrQuotaExceeded {
+		t.Errorf("expected ErrQuotaExceeded for file count got %+v", err)
+	}
+
+	if bytes, files := quota.Usage(); bytes != 90 || files != 3 {
+		t.Errorf("expected usage 90 bytes in 3 files got %d bytes in %d files", bytes, files)
+	}
+
+	if err = storage.Delete("a"); err != nil {
+		t.Fatalf("unexpected error when calling Delete %+v", err)
+	}
+	if bytes, files := quota.Usage(); bytes != 10 || files != 2 {
+		t.Errorf("expected usage 10 bytes in 2 files got %d bytes in %d files", bytes, files)
+	}
+
+	t.Log("concurrent writers do not overshoot quota")
+	{
+		storage, _ := NewQuotaStorage(underlying, 1000, 0)
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				storage.WriteFile(fmt.Sprintf("concurrent/%d", i), make([]byte, 900))
+			}(i)
+		}
+		wg.Wait()
+		if bytes, _ := storage.(QuotaStorage).Usage(); bytes > 1000 {
+			t.Errorf("expected usage within quota got %d bytes", bytes)
+		}
+		if count, _ := underlying.CountFiles("concurrent"); count != 1 {
+			t.Errorf("expected single write to fit quota got %d files", count)
+		}
+	}
+
+	t.Log("concurrent writers of same path keep usage exact")
+	{
+		root := tmpdir + "/same"
+		underlying, _ := NewPlaintextStorage(root)
+		storage, _ := NewQuotaStorage(underlying, 0, 0)
+		var wg sync.WaitGroup
+		for i := 0; i < 200; i++ {
+			for j := 0; j < 8; j++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					storage.WriteFile(fmt.Sprintf("file/%d", i), make([]byte, 10))
+				}(i)
+			}
+		}
+		wg.Wait()
+		if bytes, files := storage.(QuotaStorage).Usage(); bytes != 2000 || files != 200 {
+			t.Errorf("expected usage 2000 bytes in 200 files got %d bytes in %d files", bytes, files)
+		}
+	}
+
+	t.Log("stacks with other ordering decorators")
+	{
+		quota, _ := NewQuotaStorage(underlying, 0, 0)
+		journaled, _ := NewJournaledStorage(quota)
+		if err := journaled.WriteFile("stacked", []byte("data")); err != nil {
+			t.Errorf("unexpected error when calling WriteFile %+v", err)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"time"
+)
+
+// ErrInternal is matched by errors.Is for every InternalError
+var ErrInternal = errors.New("internal storage error")
+
+// InternalError represents panic recovered in storage operation
+type InternalError struct {
+	Op    string
+	Path  string
+	Value interface{}
+	Stack []byte
+}
+
+func (err *InternalError) Error() string {
+	return fmt.Sprintf("%s %s: internal storage error: %v", err.Op, err.Path, err.Value)
+}
+
+// Is reports InternalError to be ErrInternal
+func (err *InternalError) Is(target error) bool {
+	return target == ErrInternal
+}
+
+// Unwrap returns panic value when it was error
+func (err *InternalError) Unwrap() error {
+	if cause, ok := err.Value.(error); ok {
+		return cause
+	}
+	return nil
+}
+
+// recoverInternal converts panic of operation into InternalError stored into
+// err, it must be deferred directly
+func recoverInternal(op string, path string, err *error) {
+	if value := recover(); value != nil {
+		*err = &InternalError{
+			Op:    op,
+			Path:  path,
+			Value: value,
+			Stack: debug.Stack(),
+		}
+	}
+}
+
+// RecoveredStorage is a fascade converting panics of underlying storage, e.g.
+// from malformed directory entries or nil embedded storage of decorator, into
+// InternalError so single bad directory cannot crash whole process
+type RecoveredStorage struct {
+	Storage
+}
+
+// NewRecoveredStorage returns storage recovering panics of every operation
+// of underlying storage, it is meant to be outermost decorator
+func NewRecoveredStorage(underlying Storage) Storage {
+	if underlying == nil {
+		return NilStorage{}
+	}
+	return RecoveredStorage{
+		Storage: underlying,
+	}
+}
+
+func (storage RecoveredStorage) unwrap() Storage {
+	return storage.Storage
+}
+
+// Chmod sets chmod flag on given file
+func (storage RecoveredStorage) Chmod(path string, mod os.FileMode) (err error) {
+	defer recoverInternal("Chmod", path, &err)
+	return storage.Storage.Chmod(path, mod)
+}
+
+// ListDirectory returns sorted slice of item names in given path
+func (storage RecoveredStorage) ListDirectory(path string, ascending bool) (result []string, err error) {
+	defer recoverInternal("ListDirectory", path, &err)
+	return storage.Storage.ListDirectory(path, ascending)
+}
+
+// CountFiles returns number of items in directory
+func (storage RecoveredStorage) CountFiles(path string) (result int, err error) {
+	defer recoverInternal("CountFiles", path, &err)
+	return storage.Storage.CountFiles(path)
+}
+
+// Exists returns true if path exists
+func (storage RecoveredStorage) Exists(path string) (result bool, err error) {
+	defer recoverInternal("Exists", path, &err)
+	return storage.Storage.Exists(path)
+}
+
+// LastModification returns time of last modification
+func (storage RecoveredStorage) LastModification(path string) (result time.Time, err error) {
+	defer recoverInternal("LastModification", path, &err)
+	return storage.Storage.LastModification(path)
+}
+
+// FileSize returns size of file in bytes
+func (storage RecoveredStorage) FileSize(path string) (result int64, err error) {
+	defer recoverInternal("FileSize", path, &err)
+	return storage.Storage.FileSize(path)
+}
+
+// TouchFile creates file given path if file does not already exist
+func (storage RecoveredStorage) TouchFile(path string) (err error) {
+	defer recoverInternal("TouchFile", path, &err)
+	return storage.Storage.TouchFile(path)
+}
+
+// Mkdir creates directory given path
+func (storage RecoveredStorage) Mkdir(path string) (err error) {
+	defer recoverInternal("Mkdir", path, &err)
+	return storage.Storage.Mkdir(path)
+}
+
+// Delete removes given path
+func (storage RecoveredStorage) Delete(path string) (err error) {
+	defer recoverInternal("Delete", path, &err)
+	return storage.Storage.Delete(path)
+}
+
+// ReadFileFully reads whole file given path
+func (storage RecoveredStorage) ReadFileFully(path string) (result []byte, err error) {
+	defer recoverInternal("ReadFileFully", path, &err)
+	return storage.Storage.ReadFileFully(path)
+}
+
+// GetFileReader returns reader of file given path
+func (storage RecoveredStorage) GetFileReader(path string) (result io.ReadCloser, err error) {
+	defer recoverInternal("GetFileReader", path, &err)
+	return storage.Storage.GetFileReader(path)
+}
+
+// WriteFileExclusive writes data given path to a file if that file does not
+// already exists
+func (storage RecoveredStorage) WriteFileExclusive(path string, data []byte) (err error) {
+	defer recoverInternal("WriteFileExclusive", path, &err)
+	return storage.Storage.WriteFileExclusive(path, data)
+}
+
+// WriteFile writes data given path to a file, creates it if it does not exist
+func (storage RecoveredStorage) WriteFile(path string, data []byte) (err error) {
+	defer recoverInternal("WriteFile", path, &err)
+	return storage.Storage.WriteFile(path, data)
+}
+
+// AppendFile appends data given path to a file, creates it if it does not
+// exist
+func (storage RecoveredStorage) AppendFile(path string, data []byte) (err error) {
+	defer recoverInternal("AppendFile", path, &err)
+	return storage.Storage.AppendFile(path, data)
+}
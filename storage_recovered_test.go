@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRecoveredStorage(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	t.Log("nil embedded storage")
+	{
+		storage := NewRecoveredStorage(TracedStorage{})
+		_, err := storage.ReadFileFully("foo")
+		if !errors.Is(err, ErrInternal) {
+			t.Fatalf("expected ErrInternal got %+v", err)
+		}
+		var internal *InternalError
+		if !errors.As(err, &internal) {
+			t.Fatalf("expected InternalError got %+v", err)
+		}
+		if internal.Op != "ReadFileFully" || internal.Path != "foo" || len(internal.Stack) == 0 {
+			t.Errorf("unexpected internal error %+v", internal)
+		}
+	}
+
+	t.Log("operations pass through")
+	{
+		plaintext, _ := NewPlaintextStorage(tmpdir)
+		storage := NewRecoveredStorage(plaintext)
+		if err = storage.WriteFile("foo", []byte("abc")); err != nil {
+			t.Fatalf("unexpected error when calling WriteFile %+v", err)
+		}
+		if data, err := storage.ReadFileFully("foo"); err != nil || string(data) != "abc" {
+			t.Errorf("unexpected result of ReadFileFully %s %+v", string(data), err)
+		}
+	}
+
+	t.Log("panic during directory scan")
+	{
+		plaintext, _ := NewPlaintextStorage(tmpdir)
+		err := plaintext.(PlaintextStorage).ForEachEntry("", func(name string) bool {
+			panic("bad entry " + name)
+		})
+		if !errors.Is(err, ErrInternal) || !strings.Contains(err.Error(), "bad entry") {
+			t.Errorf("expected ErrInternal got %+v", err)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// S3Config describes S3 compatible object store
+type S3Config struct {
+	// Endpoint is base URL of object store e.g. https://s3.eu-west-1.amazonaws.com
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is key prefix under which storage root lives
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	// VirtualHost addresses bucket as subdomain of endpoint instead of path
+	VirtualHost bool
+	Client      *http.Client
+}
+
+// S3Storage is a fascade to access S3 compatible object store with same
+// contract as local storage, directories are mapped to key prefixes
+type S3Storage struct {
+	Storage
+	config S3Config
+	client *http.Client
+}
+
+// NewS3Storage returns new storage over given bucket
+func NewS3Storage(config S3Config) (Storage, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return NilStorage{}, fmt.Errorf("invalid object store endpoint or bucket")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	config.Prefix = strings.Trim(config.Prefix, "/")
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: time.Minute}
+	}
+	return S3Storage{
+		config: config,
+		client: client,
+	}, nil
+}
+
+func (storage S3Storage) key(name string) string {
+	cleaned := strings.Trim(path.Clean("/"+name), "/")
+	if storage.config.Prefix == "" {
+		return cleaned
+	}
+	if cleaned == "" {
+		return storage.config.Prefix
+	}
+	return storage.config.Prefix + "/" + cleaned
+}
+
+func (storage S3Storage) directoryKey(name string) string {
+	key := storage.key(name)
+	if key == "" {
+		return ""
+	}
+	return key + "/"
+}
+
+func uriEncode(value string, encodeSlash bool) string {
+	var buffer strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			buffer.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&buffer, "%%%02X", c)
+	}
+	return buffer.String()
+}
+
+// canonicalQuery encodes query sorted by key the way signature version 4
+// expects, spaces are %20 instead of + of url.Values.Encode so same string
+// is signed and sent
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	query := make([]string, 0, len(keys))
+	for _, key := range keys {
+		for _, value := range values[key] {
+			query = append(query, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(query, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sign signs request with AWS signature version 4
+func (storage S3Storage) sign(request *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	request.Header.Set("x-amz-date", amzDate)
+	request.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	canonicalHeaders := "host:" + request.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		uriEncode(request.URL.Path, false),
+		canonicalQuery(request.URL.Query()),
+		canonicalHeaders,
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + storage.config.Region + "/s3/aws4_request"
+	digest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+storage.config.SecretKey), date)
+	signingKey = hmacSHA256(signingKey, storage.config.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		storage.config.AccessKey, scope, strings.Join(signedHeaders, ";"), signature,
+	))
+}
+
+func (storage S3Storage) objectURL(key string, query url.Values) (*url.URL, error) {
+	base, err := url.Parse(storage.config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if storage.config.VirtualHost {
+		base.Host = storage.config.Bucket + "." + base.Host
+		base.Path = "/" + key
+	} else {
+		base.Path = "/" + storage.config.Bucket + "/" + key
+	}
+	base.RawQuery = canonicalQuery(query)
+	return base, nil
+}
+
+func (storage S3Storage) do(method string, key string, query url.Values, body []byte, headers map[string]string) (*http.Response, error) {
+	target, err := storage.objectURL(key, query)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest(method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for header, value := range headers {
+		request.Header.Set(header, value)
+	}
+	digest := sha256.Sum256(body)
+	storage.sign(request, hex.EncodeToString(digest[:]), time.Now())
+	return storage.client.Do(request)
+}
+
+func s3Error(op string, key string, response *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+	switch response.StatusCode {
+	case http.StatusNotFound:
+		return &os.PathError{Op: op, Path: key, Err: os.ErrNotExist}
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		return &os.PathError{Op: op, Path: key, Err: os.ErrExist}
+	default:
+		return &os.PathError{Op: op, Path: key, Err: fmt.Errorf("object store responded %d %s", response.StatusCode, strings.TrimSpace(string(body)))}
+	}
+}
+
+type s3ListResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+// list calls fn for every page of objects under given prefix
+func (storage S3Storage) list(prefix string, delimiter string, limit int, fn func(s3ListResult) bool) error {
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if limit > 0 {
+			query.Set("max-keys", fmt.Sprintf("%d", limit))
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		target, err := storage.objectURL("", query)
+		if err != nil {
+			return err
+		}
+		target.Path = strings.TrimSuffix(target.Path, "/")
+		if target.Path == "" {
+			target.Path = "/"
+		}
+		request, err := http.NewRequest(http.MethodGet, target.String(), nil)
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256(nil)
+		storage.sign(request, hex.EncodeToString(digest[:]), time.Now())
+		response, err := storage.client.Do(request)
+		if err != nil {
+			return err
+		}
+		if response.StatusCode != http.StatusOK {
+			err = s3Error("list", prefix, response)
+			response.Body.Close()
+			return err
+		}
+		var page s3ListResult
+		err = xml.NewDecoder(response.Body).Decode(&page)
+		response.Body.Close()
+		if err != nil {
+			return err
+		}
+		if !fn(page) || !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// Chmod is noop because object store has no file modes
+func (storage S3Storage) Chmod(path string, mod os.FileMode) error {
+	return nil
+}
+
+// ListDirectory returns sorted slice of item names under given prefix
+func (storage S3Storage) ListDirectory(path string, ascending bool) ([]string, error) {
+	prefix := storage.directoryKey(path)
+	seen := make(map[string]bool)
+	result := make([]string, 0)
+	err := storage.list(prefix, "/", 0, func(page s3ListResult) bool {
+		for _, item := range page.Contents {
+			name := strings.TrimPrefix(item.Key, prefix)
+			if name != "" && !seen[name] {
+				seen[name] = true
+				result = append(result, name)
+			}
+		}
+		for _, item := range page.CommonPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(item.Prefix, prefix), "/")
+			if name != "" && !seen[name] {
+				seen[name] = true
+				result = append(result, name)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ascending {
+		sort.Strings(result)
+	} else {
+		sort.Sort(sort.Reverse(sort.StringSlice(result)))
+	}
+	return result, nil
+}
+
+// CountFiles returns number of objects directly under given prefix
+func (storage S3Storage) CountFiles(path string) (int, error) {
+	prefix := storage.directoryKey(path)
+	result := 0
+	err := storage.list(prefix, "/", 0, func(page s3ListResult) bool {
+		for _, item := range page.Contents {
+			if item.Key != prefix {
+				result++
+			}
+		}
+		return true
+	})
+	return result, err
+}
+
+func (storage S3Storage) head(path string) (*http.Response, error) {
+	response, err := storage.do(http.MethodHead, storage.key(path), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	response.Body.Close()
+	return response, nil
+}
+
+// Exists returns true if object or prefix exists
+func (storage S3Storage) Exists(path string) (bool, error) {
+	response, err := storage.head(path)
+	if err != nil {
+		return false, err
+	}
+	if response.StatusCode == http.StatusOK {
+		return true, nil
+	}
+	if response.StatusCode != http.StatusNotFound {
+		return false, s3Error("head", path, response)
+	}
+	found := false
+	err = storage.list(storage.directoryKey(path), "/", 1, func(page s3ListResult) bool {
+		found = len(page.Contents) > 0 || len(page.CommonPrefixes) > 0
+		return false
+	})
+	return found, err
+}
+
+// LastModification returns time of last modification of object
+func (storage S3Storage) LastModification(path string) (time.Time, error) {
+	response, err := storage.head(path)
+	if err != nil {
+		return time.Now(), err
+	}
+	if response.StatusCode != http.StatusOK {
+		return time.Now(), s3Error("head", path, response)
+	}
+	return http.ParseTime(response.Header.Get("Last-Modified"))
+}
+
+// FileSize returns size of object in bytes
+func (storage S3Storage) FileSize(path string) (int64, error) {
+	response, err := storage.head(path)
+	if err != nil {
+		return 0, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return 0, s3Error("head", path, response)
+	}
+	return response.ContentLength, nil
+}
+
+func (storage S3Storage) put(op string, path string, data []byte, headers map[string]string) error {
+	response, err := storage.do(http.MethodPut, storage.key(path), nil, data, headers)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return s3Error(op, path, response)
+	}
+	return nil
+}
+
+// TouchFile creates empty object if it does not already exist
+func (storage S3Storage) TouchFile(path string) error {
+	return storage.put("touch", path, nil, map[string]string{"If-None-Match": "*"})
+}
+
+// Mkdir creates directory marker object
+func (storage S3Storage) Mkdir(path string) error {
+	key := storage.directoryKey(path)
+	if key == "" {
+		return nil
+	}
+	response, err := storage.do(http.MethodPut, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return s3Error("mkdir", path, response)
+	}
+	return nil
+}
+
+// Delete removes object and all objects under its prefix, empty key is
+// rejected because DELETE of bucket root deletes bucket itself
+func (storage S3Storage) Delete(path string) error {
+	if storage.key(path) == "" {
+		return &os.PathError{Op: "delete", Path: path, Err: syscall.EINVAL}
+	}
+	keys := []string{storage.key(path)}
+	err := storage.list(storage.directoryKey(path), "", 0, func(page s3ListResult) bool {
+		for _, item := range page.Contents {
+			keys = append(keys, item.Key)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		response, err := storage.do(http.MethodDelete, key, nil, nil, nil)
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusNoContent && response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNotFound {
+			return s3Error("delete", key, response)
+		}
+	}
+	return nil
+}
+
+func (storage S3Storage) get(path string) ([]byte, string, error) {
+	response, err := storage.do(http.MethodGet, storage.key(path), nil, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, "", s3Error("read", path, response)
+	}
+	data, err := io.ReadAll(response.Body)
+	return data, response.Header.Get("ETag"), err
+}
+
+// ReadFileFully reads whole object
+func (storage S3Storage) ReadFileFully(path string) ([]byte, error) {
+	data, _, err := storage.get(path)
+	return data, err
+}
+
+// GetFileReader returns reader of object
+func (storage S3Storage) GetFileReader(path string) (io.ReadCloser, error) {
+	return readerOf(storage.ReadFileFully(path))
+}
+
+// WriteFileExclusive writes object via conditional put if it does not
+// already exists
+func (storage S3Storage) WriteFileExclusive(path string, data []byte) error {
+	return storage.put("write", path, data, map[string]string{"If-None-Match": "*"})
+}
+
+// WriteFile writes object
+func (storage S3Storage) WriteFile(path string, data []byte) error {
+	return storage.put("write", path, data, nil)
+}
+
+// put of object store replaces object atomically
+func (storage S3Storage) replaceFile(path string, data []byte) error {
+	return storage.WriteFile(path, data)
+}
+
+// AppendFile appends data to object using read-modify-write guarded by ETag,
+// creates object if it does not exist
+func (storage S3Storage) AppendFile(path string, data []byte) error {
+	for attempt := 0; attempt < 10; attempt++ {
+		head, etag, err := storage.get(path)
+		headers := map[string]string{"If-Match": etag}
+		if os.IsNotExist(err) {
+			headers = map[string]string{"If-None-Match": "*"}
+		} else if err != nil {
+			return err
+		}
+		err = storage.put("append", path, append(head, data...), headers)
+		if err == nil || !os.IsExist(err) {
+			return err
+		}
+	}
+	return fmt.Errorf("append to %s failed due to concurrent modification", path)
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ScopedStorage is a fascade confining storage to its subdirectory, every
+// path is resolved inside the subdirectory so ".." cannot reach siblings,
+// underlying storage is deliberately not embedded nor unwrapped so neither
+// its root nor operations added later leak through scoped handle
+type ScopedStorage struct {
+	underlying Storage
+	prefix     string
+}
+
+// Scope returns storage confined to given subdirectory of storage sharing its
+// configuration and keys
+func Scope(storage Storage, prefix string) (Storage, error) {
+	cleaned := strings.Trim(filepath.Clean("/"+prefix), "/")
+	if cleaned == "" {
+		return NilStorage{}, fmt.Errorf("invalid scope %q", prefix)
+	}
+	if scoped, ok := storage.(ScopedStorage); ok {
+		return ScopedStorage{
+			underlying: scoped.underlying,
+			prefix:     scoped.prefix + "/" + cleaned,
+		}, nil
+	}
+	return ScopedStorage{
+		underlying: storage,
+		prefix:     cleaned,
+	}, nil
+}
+
+// scoped returns path of underlying storage for path inside scope
+func (storage ScopedStorage) scoped(path string) string {
+	return storage.prefix + filepath.Clean("/"+path)
+}
+
+// Chmod sets chmod flag on given file
+func (storage ScopedStorage) Chmod(path string, mod os.FileMode) error {
+	return storage.underlying.Chmod(storage.scoped(path), mod)
+}
+
+// ListDirectory returns sorted slice of item names in given path
+func (storage ScopedStorage) ListDirectory(path string, ascending bool) ([]string, error) {
+	return storage.underlying.ListDirectory(storage.scoped(path), ascending)
+}
+
+// CountFiles returns number of items in directory
+func (storage ScopedStorage) CountFiles(path string) (int, error) {
+	return storage.underlying.CountFiles(storage.scoped(path))
+}
+
+// Exists returns true if path exists in storage
+func (storage ScopedStorage) Exists(path string) (bool, error) {
+	return storage.underlying.Exists(storage.scoped(path))
+}
+
+// LastModification returns time of last modification
+func (storage ScopedStorage) LastModification(path string) (time.Time, error) {
+	return storage.underlying.LastModification(storage.scoped(path))
+}
+
+// FileSize returns size of file in bytes
+func (storage ScopedStorage) FileSize(path string) (int64, error) {
+	return storage.underlying.FileSize(storage.scoped(path))
+}
+
+// TouchFile creates file given path if file does not already exist
+func (storage ScopedStorage) TouchFile(path string) error {
+	return storage.underlying.TouchFile(storage.scoped(path))
+}
+
+// Mkdir creates directory given path
+func (storage ScopedStorage) Mkdir(path string) error {
+	return storage.underlying.Mkdir(storage.scoped(path))
+}
+
+// Delete removes given path, deleting root of scope removes whole scope
+func (storage ScopedStorage) Delete(path string) error {
+	return storage.underlying.Delete(storage.scoped(path))
+}
+
+// ReadFileFully reads whole file given path
+func (storage ScopedStorage) ReadFileFully(path string) ([]byte, error) {
+	return storage.underlying.ReadFileFully(storage.scoped(path))
+}
+
+// GetFileReader returns reader of file given path
+func (storage ScopedStorage) GetFileReader(path string) (io.ReadCloser, error) {
+	return storage.underlying.GetFileReader(storage.scoped(path))
+}
+
+// WriteFileExclusive writes data given path to a file if that file does not
+// already exists
+func (storage ScopedStorage) WriteFileExclusive(path string, data []byte) error {
+	return storage.underlying.WriteFileExclusive(storage.scoped(path), data)
+}
+
+// WriteFile writes data given path to a file, creates it if it does not exist
+func (storage ScopedStorage) WriteFile(path string, data []byte) error {
+	return storage.underlying.WriteFile(storage.scoped(path), data)
+}
+
+func (storage ScopedStorage) replaceFile(path string, data []byte) error {
+	return replaceFile(storage.underlying, storage.scoped(path), data)
+}
+
+// AppendFile appends data given path to a file, creates it if it does not
+// exist
+func (storage ScopedStorage) AppendFile(path string, data []byte) error {
+	return storage.underlying.AppendFile(storage.scoped(path), data)
+}
+
+// ReadFileRange returns at most length bytes of file starting at offset
+func (storage ScopedStorage) ReadFileRange(path string, offset int64, length int64) ([]byte, error) {
+	return ReadFileRange(storage.underlying, storage.scoped(path), offset, length)
+}
+
+// WriteFileAt writes data into file at offset leaving rest of file intact
+func (storage ScopedStorage) WriteFileAt(path string, offset int64, data []byte) error {
+	return WriteFileAt(storage.underlying, storage.scoped(path), offset, data)
+}
+
+// LockFile acquires advisory lock on file creating the file when missing
+func (storage ScopedStorage) LockFile(path string, exclusive bool) (*Lock, error) {
+	return LockFile(storage.underlying, storage.scoped(path), exclusive)
+}
+
+// TryLockFile acquires advisory lock on file without waiting for it
+func (storage ScopedStorage) TryLockFile(path string, exclusive bool) (*Lock, error) {
+	return TryLockFile(storage.underlying, storage.scoped(path), exclusive)
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestScopedStorage(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	underlying, _ := NewEncryptedStorage(tmpdir, getKey())
+	underlying.WriteFile("tenant_b/account/A", []byte("secret"))
+
+	storage, err := Scope(underlying, "tenant_a")
+	if err != nil {
+		t.Fatalf("unexpected error when calling Scope %+v", err)
+	}
+
+	t.Log("writes land in subdirectory")
+	{
+		if err := storage.WriteFile("account/A", []byte("balance 100")); err != nil {
+			t.Fatalf("unexpected error when calling WriteFile %+v", err)
+		}
+		if data, err := underlying.ReadFileFully("tenant_a/account/A"); err != nil || string(data) != "balance 100" {
+			t.Errorf("expected file in scope directory got %q %+v", data, err)
+		}
+		if data, err := storage.ReadFileFully("/account/A"); err != nil || string(data) != "balance 100" {
+			t.Errorf("expected balance 100 got %q %+v", data, err)
+		}
+		if names, err := storage.ListDirectory("/", true); err != nil || len(names) != 1 || names[0] != "account" {
+			t.Errorf("expected only account directory got %+v %+v", names, err)
+		}
+	}
+
+	t.Log("cannot escape to sibling")
+	{
+		if _, err := storage.ReadFileFully("../tenant_b/account/A"); !os.IsNotExist(err) {
+			t.Errorf("expected not exist error got %+v", err)
+		}
+		if exists, _ := storage.Exists("../../tenant_b/account/A"); exists {
+			t.Errorf("expected sibling tenant to be unreachable")
+		}
+		storage.Delete("../tenant_b")
+		if exists, _ := underlying.Exists("tenant_b/account/A"); !exists {
+			t.Errorf("expected sibling tenant to survive delete")
+		}
+	}
+
+	t.Log("nested scope")
+	{
+		nested, _ := Scope(storage, "../account")
+		if data, err := nested.ReadFileFully("A"); err != nil || string(data) != "balance 100" {
+			t.Errorf("expected balance 100 got %q %+v", data, err)
+		}
+	}
+
+	t.Log("optional capabilities pass through")
+	{
+		if data, err := ReadFileRange(storage, "account/A", 8, 3); err != nil || string(data) != "100" {
+			t.Errorf("expected 100 got %q %+v", data, err)
+		}
+		lock, err := LockFile(storage, "account/A", true)
+		if err != nil {
+			t.Fatalf("unexpected error when calling LockFile %+v", err)
+		}
+		lock.Unlock()
+	}
+
+	t.Log("hides root of underlying storage")
+	{
+		if _, ok := rootOf(storage); ok {
+			t.Errorf("expected scoped storage not to expose root")
+		}
+	}
+
+	t.Log("rejects empty scope")
+	{
+		if _, err := Scope(underlying, "/../"); err == nil {
+			t.Errorf("expected error for empty scope")
+		}
+	}
+}
@@ -0,0 +1,317 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"time"
+)
+
+// ShardedStorage is a fascade fanning files of every directory out into two
+// levels of hashed subdirectories "~xx/~yy" so no single directory holds
+// millions of entries, directories keep their logical paths and files of
+// flat layout are still read until they are migrated with MigrateFlat
+type ShardedStorage struct {
+	Storage
+}
+
+// NewShardedStorage returns storage fanning files out into hashed
+// subdirectories of underlying storage
+func NewShardedStorage(underlying Storage) Storage {
+	return ShardedStorage{
+		Storage: underlying,
+	}
+}
+
+func (storage ShardedStorage) unwrap() Storage {
+	return storage.Storage
+}
+
+// shardPath returns physical path of file
+func shardPath(name string) string {
+	dir, base := splitPath(name)
+	hash := fnv.New32a()
+	hash.Write([]byte(base))
+	sum := hash.Sum32()
+	shard := fmt.Sprintf("~%02x/~%02x/%s", byte(sum>>24), byte(sum>>16), base)
+	if dir == "" {
+		return shard
+	}
+	return dir + "/" + shard
+}
+
+// isShard returns true for name of shard directory
+func isShard(name string) bool {
+	if len(name) != 3 || name[0] != '~' {
+		return false
+	}
+	for _, c := range name[1:] {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// join returns child path of directory
+func join(dir string, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// resolve returns physical path of file, sharded when it exists and flat
+// otherwise
+func (storage ShardedStorage) resolve(name string) (string, error) {
+	sharded := shardPath(name)
+	ok, err := storage.Storage.Exists(sharded)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return sharded, nil
+	}
+	return name, nil
+}
+
+// flatFile returns true when file of flat layout exists, directory of same
+// name is not a file
+func (storage ShardedStorage) flatFile(name string) (bool, error) {
+	ok, err := storage.Storage.Exists(name)
+	if err != nil || !ok {
+		return false, err
+	}
+	_, err = storage.Storage.ListDirectory(name, true)
+	return err != nil, nil
+}
+
+// forEachShard calls fn with every second level shard directory of dir
+func (storage ShardedStorage) forEachShard(dir string, names []string, fn func(shard string) error) error {
+	for _, first := range names {
+		if !isShard(first) {
+			continue
+		}
+		seconds, err := storage.Storage.ListDirectory(join(dir, first), true)
+		if err != nil {
+			return err
+		}
+		for _, second := range seconds {
+			if !isShard(second) {
+				continue
+			}
+			if err = fn(join(dir, first+"/"+second)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Chmod sets chmod flag on given file or directory
+func (storage ShardedStorage) Chmod(name string, mod os.FileMode) error {
+	resolved, err := storage.resolve(name)
+	if err != nil {
+		return err
+	}
+	return storage.Storage.Chmod(resolved, mod)
+}
+
+// ListDirectory returns sorted logical names in directory, files of all its
+// shards together with its subdirectories and not yet migrated files
+func (storage ShardedStorage) ListDirectory(path string, ascending bool) ([]string, error) {
+	dir, base := splitPath(path)
+	dir = join(dir, base)
+	names, err := storage.Storage.ListDirectory(dir, true)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(names))
+	for _, name := range names {
+		if !isShard(name) {
+			result = append(result, name)
+		}
+	}
+	err = storage.forEachShard(dir, names, func(shard string) error {
+		files, err := storage.Storage.ListDirectory(shard, true)
+		result = append(result, files...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortNames(result, SortLexicographic, ascending)
+	return result, nil
+}
+
+// CountFiles returns number of files in directory including all its shards
+func (storage ShardedStorage) CountFiles(path string) (int, error) {
+	dir, base := splitPath(path)
+	dir = join(dir, base)
+	count, err := storage.Storage.CountFiles(dir)
+	if err != nil {
+		return 0, err
+	}
+	names, err := storage.Storage.ListDirectory(dir, true)
+	if err != nil {
+		return 0, err
+	}
+	err = storage.forEachShard(dir, names, func(shard string) error {
+		files, err := storage.Storage.CountFiles(shard)
+		count += files
+		return err
+	})
+	return count, err
+}
+
+// Exists returns true if file or directory exists
+func (storage ShardedStorage) Exists(name string) (bool, error) {
+	ok, err := storage.Storage.Exists(shardPath(name))
+	if err != nil || ok {
+		return ok, err
+	}
+	return storage.Storage.Exists(name)
+}
+
+// LastModification returns time of last modification of file or directory
+func (storage ShardedStorage) LastModification(name string) (time.Time, error) {
+	resolved, err := storage.resolve(name)
+	if err != nil {
+		return time.Now(), err
+	}
+	return storage.Storage.LastModification(resolved)
+}
+
+// FileSize returns size of file in bytes
+func (storage ShardedStorage) FileSize(name string) (int64, error) {
+	resolved, err := storage.resolve(name)
+	if err != nil {
+		return 0, err
+	}
+	return storage.Storage.FileSize(resolved)
+}
+
+// TouchFile creates file in its shard if file does not already exist
+func (storage ShardedStorage) TouchFile(name string) error {
+	return storage.Storage.TouchFile(shardPath(name))
+}
+
+// ReadFileFully reads whole file
+func (storage ShardedStorage) ReadFileFully(name string) ([]byte, error) {
+	resolved, err := storage.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return storage.Storage.ReadFileFully(resolved)
+}
+
+// GetFileReader returns reader of file
+func (storage ShardedStorage) GetFileReader(name string) (io.ReadCloser, error) {
+	resolved, err := storage.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return storage.Storage.GetFileReader(resolved)
+}
+
+// WriteFileExclusive writes file into its shard if file does not already
+// exists
+func (storage ShardedStorage) WriteFileExclusive(name string, data []byte) error {
+	ok, err := storage.flatFile(name)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	}
+	return storage.Storage.WriteFileExclusive(shardPath(name), data)
+}
+
+// WriteFile writes file into its shard removing file of flat layout
+func (storage ShardedStorage) WriteFile(name string, data []byte) error {
+	if err := storage.Storage.WriteFile(shardPath(name), data); err != nil {
+		return err
+	}
+	ok, err := storage.flatFile(name)
+	if err != nil || !ok {
+		return err
+	}
+	return storage.Storage.Delete(name)
+}
+
+// AppendFile appends data to file, files of flat layout are appended in
+// place
+func (storage ShardedStorage) AppendFile(name string, data []byte) error {
+	resolved := shardPath(name)
+	ok, err := storage.flatFile(name)
+	if err != nil {
+		return err
+	}
+	if ok {
+		resolved = name
+	}
+	return storage.Storage.AppendFile(resolved, data)
+}
+
+// Delete removes file from its shard or directory with all its shards
+func (storage ShardedStorage) Delete(name string) error {
+	resolved, err := storage.resolve(name)
+	if err != nil {
+		return err
+	}
+	return storage.Storage.Delete(resolved)
+}
+
+// MigrateFlat moves files of flat layout in directory and its subdirectories
+// into shards, returns number of moved files, interrupted migration is
+// resumed by running it again
+func (storage ShardedStorage) MigrateFlat(path string) (int, error) {
+	dir, base := splitPath(path)
+	dir = join(dir, base)
+	names, err := storage.Storage.ListDirectory(dir, true)
+	if err != nil {
+		return 0, err
+	}
+	moved := 0
+	for _, name := range names {
+		if isShard(name) {
+			continue
+		}
+		child := join(dir, name)
+		flat, err := storage.flatFile(child)
+		if err != nil {
+			return moved, err
+		}
+		if !flat {
+			count, err := storage.MigrateFlat(child)
+			moved += count
+			if err != nil {
+				return moved, err
+			}
+			continue
+		}
+		if err = Transfer(storage.Storage, shardPath(child), storage.Storage, child); err != nil {
+			return moved, err
+		}
+		if err = storage.Storage.Delete(child); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	return moved, nil
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestShardedStorage(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	underlying, _ := NewEncryptedStorageWithOptions(tmpdir, getKey(), EncryptionOptions{AEAD: true})
+	storage := NewShardedStorage(underlying)
+
+	t.Log("files fan out into shards")
+	{
+		for i := 0; i < 50; i++ {
+			if err := storage.WriteFile(fmt.Sprintf("account/tx_%02d", i), []byte(fmt.Sprintf("%d", i))); err != nil {
+				t.Fatalf("unexpected error when calling WriteFile %+v", err)
+			}
+		}
+		storage.WriteFile("account/nested/tx", []byte("nested"))
+		names, _ := underlying.ListDirectory("account", true)
+		for _, name := range names {
+			if name != "nested" && !isShard(name) {
+				t.Errorf("expected only shards and subdirectories got %s", name)
+			}
+		}
+		if data, err := underlying.ReadFileFully(shardPath("account/tx_07")); err != nil || string(data) != "7" {
+			t.Errorf("expected file in its shard got %q %+v", data, err)
+		}
+	}
+
+	t.Log("reads and lists logical layout")
+	{
+		if data, err := storage.ReadFileFully("account/tx_07"); err != nil || string(data) != "7" {
+			t.Errorf("expected 7 got %q %+v", data, err)
+		}
+		names, err := storage.ListDirectory("account", true)
+		if err != nil || len(names) != 51 || names[0] != "nested" || names[1] != "tx_00" {
+			t.Errorf("expected logical listing got %+v %+v", names, err)
+		}
+		if count, err := storage.CountFiles("account"); err != nil || count != 50 {
+			t.Errorf("expected 50 files got %d %+v", count, err)
+		}
+		if ok, _ := storage.Exists("account/tx_49"); !ok {
+			t.Errorf("expected file to exist")
+		}
+		if ok, _ := storage.Exists("account/nested"); !ok {
+			t.Errorf("expected directory to exist")
+		}
+		if size, err := storage.FileSize("account/tx_10"); err != nil || size != 2 {
+			t.Errorf("expected size 2 got %d %+v", size, err)
+		}
+	}
+
+	t.Log("exclusive write, append and delete")
+	{
+		if err := storage.WriteFileExclusive("account/tx_00", nil); err == nil {
+			t.Errorf("expected error when file already exists")
+		}
+		storage.AppendFile("account/tx_01", []byte("!"))
+		if data, _ := storage.ReadFileFully("account/tx_01"); string(data) != "1!" {
+			t.Errorf("expected 1! got %q", data)
+		}
+		storage.Delete("account/tx_01")
+		if ok, _ := storage.Exists("account/tx_01"); ok {
+			t.Errorf("expected file to be deleted")
+		}
+	}
+
+	t.Log("migrates flat layout")
+	{
+		flat := []string{"ledger/a", "ledger/b", "ledger/2023/c"}
+		for _, name := range flat {
+			underlying.WriteFile(name, []byte(name))
+		}
+		if data, err := storage.ReadFileFully("ledger/a"); err != nil || string(data) != "ledger/a" {
+			t.Errorf("expected flat file to be readable before migration got %q %+v", data, err)
+		}
+		moved, err := storage.(ShardedStorage).MigrateFlat("ledger")
+		if err != nil || moved != 3 {
+			t.Fatalf("expected 3 moved files got %d %+v", moved, err)
+		}
+		for _, name := range flat {
+			if ok, _ := underlying.Exists(name); ok {
+				t.Errorf("expected %s to be moved", name)
+			}
+			if data, err := storage.ReadFileFully(name); err != nil || string(data) != name {
+				t.Errorf("expected %s after migration got %q %+v", name, data, err)
+			}
+		}
+		names, _ := storage.ListDirectory("ledger", true)
+		if !reflect.DeepEqual(names, []string{"2023", "a", "b"}) {
+			t.Errorf("unexpected listing %+v", names)
+		}
+		if moved, err := storage.(ShardedStorage).MigrateFlat("ledger"); err != nil || moved != 0 {
+			t.Errorf("expected nothing to migrate got %d %+v", moved, err)
+		}
+	}
+}
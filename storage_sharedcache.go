@@ -0,0 +1,347 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	sharedCacheMagic      = "LFSCACHE"
+	sharedCacheVersion    = 1
+	sharedCacheHeaderSize = 64
+	sharedSlotHeaderSize  = 64
+)
+
+// SharedCacheMetrics represents effectiveness of shared cache in this process
+type SharedCacheMetrics struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Stores uint64 `json:"stores"`
+}
+
+// sharedCache is fixed table of slots in memory mapped file shared by
+// processes, file path selects slot, slot header is guarded by sequence
+// number odd while slot is written and writers of slot exclude each other
+// with open file description lock released even when writer crashes, slot
+// header holds sequence, path hash, inode, mtime and size (8 bytes each), path
+// length, data length and crc32 of path and data (4 bytes each) followed by
+// path and data
+type sharedCache struct {
+	fd       int
+	data     []byte
+	slots    uint64
+	slotSize int
+	hits     uint64
+	misses   uint64
+	stores   uint64
+}
+
+// SharedCacheStorage is a fascade keeping recently read plaintext in cache
+// shared by all processes mapping same cache file, so several readers of hot
+// files do not each keep and decrypt own copy, entries are keyed by inode,
+// size and modification time of file and checksummed so stale or torn entries
+// are never returned
+type SharedCacheStorage struct {
+	Storage
+	root  string
+	cache *sharedCache
+}
+
+// NewSharedCacheStorage returns storage caching reads of underlying local
+// storage in cache file at given path (preferably on tmpfs such as /dev/shm)
+// with given number of slots of given size, processes sharing cache must use
+// same geometry, cached plaintext of encrypted storage is only as protected
+// as cache file which is created with 0600 mode
+func NewSharedCacheStorage(underlying Storage, path string, slots int, slotSize int) (Storage, error) {
+	root, ok := rootOf(underlying)
+	if !ok {
+		return NilStorage{}, fmt.Errorf("shared cache requires local storage")
+	}
+	if slots <= 0 || slotSize <= sharedSlotHeaderSize || slotSize%8 != 0 {
+		return NilStorage{}, fmt.Errorf("invalid shared cache geometry")
+	}
+	cache, err := openSharedCache(filepath.Clean(path), slots, slotSize)
+	if err != nil {
+		return NilStorage{}, err
+	}
+	return SharedCacheStorage{
+		Storage: underlying,
+		root:    filepath.Clean(root),
+		cache:   cache,
+	}, nil
+}
+
+func (storage SharedCacheStorage) unwrap() Storage {
+	return storage.Storage
+}
+
+func openSharedCache(filename string, slots int, slotSize int) (*sharedCache, error) {
+	fd, err := openRetrying(filename, syscall.O_CREAT|syscall.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	// initialization is serialized, geometry is written last
+	if err = syscall.Flock(fd, syscall.LOCK_EX); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	defer syscall.Flock(fd, syscall.LOCK_UN)
+	size := sharedCacheHeaderSize + slots*slotSize
+	var fs syscall.Stat_t
+	if err = syscall.Fstat(fd, &fs); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	fresh := fs.Size == 0
+	if fresh {
+		if err = syscall.Ftruncate(fd, int64(size)); err != nil {
+			syscall.Close(fd)
+			return nil, err
+		}
+	} else if fs.Size != int64(size) {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("shared cache %s has different geometry", filename)
+	}
+	data, err := syscall.Mmap(fd, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	header := make([]byte, 0, 20)
+	header = append(header, sharedCacheMagic...)
+	header = binary.LittleEndian.AppendUint32(header, sharedCacheVersion)
+	header = binary.LittleEndian.AppendUint32(header, uint32(slots))
+	header = binary.LittleEndian.AppendUint32(header, uint32(slotSize))
+	// header is zero when initializing process died before writing it
+	if fresh || data[0] == 0 {
+		copy(data, header)
+	} else if !bytes.Equal(data[:len(header)], header) {
+		syscall.Munmap(data)
+		syscall.Close(fd)
+		return nil, fmt.Errorf("shared cache %s has different geometry", filename)
+	}
+	return &sharedCache{
+		fd:       fd,
+		data:     data,
+		slots:    uint64(slots),
+		slotSize: slotSize,
+	}, nil
+}
+
+type sharedCacheKey struct {
+	path  string
+	hash  uint64
+	inode uint64
+	mtime int64
+	size  int64
+}
+
+func (cache *sharedCache) slot(hash uint64) (int, []byte) {
+	offset := sharedCacheHeaderSize + int(hash%cache.slots)*cache.slotSize
+	return offset, cache.data[offset : offset+cache.slotSize]
+}
+
+func sequenceOf(slot []byte) *uint64 {
+	return (*uint64)(unsafe.Pointer(&slot[0]))
+}
+
+func sharedChecksum(path string, data []byte) uint32 {
+	checksum := crc32.ChecksumIEEE([]byte(path))
+	return crc32.Update(checksum, crc32.IEEETable, data)
+}
+
+// load returns copy of cached data of key or false when slot holds other
+// entry, is being written or fails checksum
+func (cache *sharedCache) load(key sharedCacheKey) ([]byte, bool) {
+	_, slot := cache.slot(key.hash)
+	sequence := atomic.LoadUint64(sequenceOf(slot))
+	if sequence == 0 || sequence&1 == 1 {
+		return nil, false
+	}
+	if binary.LittleEndian.Uint64(slot[8:]) != key.hash ||
+		binary.LittleEndian.Uint64(slot[16:]) != key.inode ||
+		int64(binary.LittleEndian.Uint64(slot[24:])) != key.mtime ||
+		int64(binary.LittleEndian.Uint64(slot[32:])) != key.size {
+		return nil, false
+	}
+	pathLength := int(binary.LittleEndian.Uint32(slot[40:]))
+	dataLength := int(binary.LittleEndian.Uint32(slot[44:]))
+	if sharedSlotHeaderSize+pathLength+dataLength > len(slot) {
+		return nil, false
+	}
+	body := slot[sharedSlotHeaderSize:]
+	if string(body[:pathLength]) != key.path {
+		return nil, false
+	}
+	result := make([]byte, dataLength)
+	copy(result, body[pathLength:pathLength+dataLength])
+	checksum := binary.LittleEndian.Uint32(slot[48:])
+	if atomic.LoadUint64(sequenceOf(slot)) != sequence || sharedChecksum(key.path, result) != checksum {
+		return nil, false
+	}
+	return result, true
+}
+
+// lockSlot takes non blocking open file description lock of slot, false
+// means other writer holds it and store is skipped
+func (cache *sharedCache) lockSlot(offset int, kind int16) bool {
+	lock := unix.Flock_t{
+		Type:   kind,
+		Whence: 0,
+		Start:  int64(offset),
+		Len:    int64(cache.slotSize),
+	}
+	return unix.FcntlFlock(uintptr(cache.fd), fcntlSetLock, &lock) == nil
+}
+
+// store writes data of key into its slot unless it does not fit or slot is
+// being written by other process
+func (cache *sharedCache) store(key sharedCacheKey, data []byte) {
+	if sharedSlotHeaderSize+len(key.path)+len(data) > cache.slotSize {
+		return
+	}
+	offset, slot := cache.slot(key.hash)
+	if !cache.lockSlot(offset, unix.F_WRLCK) {
+		return
+	}
+	defer cache.lockSlot(offset, unix.F_UNLCK)
+	sequence := atomic.LoadUint64(sequenceOf(slot)) | 1
+	atomic.StoreUint64(sequenceOf(slot), sequence)
+	binary.LittleEndian.PutUint64(slot[8:], key.hash)
+	binary.LittleEndian.PutUint64(slot[16:], key.inode)
+	binary.LittleEndian.PutUint64(slot[24:], uint64(key.mtime))
+	binary.LittleEndian.PutUint64(slot[32:], uint64(key.size))
+	binary.LittleEndian.PutUint32(slot[40:], uint32(len(key.path)))
+	binary.LittleEndian.PutUint32(slot[44:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(slot[48:], sharedChecksum(key.path, data))
+	body := slot[sharedSlotHeaderSize:]
+	copy(body, key.path)
+	copy(body[len(key.path):], data)
+	atomic.StoreUint64(sequenceOf(slot), sequence+1)
+	atomic.AddUint64(&cache.stores, 1)
+}
+
+// invalidate marks slot of path empty so no process returns its entry
+func (cache *sharedCache) invalidate(hash uint64) {
+	offset, slot := cache.slot(hash)
+	if !cache.lockSlot(offset, unix.F_WRLCK) {
+		return
+	}
+	defer cache.lockSlot(offset, unix.F_UNLCK)
+	sequence := atomic.LoadUint64(sequenceOf(slot)) | 1
+	atomic.StoreUint64(sequenceOf(slot), sequence)
+	binary.LittleEndian.PutUint64(slot[8:], 0)
+	atomic.StoreUint64(sequenceOf(slot), sequence+1)
+}
+
+func (storage SharedCacheStorage) hash(path string) (string, uint64) {
+	path = filepath.Clean("/" + path)
+	hash := fnv.New64a()
+	hash.Write([]byte(path))
+	return path, hash.Sum64()
+}
+
+// key returns cache key of file as it is on disk now
+func (storage SharedCacheStorage) key(path string) (sharedCacheKey, error) {
+	var (
+		key sharedCacheKey
+		fs  syscall.Stat_t
+	)
+	key.path, key.hash = storage.hash(path)
+	if err := syscall.Stat(storage.root+key.path, &fs); err != nil {
+		return key, err
+	}
+	key.inode = fs.Ino
+	key.mtime = statMtime(&fs).UnixNano()
+	key.size = fs.Size
+	return key, nil
+}
+
+// SharedCacheMetrics returns hits, misses and stores of this process
+func (storage SharedCacheStorage) SharedCacheMetrics() SharedCacheMetrics {
+	return SharedCacheMetrics{
+		Hits:   atomic.LoadUint64(&storage.cache.hits),
+		Misses: atomic.LoadUint64(&storage.cache.misses),
+		Stores: atomic.LoadUint64(&storage.cache.stores),
+	}
+}
+
+// Close unmaps shared cache, cache file is left for other processes
+func (storage SharedCacheStorage) Close() error {
+	if err := syscall.Munmap(storage.cache.data); err != nil {
+		return err
+	}
+	return syscall.Close(storage.cache.fd)
+}
+
+// ReadFileFully reads whole file given path, serving it from shared cache
+// when entry matches file on disk
+func (storage SharedCacheStorage) ReadFileFully(path string) ([]byte, error) {
+	key, err := storage.key(path)
+	if err != nil {
+		return storage.Storage.ReadFileFully(path)
+	}
+	if data, ok := storage.cache.load(key); ok {
+		atomic.AddUint64(&storage.cache.hits, 1)
+		return data, nil
+	}
+	atomic.AddUint64(&storage.cache.misses, 1)
+	data, err := storage.Storage.ReadFileFully(path)
+	if err != nil {
+		return nil, err
+	}
+	storage.cache.store(key, data)
+	return data, nil
+}
+
+// WriteFile writes data given path and invalidates cached entry
+func (storage SharedCacheStorage) WriteFile(path string, data []byte) error {
+	_, hash := storage.hash(path)
+	defer storage.cache.invalidate(hash)
+	return storage.Storage.WriteFile(path, data)
+}
+
+// WriteFileExclusive writes data given path if file does not exist and
+// invalidates cached entry
+func (storage SharedCacheStorage) WriteFileExclusive(path string, data []byte) error {
+	_, hash := storage.hash(path)
+	defer storage.cache.invalidate(hash)
+	return storage.Storage.WriteFileExclusive(path, data)
+}
+
+// AppendFile appends data given path and invalidates cached entry
+func (storage SharedCacheStorage) AppendFile(path string, data []byte) error {
+	_, hash := storage.hash(path)
+	defer storage.cache.invalidate(hash)
+	return storage.Storage.AppendFile(path, data)
+}
+
+// Delete removes given path and invalidates cached entry
+func (storage SharedCacheStorage) Delete(path string) error {
+	_, hash := storage.hash(path)
+	defer storage.cache.invalidate(hash)
+	return storage.Storage.Delete(path)
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSharedCacheStorage(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	encrypted, _ := NewEncryptedStorage(tmpdir+"/data", getKey())
+	first, err := NewSharedCacheStorage(encrypted, tmpdir+"/cache", 16, 4096)
+	if err != nil {
+		t.Fatalf("unexpected error when calling NewSharedCacheStorage %+v", err)
+	}
+	defer first.(SharedCacheStorage).Close()
+	second, err := NewSharedCacheStorage(encrypted, tmpdir+"/cache", 16, 4096)
+	if err != nil {
+		t.Fatalf("unexpected error when calling NewSharedCacheStorage %+v", err)
+	}
+	defer second.(SharedCacheStorage).Close()
+
+	t.Log("entry stored by one reader is served to other")
+	{
+		first.WriteFile("account/a", []byte("balance"))
+		if data, err := first.ReadFileFully("account/a"); err != nil || string(data) != "balance" {
+			t.Fatalf("unexpected result of ReadFileFully %s %+v", string(data), err)
+		}
+		if data, err := second.ReadFileFully("account/a"); err != nil || string(data) != "balance" {
+			t.Fatalf("unexpected result of ReadFileFully %s %+v", string(data), err)
+		}
+		if metrics := second.(SharedCacheStorage).SharedCacheMetrics(); metrics.Hits != 1 || metrics.Misses != 0 {
+			t.Errorf("expected hit in second reader got %+v", metrics)
+		}
+	}
+
+	t.Log("write bypassing cache is not served stale")
+	{
+		encrypted.WriteFile("account/a", []byte("changed balance"))
+		if data, _ := second.ReadFileFully("account/a"); string(data) != "changed balance" {
+			t.Errorf("expected fresh content got %s", string(data))
+		}
+	}
+
+	t.Log("corrupted entry is not served")
+	{
+		cache := first.(SharedCacheStorage).cache
+		key, _ := first.(SharedCacheStorage).key("account/a")
+		_, slot := cache.slot(key.hash)
+		slot[len(slot)-1] ^= 0xff
+		slot[sharedSlotHeaderSize+len(key.path)] ^= 0xff
+		if data, _ := first.ReadFileFully("account/a"); string(data) != "changed balance" {
+			t.Errorf("expected content from disk got %s", string(data))
+		}
+	}
+
+	t.Log("entries larger than slot are not cached")
+	{
+		large := strings.Repeat("x", 8192)
+		first.WriteFile("account/b", []byte(large))
+		before := first.(SharedCacheStorage).SharedCacheMetrics()
+		first.ReadFileFully("account/b")
+		if data, _ := first.ReadFileFully("account/b"); string(data) != large {
+			t.Errorf("expected large content to be read from disk")
+		}
+		if after := first.(SharedCacheStorage).SharedCacheMetrics(); after.Hits != before.Hits {
+			t.Errorf("expected no hits for large file got %+v", after)
+		}
+	}
+
+	t.Log("different geometry is refused")
+	{
+		if _, err := NewSharedCacheStorage(encrypted, tmpdir+"/cache", 32, 4096); err == nil {
+			t.Errorf("expected error when opening cache with different geometry")
+		}
+	}
+}
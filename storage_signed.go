@@ -0,0 +1,198 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// SignatureSuffix is suffix of detached signature stored next to signed file
+const SignatureSuffix = ".sig"
+
+var (
+	// ErrSignatureMissing is returned when signed file has no signature
+	ErrSignatureMissing = errors.New("signature missing")
+	// ErrSignatureInvalid is returned when signature does not match file
+	ErrSignatureInvalid = errors.New("signature invalid")
+)
+
+// SignedStorage is a fascade storing detached Ed25519 signature next to every
+// written file and verifying it on read
+type SignedStorage struct {
+	Storage
+	keys  KeyProvider
+	match func(path string) bool
+}
+
+// NewSignedStorage returns storage signing files selected by match with keys
+// of given provider, nil match signs all files
+func NewSignedStorage(underlying Storage, keys KeyProvider, match func(path string) bool) Storage {
+	return SignedStorage{
+		Storage: underlying,
+		keys:    keys,
+		match:   match,
+	}
+}
+
+func (storage SignedStorage) unwrap() Storage {
+	return storage.Storage
+}
+
+func (storage SignedStorage) signed(path string) bool {
+	if strings.HasSuffix(path, SignatureSuffix) {
+		return false
+	}
+	return storage.match == nil || storage.match(path)
+}
+
+// signedMessage binds path to data so signature cannot be moved to another
+// file
+func signedMessage(path string, data []byte) []byte {
+	message := make([]byte, 0, len(path)+1+len(data))
+	message = append(message, path...)
+	message = append(message, 0)
+	return append(message, data...)
+}
+
+func (storage SignedStorage) sign(path string, data []byte) error {
+	id, key, err := storage.keys.SigningKey()
+	if err != nil {
+		return err
+	}
+	signature := ed25519.Sign(key, signedMessage(path, data))
+	return storage.Storage.WriteFile(path+SignatureSuffix, []byte(id+" "+hex.EncodeToString(signature)+"\n"))
+}
+
+// Verify checks detached signature of given file and returns id of key that
+// signed it
+func (storage SignedStorage) Verify(path string) (string, error) {
+	path = filepath.Clean(path)
+	data, err := storage.Storage.ReadFileFully(path)
+	if err != nil {
+		return "", err
+	}
+	return storage.verify(path, data)
+}
+
+func (storage SignedStorage) verify(path string, data []byte) (string, error) {
+	raw, err := storage.Storage.ReadFileFully(path + SignatureSuffix)
+	if err != nil {
+		if ok, _ := storage.Storage.Exists(path + SignatureSuffix); !ok {
+			return "", fmt.Errorf("%s %w", path, ErrSignatureMissing)
+		}
+		return "", err
+	}
+	fields := strings.Fields(string(raw))
+	if len(fields) != 2 {
+		return "", fmt.Errorf("%s %w", path, ErrSignatureInvalid)
+	}
+	signature, err := hex.DecodeString(fields[1])
+	if err != nil {
+		return "", fmt.Errorf("%s %w", path, ErrSignatureInvalid)
+	}
+	key, err := storage.keys.VerificationKey(fields[0])
+	if err != nil {
+		return "", err
+	}
+	if !ed25519.Verify(key, signedMessage(path, data), signature) {
+		return fields[0], fmt.Errorf("%s %w", path, ErrSignatureInvalid)
+	}
+	return fields[0], nil
+}
+
+// ReadFileFully reads whole file and verifies its signature
+func (storage SignedStorage) ReadFileFully(path string) ([]byte, error) {
+	path = filepath.Clean(path)
+	data, err := storage.Storage.ReadFileFully(path)
+	if err != nil || !storage.signed(path) {
+		return data, err
+	}
+	if _, err = storage.verify(path, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// GetFileReader returns reader of file given path, signed file is verified
+// before reader is returned
+func (storage SignedStorage) GetFileReader(path string) (io.ReadCloser, error) {
+	path = filepath.Clean(path)
+	if !storage.signed(path) {
+		return storage.Storage.GetFileReader(path)
+	}
+	return readerOf(storage.ReadFileFully(path))
+}
+
+// WriteFileExclusive writes data if file does not exist and signs it
+func (storage SignedStorage) WriteFileExclusive(path string, data []byte) error {
+	path = filepath.Clean(path)
+	if err := storage.Storage.WriteFileExclusive(path, data); err != nil || !storage.signed(path) {
+		return err
+	}
+	return storage.sign(path, data)
+}
+
+// WriteFile writes data and signs it
+func (storage SignedStorage) WriteFile(path string, data []byte) error {
+	path = filepath.Clean(path)
+	if err := storage.Storage.WriteFile(path, data); err != nil || !storage.signed(path) {
+		return err
+	}
+	return storage.sign(path, data)
+}
+
+// AppendFile appends data and signs whole resulting content
+func (storage SignedStorage) AppendFile(path string, data []byte) error {
+	path = filepath.Clean(path)
+	if err := storage.Storage.AppendFile(path, data); err != nil || !storage.signed(path) {
+		return err
+	}
+	content, err := storage.Storage.ReadFileFully(path)
+	if err != nil {
+		return err
+	}
+	return storage.sign(path, content)
+}
+
+// TouchFile creates file if it does not exist and signs it when created
+func (storage SignedStorage) TouchFile(path string) error {
+	path = filepath.Clean(path)
+	existed, err := storage.Storage.Exists(path)
+	if err != nil {
+		return err
+	}
+	if err = storage.Storage.TouchFile(path); err != nil || existed || !storage.signed(path) {
+		return err
+	}
+	return storage.sign(path, []byte{})
+}
+
+// Delete removes given path together with its signature
+func (storage SignedStorage) Delete(path string) error {
+	path = filepath.Clean(path)
+	if err := storage.Storage.Delete(path); err != nil {
+		return err
+	}
+	if ok, _ := storage.Storage.Exists(path + SignatureSuffix); ok {
+		return storage.Storage.Delete(path + SignatureSuffix)
+	}
+	return nil
+}
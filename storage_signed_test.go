@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSignedStorage(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	keys := NewStaticKeyProvider()
+	_, private, _ := ed25519.GenerateKey(nil)
+	keys.AddSigningKey("k1", private)
+
+	underlying, _ := NewPlaintextStorage(tmpdir)
+	storage := NewSignedStorage(underlying, keys, func(path string) bool {
+		return strings.HasPrefix(path, "settlement/")
+	})
+
+	t.Log("signs on write and verifies on read")
+	{
+		if err := storage.WriteFile("settlement/1", []byte("pay 100")); err != nil {
+			t.Fatalf("unexpected error when calling WriteFile %+v", err)
+		}
+		if ok, _ := underlying.Exists("settlement/1.sig"); !ok {
+			t.Fatalf("expected detached signature to exist")
+		}
+		data, err := storage.ReadFileFully("settlement/1")
+		if err != nil || string(data) != "pay 100" {
+			t.Errorf("expected verified content got %s %+v", string(data), err)
+		}
+	}
+
+	t.Log("rejects tampered file")
+	{
+		underlying.WriteFile("settlement/1", []byte("pay 999"))
+		if _, err := storage.ReadFileFully("settlement/1"); !errors.Is(err, ErrSignatureInvalid) {
+			t.Errorf("expected ErrSignatureInvalid got %+v", err)
+		}
+	}
+
+	t.Log("rejects signature moved to other file")
+	{
+		storage.WriteFile("settlement/2", []byte("pay 5"))
+		underlying.WriteFile("settlement/3", []byte("pay 5"))
+		signature, _ := underlying.ReadFileFully("settlement/2.sig")
+		underlying.WriteFile("settlement/3.sig", signature)
+		if _, err := storage.ReadFileFully("settlement/3"); !errors.Is(err, ErrSignatureInvalid) {
+			t.Errorf("expected ErrSignatureInvalid got %+v", err)
+		}
+	}
+
+	t.Log("rejects missing signature")
+	{
+		underlying.WriteFile("settlement/4", []byte("pay 1"))
+		if _, err := storage.ReadFileFully("settlement/4"); !errors.Is(err, ErrSignatureMissing) {
+			t.Errorf("expected ErrSignatureMissing got %+v", err)
+		}
+	}
+
+	t.Log("signs appended content and verifies after key rotation")
+	{
+		storage.AppendFile("settlement/5", []byte("a"))
+		_, rotated, _ := ed25519.GenerateKey(nil)
+		keys.AddSigningKey("k2", rotated)
+		storage.AppendFile("settlement/6", []byte("b"))
+		if id, err := storage.(SignedStorage).Verify("settlement/5"); err != nil || id != "k1" {
+			t.Errorf("expected settlement/5 signed by k1 got %s %+v", id, err)
+		}
+		if id, err := storage.(SignedStorage).Verify("settlement/6"); err != nil || id != "k2" {
+			t.Errorf("expected settlement/6 signed by k2 got %s %+v", id, err)
+		}
+	}
+
+	t.Log("leaves unmatched files unsigned")
+	{
+		storage.WriteFile("other", []byte("x"))
+		if ok, _ := underlying.Exists("other.sig"); ok {
+			t.Errorf("expected unmatched file not to be signed")
+		}
+		storage.Delete("settlement/2")
+		if ok, _ := underlying.Exists("settlement/2.sig"); ok {
+			t.Errorf("expected signature to be deleted with file")
+		}
+	}
+}
@@ -0,0 +1,339 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stubMagic prefixes content of local stub of file evicted to remote tier
+const stubMagic = "LFSTUB\x01"
+
+// TieringPolicy selects cold files evicted to remote tier
+type TieringPolicy struct {
+	// Prefix is subtree evaluated by policy
+	Prefix string
+	// MinAge is minimal time since last modification of evicted file
+	MinAge time.Duration
+	// MinSize evicts only files of at least given size, smaller files are
+	// cheaper to keep than their stubs
+	MinSize int64
+}
+
+// TierMetrics represents counters of eviction and recall
+type TierMetrics struct {
+	Evicted          uint64        `json:"evicted"`
+	EvictedBytes     uint64        `json:"evictedBytes"`
+	Recalls          uint64        `json:"recalls"`
+	RecalledBytes    uint64        `json:"recalledBytes"`
+	RecallFailures   uint64        `json:"recallFailures"`
+	RecallLatency    time.Duration `json:"recallLatency"`
+	MaxRecallLatency time.Duration `json:"maxRecallLatency"`
+}
+
+type tierStub struct {
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	Checksum string    `json:"checksum"`
+	Evicted  time.Time `json:"evicted"`
+}
+
+type tierState struct {
+	sync.Mutex
+	metrics TierMetrics
+}
+
+// TieredStorage is a fascade evicting cold files to remote tier leaving small
+// stub in local storage, stubbed files are recalled transparently on read
+type TieredStorage struct {
+	Storage
+	remote   Storage
+	root     string
+	policies []TieringPolicy
+	state    *tierState
+}
+
+// NewTieredStorage returns storage evicting files of local storage selected
+// by policies to remote storage
+func NewTieredStorage(local Storage, remote Storage, policies ...TieringPolicy) (Storage, error) {
+	root, ok := rootOf(local)
+	if !ok {
+		return NilStorage{}, fmt.Errorf("tiering requires local storage")
+	}
+	return TieredStorage{
+		Storage:  local,
+		remote:   remote,
+		root:     filepath.Clean(root),
+		policies: policies,
+		state:    new(tierState),
+	}, nil
+}
+
+func (storage TieredStorage) unwrap() Storage {
+	return storage.Storage
+}
+
+func decodeStub(data []byte) (tierStub, bool) {
+	var stub tierStub
+	if !bytes.HasPrefix(data, []byte(stubMagic)) {
+		return stub, false
+	}
+	if json.Unmarshal(data[len(stubMagic):], &stub) != nil {
+		return stub, false
+	}
+	return stub, true
+}
+
+// stub returns stub of evicted file
+func (storage TieredStorage) stub(path string) (tierStub, bool, error) {
+	data, err := storage.Storage.ReadFileFully(path)
+	if err != nil {
+		return tierStub{}, false, err
+	}
+	stub, ok := decodeStub(data)
+	return stub, ok, nil
+}
+
+// Evicted returns true if content of file lives in remote tier
+func (storage TieredStorage) Evicted(path string) (bool, error) {
+	_, ok, err := storage.stub(path)
+	return ok, err
+}
+
+// Evict moves content of file to remote tier and replaces it with stub
+func (storage TieredStorage) Evict(path string) error {
+	data, err := storage.Storage.ReadFileFully(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := decodeStub(data); ok {
+		return nil
+	}
+	modified, err := storage.Storage.LastModification(path)
+	if err != nil {
+		return err
+	}
+	if err = storage.remote.WriteFile(path, data); err != nil {
+		return err
+	}
+	checksum := sha256.Sum256(data)
+	encoded, err := json.Marshal(tierStub{
+		Size:     int64(len(data)),
+		Modified: modified,
+		Checksum: hex.EncodeToString(checksum[:]),
+		Evicted:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	if err = storage.Storage.WriteFile(path, append([]byte(stubMagic), encoded...)); err != nil {
+		return err
+	}
+	// stub keeps age of original so policies keep seeing file as cold
+	os.Chtimes(filepath.Clean(storage.root+"/"+path), modified, modified)
+
+	storage.state.Lock()
+	storage.state.metrics.Evicted++
+	storage.state.metrics.EvictedBytes += uint64(len(data))
+	storage.state.Unlock()
+	return nil
+}
+
+// recall reads content of evicted file from remote tier
+func (storage TieredStorage) recall(path string, stub tierStub) ([]byte, error) {
+	start := time.Now()
+	data, err := storage.remote.ReadFileFully(path)
+	if err == nil {
+		checksum := sha256.Sum256(data)
+		if hex.EncodeToString(checksum[:]) != stub.Checksum {
+			err = fmt.Errorf("recalled content of %s does not match its stub", path)
+		}
+	}
+	latency := time.Since(start)
+
+	storage.state.Lock()
+	defer storage.state.Unlock()
+	if err != nil {
+		storage.state.metrics.RecallFailures++
+		return nil, err
+	}
+	storage.state.metrics.Recalls++
+	storage.state.metrics.RecalledBytes += uint64(len(data))
+	storage.state.metrics.RecallLatency += latency
+	if latency > storage.state.metrics.MaxRecallLatency {
+		storage.state.metrics.MaxRecallLatency = latency
+	}
+	return data, nil
+}
+
+// Recall brings content of evicted file back to local storage
+func (storage TieredStorage) Recall(path string) error {
+	stub, ok, err := storage.stub(path)
+	if err != nil || !ok {
+		return err
+	}
+	data, err := storage.recall(path, stub)
+	if err != nil {
+		return err
+	}
+	if err = storage.Storage.WriteFile(path, data); err != nil {
+		return err
+	}
+	os.Chtimes(filepath.Clean(storage.root+"/"+path), stub.Modified, stub.Modified)
+	return storage.remote.Delete(path)
+}
+
+// TierMetrics returns counters of eviction and recall
+func (storage TieredStorage) TierMetrics() TierMetrics {
+	storage.state.Lock()
+	defer storage.state.Unlock()
+	return storage.state.metrics
+}
+
+// Name returns name of eviction as maintenance task
+func (storage TieredStorage) Name() string {
+	return "eviction"
+}
+
+// Run evaluates every policy once and evicts selected files
+func (storage TieredStorage) Run() error {
+	var (
+		now      = time.Now()
+		firstErr error
+	)
+	for _, policy := range storage.policies {
+		base := filepath.Clean(storage.root + "/" + policy.Prefix)
+		err := filepath.WalkDir(base, func(absPath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if absPath == base {
+					return nil
+				}
+				return err
+			}
+			if entry.IsDir() {
+				if absPath != base && strings.HasPrefix(entry.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !entry.Type().IsRegular() {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return nil
+			}
+			if now.Sub(info.ModTime()) < policy.MinAge || info.Size() < policy.MinSize {
+				return nil
+			}
+			relPath, err := filepath.Rel(storage.root, absPath)
+			if err != nil {
+				return err
+			}
+			if err := storage.Evict(relPath); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			return nil
+		})
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// ReadFileFully reads whole file recalling evicted content from remote tier
+func (storage TieredStorage) ReadFileFully(path string) ([]byte, error) {
+	data, err := storage.Storage.ReadFileFully(path)
+	if err != nil {
+		return nil, err
+	}
+	stub, ok := decodeStub(data)
+	if !ok {
+		return data, nil
+	}
+	return storage.recall(path, stub)
+}
+
+// GetFileReader returns reader of file given path, evicted content is
+// recalled from remote tier before reader is returned
+func (storage TieredStorage) GetFileReader(path string) (io.ReadCloser, error) {
+	if _, ok, err := storage.stub(path); err == nil && ok {
+		return readerOf(storage.ReadFileFully(path))
+	}
+	return storage.Storage.GetFileReader(path)
+}
+
+// LastModification returns time of last modification of original content
+func (storage TieredStorage) LastModification(path string) (time.Time, error) {
+	if stub, ok, err := storage.stub(path); err == nil && ok {
+		return stub.Modified, nil
+	}
+	return storage.Storage.LastModification(path)
+}
+
+// FileSize returns size of original content of evicted file
+func (storage TieredStorage) FileSize(path string) (int64, error) {
+	if stub, ok, err := storage.stub(path); err == nil && ok {
+		return stub.Size, nil
+	}
+	return storage.Storage.FileSize(path)
+}
+
+// discard removes remote content of evicted file about to be replaced
+func (storage TieredStorage) discard(path string) error {
+	if _, ok, err := storage.stub(path); err != nil || !ok {
+		return nil
+	}
+	if err := storage.remote.Delete(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// WriteFile writes data to local storage dropping evicted content
+func (storage TieredStorage) WriteFile(path string, data []byte) error {
+	if err := storage.discard(path); err != nil {
+		return err
+	}
+	return storage.Storage.WriteFile(path, data)
+}
+
+// AppendFile appends data, evicted file is recalled first
+func (storage TieredStorage) AppendFile(path string, data []byte) error {
+	if err := storage.Recall(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return storage.Storage.AppendFile(path, data)
+}
+
+// Delete removes file from local storage and its content from remote tier
+func (storage TieredStorage) Delete(path string) error {
+	if err := storage.discard(path); err != nil {
+		return err
+	}
+	return storage.Storage.Delete(path)
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTieredStorage(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	local, _ := NewPlaintextStorage(tmpdir + "/local")
+	remote, _ := NewPlaintextStorage(tmpdir + "/remote")
+	storage, err := NewTieredStorage(local, remote, TieringPolicy{
+		Prefix:  "ledger",
+		MinAge:  24 * time.Hour,
+		MinSize: 1024,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error when calling NewTieredStorage %+v", err)
+	}
+	tiered := storage.(TieredStorage)
+
+	old := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	cold := bytes.Repeat([]byte("cold"), 1024)
+	storage.WriteFile("ledger/cold", cold)
+	storage.WriteFile("ledger/small", []byte("small"))
+	storage.WriteFile("ledger/hot", bytes.Repeat([]byte("hot"), 1024))
+	os.Chtimes(filepath.Join(tmpdir, "local", "ledger", "cold"), old, old)
+	os.Chtimes(filepath.Join(tmpdir, "local", "ledger", "small"), old, old)
+
+	if err := tiered.Run(); err != nil {
+		t.Fatalf("unexpected error when calling Run %+v", err)
+	}
+
+	t.Log("evicts only cold large files")
+	{
+		if ok, _ := tiered.Evicted("ledger/cold"); !ok {
+			t.Errorf("expected cold file to be evicted")
+		}
+		if ok, _ := tiered.Evicted("ledger/small"); ok {
+			t.Errorf("expected small file to stay local")
+		}
+		if ok, _ := tiered.Evicted("ledger/hot"); ok {
+			t.Errorf("expected hot file to stay local")
+		}
+		info, _ := os.Stat(filepath.Join(tmpdir, "local", "ledger", "cold"))
+		if info.Size() >= int64(len(cold)) {
+			t.Errorf("expected small stub got %d bytes", info.Size())
+		}
+		if modified, _ := storage.LastModification("ledger/cold"); !modified.Equal(old) {
+			t.Errorf("expected original modification time got %v", modified)
+		}
+		if size, _ := storage.FileSize("ledger/cold"); size != int64(len(cold)) {
+			t.Errorf("expected original size %d got %d", len(cold), size)
+		}
+	}
+
+	t.Log("recalls transparently on read")
+	{
+		data, err := storage.ReadFileFully("ledger/cold")
+		if err != nil || !bytes.Equal(data, cold) {
+			t.Errorf("unexpected recalled content %+v", err)
+		}
+		metrics := tiered.TierMetrics()
+		if metrics.Evicted != 1 || metrics.Recalls != 1 || metrics.RecalledBytes != uint64(len(cold)) || metrics.MaxRecallLatency <= 0 {
+			t.Errorf("unexpected metrics %+v", metrics)
+		}
+	}
+
+	t.Log("appending recalls content back")
+	{
+		if err := storage.AppendFile("ledger/cold", []byte("!")); err != nil {
+			t.Fatalf("unexpected error when calling AppendFile %+v", err)
+		}
+		if ok, _ := tiered.Evicted("ledger/cold"); ok {
+			t.Errorf("expected file to be local after append")
+		}
+		if ok, _ := remote.Exists("ledger/cold"); ok {
+			t.Errorf("expected remote content to be dropped")
+		}
+		data, _ := storage.ReadFileFully("ledger/cold")
+		if len(data) != len(cold)+1 {
+			t.Errorf("expected appended content got %d bytes", len(data))
+		}
+	}
+
+	t.Log("delete removes remote content")
+	{
+		tiered.Evict("ledger/hot")
+		if ok, _ := remote.Exists("ledger/hot"); !ok {
+			t.Errorf("expected remote content")
+		}
+		if err := storage.Delete("ledger/hot"); err != nil {
+			t.Fatalf("unexpected error when calling Delete %+v", err)
+		}
+		if ok, _ := remote.Exists("ledger/hot"); ok {
+			t.Errorf("expected remote content to be deleted")
+		}
+	}
+}
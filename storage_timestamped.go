@@ -0,0 +1,260 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// TimestampSuffix is suffix of timestamp token stored next to file
+const TimestampSuffix = ".tsr"
+
+// TimestampAuthority issues timestamp tokens for batch of sha256 hashes,
+// returning one token per hash in same order
+type TimestampAuthority interface {
+	Timestamp(hashes [][]byte) ([][]byte, error)
+}
+
+// TimestampToken is token issued by authority for content of file
+type TimestampToken struct {
+	Checksum string    `json:"checksum"`
+	Token    []byte    `json:"token"`
+	Stamped  time.Time `json:"stamped"`
+}
+
+type timestampRequest struct {
+	path string
+	hash []byte
+}
+
+type timestampBatcher struct {
+	sync.Mutex
+	flushing  sync.Mutex
+	storage   Storage
+	authority TimestampAuthority
+	batchSize int
+	pending   []timestampRequest
+	err       error
+	done      chan struct{}
+	stopped   sync.WaitGroup
+}
+
+// TimestampedStorage is a fascade sending hashes of written files to
+// timestamp authority in batches and storing returned tokens next to files
+type TimestampedStorage struct {
+	Storage
+	batcher *timestampBatcher
+}
+
+// NewTimestampedStorage returns storage timestamping written files, batch is
+// sent once it reaches batchSize or every interval, whichever comes first
+func NewTimestampedStorage(underlying Storage, authority TimestampAuthority, batchSize int, interval time.Duration) Storage {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	batcher := &timestampBatcher{
+		storage:   underlying,
+		authority: authority,
+		batchSize: batchSize,
+		done:      make(chan struct{}),
+	}
+	if interval > 0 {
+		batcher.stopped.Add(1)
+		go batcher.loop(interval)
+	}
+	return TimestampedStorage{
+		Storage: underlying,
+		batcher: batcher,
+	}
+}
+
+func (storage TimestampedStorage) unwrap() Storage {
+	return storage.Storage
+}
+
+func (batcher *timestampBatcher) loop(interval time.Duration) {
+	defer batcher.stopped.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-batcher.done:
+			return
+		case <-ticker.C:
+			batcher.flush()
+		}
+	}
+}
+
+func (batcher *timestampBatcher) enqueue(path string, data []byte) {
+	sum := sha256.Sum256(data)
+	batcher.Lock()
+	replaced := false
+	for i := range batcher.pending {
+		if batcher.pending[i].path == path {
+			batcher.pending[i].hash = sum[:]
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		batcher.pending = append(batcher.pending, timestampRequest{path: path, hash: sum[:]})
+	}
+	full := len(batcher.pending) >= batcher.batchSize
+	batcher.Unlock()
+	if full {
+		batcher.flush()
+	}
+}
+
+// flush sends pending hashes to authority, failed batch is kept for next
+// flush unless newer content of same file was queued meanwhile
+func (batcher *timestampBatcher) flush() error {
+	batcher.flushing.Lock()
+	defer batcher.flushing.Unlock()
+
+	batcher.Lock()
+	batch := batcher.pending
+	batcher.pending = nil
+	batcher.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	hashes := make([][]byte, len(batch))
+	for i, request := range batch {
+		hashes[i] = request.hash
+	}
+	tokens, err := batcher.authority.Timestamp(hashes)
+	if err == nil && len(tokens) != len(batch) {
+		err = fmt.Errorf("timestamp authority returned %d tokens for %d hashes", len(tokens), len(batch))
+	}
+	if err != nil {
+		batcher.Lock()
+		queued := make(map[string]bool, len(batcher.pending))
+		for _, request := range batcher.pending {
+			queued[request.path] = true
+		}
+		for _, request := range batch {
+			if !queued[request.path] {
+				batcher.pending = append(batcher.pending, request)
+			}
+		}
+		batcher.err = err
+		batcher.Unlock()
+		return err
+	}
+
+	now := time.Now().UTC()
+	for i, request := range batch {
+		data, err := json.Marshal(TimestampToken{
+			Checksum: fmt.Sprintf("sha256:%x", request.hash),
+			Token:    tokens[i],
+			Stamped:  now,
+		})
+		if err == nil {
+			err = batcher.storage.WriteFile(request.path+TimestampSuffix, data)
+		}
+		if err != nil {
+			batcher.Lock()
+			batcher.err = err
+			batcher.Unlock()
+			return err
+		}
+	}
+	batcher.Lock()
+	batcher.err = nil
+	batcher.Unlock()
+	return nil
+}
+
+// Flush sends pending hashes to authority immediately
+func (storage TimestampedStorage) Flush() error {
+	return storage.batcher.flush()
+}
+
+// Pending returns number of files waiting for timestamp
+func (storage TimestampedStorage) Pending() int {
+	storage.batcher.Lock()
+	defer storage.batcher.Unlock()
+	return len(storage.batcher.pending)
+}
+
+// Err returns error of last unsuccessful flush, nil once flush succeeds
+func (storage TimestampedStorage) Err() error {
+	storage.batcher.Lock()
+	defer storage.batcher.Unlock()
+	return storage.batcher.err
+}
+
+// Close stops periodic flushing and flushes pending hashes
+func (storage TimestampedStorage) Close() error {
+	select {
+	case <-storage.batcher.done:
+	default:
+		close(storage.batcher.done)
+	}
+	storage.batcher.stopped.Wait()
+	return storage.batcher.flush()
+}
+
+// Token returns timestamp token of given file
+func (storage TimestampedStorage) Token(path string) (TimestampToken, error) {
+	var result TimestampToken
+	data, err := storage.Storage.ReadFileFully(filepath.Clean(path) + TimestampSuffix)
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
+
+// WriteFileExclusive writes data if file does not exist and queues it for
+// timestamping
+func (storage TimestampedStorage) WriteFileExclusive(path string, data []byte) error {
+	if err := storage.Storage.WriteFileExclusive(path, data); err != nil {
+		return err
+	}
+	storage.batcher.enqueue(filepath.Clean(path), data)
+	return nil
+}
+
+// WriteFile writes data and queues it for timestamping
+func (storage TimestampedStorage) WriteFile(path string, data []byte) error {
+	if err := storage.Storage.WriteFile(path, data); err != nil {
+		return err
+	}
+	storage.batcher.enqueue(filepath.Clean(path), data)
+	return nil
+}
+
+// AppendFile appends data and queues whole resulting content for
+// timestamping
+func (storage TimestampedStorage) AppendFile(path string, data []byte) error {
+	if err := storage.Storage.AppendFile(path, data); err != nil {
+		return err
+	}
+	content, err := storage.Storage.ReadFileFully(path)
+	if err != nil {
+		return err
+	}
+	storage.batcher.enqueue(filepath.Clean(path), content)
+	return nil
+}
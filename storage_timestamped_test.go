@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type fakeAuthority struct {
+	batches [][][]byte
+	fail    bool
+}
+
+func (authority *fakeAuthority) Timestamp(hashes [][]byte) ([][]byte, error) {
+	if authority.fail {
+		return nil, fmt.Errorf("authority unavailable")
+	}
+	authority.batches = append(authority.batches, hashes)
+	tokens := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		tokens[i] = append([]byte("token:"), hash...)
+	}
+	return tokens, nil
+}
+
+func TestTimestampedStorage(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	authority := new(fakeAuthority)
+	underlying, _ := NewPlaintextStorage(tmpdir)
+	storage := NewTimestampedStorage(underlying, authority, 3, 0).(TimestampedStorage)
+	defer storage.Close()
+
+	t.Log("sends full batch")
+	{
+		storage.WriteFile("a", []byte("1"))
+		storage.WriteFile("b", []byte("2"))
+		if len(authority.batches) != 0 || storage.Pending() != 2 {
+			t.Fatalf("expected batch to wait got %d batches %d pending", len(authority.batches), storage.Pending())
+		}
+		storage.AppendFile("c", []byte("3"))
+		if len(authority.batches) != 1 || len(authority.batches[0]) != 3 {
+			t.Fatalf("expected single batch of 3 got %+v", authority.batches)
+		}
+		token, err := storage.Token("b")
+		if err != nil {
+			t.Fatalf("unexpected error when calling Token %+v", err)
+		}
+		sum := sha256.Sum256([]byte("2"))
+		if token.Checksum != fmt.Sprintf("sha256:%x", sum) || string(token.Token) != "token:"+string(sum[:]) {
+			t.Errorf("unexpected token %+v", token)
+		}
+	}
+
+	t.Log("keeps batch when authority fails")
+	{
+		authority.fail = true
+		storage.WriteFile("d", []byte("4"))
+		if err := storage.Flush(); err == nil || storage.Err() == nil {
+			t.Errorf("expected flush to fail")
+		}
+		if storage.Pending() != 1 {
+			t.Errorf("expected failed batch to stay pending got %d", storage.Pending())
+		}
+		authority.fail = false
+		if err := storage.Flush(); err != nil || storage.Err() != nil {
+			t.Errorf("unexpected error when calling Flush %+v", err)
+		}
+		if _, err := storage.Token("d"); err != nil {
+			t.Errorf("expected token for d got %+v", err)
+		}
+	}
+}
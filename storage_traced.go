@@ -0,0 +1,204 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// Span represents single traced storage operation, it is intentionally
+// minimal so that OpenTelemetry or any other tracer can be adapted to it
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// Tracer starts spans for storage operations
+type Tracer interface {
+	StartSpan(operation string) Span
+}
+
+// TracedStorage is a fascade that opens span per storage operation
+type TracedStorage struct {
+	Storage
+	tracer Tracer
+}
+
+// NewTracedStorage returns storage that traces every operation of underlying
+// storage with given tracer
+func NewTracedStorage(underlying Storage, tracer Tracer) Storage {
+	if underlying == nil {
+		return NilStorage{}
+	}
+	if tracer == nil {
+		return underlying
+	}
+	return TracedStorage{
+		Storage: underlying,
+		tracer:  tracer,
+	}
+}
+
+func (storage TracedStorage) start(operation string, path string) Span {
+	span := storage.tracer.StartSpan("localfs." + operation)
+	span.SetAttribute("localfs.path", path)
+	return span
+}
+
+func finish(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetAttribute("localfs.outcome", "error")
+	} else {
+		span.SetAttribute("localfs.outcome", "ok")
+	}
+	span.End()
+}
+
+func (storage TracedStorage) unwrap() Storage {
+	return storage.Storage
+}
+
+// Chmod sets chmod flag on given file
+func (storage TracedStorage) Chmod(path string, mod os.FileMode) error {
+	span := storage.start("Chmod", path)
+	err := storage.Storage.Chmod(path, mod)
+	finish(span, err)
+	return err
+}
+
+// ListDirectory returns sorted slice of item names in given path
+func (storage TracedStorage) ListDirectory(path string, ascending bool) ([]string, error) {
+	span := storage.start("ListDirectory", path)
+	result, err := storage.Storage.ListDirectory(path, ascending)
+	span.SetAttribute("localfs.entries", len(result))
+	finish(span, err)
+	return result, err
+}
+
+// CountFiles returns number of items in directory
+func (storage TracedStorage) CountFiles(path string) (int, error) {
+	span := storage.start("CountFiles", path)
+	result, err := storage.Storage.CountFiles(path)
+	span.SetAttribute("localfs.entries", result)
+	finish(span, err)
+	return result, err
+}
+
+// Exists returns true if path exists
+func (storage TracedStorage) Exists(path string) (bool, error) {
+	span := storage.start("Exists", path)
+	result, err := storage.Storage.Exists(path)
+	finish(span, err)
+	return result, err
+}
+
+// LastModification returns time of last modification
+func (storage TracedStorage) LastModification(path string) (time.Time, error) {
+	span := storage.start("LastModification", path)
+	result, err := storage.Storage.LastModification(path)
+	finish(span, err)
+	return result, err
+}
+
+// FileSize returns size of file in bytes
+func (storage TracedStorage) FileSize(path string) (int64, error) {
+	span := storage.start("FileSize", path)
+	result, err := storage.Storage.FileSize(path)
+	span.SetAttribute("localfs.size", result)
+	finish(span, err)
+	return result, err
+}
+
+// TouchFile creates file given path if file does not already exist
+func (storage TracedStorage) TouchFile(path string) error {
+	span := storage.start("TouchFile", path)
+	err := storage.Storage.TouchFile(path)
+	finish(span, err)
+	return err
+}
+
+// Mkdir creates directory given path
+func (storage TracedStorage) Mkdir(path string) error {
+	span := storage.start("Mkdir", path)
+	err := storage.Storage.Mkdir(path)
+	finish(span, err)
+	return err
+}
+
+// Delete removes given path
+func (storage TracedStorage) Delete(path string) error {
+	span := storage.start("Delete", path)
+	err := storage.Storage.Delete(path)
+	finish(span, err)
+	return err
+}
+
+// ReadFileFully reads whole file given path
+func (storage TracedStorage) ReadFileFully(path string) ([]byte, error) {
+	span := storage.start("ReadFileFully", path)
+	result, err := storage.Storage.ReadFileFully(path)
+	span.SetAttribute("localfs.size", len(result))
+	finish(span, err)
+	return result, err
+}
+
+// GetFileReader returns reader of file given path
+func (storage TracedStorage) GetFileReader(path string) (io.ReadCloser, error) {
+	span := storage.start("GetFileReader", path)
+	result, err := storage.Storage.GetFileReader(path)
+	finish(span, err)
+	return result, err
+}
+
+// WriteFileExclusive writes data given path to a file if that file does not
+// already exists
+func (storage TracedStorage) WriteFileExclusive(path string, data []byte) error {
+	span := storage.start("WriteFileExclusive", path)
+	span.SetAttribute("localfs.size", len(data))
+	err := storage.Storage.WriteFileExclusive(path, data)
+	finish(span, err)
+	return err
+}
+
+// WriteFile writes data given path to a file, creates it if it does not exist
+func (storage TracedStorage) WriteFile(path string, data []byte) error {
+	span := storage.start("WriteFile", path)
+	span.SetAttribute("localfs.size", len(data))
+	err := storage.Storage.WriteFile(path, data)
+	finish(span, err)
+	return err
+}
+
+func (storage TracedStorage) replaceFile(path string, data []byte) error {
+	span := storage.start("WriteFile", path)
+	span.SetAttribute("localfs.size", len(data))
+	err := replaceFile(storage.Storage, path, data)
+	finish(span, err)
+	return err
+}
+
+// AppendFile appends data given path to a file, creates it if it does not
+// exist
+func (storage TracedStorage) AppendFile(path string, data []byte) error {
+	span := storage.start("AppendFile", path)
+	span.SetAttribute("localfs.size", len(data))
+	err := storage.Storage.AppendFile(path, data)
+	finish(span, err)
+	return err
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+type recordedSpan struct {
+	operation  string
+	attributes map[string]interface{}
+	err        error
+	ended      bool
+}
+
+type recordingTracer struct {
+	mutex sync.Mutex
+	spans []*recordedSpan
+}
+
+func (tracer *recordingTracer) StartSpan(operation string) Span {
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+	span := &recordedSpan{operation: operation, attributes: make(map[string]interface{})}
+	tracer.spans = append(tracer.spans, span)
+	return span
+}
+
+func (span *recordedSpan) SetAttribute(key string, value interface{}) {
+	span.attributes[key] = value
+}
+
+func (span *recordedSpan) RecordError(err error) {
+	span.err = err
+}
+
+func (span *recordedSpan) End() {
+	span.ended = true
+}
+
+func TestTracedStorage(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	underlying, _ := NewPlaintextStorage(tmpdir)
+	tracer := new(recordingTracer)
+	storage := NewTracedStorage(underlying, tracer)
+
+	if err = storage.WriteFile("foo", []byte("abcd")); err != nil {
+		t.Fatalf("unexpected error when calling WriteFile %+v", err)
+	}
+	if _, err = storage.ReadFileFully("bar"); err == nil {
+		t.Fatalf("expected error when reading non existent file")
+	}
+
+	if len(tracer.spans) != 2 {
+		t.Fatalf("expected 2 spans got %d instead", len(tracer.spans))
+	}
+
+	write := tracer.spans[0]
+	if write.operation != "localfs.WriteFile" || !write.ended {
+		t.Errorf("expected ended localfs.WriteFile span got %+v", write)
+	}
+	if write.attributes["localfs.path"] != "foo" || write.attributes["localfs.size"] != 4 {
+		t.Errorf("unexpected attributes %+v", write.attributes)
+	}
+	if write.attributes["localfs.outcome"] != "ok" {
+		t.Errorf("expected ok outcome got %+v", write.attributes["localfs.outcome"])
+	}
+
+	read := tracer.spans[1]
+	if read.err == nil || read.attributes["localfs.outcome"] != "error" {
+		t.Errorf("expected read span to record error got %+v", read)
+	}
+}
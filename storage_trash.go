@@ -0,0 +1,320 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// TrashDirectory is directory under storage root holding soft deleted entries
+const TrashDirectory = ".trash"
+
+// TrashPolicy limits trash, oldest entries are purged once any limit is
+// exceeded, non positive limit means unlimited
+type TrashPolicy struct {
+	MaxBytes   int64
+	MaxEntries int
+	MaxAge     time.Duration
+}
+
+// TrashMetrics represents usage of trash
+type TrashMetrics struct {
+	Entries     int       `json:"entries"`
+	Bytes       int64     `json:"bytes"`
+	Oldest      time.Time `json:"oldest"`
+	Purged      int64     `json:"purged"`
+	PurgedBytes int64     `json:"purgedBytes"`
+}
+
+type trashEntry struct {
+	id      string
+	deleted time.Time
+	bytes   int64
+}
+
+// trashState holds running usage of trash so Delete walks whole trash only
+// when limits of policy are exceeded, usage is unknown until first purge
+type trashState struct {
+	sync.Mutex
+	last        int64
+	purged      int64
+	purgedBytes int64
+	known       bool
+	entries     int
+	bytes       int64
+	oldest      time.Time
+}
+
+// TrashStorage is a fascade moving deleted entries into trash instead of
+// removing them
+type TrashStorage struct {
+	Storage
+	root   string
+	policy TrashPolicy
+	state  *trashState
+}
+
+// NewTrashStorage returns storage soft deleting into trash of underlying
+// storage root limited by given policy
+func NewTrashStorage(underlying Storage, policy TrashPolicy) (Storage, error) {
+	root, ok := rootOf(underlying)
+	if !ok {
+		return NilStorage{}, fmt.Errorf("trash requires local storage")
+	}
+	return TrashStorage{
+		Storage: underlying,
+		root:    filepath.Clean(root),
+		policy:  policy,
+		state:   new(trashState),
+	}, nil
+}
+
+func (storage TrashStorage) unwrap() Storage {
+	return storage.Storage
+}
+
+// Delete moves given path into trash and purges trash exceeding policy,
+// missing path is not an error, root and trash itself cannot be deleted and
+// entries inside trash are deleted permanently
+func (storage TrashStorage) Delete(path string) error {
+	relPath := strings.TrimPrefix(filepath.Clean("/"+path), "/")
+	switch {
+	case relPath == "" || relPath == TrashDirectory:
+		return &os.PathError{Op: "delete", Path: path, Err: syscall.EINVAL}
+	case strings.HasPrefix(relPath, TrashDirectory+"/"):
+		defer storage.forget()
+		return storage.Storage.Delete(relPath)
+	}
+	source := filepath.Join(storage.root, relPath)
+	if _, err := os.Lstat(source); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	bytes := treeSize(source)
+	storage.state.Lock()
+	id := time.Now().UnixNano()
+	if id <= storage.state.last {
+		id = storage.state.last + 1
+	}
+	storage.state.last = id
+	storage.state.Unlock()
+	target := filepath.Join(storage.root, TrashDirectory, strconv.FormatInt(id, 10), relPath)
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Rename(source, target); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !storage.account(time.Unix(0, id), bytes) {
+		return nil
+	}
+	_, err := storage.Purge()
+	return err
+}
+
+// account adds trashed entry to running usage and returns true when trash
+// has to be purged
+func (storage TrashStorage) account(deleted time.Time, bytes int64) bool {
+	storage.state.Lock()
+	defer storage.state.Unlock()
+	if !storage.state.known {
+		return true
+	}
+	storage.state.entries++
+	storage.state.bytes += bytes
+	if storage.state.oldest.IsZero() {
+		storage.state.oldest = deleted
+	}
+	return (storage.policy.MaxEntries > 0 && storage.state.entries > storage.policy.MaxEntries) ||
+		(storage.policy.MaxBytes > 0 && storage.state.bytes > storage.policy.MaxBytes) ||
+		(storage.policy.MaxAge > 0 && time.Since(storage.state.oldest) > storage.policy.MaxAge)
+}
+
+// forget marks running usage unknown after trash was changed other way
+func (storage TrashStorage) forget() {
+	storage.state.Lock()
+	storage.state.known = false
+	storage.state.Unlock()
+}
+
+// treeSize returns total size of regular files under given path
+func treeSize(absPath string) int64 {
+	var bytes int64
+	filepath.WalkDir(absPath, func(_ string, item fs.DirEntry, err error) error {
+		if err != nil || !item.Type().IsRegular() {
+			return nil
+		}
+		if info, err := item.Info(); err == nil {
+			bytes += info.Size()
+		}
+		return nil
+	})
+	return bytes
+}
+
+// Restore moves most recently deleted version of given path back from trash
+func (storage TrashStorage) Restore(path string) error {
+	entries, err := storage.entries(false)
+	if err != nil {
+		return err
+	}
+	target := filepath.Clean(storage.root + "/" + path)
+	for i := len(entries) - 1; i >= 0; i-- {
+		source := filepath.Join(storage.root, TrashDirectory, entries[i].id, filepath.Clean("/"+path))
+		if _, err := os.Lstat(source); err != nil {
+			continue
+		}
+		if _, err := os.Lstat(target); err == nil {
+			return &os.PathError{Op: "restore", Path: path, Err: os.ErrExist}
+		}
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.Rename(source, target); err != nil {
+			return err
+		}
+		storage.prune(filepath.Dir(source), filepath.Join(storage.root, TrashDirectory))
+		storage.forget()
+		return nil
+	}
+	return &os.PathError{Op: "restore", Path: path, Err: os.ErrNotExist}
+}
+
+// prune removes empty directories left behind restored entry
+func (storage TrashStorage) prune(dir string, stop string) {
+	for dir != stop && len(dir) > len(stop) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// entries returns trash entries from oldest, sizes are computed only when
+// asked for
+func (storage TrashStorage) entries(sized bool) ([]trashEntry, error) {
+	dirs, err := os.ReadDir(filepath.Join(storage.root, TrashDirectory))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := make([]trashEntry, 0, len(dirs))
+	for _, dir := range dirs {
+		nanos, err := strconv.ParseInt(dir.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		entry := trashEntry{id: dir.Name(), deleted: time.Unix(0, nanos)}
+		if sized {
+			entry.bytes = treeSize(filepath.Join(storage.root, TrashDirectory, dir.Name()))
+		}
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].deleted.Before(result[j].deleted)
+	})
+	return result, nil
+}
+
+// Purge removes oldest trash entries until trash fits policy and returns
+// number of purged entries
+func (storage TrashStorage) Purge() (int, error) {
+	entries, err := storage.entries(true)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, entry := range entries {
+		total += entry.bytes
+	}
+	now := time.Now()
+	purged := 0
+	for _, entry := range entries {
+		remaining := len(entries) - purged
+		expired := storage.policy.MaxAge > 0 && now.Sub(entry.deleted) > storage.policy.MaxAge
+		tooMany := storage.policy.MaxEntries > 0 && remaining > storage.policy.MaxEntries
+		tooBig := storage.policy.MaxBytes > 0 && total > storage.policy.MaxBytes
+		if !expired && !tooMany && !tooBig {
+			break
+		}
+		if err := os.RemoveAll(filepath.Join(storage.root, TrashDirectory, entry.id)); err != nil {
+			storage.forget()
+			return purged, err
+		}
+		total -= entry.bytes
+		purged++
+		storage.state.Lock()
+		storage.state.purged++
+		storage.state.purgedBytes += entry.bytes
+		storage.state.Unlock()
+	}
+	storage.state.Lock()
+	storage.state.known = true
+	storage.state.entries = len(entries) - purged
+	storage.state.bytes = total
+	storage.state.oldest = time.Time{}
+	if purged < len(entries) {
+		storage.state.oldest = entries[purged].deleted
+	}
+	storage.state.Unlock()
+	return purged, nil
+}
+
+// Name returns name of trash purging maintenance task
+func (storage TrashStorage) Name() string {
+	return "trash"
+}
+
+// Run purges trash so entries expire by MaxAge without further deletes
+func (storage TrashStorage) Run() error {
+	_, err := storage.Purge()
+	return err
+}
+
+// TrashMetrics returns current usage of trash and totals purged so far
+func (storage TrashStorage) TrashMetrics() (TrashMetrics, error) {
+	var result TrashMetrics
+	entries, err := storage.entries(true)
+	if err != nil {
+		return result, err
+	}
+	result.Entries = len(entries)
+	for _, entry := range entries {
+		result.Bytes += entry.bytes
+	}
+	if len(entries) > 0 {
+		result.Oldest = entries[0].deleted
+	}
+	storage.state.Lock()
+	result.Purged = storage.state.purged
+	result.PurgedBytes = storage.state.purgedBytes
+	storage.state.Unlock()
+	return result, nil
+}
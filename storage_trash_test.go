@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestTrash(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	underlying, _ := NewPlaintextStorage(tmpdir)
+	storage, err := NewTrashStorage(underlying, TrashPolicy{MaxEntries: 3, MaxBytes: 10})
+	if err != nil {
+		t.Fatalf("unexpected error when calling NewTrashStorage %+v", err)
+	}
+	trash := storage.(TrashStorage)
+
+	t.Log("delete moves into trash")
+	{
+		storage.WriteFile("a/b", []byte("abc"))
+		if err := storage.Delete("a/b"); err != nil {
+			t.Fatalf("unexpected error when calling Delete %+v", err)
+		}
+		if ok, _ := storage.Exists("a/b"); ok {
+			t.Errorf("expected a/b to be deleted")
+		}
+		metrics, _ := trash.TrashMetrics()
+		if metrics.Entries != 1 || metrics.Bytes != 3 {
+			t.Errorf("expected 1 entry of 3 bytes got %+v", metrics)
+		}
+	}
+
+	t.Log("restore brings back latest version")
+	{
+		storage.WriteFile("a/b", []byte("abcd"))
+		storage.Delete("a/b")
+		if err := trash.Restore("a/b"); err != nil {
+			t.Fatalf("unexpected error when calling Restore %+v", err)
+		}
+		if data, _ := storage.ReadFileFully("a/b"); string(data) != "abcd" {
+			t.Errorf("expected abcd got %s", string(data))
+		}
+		if err := trash.Restore("a/b"); !os.IsExist(err) {
+			t.Errorf("expected exist error got %+v", err)
+		}
+	}
+
+	t.Log("purges oldest entries over limits")
+	{
+		storage.Delete("a/b")
+		storage.WriteFile("c", []byte("12345678"))
+		storage.Delete("c")
+		metrics, _ := trash.TrashMetrics()
+		if metrics.Bytes > 10 {
+			t.Errorf("expected trash to fit 10 bytes got %+v", metrics)
+		}
+		if metrics.Purged == 0 {
+			t.Errorf("expected purge to be recorded got %+v", metrics)
+		}
+		if err := trash.Restore("c"); err != nil {
+			t.Errorf("expected newest entry to survive purge got %+v", err)
+		}
+	}
+
+	t.Log("deleting missing path is not an error")
+	{
+		if err := storage.Delete("missing"); err != nil {
+			t.Errorf("expected nil got %+v", err)
+		}
+	}
+
+	t.Log("rejects root and trash itself")
+	{
+		for _, path := range []string{"", "/", TrashDirectory} {
+			if err := storage.Delete(path); err == nil {
+				t.Errorf("expected error when deleting %q", path)
+			}
+		}
+		if ok, _ := underlying.Exists("c"); !ok {
+			t.Errorf("expected root to stay intact")
+		}
+	}
+}
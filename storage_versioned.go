@@ -0,0 +1,167 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VersionedStorage is a fascade that keeps previous versions of files
+// overwritten by WriteFile as path.v0001, path.v0002 … hidden from listings
+type VersionedStorage struct {
+	Storage
+	keep int
+}
+
+// NewVersionedStorage returns storage keeping up to keep previous versions of
+// every file written via WriteFile
+func NewVersionedStorage(underlying Storage, keep int) Storage {
+	return VersionedStorage{
+		Storage: underlying,
+		keep:    keep,
+	}
+}
+
+func (storage VersionedStorage) unwrap() Storage {
+	return storage.Storage
+}
+
+func versionPath(path string, version int) string {
+	return fmt.Sprintf("%s.v%04d", path, version)
+}
+
+// isVersionName returns true for name of kept version of file
+func isVersionName(name string) bool {
+	i := strings.LastIndex(name, ".v")
+	if i <= 0 || len(name)-i-2 < 4 {
+		return false
+	}
+	version, err := strconv.Atoi(name[i+2:])
+	return err == nil && version > 0
+}
+
+// ListDirectory returns sorted slice of item names in given path without
+// kept versions
+func (storage VersionedStorage) ListDirectory(path string, ascending bool) ([]string, error) {
+	names, err := storage.Storage.ListDirectory(path, ascending)
+	if err != nil {
+		return nil, err
+	}
+	result := names[:0]
+	for _, name := range names {
+		if !isVersionName(name) {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+// CountFiles returns number of files in directory without kept versions
+func (storage VersionedStorage) CountFiles(path string) (int, error) {
+	count, err := storage.Storage.CountFiles(path)
+	if err != nil {
+		return 0, err
+	}
+	names, err := storage.Storage.ListDirectory(path, true)
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range names {
+		if isVersionName(name) {
+			count--
+		}
+	}
+	return count, nil
+}
+
+// ListVersions returns ascending version numbers kept for given path
+func (storage VersionedStorage) ListVersions(path string) ([]int, error) {
+	dir, base := filepath.Split(filepath.Clean(path))
+	names, err := storage.Storage.ListDirectory(dir, true)
+	if err != nil {
+		return nil, err
+	}
+	prefix := base + ".v"
+	result := make([]int, 0)
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		version, err := strconv.Atoi(name[len(prefix):])
+		if err != nil || version <= 0 {
+			continue
+		}
+		result = append(result, version)
+	}
+	sort.Ints(result)
+	return result, nil
+}
+
+// ReadVersion reads whole content of given version of file
+func (storage VersionedStorage) ReadVersion(path string, version int) ([]byte, error) {
+	return storage.Storage.ReadFileFully(versionPath(filepath.Clean(path), version))
+}
+
+// WriteFile preserves current content of file as new version, prunes versions
+// beyond limit and then writes data, rotation runs under ordering lock of
+// path so concurrent writers never pick same version
+func (storage VersionedStorage) WriteFile(path string, data []byte) error {
+	path = filepath.Clean(path)
+	root, _ := rootOf(storage.Storage)
+	defer lockPathOrder(&versionOrder, filepath.Clean(root+"/"+path)).Unlock()
+	ok, err := storage.Storage.Exists(path)
+	if err != nil {
+		return err
+	}
+	if ok && storage.keep > 0 {
+		current, err := storage.Storage.ReadFileFully(path)
+		if err != nil {
+			return err
+		}
+		versions, err := storage.ListVersions(path)
+		if err != nil {
+			return err
+		}
+		next := 1
+		if len(versions) > 0 {
+			next = versions[len(versions)-1] + 1
+		}
+		if err = storage.Storage.WriteFileExclusive(versionPath(path, next), current); err != nil {
+			return err
+		}
+		versions = append(versions, next)
+		for len(versions) > storage.keep {
+			if err = storage.Storage.Delete(versionPath(path, versions[0])); err != nil {
+				return err
+			}
+			versions = versions[1:]
+		}
+	}
+	return storage.Storage.WriteFile(path, data)
+}
+
+// RollbackTo replaces content of file with given version, current content is
+// preserved as new version
+func (storage VersionedStorage) RollbackTo(path string, version int) error {
+	data, err := storage.ReadVersion(path, version)
+	if err != nil {
+		return err
+	}
+	return storage.WriteFile(path, data)
+}
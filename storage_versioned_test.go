@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestVersionedStorage(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	underlying, _ := NewEncryptedStorage(tmpdir, getKey())
+	storage := NewVersionedStorage(underlying, 2)
+	versioned := storage.(VersionedStorage)
+
+	for _, content := range []string{"a", "b", "c", "d"} {
+		if err = storage.WriteFile("snapshot/foo", []byte(content)); err != nil {
+			t.Fatalf("unexpected error when calling WriteFile %+v", err)
+		}
+	}
+
+	versions, err := versioned.ListVersions("snapshot/foo")
+	if err != nil {
+		t.Fatalf("unexpected error when calling ListVersions %+v", err)
+	}
+	if len(versions) != 2 || versions[0] != 2 || versions[1] != 3 {
+		t.Fatalf("expected versions [2 3] got %+v", versions)
+	}
+
+	data, err := versioned.ReadVersion("snapshot/foo", 2)
+	if err != nil {
+		t.Fatalf("unexpected error when calling ReadVersion %+v", err)
+	}
+	if string(data) != "b" {
+		t.Errorf("expected version 2 to be b got %s", string(data))
+	}
+
+	if err = versioned.RollbackTo("snapshot/foo", 2); err != nil {
+		t.Fatalf("unexpected error when calling RollbackTo %+v", err)
+	}
+	data, _ = storage.ReadFileFully("snapshot/foo")
+	if string(data) != "b" {
+		t.Errorf("expected rolled back content b got %s", string(data))
+	}
+	data, _ = versioned.ReadVersion("snapshot/foo", 4)
+	if string(data) != "d" {
+		t.Errorf("expected content before rollback to be kept as version 4 got %s", string(data))
+	}
+
+	t.Log("kept versions are hidden from listings")
+	{
+		names, err := storage.ListDirectory("snapshot", true)
+		if err != nil || len(names) != 1 || names[0] != "foo" {
+			t.Errorf("expected only foo got %+v %+v", names, err)
+		}
+		if count, err := storage.CountFiles("snapshot"); err != nil || count != 1 {
+			t.Errorf("expected single file got %d %+v", count, err)
+		}
+	}
+
+	t.Log("concurrent writers rotate distinct versions")
+	{
+		storage := NewVersionedStorage(underlying, 100)
+		storage.WriteFile("concurrent", []byte("initial"))
+		var wg sync.WaitGroup
+		failures := make(chan error, 16)
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if err := storage.WriteFile("concurrent", []byte(fmt.Sprintf("%d", i))); err != nil {
+					failures <- err
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(failures)
+		for err := range failures {
+			t.Errorf("unexpected error when calling WriteFile %+v", err)
+		}
+		if versions, _ := storage.(VersionedStorage).ListVersions("concurrent"); len(versions) != 16 {
+			t.Errorf("expected 16 versions got %+v", versions)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || freebsd
+
+package storage
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// oNoAtime is zero because BSD kernels have no O_NOATIME, access time is
+	// governed by mount options only
+	oNoAtime = 0
+	// oPath is zero because BSD kernels have no O_PATH, directory is opened
+	// for reading instead
+	oPath = 0
+	// fcntlSetLock takes process associated lock because BSD kernels have no
+	// open file description locks
+	fcntlSetLock = unix.F_SETLK
+)
+
+// statx masks, Fstatat always fetches everything so they only keep callers
+// portable
+const (
+	statxType = 1 << iota
+	statxSize
+	statxMtime
+	statxBtime
+)
+
+var (
+	defaultIoctlFileClone = func(destFd int, srcFd int) error {
+		return syscall.ENOTSUP
+	}
+	defaultCopyFileRange = func(rfd int, roff *int64, wfd int, woff *int64, len int, flags int) (int, error) {
+		return 0, syscall.ENOSYS
+	}
+	defaultSendFile = unix.Sendfile
+	defaultWritev   = writev
+)
+
+// writev emulates vectored write by writing segments in order, it stops at
+// first short write and reports bytes written so far so caller writes rest
+func writev(fd int, segments [][]byte) (int, error) {
+	written := 0
+	for _, segment := range segments {
+		if len(segment) == 0 {
+			continue
+		}
+		n, err := syscall.Write(fd, segment)
+		if n > 0 {
+			written += n
+		}
+		if err != nil {
+			if written > 0 {
+				return written, nil
+			}
+			return 0, err
+		}
+		if n < len(segment) {
+			break
+		}
+	}
+	return written, nil
+}
+
+// direntNameLen returns length of name of directory entry, BSD dirent
+// carries it unlike Linux where record is NUL padded
+func direntNameLen(record []byte) int {
+	return int(direntUint16(record, int(unsafe.Offsetof(syscall.Dirent{}.Namlen))))
+}
+
+func statMtime(stat *syscall.Stat_t) time.Time {
+	return time.Unix(int64(stat.Mtimespec.Sec), int64(stat.Mtimespec.Nsec))
+}
+
+func statAtime(stat *syscall.Stat_t) time.Time {
+	return time.Unix(int64(stat.Atimespec.Sec), int64(stat.Atimespec.Nsec))
+}
+
+func statCtime(stat *syscall.Stat_t) time.Time {
+	return time.Unix(int64(stat.Ctimespec.Sec), int64(stat.Ctimespec.Nsec))
+}
+
+// statAt fetches metadata of name relative to directory descriptor without
+// following symlinks, birth time is zero when filesystem does not record it
+func statAt(dirfd int, name string, mask int) (fileMeta, error) {
+	var stat unix.Stat_t
+	if err := unix.Fstatat(dirfd, name, &stat, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return fileMeta{}, err
+	}
+	meta := fileMeta{
+		mode:     stat.Mode,
+		size:     stat.Size,
+		modified: time.Unix(int64(stat.Mtim.Sec), int64(stat.Mtim.Nsec)),
+	}
+	if stat.Btim.Sec > 0 {
+		meta.birth = time.Unix(int64(stat.Btim.Sec), int64(stat.Btim.Nsec))
+	}
+	return meta, nil
+}
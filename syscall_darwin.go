@@ -0,0 +1,87 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// posix_fadvise advice, darwin has no posix_fadvise so they only keep
+// callers portable
+const (
+	fadvSequential = 2
+	fadvWillNeed   = 3
+	fadvDontNeed   = 4
+)
+
+var defaultFadvise = func(fd int, offset int64, length int64, advice int) error {
+	return syscall.ENOTSUP
+}
+
+// direntIno returns inode of directory entry, zero means deleted entry
+func direntIno(record []byte) uint64 {
+	return direntUint64(record, int(unsafe.Offsetof(syscall.Dirent{}.Ino)))
+}
+
+// openDirect opens file with F_NOCACHE which is darwin counterpart of
+// O_DIRECT, EINVAL means filesystem does not support it
+func openDirect(filename string, flags int, mode uint32) (int, error) {
+	fd, err := openRetrying(filename, flags, mode)
+	if err != nil {
+		return fd, err
+	}
+	if _, err = unix.FcntlInt(uintptr(fd), unix.F_NOCACHE, 1); err != nil {
+		syscall.Close(fd)
+		return -1, syscall.EINVAL
+	}
+	return fd, nil
+}
+
+// clearDirect switches descriptor back to page cache IO
+func clearDirect(fd int) error {
+	_, err := unix.FcntlInt(uintptr(fd), unix.F_NOCACHE, 0)
+	return err
+}
+
+// reserveSpace allocates blocks of file up to size keeping its size,
+// contiguous allocation is preferred
+func reserveSpace(fd int, size int64) error {
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(fd, &stat); err != nil {
+		return err
+	}
+	missing := size - stat.Blocks*512
+	if missing <= 0 {
+		return nil
+	}
+	store := unix.Fstore_t{
+		Flags:   unix.F_ALLOCATECONTIG | unix.F_ALLOCATEALL,
+		Posmode: unix.F_PEOFPOSMODE,
+		Length:  missing,
+	}
+	if err := unix.FcntlFstore(uintptr(fd), unix.F_PREALLOCATE, &store); err == nil {
+		return nil
+	}
+	store.Flags = unix.F_ALLOCATEALL
+	return unix.FcntlFstore(uintptr(fd), unix.F_PREALLOCATE, &store)
+}
+
+// punchHole is not supported, F_PUNCHHOLE has no binding
+func punchHole(fd int, offset int64, length int64) error {
+	return syscall.ENOTSUP
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// posix_fadvise advice
+const (
+	fadvSequential = unix.FADV_SEQUENTIAL
+	fadvWillNeed   = unix.FADV_WILLNEED
+	fadvDontNeed   = unix.FADV_DONTNEED
+)
+
+var defaultFadvise = unix.Fadvise
+
+// direntIno returns inode of directory entry, zero means deleted entry
+func direntIno(record []byte) uint64 {
+	return direntUint64(record, int(unsafe.Offsetof(syscall.Dirent{}.Fileno)))
+}
+
+// openDirect opens file with O_DIRECT, EINVAL means filesystem does not
+// support direct IO
+func openDirect(filename string, flags int, mode uint32) (int, error) {
+	return openRetrying(filename, flags|syscall.O_DIRECT, mode)
+}
+
+// clearDirect switches descriptor back to page cache IO
+func clearDirect(fd int) error {
+	flags, err := unix.FcntlInt(uintptr(fd), syscall.F_GETFL, 0)
+	if err != nil {
+		return err
+	}
+	_, err = unix.FcntlInt(uintptr(fd), syscall.F_SETFL, flags&^syscall.O_DIRECT)
+	return err
+}
+
+// reserveSpace is not supported, posix_fallocate has no binding and would
+// change file size
+func reserveSpace(fd int, size int64) error {
+	return syscall.ENOTSUP
+}
+
+// punchHole is not supported, fspacectl has no binding
+func punchHole(fd int, offset int64, length int64) error {
+	return syscall.ENOTSUP
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// oNoAtime suppresses update of access time of files opened for reading
+	oNoAtime = syscall.O_NOATIME
+	// oPath opens directory only as anchor of relative lookups
+	oPath = unix.O_PATH
+	// fcntlSetLock takes open file description lock so locks of descriptors
+	// within same process exclude each other
+	fcntlSetLock = unix.F_OFD_SETLK
+)
+
+// statx masks selecting fetched metadata
+const (
+	statxType  = unix.STATX_TYPE
+	statxSize  = unix.STATX_SIZE
+	statxMtime = unix.STATX_MTIME
+	statxBtime = unix.STATX_BTIME
+)
+
+// posix_fadvise advice
+const (
+	fadvSequential = unix.FADV_SEQUENTIAL
+	fadvWillNeed   = unix.FADV_WILLNEED
+	fadvDontNeed   = unix.FADV_DONTNEED
+)
+
+var (
+	defaultIoctlFileClone = unix.IoctlFileClone
+	defaultCopyFileRange  = unix.CopyFileRange
+	defaultSendFile       = unix.Sendfile
+	defaultWritev         = unix.Writev
+	defaultFadvise        = unix.Fadvise
+)
+
+// direntIno returns inode of directory entry, zero means deleted entry
+func direntIno(record []byte) uint64 {
+	return direntUint64(record, int(unsafe.Offsetof(syscall.Dirent{}.Ino)))
+}
+
+// direntNameLen returns upper bound of length of name of directory entry,
+// name is NUL padded up to record length
+func direntNameLen(record []byte) int {
+	return len(record) - direntNameOffset
+}
+
+func statMtime(stat *syscall.Stat_t) time.Time {
+	return time.Unix(int64(stat.Mtim.Sec), int64(stat.Mtim.Nsec))
+}
+
+func statAtime(stat *syscall.Stat_t) time.Time {
+	return time.Unix(int64(stat.Atim.Sec), int64(stat.Atim.Nsec))
+}
+
+func statCtime(stat *syscall.Stat_t) time.Time {
+	return time.Unix(int64(stat.Ctim.Sec), int64(stat.Ctim.Nsec))
+}
+
+// openDirect opens file with O_DIRECT, EINVAL means filesystem does not
+// support direct IO
+func openDirect(filename string, flags int, mode uint32) (int, error) {
+	return openRetrying(filename, flags|syscall.O_DIRECT, mode)
+}
+
+// clearDirect switches descriptor back to page cache IO
+func clearDirect(fd int) error {
+	flags, err := unix.FcntlInt(uintptr(fd), syscall.F_GETFL, 0)
+	if err != nil {
+		return err
+	}
+	_, err = unix.FcntlInt(uintptr(fd), syscall.F_SETFL, flags&^syscall.O_DIRECT)
+	return err
+}
+
+// reserveSpace allocates blocks of file up to size keeping its size
+func reserveSpace(fd int, size int64) error {
+	return unix.Fallocate(fd, unix.FALLOC_FL_KEEP_SIZE, 0, size)
+}
+
+// punchHole deallocates range of file keeping its size
+func punchHole(fd int, offset int64, length int64) error {
+	return unix.Fallocate(fd, unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, offset, length)
+}
+
+// statAt fetches metadata selected by mask of name relative to directory
+// descriptor without following symlinks
+func statAt(dirfd int, name string, mask int) (fileMeta, error) {
+	var stat unix.Statx_t
+	if err := unix.Statx(dirfd, name, unix.AT_SYMLINK_NOFOLLOW|unix.AT_STATX_DONT_SYNC, mask, &stat); err != nil {
+		return fileMeta{}, err
+	}
+	meta := fileMeta{
+		mode: stat.Mode,
+		size: int64(stat.Size),
+	}
+	if stat.Mask&unix.STATX_MTIME != 0 {
+		meta.modified = time.Unix(stat.Mtime.Sec, int64(stat.Mtime.Nsec))
+	}
+	if stat.Mask&unix.STATX_BTIME != 0 {
+		meta.birth = time.Unix(stat.Btime.Sec, int64(stat.Btime.Nsec))
+	}
+	return meta, nil
+}
@@ -0,0 +1,341 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// fdReader reads descriptor sequentially with pread from offset up to end
+type fdReader struct {
+	fd     int
+	offset int64
+	end    int64
+}
+
+func (reader *fdReader) Read(p []byte) (int, error) {
+	if reader.offset >= reader.end {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > reader.end-reader.offset {
+		p = p[:reader.end-reader.offset]
+	}
+	n, err := preadFull(reader.fd, p, reader.offset)
+	reader.offset += int64(n)
+	if err != nil {
+		return n, err
+	}
+	if n == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return n, nil
+}
+
+// fdWriter writes descriptor sequentially
+type fdWriter int
+
+func (writer fdWriter) Write(p []byte) (int, error) {
+	if err := writeFull(int(writer), p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Transfer copies file of src storage to file of dst storage, plaintext to
+// plaintext transfer stays in kernel using reflink, copy_file_range or
+// sendfile, other combinations stream through decryption and encryption with
+// bounded buffer, only AEAD mode and foreign storages hold whole file in
+// memory, target appears atomically
+func Transfer(dst Storage, dstPath string, src Storage, srcPath string) error {
+	source, plainSource := src.(PlaintextStorage)
+	target, plainTarget := dst.(PlaintextStorage)
+	if plainSource && plainTarget {
+		defer target.barrier.enter()()
+		_, err := cloneFile(filepath.Clean(source.root+"/"+srcPath), filepath.Clean(target.root+"/"+dstPath), target.bufferSize, target.handles, target.lockTimeout)
+		return err
+	}
+	bufferSize := 8192
+	if plainSource {
+		bufferSize = source.bufferSize
+	} else if encrypted, ok := src.(EncryptedStorage); ok {
+		bufferSize = encrypted.bufferSize
+	}
+	buffer := getScratch(bufferSize)
+	defer putScratch(buffer)
+	return streamTo(dst, dstPath, func(w io.Writer) error {
+		return streamFrom(src, srcPath, w, *buffer)
+	})
+}
+
+// streamFrom writes plaintext content of file into w
+func streamFrom(storage Storage, path string, w io.Writer, buffer []byte) error {
+	switch source := storage.(type) {
+	case PlaintextStorage:
+		return withSharedLock(filepath.Clean(source.root+"/"+path), source.handles, source.noAtime, source.lockTimeout, func(fd int, size int64) error {
+			_, err := io.CopyBuffer(w, &fdReader{fd: fd, end: size}, buffer)
+			return err
+		})
+	case EncryptedStorage:
+		reader, chunked, err := source.openChunked(path)
+		if err != nil {
+			return err
+		}
+		if chunked {
+			defer reader.Close()
+			_, err = io.CopyBuffer(w, reader, buffer)
+			return err
+		}
+		if !source.aead {
+			return source.streamDecrypt(path, w, buffer)
+		}
+	}
+	data, err := storage.ReadFileFully(path)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// streamTo replaces file with content written by fill
+func streamTo(storage Storage, path string, fill func(w io.Writer) error) error {
+	switch target := storage.(type) {
+	case PlaintextStorage:
+		defer target.barrier.enter()()
+		return writeReplacing(filepath.Clean(target.root+"/"+path), target.handles, target.lockTimeout, func(fd int) error {
+			return fill(fdWriter(fd))
+		})
+	case EncryptedStorage:
+		defer target.barrier.enter()()
+		if !target.aead && target.chunkSize == 0 {
+			return writeReplacing(filepath.Clean(target.root+"/"+path), target.handles, target.lockTimeout, func(fd int) error {
+				return target.streamEncrypt(fdWriter(fd), fill)
+			})
+		}
+		// AEAD tag and chunked format need whole content before encryption
+		var content bytes.Buffer
+		if err := fill(&content); err != nil {
+			return err
+		}
+		return writeReplacing(filepath.Clean(target.root+"/"+path), target.handles, target.lockTimeout, func(fd int) error {
+			segments, err := target.encryptSegments(path, content.Bytes())
+			if err != nil {
+				return err
+			}
+			return writevFull(fd, segments)
+		})
+	}
+	var content bytes.Buffer
+	if err := fill(&content); err != nil {
+		return err
+	}
+	return storage.WriteFile(path, content.Bytes())
+}
+
+// atomicReplacer is implemented by storages replacing whole file so crash
+// leaves either old or new content
+type atomicReplacer interface {
+	replaceFile(path string, data []byte) error
+}
+
+// replaceFile replaces file with data so crash never leaves file torn, local
+// storages write it through temporary file renamed into place, decorators
+// forward it keeping their semantics, ENOTSUP is returned by storages unable
+// to replace file atomically
+func replaceFile(storage Storage, path string, data []byte) error {
+	if candidate, ok := storage.(atomicReplacer); ok {
+		return candidate.replaceFile(path, data)
+	}
+	return syscall.ENOTSUP
+}
+
+// streamData returns fill writing data
+func streamData(data []byte) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}
+}
+
+func (storage PlaintextStorage) replaceFile(path string, data []byte) error {
+	return streamTo(storage, path, streamData(data))
+}
+
+func (storage EncryptedStorage) replaceFile(path string, data []byte) error {
+	return streamTo(storage, path, streamData(data))
+}
+
+// writeReplacing writes file through temporary file renamed into place once
+// fn succeeds, rename is made under lock of path and exclusive lock of
+// replaced file and directory is synced so rename survives crash
+func writeReplacing(filename string, handles *handleRegistry, timeout time.Duration, fn func(fd int) error) error {
+	defer lockPath(filename).Unlock()
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+		return err
+	}
+	temporary := temporarySibling(filename, "transfer")
+	fd, err := openRetrying(temporary, syscall.O_CREAT|syscall.O_WRONLY|syscall.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer handles.track(filename, "transfer")()
+	err = fn(fd)
+	if err == nil {
+		err = syscall.Fsync(fd)
+	}
+	syscall.Close(fd)
+	if err == nil {
+		err = renameLocked(temporary, filename, timeout)
+	}
+	if err != nil {
+		os.Remove(temporary)
+		return err
+	}
+	return syncDirectory(filepath.Dir(filename))
+}
+
+// renameLocked renames temporary over filename under exclusive lock of
+// replaced file so writers of other processes holding it finish first
+func renameLocked(temporary string, filename string, timeout time.Duration) error {
+	fd, err := openRetrying(filename, syscall.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err == syscall.ENOENT {
+		return os.Rename(temporary, filename)
+	}
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	if err = flock(fd, filename, syscall.LOCK_EX, timeout); err != nil {
+		return err
+	}
+	defer funlock(fd, filename)
+	return os.Rename(temporary, filename)
+}
+
+// syncDirectory flushes entries of directory so renames into it are durable
+func syncDirectory(dir string) error {
+	fd, err := openRetrying(dir, syscall.O_RDONLY|syscall.O_DIRECTORY, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	return syscall.Fsync(fd)
+}
+
+// streamDecrypt writes decrypted content of CFB encrypted file into w, in
+// authenticated mode tag is verified once whole file was streamed so caller
+// must discard output on error
+func (storage EncryptedStorage) streamDecrypt(path string, w io.Writer, buffer []byte) error {
+	return withSharedLock(filepath.Clean(storage.root+"/"+path), storage.handles, storage.noAtime, storage.lockTimeout, func(fd int, size int64) error {
+		prefix := make([]byte, len(keyHeaderMagic)+1+255)
+		n, err := preadFull(fd, prefix, 0)
+		if err != nil {
+			return err
+		}
+		offset, _, key := storage.header(prefix[:n])
+		var trailer int64
+		if storage.authenticate {
+			trailer = sha256.Size
+		}
+		if size-int64(offset)-aes.BlockSize-trailer < 0 {
+			if storage.authenticate {
+				return ErrIntegrity
+			}
+			return fmt.Errorf("invalid blocksize expected %d but actual is %d", aes.BlockSize, size-int64(offset))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return err
+		}
+		iv := make([]byte, aes.BlockSize)
+		if _, err = preadFull(fd, iv, int64(offset)); err != nil {
+			return err
+		}
+		var (
+			reader io.Reader = &fdReader{fd: fd, offset: int64(offset) + aes.BlockSize, end: size - trailer}
+			mac    hash.Hash
+		)
+		if storage.authenticate {
+			mac = newTag(key)
+			mac.Write(prefix[:offset])
+			mac.Write(iv)
+			reader = io.TeeReader(reader, mac)
+		}
+		if _, err = io.CopyBuffer(w, cipher.StreamReader{S: cipher.NewCFBDecrypter(block, iv), R: reader}, buffer); err != nil {
+			return err
+		}
+		if mac == nil {
+			return nil
+		}
+		expected := make([]byte, sha256.Size)
+		if _, err = preadFull(fd, expected, size-trailer); err != nil {
+			return err
+		}
+		if !hmac.Equal(expected, mac.Sum(nil)) {
+			return ErrIntegrity
+		}
+		return nil
+	})
+}
+
+// streamEncrypt writes key header and IV into w followed by CFB ciphertext of
+// content written by fill and optional HMAC tag
+func (storage EncryptedStorage) streamEncrypt(w io.Writer, fill func(w io.Writer) error) error {
+	id, key := storage.writeKey()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	offset := 0
+	if id != "" {
+		offset = len(keyHeaderMagic) + 1 + len(id)
+	}
+	header := make([]byte, offset+aes.BlockSize)
+	writeKeyHeader(header, id)
+	iv := header[offset:]
+	if _, err = io.ReadFull(rand.Reader, iv); err != nil {
+		return err
+	}
+	if _, err = w.Write(header); err != nil {
+		return err
+	}
+	out := w
+	var mac hash.Hash
+	if storage.authenticate {
+		mac = newTag(key)
+		mac.Write(header)
+		out = io.MultiWriter(w, mac)
+	}
+	if err = fill(cipher.StreamWriter{S: cipher.NewCFBEncrypter(block, iv), W: out}); err != nil {
+		return err
+	}
+	if mac == nil {
+		return nil
+	}
+	_, err = w.Write(mac.Sum(nil))
+	return err
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTransfer(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 5000)[:79999]
+
+	plain, _ := NewPlaintextStorage(tmpdir + "/plain")
+	other, _ := NewPlaintextStorage(tmpdir + "/other")
+	cfb, _ := NewEncryptedStorage(tmpdir+"/cfb", getKey())
+	authenticated, _ := NewEncryptedStorageWithOptions(tmpdir+"/hmac", getKey(), EncryptionOptions{HMAC: true})
+	aead, _ := NewEncryptedStorageWithOptions(tmpdir+"/aead", getKey(), EncryptionOptions{AEAD: true})
+
+	plain.WriteFile("account/source", data)
+
+	t.Log("plaintext to plaintext")
+	{
+		if err := Transfer(other, "account/target", plain, "account/source"); err != nil {
+			t.Fatalf("unexpected error when calling Transfer %+v", err)
+		}
+		if content, _ := other.ReadFileFully("account/target"); !bytes.Equal(content, data) {
+			t.Errorf("expected transferred content to equal source")
+		}
+	}
+
+	t.Log("through encryption pipeline")
+	{
+		chain := []struct {
+			storage Storage
+			path    string
+		}{
+			{plain, "account/source"},
+			{cfb, "account/a"},
+			{authenticated, "account/b"},
+			{aead, "account/c"},
+			{struct{ Storage }{cfb}, "account/d"},
+			{plain, "account/e"},
+		}
+		for i := 1; i < len(chain); i++ {
+			if err := Transfer(chain[i].storage, chain[i].path, chain[i-1].storage, chain[i-1].path); err != nil {
+				t.Fatalf("unexpected error when calling Transfer to %s %+v", chain[i].path, err)
+			}
+			content, err := chain[i].storage.ReadFileFully(chain[i].path)
+			if err != nil || !bytes.Equal(content, data) {
+				t.Errorf("expected %s to equal source got %+v", chain[i].path, err)
+			}
+		}
+	}
+
+	t.Log("empty file")
+	{
+		plain.WriteFile("empty", nil)
+		if err := Transfer(authenticated, "empty", plain, "empty"); err != nil {
+			t.Fatalf("unexpected error when calling Transfer %+v", err)
+		}
+		if err := Transfer(other, "empty", authenticated, "empty"); err != nil {
+			t.Fatalf("unexpected error when calling Transfer %+v", err)
+		}
+		if content, err := other.ReadFileFully("empty"); err != nil || len(content) != 0 {
+			t.Errorf("expected empty file got %d bytes %+v", len(content), err)
+		}
+	}
+
+	t.Log("tampered source leaves target untouched")
+	{
+		other.WriteFile("account/untouched", []byte("previous"))
+		raw, _ := os.ReadFile(tmpdir + "/hmac/account/b")
+		raw[100] ^= 0xff
+		os.WriteFile(tmpdir+"/hmac/account/b", raw, 0600)
+		if err := Transfer(other, "account/untouched", authenticated, "account/b"); !errors.Is(err, ErrIntegrity) {
+			t.Errorf("expected ErrIntegrity got %+v", err)
+		}
+		if content, _ := other.ReadFileFully("account/untouched"); string(content) != "previous" {
+			t.Errorf("expected target to stay intact got %q", content)
+		}
+		if entries, _ := other.ListDirectory("account", true); len(entries) != 2 {
+			t.Errorf("expected no temporary files left got %+v", entries)
+		}
+	}
+
+	t.Log("missing source")
+	{
+		if err := Transfer(cfb, "missing", plain, "missing"); !os.IsNotExist(err) {
+			t.Errorf("expected not exist error got %+v", err)
+		}
+	}
+
+	t.Log("replacing waits for writers of target path")
+	{
+		filename := filepath.Clean(tmpdir + "/cfb/account/locked")
+		stripe := lockPath(filename)
+		done := make(chan error, 1)
+		go func() {
+			done <- Transfer(cfb, "account/locked", plain, "account/source")
+		}()
+		select {
+		case err := <-done:
+			t.Fatalf("expected transfer to wait for lock of path got %+v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		stripe.Unlock()
+		if err := <-done; err != nil {
+			t.Fatalf("unexpected error when calling Transfer %+v", err)
+		}
+		if content, _ := cfb.ReadFileFully("account/locked"); !bytes.Equal(content, data) {
+			t.Errorf("expected transferred content to equal source")
+		}
+	}
+}
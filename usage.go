@@ -0,0 +1,91 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"io/fs"
+	"path/filepath"
+	"syscall"
+)
+
+// TreeUsage represents space used by directory tree
+type TreeUsage struct {
+	Files       int64 `json:"files"`
+	Directories int64 `json:"directories"`
+	// Bytes is logical size of files
+	Bytes int64 `json:"bytes"`
+	// Allocated is size of blocks allocated to files, it is lower than Bytes
+	// for sparse or compressed files and higher for small files
+	Allocated int64 `json:"allocated"`
+}
+
+// treeUsage sums usage of tree at absPath, hard linked files are counted once
+// and control directory of root is skipped
+func treeUsage(root string, absPath string) (TreeUsage, error) {
+	var (
+		usage TreeUsage
+		seen  = make(map[[2]uint64]bool)
+	)
+	err := filepath.WalkDir(absPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if relPath, err := filepath.Rel(root, path); err == nil && isControlPath(relPath) {
+				return filepath.SkipDir
+			}
+			if path != absPath {
+				usage.Directories++
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// file removed during walk
+			return nil
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			if stat.Nlink > 1 {
+				inode := [2]uint64{uint64(stat.Dev), stat.Ino}
+				if seen[inode] {
+					return nil
+				}
+				seen[inode] = true
+			}
+			usage.Allocated += stat.Blocks * 512
+		} else {
+			usage.Allocated += info.Size()
+		}
+		usage.Files++
+		usage.Bytes += info.Size()
+		return nil
+	})
+	return usage, err
+}
+
+// DiskUsage returns number of files and directories and logical and
+// allocated bytes of tree at given path
+func (storage PlaintextStorage) DiskUsage(path string) (TreeUsage, error) {
+	return treeUsage(filepath.Clean(storage.root), filepath.Clean(storage.root+"/"+path))
+}
+
+// DiskUsage returns number of files and directories and logical and
+// allocated bytes of tree at given path, sizes are of ciphertext
+func (storage EncryptedStorage) DiskUsage(path string) (TreeUsage, error) {
+	return treeUsage(filepath.Clean(storage.root), filepath.Clean(storage.root+"/"+path))
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDiskUsage(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	plaintext := storage.(PlaintextStorage)
+	storage.WriteFile("tree/a", make([]byte, 100))
+	storage.WriteFile("tree/sub/b", make([]byte, 5000))
+	storage.WriteFile("other/c", make([]byte, 10))
+	if err = os.Link(tmpdir+"/tree/a", tmpdir+"/tree/sub/a"); err != nil {
+		t.Fatalf("unexpected error when creating hard link %+v", err)
+	}
+
+	t.Log("subtree")
+	{
+		usage, err := plaintext.DiskUsage("tree")
+		if err != nil {
+			t.Fatalf("unexpected error when calling DiskUsage %+v", err)
+		}
+		if usage.Files != 2 || usage.Directories != 1 || usage.Bytes != 5100 {
+			t.Errorf("unexpected usage %+v", usage)
+		}
+		if usage.Allocated < 5000 {
+			t.Errorf("expected allocated bytes to cover content got %+v", usage)
+		}
+	}
+
+	t.Log("root skips control directory")
+	{
+		usage, err := plaintext.DiskUsage("")
+		if err != nil {
+			t.Fatalf("unexpected error when calling DiskUsage %+v", err)
+		}
+		if usage.Files != 3 || usage.Bytes != 5110 {
+			t.Errorf("unexpected usage %+v", usage)
+		}
+	}
+
+	t.Log("missing path")
+	{
+		if _, err := plaintext.DiskUsage("missing"); err == nil {
+			t.Errorf("expected error for missing path")
+		}
+	}
+}
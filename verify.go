@@ -0,0 +1,140 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// VerifyFailure represents single file that failed verification
+type VerifyFailure struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// VerifyReport represents outcome of integrity scan
+type VerifyReport struct {
+	Prefix     string          `json:"prefix"`
+	Scanned    int             `json:"scanned"`
+	Bytes      int64           `json:"bytes"`
+	Unreadable []VerifyFailure `json:"unreadable"`
+	Corrupted  []VerifyFailure `json:"corrupted"`
+	Duration   time.Duration   `json:"duration"`
+}
+
+// Healthy returns true when no file failed verification
+func (report VerifyReport) Healthy() bool {
+	return len(report.Unreadable) == 0 && len(report.Corrupted) == 0
+}
+
+// verifyTree reads every file of subtree, decode turns raw content of path into
+// payload and its failure marks file as corrupted, payload is compared with
+// checksum of timestamp token when file has one, files are read according to
+// hints
+func verifyTree(root string, prefix string, hints scanHints, decode func(string, []byte) ([]byte, error)) (VerifyReport, error) {
+	started := time.Now()
+	report := VerifyReport{
+		Prefix:     prefix,
+		Unreadable: make([]VerifyFailure, 0),
+		Corrupted:  make([]VerifyFailure, 0),
+	}
+	base := filepath.Clean(root)
+	err := filepath.WalkDir(filepath.Clean(base+"/"+prefix), func(absPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if absPath == filepath.Clean(base+"/"+prefix) {
+				return err
+			}
+			relPath, _ := filepath.Rel(base, absPath)
+			report.Unreadable = append(report.Unreadable, VerifyFailure{Path: relPath, Reason: err.Error()})
+			return nil
+		}
+		relPath, err := filepath.Rel(base, absPath)
+		if err != nil {
+			return err
+		}
+		if isInternalPath(relPath) {
+			return filepath.SkipDir
+		}
+		if !entry.Type().IsRegular() || strings.HasSuffix(absPath, TimestampSuffix) {
+			return nil
+		}
+		report.Scanned++
+		raw, err := hints.read(absPath, nil)
+		if err != nil {
+			report.Unreadable = append(report.Unreadable, VerifyFailure{Path: relPath, Reason: err.Error()})
+			return nil
+		}
+		report.Bytes += int64(len(raw))
+		data, err := decode(relPath, raw)
+		if err != nil {
+			report.Corrupted = append(report.Corrupted, VerifyFailure{Path: relPath, Reason: err.Error()})
+			return nil
+		}
+		if reason := checkTimestampChecksum(absPath, relPath, data, decode); reason != "" {
+			report.Corrupted = append(report.Corrupted, VerifyFailure{Path: relPath, Reason: reason})
+		}
+		return nil
+	})
+	report.Duration = time.Since(started)
+	return report, err
+}
+
+func checkTimestampChecksum(absPath string, relPath string, data []byte, decode func(string, []byte) ([]byte, error)) string {
+	raw, err := os.ReadFile(absPath + TimestampSuffix)
+	if err != nil {
+		return ""
+	}
+	encoded, err := decode(relPath+TimestampSuffix, raw)
+	if err != nil {
+		return "timestamp token unreadable " + err.Error()
+	}
+	var token TimestampToken
+	if err = json.Unmarshal(encoded, &token); err != nil {
+		return "timestamp token unreadable " + err.Error()
+	}
+	sum := sha256.Sum256(data)
+	if token.Checksum != "sha256:"+hex.EncodeToString(sum[:]) {
+		return "checksum mismatch"
+	}
+	return ""
+}
+
+// Verify reads every file under given prefix and reports unreadable files
+// and files whose content does not match checksum of their timestamp token,
+// options hint kernel how to cache scanned files
+func (storage PlaintextStorage) Verify(prefix string, options ...ScanOption) (VerifyReport, error) {
+	hints := newScanHints(options)
+	hints.lockTimeout = storage.lockTimeout
+	return verifyTree(storage.root, prefix, hints, func(_ string, raw []byte) ([]byte, error) {
+		return raw, nil
+	})
+}
+
+// Verify reads and decrypts every file under given prefix and reports
+// unreadable files, files that cannot be decrypted and files whose content
+// does not match checksum of their timestamp token, options hint kernel how to
+// cache scanned files
+func (storage EncryptedStorage) Verify(prefix string, options ...ScanOption) (VerifyReport, error) {
+	hints := newScanHints(options)
+	hints.lockTimeout = storage.lockTimeout
+	return verifyTree(storage.root, prefix, hints, storage.decrypt)
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import "time"
+
+// RestoreReport represents outcome of dry run restore, Integrity covers
+// readability of backup and Difference compares backup to live data
+type RestoreReport struct {
+	Integrity  VerifyReport  `json:"integrity"`
+	Difference DiffReport    `json:"difference"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// Restorable returns true when every file of backup can be read
+func (report RestoreReport) Restorable() bool {
+	return report.Integrity.Healthy()
+}
+
+type verifiable interface {
+	Verify(string, ...ScanOption) (VerifyReport, error)
+}
+
+// verifyStorage scans storage using its own Verify when available looking
+// through decorators, other storages are verified by reading every file
+func verifyStorage(storage Storage, prefix string) (VerifyReport, error) {
+	for candidate := storage; candidate != nil; {
+		if subject, ok := candidate.(verifiable); ok {
+			return subject.Verify(prefix)
+		}
+		decorator, ok := candidate.(wrapper)
+		if !ok {
+			break
+		}
+		candidate = decorator.unwrap()
+	}
+	started := time.Now()
+	report := VerifyReport{
+		Prefix:     prefix,
+		Unreadable: make([]VerifyFailure, 0),
+		Corrupted:  make([]VerifyFailure, 0),
+	}
+	files, err := walkFiles(storage, prefix)
+	if err != nil {
+		return report, err
+	}
+	for _, file := range files {
+		report.Scanned++
+		data, err := storage.ReadFileFully(file)
+		if err != nil {
+			report.Unreadable = append(report.Unreadable, VerifyFailure{Path: file, Reason: err.Error()})
+			continue
+		}
+		report.Bytes += int64(len(data))
+	}
+	report.Duration = time.Since(started)
+	return report, nil
+}
+
+// VerifyRestore checks that every file of backup under prefix can be read
+// and decrypted and compares backup with live data without writing anything
+func VerifyRestore(backup Storage, live Storage, prefix string) (RestoreReport, error) {
+	started := time.Now()
+	var (
+		report RestoreReport
+		err    error
+	)
+	if report.Integrity, err = verifyStorage(backup, prefix); err != nil {
+		return report, err
+	}
+	// files of backup that cannot be read differ from live data
+	failed := make(map[string]bool)
+	for _, failure := range report.Integrity.Unreadable {
+		failed[failure.Path] = true
+	}
+	for _, failure := range report.Integrity.Corrupted {
+		failed[failure.Path] = true
+	}
+	report.Difference, err = diffTrees(live, backup, prefix, func(file string) (bool, error) {
+		if failed[file] {
+			return false, nil
+		}
+		return sameContent(live, backup, file)
+	})
+	if err != nil {
+		return report, err
+	}
+	report.Duration = time.Since(started)
+	return report, nil
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestVerifyRestore(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	live, _ := NewEncryptedStorage(tmpdir+"/live", getKey())
+	backup, _ := NewEncryptedStorage(tmpdir+"/backup", getKey())
+
+	live.WriteFile("account/a", []byte("a"))
+	live.WriteFile("account/b", []byte("b"))
+	backup.WriteFile("account/a", []byte("a"))
+	os.WriteFile(tmpdir+"/backup/account/b", []byte("x"), 0600)
+
+	before, _ := os.ReadFile(tmpdir + "/backup/account/b")
+
+	report, err := VerifyRestore(backup, live, "account")
+	if err != nil {
+		t.Fatalf("unexpected error when calling VerifyRestore %+v", err)
+	}
+	if report.Restorable() {
+		t.Errorf("expected corrupted backup not to be restorable")
+	}
+	if len(report.Integrity.Corrupted) != 1 || report.Integrity.Corrupted[0].Path != "account/b" {
+		t.Errorf("unexpected integrity report %+v", report.Integrity)
+	}
+	if len(report.Difference.Changed) != 1 || report.Difference.Changed[0] != "account/b" {
+		t.Errorf("expected corrupted file to differ from live got %+v", report.Difference)
+	}
+
+	after, _ := os.ReadFile(tmpdir + "/backup/account/b")
+	if string(before) != string(after) {
+		t.Errorf("expected backup to stay untouched")
+	}
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	underlying, _ := NewEncryptedStorage(tmpdir, getKey())
+	storage := NewTimestampedStorage(underlying, new(fakeAuthority), 1, 0)
+	storage.WriteFile("account/a", []byte("healthy"))
+	storage.WriteFile("account/b", []byte("rotten"))
+	os.WriteFile(tmpdir+"/account/c", []byte("short"), 0600)
+
+	t.Log("reports healthy tree")
+	{
+		report, err := underlying.(EncryptedStorage).Verify("account/a")
+		if err != nil {
+			t.Fatalf("unexpected error when calling Verify %+v", err)
+		}
+		if !report.Healthy() || report.Scanned != 1 {
+			t.Errorf("expected healthy report of 1 file got %+v", report)
+		}
+	}
+
+	t.Log("reports corrupted files")
+	{
+		raw, _ := os.ReadFile(tmpdir + "/account/b")
+		raw[len(raw)-1] ^= 0xff
+		os.WriteFile(tmpdir+"/account/b", raw, 0600)
+
+		report, err := underlying.(EncryptedStorage).Verify("account")
+		if err != nil {
+			t.Fatalf("unexpected error when calling Verify %+v", err)
+		}
+		if report.Scanned != 3 || len(report.Corrupted) != 2 {
+			t.Fatalf("expected 2 of 3 files corrupted got %+v", report)
+		}
+		if report.Corrupted[0].Path != "account/b" || report.Corrupted[0].Reason != "checksum mismatch" {
+			t.Errorf("expected account/b checksum mismatch got %+v", report.Corrupted[0])
+		}
+		if report.Corrupted[1].Path != "account/c" {
+			t.Errorf("expected account/c undecryptable got %+v", report.Corrupted[1])
+		}
+	}
+
+	t.Log("fails on missing prefix")
+	{
+		if _, err := underlying.(EncryptedStorage).Verify("missing"); !os.IsNotExist(err) {
+			t.Errorf("expected not exist error got %+v", err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"strings"
+	"time"
+)
+
+// EventOp represents kind of change observed on a path
+type EventOp uint8
+
+const (
+	// EventCreate file or directory was created
+	EventCreate EventOp = 1 << iota
+	// EventWrite file was written and closed
+	EventWrite
+	// EventRemove file or directory was removed
+	EventRemove
+	// EventRename file or directory was moved away
+	EventRename
+	// EventOverflow events were lost because kernel queue overflowed, path is
+	// watched path and consumer should rescan it with ListDirectory
+	EventOverflow
+)
+
+func (op EventOp) String() string {
+	var names []string
+	if op&EventCreate != 0 {
+		names = append(names, "CREATE")
+	}
+	if op&EventWrite != 0 {
+		names = append(names, "WRITE")
+	}
+	if op&EventRemove != 0 {
+		names = append(names, "REMOVE")
+	}
+	if op&EventRename != 0 {
+		names = append(names, "RENAME")
+	}
+	if op&EventOverflow != 0 {
+		names = append(names, "OVERFLOW")
+	}
+	return strings.Join(names, "|")
+}
+
+// Event represents change of a path relative to storage root
+type Event struct {
+	Path string
+	Op   EventOp
+}
+
+// Watcher delivers change events, channel returned by Events is closed when
+// watcher is closed or fails, Err then returns cause of failure if any
+type Watcher interface {
+	Events() <-chan Event
+	Err() error
+	Close() error
+}
+
+// WatchOptions customizes recursive watch
+type WatchOptions struct {
+	// MaxDepth limits depth of subscribed subdirectories, zero means unlimited
+	MaxDepth int
+	// Match selects subdirectories to subscribe given their path relative to
+	// storage root, nil means all
+	Match func(path string) bool
+	// PollInterval is initial interval of polling fallback used when inotify
+	// watch descriptors are exhausted or inotify is not available
+	PollInterval time.Duration
+	// PollBackoff is maximal interval of polling fallback
+	PollBackoff time.Duration
+}
+
+// Watch returns watcher delivering changes of entries in given directory
+func (storage PlaintextStorage) Watch(path string) (Watcher, error) {
+	return newWatcher(storage.root, path, false, WatchOptions{})
+}
+
+// Watch returns watcher delivering changes of entries in given directory
+func (storage EncryptedStorage) Watch(path string) (Watcher, error) {
+	return newWatcher(storage.root, path, false, WatchOptions{})
+}
+
+// WatchRecursive returns watcher delivering changes in whole subtree of given
+// directory, newly created subdirectories are subscribed automatically and
+// polling is used for those that cannot get inotify watch and on platforms
+// without inotify
+func (storage PlaintextStorage) WatchRecursive(path string, options WatchOptions) (Watcher, error) {
+	return newWatcher(storage.root, path, true, options)
+}
+
+// WatchRecursive returns watcher delivering changes in whole subtree of given
+// directory, newly created subdirectories are subscribed automatically and
+// polling is used for those that cannot get inotify watch and on platforms
+// without inotify
+func (storage EncryptedStorage) WatchRecursive(path string, options WatchOptions) (Watcher, error) {
+	return newWatcher(storage.root, path, true, options)
+}
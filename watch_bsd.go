@@ -0,0 +1,27 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin || freebsd
+
+package storage
+
+// newWatcher returns polling watcher, kqueue reports changes of open
+// descriptors only so watching large trees would exhaust descriptors
+func newWatcher(root string, path string, recursive bool, options WatchOptions) (Watcher, error) {
+	watcher, err := newPollWatcher(root, path, options.PollInterval, options.PollBackoff, recursive, options.MaxDepth, options.Match)
+	if err != nil {
+		return nil, err
+	}
+	return watcher, nil
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type pendingEvent struct {
+	op       EventOp
+	deadline time.Time
+}
+
+type coalescingWatcher struct {
+	underlying Watcher
+	window     time.Duration
+	events     chan Event
+	done       chan struct{}
+	once       sync.Once
+}
+
+// Coalesce returns watcher that merges bursts of events of same path into
+// single event delivered once path was quiet for given window, operations of
+// merged events are combined so consolidated event may be for example
+// EventCreate|EventWrite
+func Coalesce(underlying Watcher, window time.Duration) Watcher {
+	if window <= 0 {
+		return underlying
+	}
+	watcher := &coalescingWatcher{
+		underlying: underlying,
+		window:     window,
+		events:     make(chan Event, 64),
+		done:       make(chan struct{}),
+	}
+	go watcher.loop()
+	return watcher
+}
+
+func (watcher *coalescingWatcher) Events() <-chan Event {
+	return watcher.events
+}
+
+func (watcher *coalescingWatcher) Err() error {
+	return watcher.underlying.Err()
+}
+
+func (watcher *coalescingWatcher) Close() error {
+	watcher.once.Do(func() {
+		close(watcher.done)
+	})
+	return watcher.underlying.Close()
+}
+
+// due returns paths with deadline before given time ordered by path
+func due(pending map[string]*pendingEvent, now time.Time) []string {
+	result := make([]string, 0)
+	for path, event := range pending {
+		if !event.deadline.After(now) {
+			result = append(result, path)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (watcher *coalescingWatcher) loop() {
+	defer close(watcher.events)
+	var (
+		pending = make(map[string]*pendingEvent)
+		timer   = time.NewTimer(watcher.window)
+		source  = watcher.underlying.Events()
+	)
+	defer timer.Stop()
+
+	flush := func(paths []string) bool {
+		for _, path := range paths {
+			event := Event{Path: path, Op: pending[path].op}
+			delete(pending, path)
+			select {
+			case watcher.events <- event:
+			case <-watcher.done:
+				return false
+			}
+		}
+		return true
+	}
+
+	for {
+		select {
+		case <-watcher.done:
+			return
+		case event, ok := <-source:
+			if !ok {
+				paths := make([]string, 0, len(pending))
+				for path := range pending {
+					paths = append(paths, path)
+				}
+				sort.Strings(paths)
+				flush(paths)
+				return
+			}
+			entry, exists := pending[event.Path]
+			if !exists {
+				entry = new(pendingEvent)
+				pending[event.Path] = entry
+			}
+			entry.op |= event.Op
+			entry.deadline = time.Now().Add(watcher.window)
+		case now := <-timer.C:
+			if !flush(due(pending, now)) {
+				return
+			}
+		}
+		next := watcher.window
+		now := time.Now()
+		for _, entry := range pending {
+			if wait := entry.deadline.Sub(now); wait < next {
+				next = wait
+			}
+		}
+		if next <= 0 {
+			next = time.Microsecond
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(next)
+	}
+}
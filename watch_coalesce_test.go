@@ -0,0 +1,51 @@
+//go:build linux
+
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCoalesce(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	if err = storage.Mkdir("inbox"); err != nil {
+		t.Fatalf("unexpected error when calling Mkdir %+v", err)
+	}
+
+	underlying, err := storage.(PlaintextStorage).Watch("inbox")
+	if err != nil {
+		t.Fatalf("unexpected error when calling Watch %+v", err)
+	}
+	watcher := Coalesce(underlying, 50*time.Millisecond)
+	defer watcher.Close()
+
+	for i := 0; i < 5; i++ {
+		if err = storage.AppendFile("inbox/foo", []byte("abc")); err != nil {
+			t.Fatalf("unexpected error when calling AppendFile %+v", err)
+		}
+	}
+
+	select {
+	case event := <-watcher.Events():
+		if event.Path != "inbox/foo" || event.Op != EventCreate|EventWrite {
+			t.Errorf("expected single consolidated CREATE|WRITE event got %s %s", event.Op, event.Path)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout while waiting for consolidated event")
+	}
+
+	select {
+	case event := <-watcher.Events():
+		t.Errorf("expected no more events got %s %s", event.Op, event.Path)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
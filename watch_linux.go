@@ -0,0 +1,268 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// inotifyAddWatch is indirection allowing tests to emulate exhaustion of
+// watch descriptors
+var inotifyAddWatch = syscall.InotifyAddWatch
+
+const watchMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+
+type watchEntry struct {
+	path  string
+	depth int
+}
+
+type inotifyWatcher struct {
+	fd        int
+	file      *os.File
+	root      string
+	base      string
+	recursive bool
+	options   WatchOptions
+	events    chan Event
+	done      chan struct{}
+	mutex     sync.Mutex
+	err       error
+	watches   map[int32]watchEntry
+	fallbacks []Watcher
+}
+
+func newInotifyWatcher(root string, path string, recursive bool, options WatchOptions) (Watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = time.Second
+	}
+	if options.PollBackoff <= 0 {
+		options.PollBackoff = 10 * time.Second
+	}
+	watcher := &inotifyWatcher{
+		fd:        fd,
+		root:      filepath.Clean(root),
+		base:      filepath.Clean(path),
+		recursive: recursive,
+		options:   options,
+		events:    make(chan Event, 64),
+		done:      make(chan struct{}),
+		watches:   make(map[int32]watchEntry),
+	}
+	if err = watcher.subscribe(watcher.base, 0, false); err != nil {
+		syscall.Close(fd)
+		if err == syscall.ENOSPC && recursive {
+			return newPollWatcher(root, path, options.PollInterval, options.PollBackoff, true, options.MaxDepth, options.Match)
+		}
+		return nil, err
+	}
+	watcher.file = os.NewFile(uintptr(fd), "inotify")
+	go watcher.loop()
+	return watcher, nil
+}
+
+// subscribe adds watch for given directory and in recursive mode also for its
+// subdirectories, entries discovered in newly created directories are
+// reported as created because they could have appeared before watch was added
+func (watcher *inotifyWatcher) subscribe(relPath string, depth int, announce bool) error {
+	wd, err := inotifyAddWatch(watcher.fd, filepath.Clean(watcher.root+"/"+relPath), watchMask)
+	if err != nil {
+		return err
+	}
+	watcher.mutex.Lock()
+	watcher.watches[int32(wd)] = watchEntry{path: relPath, depth: depth}
+	watcher.mutex.Unlock()
+	if !watcher.recursive {
+		return nil
+	}
+	entries, err := os.ReadDir(filepath.Clean(watcher.root + "/" + relPath))
+	if err != nil {
+		return nil
+	}
+	for _, entry := range entries {
+		child := filepath.Join(relPath, entry.Name())
+		if announce && !watcher.emit(Event{Path: child, Op: EventCreate}) {
+			return nil
+		}
+		if entry.IsDir() && watcher.accepts(child, depth+1) {
+			if err = watcher.subscribeOrPoll(child, depth+1, announce); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// subscribeOrPoll subscribes directory falling back to polling when inotify
+// watch descriptors are exhausted
+func (watcher *inotifyWatcher) subscribeOrPoll(relPath string, depth int, announce bool) error {
+	err := watcher.subscribe(relPath, depth, announce)
+	if err != syscall.ENOSPC {
+		return err
+	}
+	recursive, maxDepth := true, 0
+	if watcher.options.MaxDepth > 0 {
+		maxDepth = watcher.options.MaxDepth - depth
+		recursive = maxDepth > 0
+	}
+	fallback, err := newPollWatcher(watcher.root, relPath, watcher.options.PollInterval, watcher.options.PollBackoff, recursive, maxDepth, watcher.options.Match)
+	if err != nil {
+		return nil
+	}
+	watcher.mutex.Lock()
+	watcher.fallbacks = append(watcher.fallbacks, fallback)
+	watcher.mutex.Unlock()
+	go func() {
+		for event := range fallback.Events() {
+			if !watcher.emit(event) {
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (watcher *inotifyWatcher) accepts(relPath string, depth int) bool {
+	if watcher.options.MaxDepth > 0 && depth > watcher.options.MaxDepth {
+		return false
+	}
+	return watcher.options.Match == nil || watcher.options.Match(relPath)
+}
+
+func (watcher *inotifyWatcher) Events() <-chan Event {
+	return watcher.events
+}
+
+func (watcher *inotifyWatcher) Err() error {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+	return watcher.err
+}
+
+func (watcher *inotifyWatcher) Close() error {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+	select {
+	case <-watcher.done:
+		return nil
+	default:
+		close(watcher.done)
+	}
+	for _, fallback := range watcher.fallbacks {
+		fallback.Close()
+	}
+	return watcher.file.Close()
+}
+
+func (watcher *inotifyWatcher) fail(err error) {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+	select {
+	case <-watcher.done:
+	default:
+		watcher.err = err
+	}
+}
+
+func (watcher *inotifyWatcher) emit(event Event) bool {
+	select {
+	case watcher.events <- event:
+		return true
+	case <-watcher.done:
+		return false
+	}
+}
+
+func (watcher *inotifyWatcher) loop() {
+	defer close(watcher.events)
+	buffer := make([]byte, syscall.SizeofInotifyEvent*4096)
+	for {
+		n, err := watcher.file.Read(buffer)
+		if err != nil {
+			watcher.fail(err)
+			return
+		}
+		offset := 0
+		for offset+syscall.SizeofInotifyEvent <= n {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			name := buffer[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(raw.Len)]
+			if index := bytes.IndexByte(name, 0); index >= 0 {
+				name = name[:index]
+			}
+			offset += syscall.SizeofInotifyEvent + int(raw.Len)
+
+			if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				if !watcher.emit(Event{Path: watcher.base, Op: EventOverflow}) {
+					return
+				}
+				continue
+			}
+			watcher.mutex.Lock()
+			dir, ok := watcher.watches[raw.Wd]
+			if raw.Mask&syscall.IN_IGNORED != 0 {
+				delete(watcher.watches, raw.Wd)
+			}
+			watcher.mutex.Unlock()
+			if !ok {
+				continue
+			}
+			event := Event{Path: dir.path}
+			if len(name) > 0 {
+				event.Path = filepath.Join(dir.path, string(name))
+			}
+			switch {
+			case raw.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+				event.Op = EventCreate
+			case raw.Mask&syscall.IN_CLOSE_WRITE != 0:
+				event.Op = EventWrite
+			case raw.Mask&syscall.IN_DELETE != 0:
+				event.Op = EventRemove
+			case raw.Mask&syscall.IN_DELETE_SELF != 0:
+				if dir.path != watcher.base {
+					continue
+				}
+				event.Op = EventRemove
+			case raw.Mask&syscall.IN_MOVED_FROM != 0:
+				event.Op = EventRename
+			default:
+				continue
+			}
+			if !watcher.emit(event) {
+				return
+			}
+			if watcher.recursive && event.Op == EventCreate && raw.Mask&syscall.IN_ISDIR != 0 && watcher.accepts(event.Path, dir.depth+1) {
+				if err = watcher.subscribeOrPoll(event.Path, dir.depth+1, true); err != nil {
+					watcher.fail(err)
+					return
+				}
+			}
+		}
+	}
+}
+
+// newWatcher returns inotify watcher
+func newWatcher(root string, path string, recursive bool, options WatchOptions) (Watcher, error) {
+	return newInotifyWatcher(root, path, recursive, options)
+}
@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	if err = storage.Mkdir("inbox"); err != nil {
+		t.Fatalf("unexpected error when calling Mkdir %+v", err)
+	}
+
+	watcher, err := storage.(PlaintextStorage).Watch("inbox")
+	if err != nil {
+		t.Fatalf("unexpected error when calling Watch %+v", err)
+	}
+
+	if err = storage.WriteFile("inbox/foo", []byte("abc")); err != nil {
+		t.Fatalf("unexpected error when calling WriteFile %+v", err)
+	}
+	expectEvent(t, watcher, "inbox/foo", EventCreate)
+	expectEvent(t, watcher, "inbox/foo", EventWrite)
+
+	if err = os.Rename(tmpdir+"/inbox/foo", tmpdir+"/bar"); err != nil {
+		t.Fatalf("unexpected error when renaming file %+v", err)
+	}
+	expectEvent(t, watcher, "inbox/foo", EventRename)
+
+	if err = storage.WriteFile("inbox/baz", []byte("abc")); err != nil {
+		t.Fatalf("unexpected error when calling WriteFile %+v", err)
+	}
+	if err = storage.Delete("inbox/baz"); err != nil {
+		t.Fatalf("unexpected error when calling Delete %+v", err)
+	}
+	expectEvent(t, watcher, "inbox/baz", EventRemove)
+
+	if err = watcher.Close(); err != nil {
+		t.Fatalf("unexpected error when calling Close %+v", err)
+	}
+	for range watcher.Events() {
+	}
+	if watcher.Err() != nil {
+		t.Errorf("expected no error after Close got %+v", watcher.Err())
+	}
+}
+
+func TestWatchRecursive(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	if err = storage.Mkdir("tree/existing"); err != nil {
+		t.Fatalf("unexpected error when calling Mkdir %+v", err)
+	}
+
+	watcher, err := storage.(PlaintextStorage).WatchRecursive("tree", WatchOptions{
+		MaxDepth: 2,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error when calling WatchRecursive %+v", err)
+	}
+	defer watcher.Close()
+
+	if err = storage.WriteFile("tree/existing/foo", []byte("abc")); err != nil {
+		t.Fatalf("unexpected error when calling WriteFile %+v", err)
+	}
+	expectEvent(t, watcher, "tree/existing/foo", EventWrite)
+
+	if err = storage.Mkdir("tree/a"); err != nil {
+		t.Fatalf("unexpected error when calling Mkdir %+v", err)
+	}
+	expectEvent(t, watcher, "tree/a", EventCreate)
+	if err = storage.WriteFile("tree/a/bar", []byte("abc")); err != nil {
+		t.Fatalf("unexpected error when calling WriteFile %+v", err)
+	}
+	expectEvent(t, watcher, "tree/a/bar", EventWrite)
+
+	if err = storage.Mkdir("tree/a/b/c"); err != nil {
+		t.Fatalf("unexpected error when calling Mkdir %+v", err)
+	}
+	expectEvent(t, watcher, "tree/a/b", EventCreate)
+	if err = storage.WriteFile("tree/a/b/c/deep", []byte("abc")); err != nil {
+		t.Fatalf("unexpected error when calling WriteFile %+v", err)
+	}
+	if err = storage.WriteFile("tree/a/b/marker", []byte("abc")); err != nil {
+		t.Fatalf("unexpected error when calling WriteFile %+v", err)
+	}
+	for {
+		select {
+		case event := <-watcher.Events():
+			if event.Path == "tree/a/b/c/deep" {
+				t.Fatalf("expected directories beyond max depth not to be watched")
+			}
+			if event.Path == "tree/a/b/marker" && event.Op == EventWrite {
+				return
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout while waiting for marker")
+		}
+	}
+}
+
+func TestWatchRecursiveFallsBackToPolling(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	if err = storage.Mkdir("tree"); err != nil {
+		t.Fatalf("unexpected error when calling Mkdir %+v", err)
+	}
+
+	watches := 0
+	inotifyAddWatch = func(fd int, path string, mask uint32) (int, error) {
+		if watches >= 1 {
+			return -1, syscall.ENOSPC
+		}
+		watches++
+		return syscall.InotifyAddWatch(fd, path, mask)
+	}
+	defer func() {
+		inotifyAddWatch = syscall.InotifyAddWatch
+	}()
+
+	watcher, err := storage.(PlaintextStorage).WatchRecursive("tree", WatchOptions{
+		PollInterval: time.Millisecond,
+		PollBackoff:  10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error when calling WatchRecursive %+v", err)
+	}
+	defer watcher.Close()
+
+	if err = storage.Mkdir("tree/a"); err != nil {
+		t.Fatalf("unexpected error when calling Mkdir %+v", err)
+	}
+	expectEvent(t, watcher, "tree/a", EventCreate)
+	time.Sleep(5 * time.Millisecond)
+	if err = storage.WriteFile("tree/a/foo", []byte("abc")); err != nil {
+		t.Fatalf("unexpected error when calling WriteFile %+v", err)
+	}
+	expectEvent(t, watcher, "tree/a/foo", EventCreate)
+}
+
+func TestWatchOverflow(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	limit, err := ioutil.ReadFile("/proc/sys/fs/inotify/max_queued_events")
+	if err != nil {
+		t.Skipf("unable to read inotify queue limit %+v", err)
+	}
+	queued, err := strconv.Atoi(strings.TrimSpace(string(limit)))
+	if err != nil || queued > 100000 {
+		t.Skipf("inotify queue limit %q too large to overflow", limit)
+	}
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	if err = storage.Mkdir("inbox"); err != nil {
+		t.Fatalf("unexpected error when calling Mkdir %+v", err)
+	}
+	watcher, err := storage.(PlaintextStorage).Watch("inbox")
+	if err != nil {
+		t.Fatalf("unexpected error when calling Watch %+v", err)
+	}
+	defer watcher.Close()
+
+	t.Log("reports overflow of kernel queue instead of dropping it")
+	{
+		// events are not consumed so kernel queue overflows, watcher itself
+		// buffers some events before it blocks so queue is overfilled twice
+		for i := 0; i <= 2*queued; i++ {
+			if err = storage.TouchFile(fmt.Sprintf("inbox/%06d", i)); err != nil {
+				t.Fatalf("unexpected error when calling TouchFile %+v", err)
+			}
+		}
+		timeout := time.After(10 * time.Second)
+		for {
+			select {
+			case event := <-watcher.Events():
+				if event.Op != EventOverflow {
+					continue
+				}
+				if event.Path != "inbox" {
+					t.Errorf("expected overflow of inbox got %s", event.Path)
+				}
+				return
+			case <-timeout:
+				t.Fatalf("expected overflow event")
+			}
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type pollState struct {
+	size  int64
+	mtime time.Time
+	inode uint64
+}
+
+type pollWatcher struct {
+	absPath   string
+	relPath   string
+	interval  time.Duration
+	backoff   time.Duration
+	recursive bool
+	maxDepth  int
+	match     func(string) bool
+	events    chan Event
+	done      chan struct{}
+	once      sync.Once
+	mutex     sync.Mutex
+	err       error
+}
+
+func newPollWatcher(root string, path string, interval time.Duration, backoff time.Duration, recursive bool, maxDepth int, match func(string) bool) (*pollWatcher, error) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	if backoff < interval {
+		backoff = interval
+	}
+	watcher := &pollWatcher{
+		absPath:   filepath.Clean(root + "/" + path),
+		relPath:   filepath.Clean(path),
+		interval:  interval,
+		backoff:   backoff,
+		recursive: recursive,
+		maxDepth:  maxDepth,
+		match:     match,
+		events:    make(chan Event, 64),
+		done:      make(chan struct{}),
+	}
+	state, err := watcher.scan()
+	if err != nil {
+		return nil, err
+	}
+	go watcher.loop(state)
+	return watcher, nil
+}
+
+func (watcher *pollWatcher) Events() <-chan Event {
+	return watcher.events
+}
+
+func (watcher *pollWatcher) Err() error {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+	return watcher.err
+}
+
+func (watcher *pollWatcher) Close() error {
+	watcher.once.Do(func() {
+		close(watcher.done)
+	})
+	return nil
+}
+
+func (watcher *pollWatcher) scan() (map[string]pollState, error) {
+	result := make(map[string]pollState)
+	if err := watcher.scanDirectory("", 0, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// scanDirectory collects state of entries of directory at given path
+// relative to watched directory, descending into subdirectories in recursive
+// mode
+func (watcher *pollWatcher) scanDirectory(relPath string, depth int, result map[string]pollState) error {
+	entries, err := os.ReadDir(filepath.Join(watcher.absPath, relPath))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		name := filepath.Join(relPath, entry.Name())
+		state := pollState{
+			size:  info.Size(),
+			mtime: info.ModTime(),
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			state.inode = uint64(stat.Ino)
+		}
+		result[name] = state
+		if !watcher.recursive || !entry.IsDir() {
+			continue
+		}
+		if watcher.maxDepth > 0 && depth+1 > watcher.maxDepth {
+			continue
+		}
+		if watcher.match != nil && !watcher.match(filepath.Join(watcher.relPath, name)) {
+			continue
+		}
+		watcher.scanDirectory(name, depth+1, result)
+	}
+	return nil
+}
+
+// diff returns events turning previous state into current one, names are
+// ordered so that delivery is deterministic
+func (watcher *pollWatcher) diff(previous map[string]pollState, current map[string]pollState) []Event {
+	var (
+		result  = make([]Event, 0)
+		created = make(map[uint64]string)
+		names   = make([]string, 0, len(current))
+	)
+	for name := range current {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		now := current[name]
+		before, ok := previous[name]
+		switch {
+		case !ok:
+			created[now.inode] = name
+			result = append(result, Event{Path: filepath.Join(watcher.relPath, name), Op: EventCreate})
+		case before.size != now.size || !before.mtime.Equal(now.mtime) || before.inode != now.inode:
+			result = append(result, Event{Path: filepath.Join(watcher.relPath, name), Op: EventWrite})
+		}
+	}
+	names = names[:0]
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		op := EventRemove
+		if _, ok := created[previous[name].inode]; ok && previous[name].inode != 0 {
+			op = EventRename
+		}
+		result = append(result, Event{Path: filepath.Join(watcher.relPath, name), Op: op})
+	}
+	return result
+}
+
+func (watcher *pollWatcher) loop(state map[string]pollState) {
+	defer close(watcher.events)
+	delay := watcher.interval
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-watcher.done:
+			return
+		case <-timer.C:
+		}
+		current, err := watcher.scan()
+		if err != nil {
+			watcher.mutex.Lock()
+			watcher.err = err
+			watcher.mutex.Unlock()
+			return
+		}
+		events := watcher.diff(state, current)
+		state = current
+		for _, event := range events {
+			select {
+			case watcher.events <- event:
+			case <-watcher.done:
+				return
+			}
+		}
+		if len(events) > 0 {
+			delay = watcher.interval
+		} else if delay *= 2; delay > watcher.backoff {
+			delay = watcher.backoff
+		}
+		timer.Reset(delay)
+	}
+}
+
+// PollWatch returns watcher detecting changes of entries in given directory
+// by polling their size and modification time, it is meant for filesystems
+// without inotify support. Polling starts at interval and backs off up to
+// backoff while nothing changes.
+func (storage PlaintextStorage) PollWatch(path string, interval time.Duration, backoff time.Duration) (Watcher, error) {
+	return newPollWatcher(storage.root, path, interval, backoff, false, 0, nil)
+}
+
+// PollWatch returns watcher detecting changes of entries in given directory
+// by polling their size and modification time, it is meant for filesystems
+// without inotify support. Polling starts at interval and backs off up to
+// backoff while nothing changes.
+func (storage EncryptedStorage) PollWatch(path string, interval time.Duration, backoff time.Duration) (Watcher, error) {
+	return newPollWatcher(storage.root, path, interval, backoff, false, 0, nil)
+}
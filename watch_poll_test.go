@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPollWatch(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	storage, _ := NewPlaintextStorage(tmpdir)
+	if err = storage.WriteFile("inbox/existing", []byte("a")); err != nil {
+		t.Fatalf("unexpected error when calling WriteFile %+v", err)
+	}
+
+	watcher, err := storage.(PlaintextStorage).PollWatch("inbox", time.Millisecond, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error when calling PollWatch %+v", err)
+	}
+	defer watcher.Close()
+
+	if err = storage.WriteFile("inbox/foo", []byte("abc")); err != nil {
+		t.Fatalf("unexpected error when calling WriteFile %+v", err)
+	}
+	expectEvent(t, watcher, "inbox/foo", EventCreate)
+
+	if err = storage.AppendFile("inbox/existing", []byte("bc")); err != nil {
+		t.Fatalf("unexpected error when calling AppendFile %+v", err)
+	}
+	expectEvent(t, watcher, "inbox/existing", EventWrite)
+
+	if err = os.Rename(tmpdir+"/inbox/foo", tmpdir+"/inbox/bar"); err != nil {
+		t.Fatalf("unexpected error when renaming file %+v", err)
+	}
+	expectEvent(t, watcher, "inbox/foo", EventRename)
+
+	if err = storage.Delete("inbox/bar"); err != nil {
+		t.Fatalf("unexpected error when calling Delete %+v", err)
+	}
+	expectEvent(t, watcher, "inbox/bar", EventRemove)
+
+	watcher.Close()
+	for range watcher.Events() {
+	}
+}
@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func expectEvent(t *testing.T, watcher Watcher, path string, op EventOp) {
+	t.Helper()
+	for {
+		select {
+		case event, ok := <-watcher.Events():
+			if !ok {
+				t.Fatalf("events channel closed while waiting for %s %s, err %+v", op, path, watcher.Err())
+			}
+			if event.Path == path && event.Op == op {
+				return
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout while waiting for %s %s", op, path)
+		}
+	}
+}
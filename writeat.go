@@ -0,0 +1,138 @@
+// Copyright (c) 2017-2023, Jan Cajthaml <jan.cajthaml@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// positionalWriter is implemented by storages able to update part of file
+// in place
+type positionalWriter interface {
+	WriteFileAt(path string, offset int64, data []byte) error
+}
+
+// WriteFileAt writes data into file at offset leaving rest of file intact,
+// file is created when it does not exist and extended with zeros when offset
+// is past its end, storages not supporting positional writes rewrite whole
+// file
+func WriteFileAt(storage Storage, path string, offset int64, data []byte) error {
+	if candidate, ok := storage.(positionalWriter); ok {
+		return candidate.WriteFileAt(path, offset, data)
+	}
+	if offset < 0 {
+		return syscall.EINVAL
+	}
+	content, err := storage.ReadFileFully(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return storage.WriteFile(path, patch(content, offset, data))
+}
+
+// patch returns content with data written at offset
+func patch(content []byte, offset int64, data []byte) []byte {
+	if end := offset + int64(len(data)); end > int64(len(content)) {
+		content = append(content, make([]byte, end-int64(len(content)))...)
+	}
+	copy(content[offset:], data)
+	return content
+}
+
+// openLocked opens file for update creating it and its directory when
+// missing and takes lock of path and exclusive lock of file, returned func
+// unlocks, syncs and closes
+func (storage PlaintextStorage) openLocked(filename string, mode string) (int, func(), error) {
+	stripe := lockPath(filename)
+	if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+		stripe.Unlock()
+		return -1, noop, err
+	}
+	fd, err := openRetrying(filename, syscall.O_CREAT|syscall.O_RDWR|syscall.O_NONBLOCK, 0600)
+	if err != nil {
+		stripe.Unlock()
+		return -1, noop, err
+	}
+	untrack := storage.handles.track(filename, mode)
+	if err = flock(fd, filename, syscall.LOCK_EX, storage.lockTimeout); err != nil {
+		syscall.Close(fd)
+		untrack()
+		stripe.Unlock()
+		return -1, noop, err
+	}
+	return fd, func() {
+		syscall.Fsync(fd)
+		funlock(fd, filename)
+		syscall.Close(fd)
+		untrack()
+		stripe.Unlock()
+	}, nil
+}
+
+// WriteFileAt writes data into file at offset with pwrite under exclusive
+// lock so fixed size records can be updated without rewriting whole file
+func (storage PlaintextStorage) WriteFileAt(path string, offset int64, data []byte) error {
+	if offset < 0 {
+		return syscall.EINVAL
+	}
+	defer storage.barrier.enter()()
+	fd, release, err := storage.openLocked(filepath.Clean(storage.root+"/"+path), "pwrite")
+	if err != nil {
+		return err
+	}
+	defer release()
+	return pwriteFull(fd, data, offset)
+}
+
+// WriteFileAt writes data into plaintext of file at offset, ciphertext past
+// offset depends on changed bytes so file is decrypted and re-encrypted as
+// whole under exclusive lock
+func (storage EncryptedStorage) WriteFileAt(path string, offset int64, data []byte) error {
+	if offset < 0 {
+		return syscall.EINVAL
+	}
+	defer storage.barrier.enter()()
+	raw := PlaintextStorage{handles: storage.handles, lockTimeout: storage.lockTimeout}
+	fd, release, err := raw.openLocked(filepath.Clean(storage.root+"/"+path), "pwrite")
+	if err != nil {
+		return err
+	}
+	defer release()
+	var fs syscall.Stat_t
+	if err = syscall.Fstat(fd, &fs); err != nil {
+		return err
+	}
+	var content []byte
+	if fs.Size > 0 {
+		ciphertext := make([]byte, fs.Size)
+		n, err := preadFull(fd, ciphertext, 0)
+		if err != nil {
+			return err
+		}
+		if content, err = storage.decrypt(path, ciphertext[:n]); err != nil {
+			return err
+		}
+	}
+	out, err := storage.encryptSegments(path, patch(content, offset, data))
+	if err != nil {
+		return err
+	}
+	if err = syscall.Ftruncate(fd, 0); err != nil {
+		return err
+	}
+	return writevFull(fd, out)
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWriteFileAt(t *testing.T) {
+	tmpdir, err := ioutil.TempDir(os.TempDir(), "test_storage")
+	if err != nil {
+		t.Fatalf("unexpected error when creating temp dir %+v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	plaintext, _ := NewPlaintextStorage(tmpdir + "/plaintext")
+	encrypted, _ := NewEncryptedStorageWithOptions(tmpdir+"/encrypted", getKey(), EncryptionOptions{HMAC: true})
+
+	for name, storage := range map[string]Storage{"plaintext": plaintext, "encrypted": encrypted, "decorated": struct{ Storage }{plaintext}} {
+		path := name + "/slots"
+		if err = storage.WriteFile(path, []byte("aaaabbbbcccc")); err != nil {
+			t.Fatalf("%s unexpected error when calling WriteFile %+v", name, err)
+		}
+		if err = WriteFileAt(storage, path, 4, []byte("BBBB")); err != nil {
+			t.Fatalf("%s unexpected error when calling WriteFileAt %+v", name, err)
+		}
+		if data, _ := storage.ReadFileFully(path); string(data) != "aaaaBBBBcccc" {
+			t.Errorf("%s expected slot to be updated in place got %q", name, string(data))
+		}
+		if err = WriteFileAt(storage, path, 14, []byte("dd")); err != nil {
+			t.Fatalf("%s unexpected error when calling WriteFileAt %+v", name, err)
+		}
+		if data, _ := storage.ReadFileFully(path); string(data) != "aaaaBBBBcccc\x00\x00dd" {
+			t.Errorf("%s expected file to be extended got %q", name, string(data))
+		}
+		if err = WriteFileAt(storage, name+"/new/slots", 2, []byte("x")); err != nil {
+			t.Fatalf("%s unexpected error when calling WriteFileAt %+v", name, err)
+		}
+		if data, _ := storage.ReadFileFully(name + "/new/slots"); string(data) != "\x00\x00x" {
+			t.Errorf("%s expected new file got %q", name, string(data))
+		}
+		if err = WriteFileAt(storage, path, -1, []byte("x")); err != syscall.EINVAL {
+			t.Errorf("%s expected EINVAL got %+v", name, err)
+		}
+	}
+
+	t.Log("waits for writers of same path")
+	{
+		stripe := lockPath(filepath.Clean(tmpdir + "/plaintext/plaintext/slots"))
+		done := make(chan error, 1)
+		go func() {
+			done <- WriteFileAt(plaintext, "plaintext/slots", 0, []byte("AAAA"))
+		}()
+		select {
+		case err := <-done:
+			t.Fatalf("expected WriteFileAt to wait for lock of path got %+v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		stripe.Unlock()
+		if err := <-done; err != nil {
+			t.Fatalf("unexpected error when calling WriteFileAt %+v", err)
+		}
+		if data, _ := plaintext.ReadFileFully("plaintext/slots"); string(data[:4]) != "AAAA" {
+			t.Errorf("expected slot to be updated got %q", string(data))
+		}
+	}
+}